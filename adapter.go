@@ -0,0 +1,143 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	ble "github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux"
+	"github.com/go-ble/ble/linux/hci/cmd"
+)
+
+// The Bluetooth adapter is opened directly over HCI, which brings it up
+// (powers it on) by itself. What it can't get past is rfkill, so a soft
+// block is lifted first, and everything else is turned into an error that
+// says what to do about it.
+
+// rfkillSwitch is a Bluetooth entry in /sys/class/rfkill
+type rfkillSwitch struct {
+	dir  string
+	name string
+	soft bool // blocked in software, e.g. by rfkill or the desktop
+	hard bool // blocked by a hardware switch or the airplane mode key
+}
+
+func bluetoothSwitches() []rfkillSwitch {
+	dirs, _ := filepath.Glob("/sys/class/rfkill/rfkill*")
+	var switches []rfkillSwitch
+	read := func(dir, file string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, file))
+		return strings.TrimSpace(string(data))
+	}
+	for _, dir := range dirs {
+		if read(dir, "type") != "bluetooth" {
+			continue
+		}
+		switches = append(switches, rfkillSwitch{
+			dir:  dir,
+			name: read(dir, "name"),
+			soft: read(dir, "soft") == "1",
+			hard: read(dir, "hard") == "1",
+		})
+	}
+	return switches
+}
+
+var (
+	errNoAdapter     = errors.New("no Bluetooth adapter found, check that one is plugged in and that 'bluetoothctl list' shows it")
+	errHardBlocked   = errors.New("Bluetooth is switched off with a hardware switch or the airplane mode key, switch it back on")
+	errSoftBlocked   = errors.New("Bluetooth is blocked by rfkill, run 'rfkill unblock bluetooth'")
+	errAdapterAccess = errors.New("no permission to use the Bluetooth adapter, run bleh as root or give it the capabilities with 'sudo setcap cap_net_raw,cap_net_admin=eip $(which bleh)'")
+	errAdapterBusy   = errors.New("the Bluetooth adapter is in use by another program, close it or stop bluetoothd with 'sudo systemctl stop bluetooth'")
+)
+
+// unblockAdapter lifts a software rfkill block on the Bluetooth adapters,
+// and fails when none of them can be used
+func unblockAdapter() error {
+	switches := bluetoothSwitches()
+	if len(switches) == 0 {
+		return nil // no rfkill support, or no adapter, which opening tells
+	}
+	var err error
+	for _, s := range switches {
+		switch {
+		case !s.soft && !s.hard:
+			return nil
+		case s.hard:
+			err = errHardBlocked
+		default:
+			if werr := os.WriteFile(filepath.Join(s.dir, "soft"), []byte("0"), 0o644); werr != nil {
+				err = errSoftBlocked
+				continue
+			}
+			log.Printf("Unblocked Bluetooth adapter %s", s.name)
+			return nil
+		}
+	}
+	return err
+}
+
+// adapterError explains why the adapter couldn't be opened
+func adapterError(err error) error {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "rf-kill") || strings.Contains(msg, "rfkill"):
+		for _, s := range bluetoothSwitches() {
+			if s.hard {
+				return errHardBlocked
+			}
+		}
+		return errSoftBlocked
+	case errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) ||
+		strings.Contains(msg, "operation not permitted") || strings.Contains(msg, "permission denied"):
+		return errAdapterAccess
+	case errors.Is(err, syscall.EBUSY) || strings.Contains(msg, "resource busy"):
+		return errAdapterBusy
+	case errors.Is(err, syscall.ENODEV) || strings.Contains(msg, "no devices available") || strings.Contains(msg, "no such device"):
+		return errNoAdapter
+	}
+	return fmt.Errorf("failed to open BLE device: %v", err)
+}
+
+// deviceOptions are the options the adapter is opened with
+func deviceOptions() []ble.Option {
+	var opts []ble.Option
+	if passiveScan {
+		// Listen only, without asking for scan responses: quieter in a
+		// crowded room, but names often come in those, so printers are
+		// mostly found by their service
+		opts = append(opts, ble.OptScanParams(cmd.LESetScanParameters{
+			LEScanType:     0x00, // passive
+			LEScanInterval: 0x0010,
+			LEScanWindow:   0x0010,
+		}))
+	}
+	return opts
+}
+
+// openAdapter opens the Bluetooth adapter, unblocking it first if need be
+func openAdapter() (*linux.Device, error) {
+	if err := unblockAdapter(); err != nil {
+		return nil, withCause(errConnect, err)
+	}
+	d, err := linux.NewDevice(deviceOptions()...)
+	if err != nil {
+		return nil, withCause(errConnect, adapterError(err))
+	}
+	return d, nil
+}
@@ -0,0 +1,63 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// parseBackground accepts "white", "black", or a #rgb / #rrggbb hex color
+func parseBackground(s string) (color.Color, error) {
+	switch strings.ToLower(s) {
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if !strings.HasPrefix(s, "#") || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid background %q, use white, black or #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid background %q, use white, black or #rrggbb", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}, nil
+}
+
+// flattenAlpha composites img over a solid background. Without this the
+// grayscale conversion sees the (usually black) color of fully transparent
+// pixels, and transparent logos print as solid blobs.
+func flattenAlpha(img image.Image, bg color.Color) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := imaging.New(bounds.Dx(), bounds.Dy(), bg)
+	return imaging.Overlay(dst, img, image.Pt(0, 0), 1.0)
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
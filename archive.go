@@ -0,0 +1,171 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// A .zip or .tar given as an image stands for the images in it, in name
+// order, each read straight from the archive when its turn comes. They get
+// paths of their own, <archive>/<name>, so everything that takes image
+// paths takes them too.
+
+// imageExtensions are the files in archives taken for images
+var imageExtensions = []string{
+	".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp", ".tif", ".tiff", ".pbm", ".pgm", ".ppm", ".pnm",
+}
+
+// archiveMember is an image inside an archive
+type archiveMember struct {
+	archive string
+	name    string
+}
+
+// archiveMembers maps the paths expandArchives made up to the images
+var archiveMembers = map[string]archiveMember{}
+
+func isArchive(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	return (ext == ".zip" || ext == ".tar") && !isURL(p)
+}
+
+// expandArchives replaces the archives among paths by the images in them
+func expandArchives(paths []string) ([]string, error) {
+	var out []string
+	for _, p := range paths {
+		if !isArchive(p) {
+			out = append(out, p)
+			continue
+		}
+		names, err := archiveImages(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", p, err)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no images in %s", p)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			member := p + "/" + name
+			archiveMembers[member] = archiveMember{archive: p, name: name}
+			out = append(out, member)
+		}
+	}
+	return out, nil
+}
+
+// isArchivedImage tells the images from the rest, including the metadata
+// macOS adds to archives it makes
+func isArchivedImage(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(base))
+	for _, e := range imageExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveImages lists the images in an archive
+func archiveImages(p string) ([]string, error) {
+	var names []string
+	err := walkArchive(p, func(name string, _ io.Reader) (bool, error) {
+		if isArchivedImage(name) {
+			names = append(names, name)
+		}
+		return false, nil
+	})
+	return names, err
+}
+
+// walkArchive calls fn with every file in an archive until it returns true
+func walkArchive(p string, fn func(name string, r io.Reader) (bool, error)) error {
+	if strings.ToLower(filepath.Ext(p)) == ".zip" {
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			done, err := fn(f.Name, r)
+			r.Close()
+			if done || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if done, err := fn(h.Name, tr); done || err != nil {
+			return err
+		}
+	}
+}
+
+func (m archiveMember) open() (image.Image, error) {
+	var img image.Image
+	found := false
+	err := walkArchive(m.archive, func(name string, r io.Reader) (bool, error) {
+		if name != m.name {
+			return false, nil
+		}
+		found = true
+		var err error
+		img, err = imaging.Decode(io.LimitReader(r, fetchMaxBytes), imaging.AutoOrientation(true))
+		return true, err
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s in %s: %v", m.name, m.archive, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,118 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// With --archive-dir every job that printed successfully is saved there as a
+// PNG of exactly the raster that was sent, one dot per pixel, with the job's
+// details in the PNG's text chunks
+
+// archiveJob saves job, just printed by c, to --archive-dir. Like the
+// history, failing to do so never fails the print.
+func archiveJob(ctx context.Context, c *printerConn, job printJob) {
+	if archiveDir == "" {
+		return
+	}
+	path, err := writeArtifact(ctx, c, job)
+	if err != nil {
+		log.Printf("Failed to archive the print: %v", err)
+		return
+	}
+	log.Printf("Print archived as %s", path)
+}
+
+func writeArtifact(ctx context.Context, c *printerConn, job printJob) (string, error) {
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	key := printerKeyFor(printerAddress(ctx))
+	mode := "1bpp"
+	if job.mode == Mode4bpp {
+		mode = "4bpp"
+	}
+	settings, err := json.Marshal(changedSettings())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(job.pixels)
+	meta := [][2]string{
+		{"Software", "Bleh! " + version},
+		{"Creation Time", now.Format(time.RFC3339)},
+		{"Source", jobSource},
+		{"Printer", key},
+		{"Model", c.model.Name},
+		{"Mode", mode},
+		{"Intensity", fmt.Sprint(job.intensity)},
+		{"Lines", fmt.Sprint(job.height)},
+		{"Length", fmt.Sprintf("%.1f mm", float64(job.height)*25.4/dpi)},
+		{"Settings", string(settings)},
+		{"Raster SHA-256", hex.EncodeToString(sum[:])},
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, previewImage(job.pixels, job.height, job.mode)); err != nil {
+		return "", err
+	}
+	data := withPNGText(buf.Bytes(), meta)
+
+	// Jobs of a batch can finish within the same second
+	name := fmt.Sprintf("%s-%s", now.Format("20060102-150405"), key)
+	path := filepath.Join(archiveDir, name+".png")
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if os.IsExist(err) {
+			path = filepath.Join(archiveDir, fmt.Sprintf("%s-%d.png", name, i))
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
+
+// withPNGText adds an iTXt chunk for every keyword and text pair of meta to
+// the encoded PNG data, right after its header
+func withPNGText(data []byte, meta [][2]string) []byte {
+	const headerEnd = 8 + 8 + 13 + 4 // signature and IHDR chunk
+	var out bytes.Buffer
+	out.Write(data[:headerEnd])
+	for _, kv := range meta {
+		// Keyword, no compression, no language and no translated keyword
+		body := append([]byte(kv[0]), 0, 0, 0, 0, 0)
+		body = append(body, kv[1]...)
+		binary.Write(&out, binary.BigEndian, uint32(len(body)))
+		chunk := append([]byte("iTXt"), body...)
+		out.Write(chunk)
+		binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	}
+	out.Write(data[headerEnd:])
+	return out.Bytes()
+}
@@ -0,0 +1,102 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"log"
+
+	"github.com/disintegration/imaging"
+)
+
+// --mode auto and --dither auto go by what the image looks like: text and
+// line art are nearly all black and white, photos are mostly midtones
+const (
+	photoMidtones   = 0.25 // from this share of midtones on, it's a photo
+	lineArtMidtones = 0.08 // below it, it's line art and needs no dither
+	detailedEdges   = 0.08 // busy photos keep more contrast with atkinson
+	analyzeWidth    = 128  // plenty to tell a photo from a drawing
+)
+
+// imageStats is what the automatic choices are made from
+type imageStats struct {
+	midtones float64 // share of pixels neither near black nor near white
+	edges    float64 // share of pixels on a sharp edge
+}
+
+func analyzeImage(img image.Image) imageStats {
+	g := toGray(imaging.Resize(img, analyzeWidth, 0, imaging.Box))
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	var mid, edges int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := g.Pix[y*g.Stride+x]
+			if v > 48 && v < 208 {
+				mid++
+			}
+			if x+1 < w && y+1 < h {
+				gx := absDiff(g.Pix[y*g.Stride+x+1], v)
+				gy := absDiff(g.Pix[(y+1)*g.Stride+x], v)
+				if int(gx)+int(gy) > 64 {
+					edges++
+				}
+			}
+		}
+	}
+	n := float64(max(1, w*h))
+	return imageStats{midtones: float64(mid) / n, edges: float64(edges) / n}
+}
+
+// resolveAutoMode picks the print mode for img with --mode auto: 4bpp for
+// photos, unless the chosen model can't print it, and 1bpp for everything
+// else. The saved tone curve follows the mode picked.
+func resolveAutoMode(img image.Image, printMode PrintMode, opts imageOptions) (PrintMode, imageOptions) {
+	if !opts.autoMode {
+		return printMode, opts
+	}
+	s := analyzeImage(img)
+	printMode = Mode1bpp
+	if m, _ := chosenModel(); s.midtones >= photoMidtones && (m == nil || m.Gray) {
+		printMode = Mode4bpp
+	}
+	log.Printf("Auto mode: %.0f%% midtones, printing in %s", 100*s.midtones, modeName(printMode))
+
+	if curvePath == "" {
+		if c, err := loadToneCurve("", modeName(printMode)); err != nil {
+			log.Printf("Warning: %v", err)
+		} else {
+			opts.curve = c
+		}
+	}
+	return printMode, opts
+}
+
+// autoDither picks the dither for --dither auto: none for line art, which it
+// would only fray, Floyd-Steinberg for photos and Atkinson for busy photos
+// in 1bpp, where its lost error keeps edges crisp
+func autoDither(img image.Image, printMode PrintMode) string {
+	s := analyzeImage(img)
+	switch {
+	case s.midtones < lineArtMidtones:
+		return "none"
+	case printMode == Mode1bpp && s.edges >= detailedEdges:
+		return "atkinson"
+	default:
+		return "floyd"
+	}
+}
+
+func modeName(m PrintMode) string {
+	if m == Mode4bpp {
+		return "4bpp"
+	}
+	return "1bpp"
+}
@@ -0,0 +1,221 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var bannerCmd = &command{
+	name:  "banner",
+	usage: "[--font bold|regular|mono|bitmap|file.ttf] [--fill solid|outline|stripes|dots|checker] <text>",
+}
+
+func init() {
+	bannerCmd.run = runBanner
+	registerCommand(bannerCmd)
+}
+
+// Banner letters are as tall as the paper is wide, so the text runs down the
+// length of the paper and is read with the strip turned sideways
+
+var bannerFonts = map[string][]byte{
+	"regular": goregular.TTF,
+	"bold":    gobold.TTF,
+	"mono":    gomono.TTF,
+}
+
+var bannerFills = []string{"solid", "outline", "stripes", "dots", "checker"}
+
+func runBanner(ctx context.Context, args []string) error {
+	fs := bannerCmd.flagSet()
+	fontName := fs.String("font", "bold", "Typeface: bold, regular, mono, bitmap (the built-in pixel font) or a TrueType/OpenType file")
+	fill := fs.String("fill", "solid", "How letters are filled in: "+strings.Join(bannerFills, ", "))
+	fs.Parse(args)
+
+	text := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(text) == "" {
+		fs.Usage()
+		return fmt.Errorf("nothing to print")
+	}
+	if !slices.Contains(bannerFills, *fill) {
+		return withCause(errBadInput, fmt.Errorf("invalid --fill %q, use one of %s", *fill, strings.Join(bannerFills, ", ")))
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	f, err := loadFont(*fontName, "font")
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	mask, err := bannerMask(text, f, opts.margins.width())
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	// Turned clockwise, so the first letter comes out first
+	img := imaging.Rotate270(fillMask(mask, *fill, opts.margins.width()))
+	opts.stamp.file = "banner"
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// loadFont returns one of the bannerFonts or the font in a file, and nil
+// for the built-in bitmap font. flagName is the option that named it.
+func loadFont(name, flagName string) (*opentype.Font, error) {
+	if name == "bitmap" {
+		return nil, nil
+	}
+	data, ok := bannerFonts[name]
+	if !ok {
+		var err error
+		if data, err = os.ReadFile(name); err != nil {
+			return nil, fmt.Errorf("invalid --%s %q, use bold, regular, mono, bitmap or a font file", flagName, name)
+		}
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font %s: %v", name, err)
+	}
+	return f, nil
+}
+
+// bannerMask draws text on one line height dots tall, as coverage, in f or
+// the bitmap font if f is nil
+func bannerMask(text string, f *opentype.Font, height int) (*image.Alpha, error) {
+	if f == nil {
+		scale := max(height/glyphHeight, 1)
+		mask := image.NewAlpha(image.Rect(0, 0, textWidth(text)*scale+2*scale, height))
+		drawTextScaled(mask, scale, (height-glyphHeight*scale)/2, text, color.Black, scale)
+		return mask, nil
+	}
+
+	// Fit ascent plus descent, measured at 100 px, into the height
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 100, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	m := face.Metrics()
+	size := 100 * float64(height) / float64((m.Ascent + m.Descent).Ceil())
+	face.Close()
+	if face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone}); err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	pad := height / 16
+	d := font.Drawer{Face: face, Src: image.Opaque}
+	width := d.MeasureString(text).Ceil() + 2*pad
+	d.Dst = image.NewAlpha(image.Rect(0, 0, width, height))
+	d.Dot = fixed.Point26_6{X: fixed.I(pad), Y: face.Metrics().Ascent}
+	d.DrawString(text)
+	return d.Dst.(*image.Alpha), nil
+}
+
+// fillMask turns coverage into black letters on white, solid or as an
+// outline around a pattern. Strokes and pattern scale with the letter size.
+func fillMask(mask *image.Alpha, fill string, height int) *image.Gray {
+	b := mask.Bounds()
+	inside := func(x, y int) bool { return mask.AlphaAt(x, y).A >= 128 }
+	stroke := max(height/48, 2)
+	// Pixels further than stroke from the outside, found by eroding the
+	// letters horizontally and then vertically
+	interior := make([]bool, b.Dx()*b.Dy())
+	if fill != "solid" {
+		across := make([]bool, len(interior))
+		for y := 0; y < b.Dy(); y++ {
+			run := 0
+			for x := 0; x < b.Dx(); x++ {
+				if inside(x, y) {
+					run++
+				} else {
+					run = 0
+				}
+				across[y*b.Dx()+x] = run > stroke
+			}
+			// The run has to reach as far to the right as well
+			run = 0
+			for x := b.Dx() - 1; x >= 0; x-- {
+				if inside(x, y) {
+					run++
+				} else {
+					run = 0
+				}
+				across[y*b.Dx()+x] = across[y*b.Dx()+x] && run > stroke
+			}
+		}
+		for x := 0; x < b.Dx(); x++ {
+			down, up := make([]int, b.Dy()), 0
+			for y := 0; y < b.Dy(); y++ {
+				if across[y*b.Dx()+x] {
+					if y > 0 {
+						down[y] = down[y-1] + 1
+					} else {
+						down[y] = 1
+					}
+				}
+			}
+			for y := b.Dy() - 1; y >= 0; y-- {
+				if across[y*b.Dx()+x] {
+					up++
+				} else {
+					up = 0
+				}
+				interior[y*b.Dx()+x] = down[y] > stroke && up > stroke
+			}
+		}
+	}
+
+	period := max(height/16, 6)
+	img := image.NewGray(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			black := inside(x, y)
+			if black && interior[y*b.Dx()+x] {
+				switch fill {
+				case "outline":
+					black = false
+				case "stripes":
+					black = (x+y)%period < period/3
+				case "dots":
+					dx, dy := x%period-period/2, y%period-period/2
+					black = dx*dx+dy*dy <= period*period/10
+				case "checker":
+					black = (x/period+y/period)%2 == 0
+				}
+			}
+			if black {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,51 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+)
+
+// QR codes and barcodes are drawn with every module a whole number of dots,
+// as anything in between would blur into gray and not scan
+
+// qrImage encodes content as a QR code at most size dots square
+func qrImage(content string, size int) (image.Image, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return nil, fmt.Errorf("can't make a QR code of %q: %v", content, err)
+	}
+	modules := code.Bounds().Dx()
+	if size < modules {
+		return nil, fmt.Errorf("a QR code of %q needs at least %d dots, only %d left", content, modules, size)
+	}
+	n := size / modules * modules
+	return barcode.Scale(code, n, n)
+}
+
+// code128Image encodes content as a Code 128 barcode, height dots tall and
+// with bars as wide as fit in width
+func code128Image(content string, width, height int) (image.Image, error) {
+	code, err := code128.Encode(content)
+	if err != nil {
+		return nil, fmt.Errorf("can't make a barcode of %q: %v", content, err)
+	}
+	modules := code.Bounds().Dx()
+	if width < modules {
+		return nil, fmt.Errorf("a barcode of %q needs at least %d dots, only %d left", content, modules, width)
+	}
+	return barcode.Scale(code, width/modules*modules, height)
+}
@@ -0,0 +1,172 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bleh/drivers"
+)
+
+var benchCmd = &command{
+	name:  "bench",
+	usage: "[--lines N] [--chunks a,b,...] [--delays a,b,...] [--writes norsp,rsp]",
+}
+
+func init() {
+	benchCmd.run = runBench
+	registerCommand(benchCmd)
+}
+
+// benchResult is one measured transfer configuration
+type benchResult struct {
+	ChunkSize   int     `json:"chunk_size"`
+	Delay       string  `json:"delay"`
+	Response    bool    `json:"write_response"`
+	Seconds     float64 `json:"seconds"`
+	BytesPerSec float64 `json:"bytes_per_second"`
+	LinesPerSec float64 `json:"lines_per_second"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// runBench sends a synthetic buffer once per combination of chunk size,
+// delay and write mode, and reports the throughput of each. No print command
+// is sent, so the printer drops the data and no paper is used.
+func runBench(ctx context.Context, args []string) error {
+	fs := benchCmd.flagSet()
+	lines := fs.Int("lines", 200, "Lines of 1bpp data to send per configuration")
+	chunkList := fs.String("chunks", "20,48,96", "Comma-separated chunk sizes in bytes")
+	delayList := fs.String("delays", "0,2ms,6ms,10ms", "Comma-separated pauses after every write")
+	writeList := fs.String("writes", "norsp,rsp", "Write modes: norsp (without response) and/or rsp (with response)")
+	fs.Parse(args)
+
+	if *lines < 1 {
+		return fmt.Errorf("--lines must be positive")
+	}
+	var chunks []int
+	for _, s := range strings.Split(*chunkList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid chunk size %q", s)
+		}
+		chunks = append(chunks, n)
+	}
+	var delays []time.Duration
+	for _, s := range strings.Split(*delayList, ",") {
+		s = strings.TrimSpace(s)
+		if s == "0" {
+			delays = append(delays, 0)
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid delay %q", s)
+		}
+		delays = append(delays, d)
+	}
+	var writes []bool
+	for _, s := range strings.Split(*writeList, ",") {
+		switch strings.TrimSpace(s) {
+		case "norsp":
+			writes = append(writes, false)
+		case "rsp":
+			writes = append(writes, true)
+		default:
+			return fmt.Errorf("invalid write mode %q, use norsp or rsp", s)
+		}
+	}
+
+	c, err := openPrinter(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	dataChr := c.driver.DataChar()
+	if dataChr == nil {
+		return fmt.Errorf("the %s takes image data as commands, there's nothing to benchmark without printing", c.model.Name)
+	}
+
+	// Alternating patterns, in case an adapter compresses or dedups writes
+	lineLen := lineBytes(Mode1bpp)
+	data := make([]byte, *lines*lineLen)
+	for i := range data {
+		data[i] = byte(i*37) ^ 0x55
+	}
+
+	var best *benchResult
+	for _, response := range writes {
+		for _, size := range chunks {
+			for _, delay := range delays {
+				link := drivers.Link{Client: c.client, Transfer: drivers.Transfer{ChunkSize: size, Delay: delay, Response: response}}
+				r := benchResult{ChunkSize: size, Delay: delay.String(), Response: response}
+				start := time.Now()
+				err := link.WriteLines(ctx, dataChr, data, lineLen, 0)
+				elapsed := time.Since(start)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					r.Error = err.Error()
+				} else {
+					r.Seconds = elapsed.Seconds()
+					r.BytesPerSec = float64(len(data)) / elapsed.Seconds()
+					r.LinesPerSec = float64(*lines) / elapsed.Seconds()
+					if best == nil || r.BytesPerSec > best.BytesPerSec {
+						best = &r
+					}
+				}
+				reportBench(r)
+				// Let the adapter and printer drain before the next run
+				if err := sleepCtx(ctx, 500*time.Millisecond); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if best == nil {
+		return withCause(errTransfer, fmt.Errorf("every configuration failed"))
+	}
+	if !jsonOutput {
+		flags := fmt.Sprintf("--chunk-size %d --chunk-delay %s", best.ChunkSize, best.Delay)
+		if best.Response {
+			flags += " --write-response"
+		}
+		fmt.Printf("Fastest: %s\n", flags)
+		log.Println("A fast setting is only worth it if prints still come out complete, so check with a real print.")
+	}
+	return nil
+}
+
+// reportBench prints a result as a table row, or a line of JSON with --json
+func reportBench(r benchResult) {
+	if jsonOutput {
+		json.NewEncoder(os.Stdout).Encode(r)
+		return
+	}
+	mode := "norsp"
+	if r.Response {
+		mode = "rsp"
+	}
+	if r.Error != "" {
+		fmt.Printf("%-5s chunk %3d  delay %-6s  failed: %s\n", mode, r.ChunkSize, r.Delay, r.Error)
+		return
+	}
+	fmt.Printf("%-5s chunk %3d  delay %-6s  %6.2fs  %7.0f bytes/s  %6.1f lines/s\n",
+		mode, r.ChunkSize, r.Delay, r.Seconds, r.BytesPerSec, r.LinesPerSec)
+}
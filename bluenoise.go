@@ -0,0 +1,137 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	dither "github.com/makeworld-the-better-one/dither"
+)
+
+// Blue-noise threshold matrices are generated once per size with Ulichney's
+// void-and-cluster method. The seed is fixed so every run (and every preview)
+// produces exactly the same pattern.
+var (
+	blueNoiseMu    sync.Mutex
+	blueNoiseCache = map[int]dither.OrderedDitherMatrix{}
+)
+
+const blueNoiseSeed = 0x626c6568 // "bleh"
+
+// blueNoiseMatrix returns the size x size void-and-cluster matrix
+func blueNoiseMatrix(size int) dither.OrderedDitherMatrix {
+	blueNoiseMu.Lock()
+	defer blueNoiseMu.Unlock()
+	if m, ok := blueNoiseCache[size]; ok {
+		return m
+	}
+	m := voidAndCluster(size, 1.5, rand.New(rand.NewSource(blueNoiseSeed)))
+	blueNoiseCache[size] = m
+	return m
+}
+
+// voidAndCluster ranks every cell of a size x size toroidal grid so that any
+// prefix of the ranking is as evenly spread out as possible
+func voidAndCluster(size int, sigma float64, rng *rand.Rand) dither.OrderedDitherMatrix {
+	n := size * size
+
+	// Gaussian weight for every toroidal offset
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			wx := float64(min(dx, size-dx))
+			wy := float64(min(dy, size-dy))
+			kernel[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(p int, on bool) {
+		pattern[p] = on
+		px, py := p%size, p/size
+		sign := 1.0
+		if !on {
+			sign = -1.0
+		}
+		for y := 0; y < size; y++ {
+			dy := (y - py + size) % size
+			for x := 0; x < size; x++ {
+				dx := (x - px + size) % size
+				energy[y*size+x] += sign * kernel[dy*size+dx]
+			}
+		}
+	}
+	// tightestCluster finds the set cell with the most set neighbours,
+	// largestVoid the unset cell with the fewest
+	tightestCluster := func() int {
+		best := -1
+		for p := 0; p < n; p++ {
+			if pattern[p] && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for p := 0; p < n; p++ {
+			if !pattern[p] && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// Seed with ~10% random points, then relax them until moving the
+	// tightest cluster into the largest void no longer changes anything
+	ones := max(n/10, 1)
+	for _, p := range rng.Perm(n)[:ones] {
+		toggle(p, true)
+	}
+	for i := 0; i < n; i++ {
+		cluster := tightestCluster()
+		toggle(cluster, false)
+		void := largestVoid()
+		toggle(void, true)
+		if void == cluster {
+			break
+		}
+	}
+	initial := append([]bool(nil), pattern...)
+	initialEnergy := append([]float64(nil), energy...)
+
+	ranks := make([]uint, n)
+
+	// Phase 1: peel points off the initial pattern, densest first
+	for r := ones - 1; r >= 0; r-- {
+		p := tightestCluster()
+		toggle(p, false)
+		ranks[p] = uint(r)
+	}
+
+	// Phases 2 and 3: from the initial pattern, keep filling the largest void
+	copy(pattern, initial)
+	copy(energy, initialEnergy)
+	for r := ones; r < n; r++ {
+		p := largestVoid()
+		toggle(p, true)
+		ranks[p] = uint(r)
+	}
+
+	matrix := make([][]uint, size)
+	for y := range matrix {
+		matrix[y] = ranks[y*size : (y+1)*size]
+	}
+	return dither.OrderedDitherMatrix{Matrix: matrix, Max: uint(n)}
+}
@@ -0,0 +1,398 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var botCmd = &command{
+	name:  "bot",
+	usage: "telegram|matrix|discord --token T [--allow id,...] [--room id] [--api URL] [--text-scale N]",
+}
+
+func init() {
+	botCmd.run = runBot
+	registerCommand(botCmd)
+}
+
+// botOptions are the settings shared by all chat platforms
+type botOptions struct {
+	token     string
+	api       string   // API server, without a trailing slash
+	room      string   // the Matrix room or Discord channel to print from
+	allow     []string // Telegram chats, or Matrix and Discord users, that may print
+	textScale int
+}
+
+func (o botOptions) allowed(id string) bool {
+	return slices.Contains(o.allow, id)
+}
+
+// Default API servers; Matrix has none, every homeserver is its own
+var botAPIs = map[string]string{
+	"telegram": "https://api.telegram.org",
+	"discord":  "https://discord.com/api/v10",
+}
+
+// runBot prints what is sent to a chat bot until interrupted
+func runBot(ctx context.Context, args []string) error {
+	fs := botCmd.flagSet()
+	token := fs.String("token", "", "Bot or access token (default: $BLEH_BOT_TOKEN)")
+	allow := fs.String("allow", "", "Comma-separated Telegram chats, or Matrix or Discord users, allowed to print")
+	room := fs.String("room", "", "Matrix room ID or alias, or Discord channel ID, to print from")
+	textScale := fs.Int("text-scale", 2, "Font scale for text messages")
+	api := fs.String("api", "", "API server: the Matrix homeserver, or another Telegram or Discord API server")
+	fs.Parse(args)
+	// Options may come after the platform too
+	platform := fs.Arg(0)
+	if fs.NArg() > 0 {
+		fs.Parse(fs.Args()[1:])
+	}
+
+	o := botOptions{token: *token, room: *room, textScale: *textScale, api: *api}
+	if o.token == "" {
+		o.token = os.Getenv("BLEH_BOT_TOKEN")
+	}
+	if o.api == "" {
+		o.api = botAPIs[platform]
+	}
+	o.api = strings.TrimSuffix(o.api, "/")
+	for _, id := range strings.Split(*allow, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			o.allow = append(o.allow, id)
+		}
+	}
+	if o.textScale < 1 || o.textScale > 8 {
+		return fmt.Errorf("--text-scale must be between 1 and 8")
+	}
+	if platform != "telegram" && platform != "matrix" && platform != "discord" {
+		fs.Usage()
+		return fmt.Errorf("expected a platform: telegram, matrix or discord")
+	}
+	if o.token == "" {
+		return fmt.Errorf("a token is required, from --token or $BLEH_BOT_TOKEN")
+	}
+
+	switch platform {
+	case "telegram":
+		if len(o.allow) == 0 {
+			log.Println("Warning: no --allow list, every chat will only be told its ID")
+		}
+		return runTelegram(ctx, o)
+	case "matrix":
+		if o.api == "" || o.room == "" {
+			return fmt.Errorf("matrix needs the homeserver as --api and a --room")
+		}
+		return runMatrix(ctx, o)
+	default:
+		if o.room == "" {
+			return fmt.Errorf("discord needs a --room, the ID of the channel to print from")
+		}
+		return runDiscord(ctx, o)
+	}
+}
+
+// botRequest sends a JSON request to a chat API and decodes the JSON reply
+// into result. The token is kept out of errors.
+func botRequest(ctx context.Context, client *http.Client, method, url string, header http.Header, body, result any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := readLimited(resp, "reply")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &botHTTPError{status: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("bad reply: %v", err)
+	}
+	return nil
+}
+
+// botHTTPError is an API request that failed with a status other than 2xx
+type botHTTPError struct {
+	status int
+	body   string
+}
+
+func (e *botHTTPError) Error() string {
+	if len(e.body) > 200 {
+		e.body = e.body[:200] + "..."
+	}
+	return fmt.Sprintf("%d %s: %s", e.status, http.StatusText(e.status), e.body)
+}
+
+// telegramBot talks to the Telegram Bot API by long polling
+type telegramBot struct {
+	api    string // API server, without the bot path
+	token  string
+	client *http.Client
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	MessageID int64 `json:"message_id"`
+	Chat      struct {
+		ID       int64  `json:"id"`
+		Title    string `json:"title"`
+		Username string `json:"username"`
+	} `json:"chat"`
+	From *struct {
+		FirstName string `json:"first_name"`
+		Username  string `json:"username"`
+	} `json:"from"`
+	Text    string `json:"text"`
+	Caption string `json:"caption"`
+	Photo   []struct {
+		FileID string `json:"file_id"`
+		Width  int    `json:"width"`
+	} `json:"photo"`
+	Sticker *struct {
+		FileID     string `json:"file_id"`
+		IsAnimated bool   `json:"is_animated"`
+		IsVideo    bool   `json:"is_video"`
+	} `json:"sticker"`
+	Document *struct {
+		FileID   string `json:"file_id"`
+		MimeType string `json:"mime_type"`
+		FileName string `json:"file_name"`
+	} `json:"document"`
+}
+
+// sender names who sent m, for logs and headers
+func (m *telegramMessage) sender() string {
+	switch {
+	case m.From != nil && m.From.Username != "":
+		return "@" + m.From.Username
+	case m.From != nil:
+		return m.From.FirstName
+	case m.Chat.Title != "":
+		return m.Chat.Title
+	}
+	return strconv.FormatInt(m.Chat.ID, 10)
+}
+
+// call invokes an API method and decodes its result into result
+func (b *telegramBot) call(ctx context.Context, method string, params url.Values, result any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.api+"/bot"+b.token+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The error has the URL in it, and so the token
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("telegram %s failed: %v", method, strings.ReplaceAll(err.Error(), b.token, "<token>"))
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("telegram %s: bad reply: %v", method, err)
+	}
+	if !reply.OK {
+		return fmt.Errorf("telegram %s failed: %s", method, reply.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+func (b *telegramBot) reply(ctx context.Context, m *telegramMessage, text string) {
+	err := b.call(ctx, "sendMessage", url.Values{
+		"chat_id":             {strconv.FormatInt(m.Chat.ID, 10)},
+		"text":                {text},
+		"reply_to_message_id": {strconv.FormatInt(m.MessageID, 10)},
+	}, nil)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// file downloads a file sent to the bot
+func (b *telegramBot) file(ctx context.Context, id string) ([]byte, error) {
+	var f struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := b.call(ctx, "getFile", url.Values{"file_id": {id}}, &f); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.api+"/file/bot"+b.token+"/"+f.FilePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %v", strings.ReplaceAll(err.Error(), b.token, "<token>"))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+	return readLimited(resp, "file")
+}
+
+// runTelegram polls for messages until ctx is done, printing photos,
+// stickers, image files and text from allowed chats
+func runTelegram(ctx context.Context, o botOptions) error {
+	b := &telegramBot{api: o.api, token: o.token, client: &http.Client{Timeout: 60 * time.Second}}
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := b.call(ctx, "getMe", nil, &me); err != nil {
+		return withCause(errConnect, err)
+	}
+	log.Printf("Telegram bot @%s is listening, Ctrl-C to stop", me.Username)
+
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		err := b.call(ctx, "getUpdates", url.Values{
+			"offset":          {strconv.FormatInt(offset, 10)},
+			"timeout":         {"30"},
+			"allowed_updates": {`["message"]`},
+		}, &updates)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Println(err)
+			if sleepCtx(ctx, 10*time.Second) != nil {
+				return nil
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handle(ctx, o, u.Message)
+			}
+		}
+	}
+}
+
+func (b *telegramBot) handle(ctx context.Context, o botOptions, m *telegramMessage) {
+	chat := strconv.FormatInt(m.Chat.ID, 10)
+	if !o.allowed(chat) {
+		log.Printf("Ignoring a message from chat %s (%s), not in --allow", chat, m.sender())
+		b.reply(ctx, m, fmt.Sprintf("This chat may not print. Its ID is %s, to add to --allow.", chat))
+		return
+	}
+	if reply, ok := botCommand(ctx, m.Text); ok {
+		b.reply(ctx, m, reply)
+		return
+	}
+
+	var fileID string
+	switch {
+	case len(m.Photo) > 0:
+		// Sizes come smallest first
+		fileID = m.Photo[len(m.Photo)-1].FileID
+	case m.Sticker != nil && !m.Sticker.IsAnimated && !m.Sticker.IsVideo:
+		fileID = m.Sticker.FileID
+	case m.Document != nil && strings.HasPrefix(m.Document.MimeType, "image/"):
+		fileID = m.Document.FileID
+	case m.Text != "":
+		if err := printReceivedText(ctx, m.Text, o.textScale, m.sender()); err != nil {
+			b.reply(ctx, m, "Printing failed: "+err.Error())
+			return
+		}
+		b.reply(ctx, m, "Printed.")
+		return
+	default:
+		b.reply(ctx, m, "Only photos, still stickers, image files and text can be printed.")
+		return
+	}
+
+	data, err := b.file(ctx, fileID)
+	if err == nil {
+		err = printReceivedData(ctx, data, m.Caption, o.textScale, m.sender())
+	}
+	if err != nil {
+		log.Printf("Message from %s: %v", m.sender(), err)
+		b.reply(ctx, m, "Printing failed: "+err.Error())
+		return
+	}
+	b.reply(ctx, m, "Printed.")
+}
+
+// botCommand answers the commands every bot understands, /status and
+// /help, and tells whether text was one of them
+func botCommand(ctx context.Context, text string) (string, bool) {
+	cmd, _, _ := strings.Cut(strings.TrimSpace(text), " ")
+	// Telegram appends the bot name in groups, /status@my_bot
+	cmd, _, _ = strings.Cut(cmd, "@")
+	switch cmd {
+	case "/status":
+		receivedMu.Lock()
+		defer receivedMu.Unlock()
+		s, err := queryStatus(ctx)
+		if err != nil {
+			return "Printer not reachable: " + err.Error(), true
+		}
+		return s.String(), true
+	case "/start", "/help":
+		return "Send a photo, sticker, image file or text and it gets printed. /status shows the printer status.", true
+	}
+	return "", false
+}
@@ -0,0 +1,201 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	wedgeBandHeight = 48 // lines per step, including the gap below it
+	wedgeGap        = 6
+	wedgeLabelWidth = 48
+)
+
+var calibrateCmd = &command{
+	name:  "calibrate",
+	usage: "[--steps N] [--scan scan.png | --densities d0,d1,...]",
+}
+
+func init() {
+	calibrateCmd.run = runCalibrate
+	registerCommand(calibrateCmd)
+}
+
+// runCalibrate prints a step wedge with the current settings and turns
+// measurements of it into a tone curve. Measurements are either typed in
+// (densitometer readings), passed with --densities, or taken from a scan of
+// the printed wedge with --scan.
+func runCalibrate(ctx context.Context, args []string) error {
+	fs := calibrateCmd.flagSet()
+	steps := fs.Int("steps", 11, "Number of patches in the step wedge")
+	scanPath := fs.String("scan", "", "Scan of the printed wedge, cropped to the patches")
+	densityList := fs.String("densities", "", "Comma-separated optical densities, lightest patch first")
+	savePath := fs.String("save", "", "Where to save the curve (default: config dir, per printer and mode)")
+	fs.Parse(args)
+
+	if *steps < 2 || *steps > 64 {
+		return fmt.Errorf("--steps must be between 2 and 64")
+	}
+
+	var darkness []float64
+	var err error
+	switch {
+	case *scanPath != "":
+		darkness, err = measureWedgeScan(*scanPath, *steps)
+	case *densityList != "":
+		darkness, err = parseDensities(strings.Split(*densityList, ","))
+	default:
+		if err := printWedge(ctx, *steps); err != nil {
+			return err
+		}
+		if outputPath != "" {
+			return nil // preview only, nothing to measure
+		}
+		darkness, err = promptDensities(*steps)
+	}
+	if err != nil {
+		return err
+	}
+	if len(darkness) != *steps {
+		return fmt.Errorf("expected %d measurements, got %d", *steps, len(darkness))
+	}
+
+	curve, err := buildToneCurve(darkness)
+	if err != nil {
+		return err
+	}
+	curve.Printer = printerKey()
+	curve.Mode = mode
+
+	path := *savePath
+	if path == "" {
+		if path, err = defaultCurvePath(mode); err != nil {
+			return err
+		}
+	}
+	if err := saveToneCurve(curve, path); err != nil {
+		return fmt.Errorf("failed to save tone curve: %v", err)
+	}
+	log.Printf("Tone curve saved to %s", path)
+	return nil
+}
+
+// printWedge prints evenly spaced gray patches from white to black with the
+// current settings, bypassing any existing calibration
+func printWedge(ctx context.Context, steps int) error {
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	opts.curve = nil
+	opts.header, opts.footer = "", ""
+
+	img := imaging.New(linePixels, steps*wedgeBandHeight, color.White)
+	for i := 0; i < steps; i++ {
+		y := i * wedgeBandHeight
+		gray := wedgeGray(i, steps)
+		patch := image.Rect(wedgeLabelWidth, y, linePixels, y+wedgeBandHeight-wedgeGap)
+		for py := patch.Min.Y; py < patch.Max.Y; py++ {
+			for px := patch.Min.X; px < patch.Max.X; px++ {
+				img.Set(px, py, color.Gray{Y: gray})
+			}
+		}
+		drawText(img, 4, y+(wedgeBandHeight-wedgeGap-glyphHeight)/2, strconv.Itoa(i+1), color.Black)
+	}
+
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// wedgeGray is the input value of patch i, going from white to black
+func wedgeGray(i, steps int) uint8 {
+	return uint8(255 - math.Round(255*float64(i)/float64(steps-1)))
+}
+
+func promptDensities(steps int) ([]float64, error) {
+	fmt.Println("Measure the optical density of each patch, starting with the lightest.")
+	in := bufio.NewScanner(os.Stdin)
+	values := make([]string, 0, steps)
+	for i := 0; i < steps; i++ {
+		fmt.Printf("Patch %d (%d%% black): ", i+1, 100*i/(steps-1))
+		if !in.Scan() {
+			return nil, fmt.Errorf("input ended after %d measurements", i)
+		}
+		values = append(values, in.Text())
+	}
+	return parseDensities(values)
+}
+
+// parseDensities converts optical densities to darkness, i.e. the fraction of
+// light absorbed by the print
+func parseDensities(values []string) ([]float64, error) {
+	darkness := make([]float64, len(values))
+	for i, v := range values {
+		d, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid density %q", v)
+		}
+		darkness[i] = 1 - math.Pow(10, -d)
+	}
+	return darkness, nil
+}
+
+// measureWedgeScan averages the middle of every patch of a scanned wedge. The
+// scan must be upright and cropped to the wedge, with its bands stacked from
+// the top like they come out of the printer.
+func measureWedgeScan(path string, steps int) ([]float64, error) {
+	img, err := decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+	gray := toGray(img)
+	w, h := gray.Bounds().Dx(), gray.Bounds().Dy()
+	band := float64(h) / float64(steps)
+	patchFrac := float64(wedgeBandHeight-wedgeGap) / wedgeBandHeight
+
+	darkness := make([]float64, steps)
+	for i := 0; i < steps; i++ {
+		top := float64(i) * band
+		y0 := int(top + band*patchFrac*0.25)
+		y1 := int(top + band*patchFrac*0.75)
+		x0 := int(float64(w) * (float64(wedgeLabelWidth)/float64(linePixels) + 0.1))
+		x1 := int(float64(w) * 0.95)
+
+		var sum float64
+		var n int
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				sum += srgbToLinear(gray.Pix[y*gray.Stride+x])
+				n++
+			}
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("scan is too small to measure %d patches", steps)
+		}
+		darkness[i] = 1 - sum/float64(n)
+	}
+	return darkness, nil
+}
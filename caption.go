@@ -0,0 +1,73 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font/opentype"
+)
+
+// captionStyle is the --caption line and the type it is set in
+type captionStyle struct {
+	text string
+	font *opentype.Font // nil for the built-in bitmap font
+	size int            // height of the line in dots
+}
+
+// captionFromFlags loads the --caption-font, if there is a caption at all
+func captionFromFlags() (captionStyle, error) {
+	c := captionStyle{text: captionText, size: captionSize}
+	if c.text == "" {
+		return c, nil
+	}
+	if c.size < glyphHeight {
+		return c, fmt.Errorf("--caption-size must be at least %d", glyphHeight)
+	}
+	var err error
+	c.font, err = loadFont(captionFont, "caption-font")
+	return c, err
+}
+
+// addCaption puts the caption, with the header placeholders filled in, on a
+// line of its own centered beneath img. A caption too long for the width is
+// set smaller, down to the size of the bitmap font, and cut off after that.
+func addCaption(img image.Image, c captionStyle, info stampInfo) image.Image {
+	text := strings.ReplaceAll(expandStamp(c.text, info, time.Now()), "\n", " ")
+	if strings.TrimSpace(text) == "" {
+		return img
+	}
+	width := img.Bounds().Dx()
+	size := c.size
+	mask, err := bannerMask(text, c.font, size)
+	for err == nil && mask.Rect.Dx() > width && size > glyphHeight {
+		size = max(glyphHeight, size*width/mask.Rect.Dx())
+		mask, err = bannerMask(text, c.font, size)
+	}
+	if err != nil {
+		log.Printf("Failed to set the caption: %v", err)
+		return img
+	}
+
+	gap := max(stampPadding, size/4)
+	line := imaging.New(width, size+2*gap, color.White)
+	x := max(0, (width-mask.Rect.Dx())/2)
+	r := image.Rect(x, gap, x+mask.Rect.Dx(), gap+size)
+	draw.DrawMask(line, r, image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+	return stackImages([]image.Image{img, line})
+}
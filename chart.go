@@ -0,0 +1,389 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var chartCmd = &command{
+	name:  "chart",
+	usage: "[--type line|bar] [--height L] [--title T] [--sparkline] <data.csv or ->",
+}
+
+func init() {
+	chartCmd.run = runChart
+	registerCommand(chartCmd)
+}
+
+// chartSeries is a column of a chart's CSV file. Missing values are NaN.
+type chartSeries struct {
+	name   string
+	values []float64
+}
+
+const (
+	chartDefaultHeight   = 40 // mm
+	sparklineHeight      = 8  // mm
+	chartTickLength      = 4
+	chartLegendSwatch    = 24 // dots
+	chartMaxSeriesStyles = 4
+)
+
+// runChart draws the numeric columns of a CSV file as a line or bar chart
+// against the first column
+func runChart(ctx context.Context, args []string) error {
+	fs := chartCmd.flagSet()
+	kind := fs.String("type", "line", "line or bar")
+	heightFlag := fs.String("height", "", fmt.Sprintf("Height of the chart, in mm or dots (default %dmm, %dmm with --sparkline)", chartDefaultHeight, sparklineHeight))
+	title := fs.String("title", "", "Title printed above the chart")
+	sparkline := fs.Bool("sparkline", false, "Only the lines, without axes, labels or legend")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one CSV file")
+	}
+	if *kind != "line" && *kind != "bar" {
+		return withCause(errBadInput, fmt.Errorf("invalid --type %q, use line or bar", *kind))
+	}
+	height := int(math.Round(chartDefaultHeight * dpi / 25.4))
+	if *sparkline {
+		height = int(math.Round(sparklineHeight * dpi / 25.4))
+	}
+	if *heightFlag != "" {
+		var err error
+		if height, err = parseLength(*heightFlag); err != nil {
+			return withCause(errBadInput, err)
+		}
+	}
+	if height < 2*glyphHeight {
+		return withCause(errBadInput, fmt.Errorf("--height must be at least %d dots", 2*glyphHeight))
+	}
+
+	records, err := readCSV(fs.Arg(0))
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	labels, series := chartData(records)
+	if len(series) == 0 {
+		return withCause(errBadInput, fmt.Errorf("%s has no numeric columns after the first", fs.Arg(0)))
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	width := opts.margins.width()
+	var img image.Image
+	if *sparkline {
+		img = drawSparkline(series, width, height)
+	} else {
+		img = drawChart(*kind, labels, series, width, height)
+	}
+	if *title != "" {
+		img = stackImages([]image.Image{textImage(*title, width, 2), img})
+	}
+	opts.stamp.file = sourceName(fs.Arg(0))
+	pixels, h, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, h, printMode)
+}
+
+// chartData splits CSV records into the labels of the first column and a
+// series for every other column with numbers in it
+func chartData(records [][]string) ([]string, []chartSeries) {
+	header, rows := records[0], records[1:]
+	labels := make([]string, len(rows))
+	for i, row := range rows {
+		if len(row) > 0 {
+			labels[i] = strings.TrimSpace(row[0])
+		}
+	}
+	var series []chartSeries
+	for col := 1; col < len(header); col++ {
+		s := chartSeries{name: strings.TrimSpace(header[col]), values: make([]float64, len(rows))}
+		numbers := 0
+		for i, row := range rows {
+			s.values[i] = math.NaN()
+			if col < len(row) {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(row[col]), 64); err == nil {
+					s.values[i] = v
+					numbers++
+				}
+			}
+		}
+		if numbers > 0 {
+			series = append(series, s)
+		}
+	}
+	return labels, series
+}
+
+// chartRange is the span of all values, with 0 in it for bar charts, which
+// grow from 0
+func chartRange(series []chartSeries, fromZero bool) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	if fromZero {
+		lo, hi = 0, 0
+	}
+	for _, s := range series {
+		for _, v := range s.values {
+			if !math.IsNaN(v) {
+				lo, hi = min(lo, v), max(hi, v)
+			}
+		}
+	}
+	if lo == hi {
+		lo, hi = lo-1, hi+1
+	}
+	return lo, hi
+}
+
+// niceStep is a round step, 1, 2 or 5 times a power of ten, that splits
+// span into about n parts
+func niceStep(span float64, n int) float64 {
+	raw := span / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if m*mag >= raw {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// formatTick prints a tick value with as many decimals as the step needs
+func formatTick(v, step float64) string {
+	decimals := max(0, -int(math.Floor(math.Log10(step))))
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// chartCanvas is an image being drawn with thick, patterned strokes
+type chartCanvas struct {
+	*image.Gray
+}
+
+func (c chartCanvas) rect(x0, y0, x1, y1 int) {
+	draw.Draw(c, image.Rect(x0, y0, x1, y1), image.Black, image.Point{}, draw.Src)
+}
+
+// line draws from (x0, y0) to (x1, y1) in a 2 dot pen. The style is the
+// series number: solid, dashed, dotted and dash-dotted, along the way
+// counted by step.
+func (c chartCanvas) line(x0, y0, x1, y1 int, style int, step *int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		if chartPenDown(style, *step) {
+			c.rect(x0, y0, x0+2, y0+2)
+		}
+		*step++
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func chartPenDown(style, step int) bool {
+	switch style % chartMaxSeriesStyles {
+	case 1:
+		return step%14 < 9
+	case 2:
+		return step%6 < 2
+	case 3:
+		return step%20 < 10 || (step%20 >= 14 && step%20 < 16)
+	}
+	return true
+}
+
+// bar fills a bar with the pattern of series style: solid, hatched, dotted
+// or empty, all with an outline
+func (c chartCanvas) bar(x0, y0, x1, y1 int, style int) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			edge := x < x0+2 || x >= x1-2 || y < y0+2 || y >= y1-2
+			var fill bool
+			switch style % chartMaxSeriesStyles {
+			case 0:
+				fill = true
+			case 1:
+				fill = (x+y)%6 < 2
+			case 2:
+				fill = x%4 == 0 && y%4 == 0
+			}
+			if edge || fill {
+				c.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// drawChart draws a chart with a legend, a value axis with round ticks and
+// as many of the labels as fit under the plot, width by height dots
+func drawChart(kind string, labels []string, series []chartSeries, width, height int) image.Image {
+	lo, hi := chartRange(series, kind == "bar")
+	step := niceStep(hi-lo, max(height/(3*glyphHeight), 2))
+	lo, hi = math.Floor(lo/step)*step, math.Ceil(hi/step)*step
+
+	// Legend: one row per series with its line or bar style
+	var bands []image.Image
+	for i, s := range series {
+		band := chartCanvas{image.NewGray(image.Rect(0, 0, width, glyphHeight+4))}
+		draw.Draw(band, band.Bounds(), image.White, image.Point{}, draw.Src)
+		mid := (glyphHeight + 4) / 2
+		if kind == "bar" {
+			band.bar(0, 2, chartLegendSwatch, glyphHeight+2, i)
+		} else {
+			var n int
+			band.line(0, mid-1, chartLegendSwatch, mid-1, i, &n)
+		}
+		drawText(band, chartLegendSwatch+6, 2, s.name, color.Black)
+		bands = append(bands, band)
+	}
+
+	// The plot area leaves room for value labels on the left and labels
+	// below
+	yLabels := map[float64]string{}
+	labelWidth := 0
+	for v := lo; v <= hi+step/2; v += step {
+		yLabels[v] = formatTick(v, step)
+		labelWidth = max(labelWidth, textWidth(yLabels[v]))
+	}
+	left := labelWidth + chartTickLength + 2
+	top := glyphHeight / 2
+	bottom := height - glyphHeight - chartTickLength - 2
+	right := width - 2
+	c := chartCanvas{image.NewGray(image.Rect(0, 0, width, height))}
+	draw.Draw(c, c.Bounds(), image.White, image.Point{}, draw.Src)
+	yOf := func(v float64) int {
+		return bottom - int(math.Round((v-lo)/(hi-lo)*float64(bottom-top)))
+	}
+
+	c.rect(left, top, left+2, bottom+2)
+	for v, label := range yLabels {
+		y := yOf(v)
+		c.rect(left-chartTickLength, y, left, y+1)
+		drawText(c, left-chartTickLength-2-textWidth(label), y-glyphHeight/2, label, color.Black)
+	}
+	c.rect(left, yOf(max(lo, 0)), right, yOf(max(lo, 0))+2)
+	if lo < 0 && kind == "line" {
+		c.rect(left, bottom, right, bottom+2)
+	}
+
+	n := len(labels)
+	slot := float64(right-left-2) / float64(n)
+	xOf := func(i int) int { return left + 2 + int(slot*(float64(i)+0.5)) }
+	switch kind {
+	case "line":
+		for si, s := range series {
+			step := 0
+			prev := -1
+			for i, v := range s.values {
+				if math.IsNaN(v) {
+					prev = -1
+					continue
+				}
+				if prev >= 0 {
+					c.line(xOf(prev), yOf(s.values[prev]), xOf(i), yOf(v), si, &step)
+				} else {
+					c.rect(xOf(i)-1, yOf(v)-1, xOf(i)+2, yOf(v)+2)
+				}
+				prev = i
+			}
+		}
+	case "bar":
+		barWidth := max(int(slot*0.8)/len(series), 3)
+		for si, s := range series {
+			for i, v := range s.values {
+				if math.IsNaN(v) {
+					continue
+				}
+				x := xOf(i) - barWidth*len(series)/2 + si*barWidth
+				y0, y1 := yOf(v), yOf(max(lo, 0))
+				if y0 > y1 {
+					y0, y1 = y1, y0
+				}
+				c.bar(x, y0, x+barWidth-1, y1+2, si)
+			}
+		}
+	}
+
+	// Labels along the bottom, skipping as many as needed to keep them
+	// from running into each other
+	widest := 0
+	for _, l := range labels {
+		widest = max(widest, textWidth(l))
+	}
+	every := max(int(math.Ceil(float64(widest+glyphWidth)/slot)), 1)
+	for i := 0; i < n; i += every {
+		x := xOf(i)
+		c.rect(x, bottom+2, x+1, bottom+2+chartTickLength)
+		lx := min(max(x-textWidth(labels[i])/2, 0), width-textWidth(labels[i]))
+		drawText(c, lx, bottom+chartTickLength+2, labels[i], color.Black)
+	}
+	return stackImages(append(bands, c))
+}
+
+// drawSparkline draws just the lines of the series, filling width by height
+// dots
+func drawSparkline(series []chartSeries, width, height int) image.Image {
+	lo, hi := chartRange(series, false)
+	c := chartCanvas{image.NewGray(image.Rect(0, 0, width, height))}
+	draw.Draw(c, c.Bounds(), image.White, image.Point{}, draw.Src)
+	for si, s := range series {
+		n := len(s.values)
+		xOf := func(i int) int { return int(math.Round(float64(i) * float64(width-3) / float64(max(n-1, 1)))) }
+		yOf := func(v float64) int { return height - 3 - int(math.Round((v-lo)/(hi-lo)*float64(height-3))) }
+		step, prev := 0, -1
+		for i, v := range s.values {
+			if math.IsNaN(v) {
+				prev = -1
+				continue
+			}
+			if prev >= 0 {
+				c.line(xOf(prev), yOf(s.values[prev]), xOf(i), yOf(v), si, &step)
+			}
+			prev = i
+		}
+	}
+	return c
+}
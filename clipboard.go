@@ -0,0 +1,156 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var clipboardCmd = &command{
+	name:  "clipboard",
+	usage: "[--text-scale N]",
+}
+
+func init() {
+	clipboardCmd.run = runClipboard
+	registerCommand(clipboardCmd)
+}
+
+// runClipboard prints the image on the clipboard, or its text if there is no
+// image. wl-paste is used on Wayland and xclip on X11.
+func runClipboard(ctx context.Context, args []string) error {
+	fs := clipboardCmd.flagSet()
+	textScale := fs.Int("text-scale", 2, "Font scale for clipboard text")
+	fs.Parse(args)
+
+	if *textScale < 1 || *textScale > 8 {
+		return fmt.Errorf("--text-scale must be between 1 and 8")
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+
+	img, err := readClipboard(opts.margins.width(), *textScale)
+	if err != nil {
+		return err
+	}
+	opts.stamp.file = "clipboard"
+	printMode, opts = resolveAutoMode(img, printMode, opts)
+
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// clipboardTool is a clipboard reader with its arguments for listing the
+// offered types and for reading one of them
+type clipboardTool struct {
+	name string
+	list []string
+	get  func(mime string) []string
+}
+
+var (
+	wlPaste = clipboardTool{
+		name: "wl-paste",
+		list: []string{"--list-types"},
+		get:  func(mime string) []string { return []string{"--no-newline", "--type", mime} },
+	}
+	xclip = clipboardTool{
+		name: "xclip",
+		list: []string{"-selection", "clipboard", "-o", "-t", "TARGETS"},
+		get:  func(mime string) []string { return []string{"-selection", "clipboard", "-o", "-t", mime} },
+	}
+)
+
+func findClipboardTool() (clipboardTool, error) {
+	var candidates []clipboardTool
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, wlPaste)
+	}
+	if os.Getenv("DISPLAY") != "" {
+		candidates = append(candidates, xclip)
+	}
+	for _, t := range candidates {
+		if _, err := exec.LookPath(t.name); err == nil {
+			return t, nil
+		}
+	}
+	if len(candidates) == 0 {
+		return clipboardTool{}, fmt.Errorf("no graphical session found (neither WAYLAND_DISPLAY nor DISPLAY is set)")
+	}
+	return clipboardTool{}, fmt.Errorf("no clipboard tool found, install wl-clipboard (Wayland) or xclip (X11)")
+}
+
+func (t clipboardTool) run(args []string) ([]byte, error) {
+	out, err := exec.Command(t.name, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", t.name, err)
+	}
+	return out, nil
+}
+
+// readClipboard returns the clipboard image, or its text rendered width dots
+// wide at textScale
+func readClipboard(width, textScale int) (image.Image, error) {
+	tool, err := findClipboardTool()
+	if err != nil {
+		return nil, err
+	}
+	types, err := tool.run(tool.list)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageType, textType string
+	for _, mime := range strings.Fields(string(types)) {
+		switch {
+		case mime == "image/png":
+			imageType = mime
+		case strings.HasPrefix(mime, "image/") && imageType == "":
+			imageType = mime
+		case mime == "text/plain;charset=utf-8" || mime == "UTF8_STRING":
+			textType = mime
+		case (mime == "text/plain" || mime == "STRING") && textType == "":
+			textType = mime
+		}
+	}
+
+	switch {
+	case imageType != "":
+		data, err := tool.run(tool.get(imageType))
+		if err != nil {
+			return nil, err
+		}
+		return decodeImageFromReader(bytes.NewReader(data))
+	case textType != "":
+		data, err := tool.run(tool.get(textType))
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimRight(string(data), "\n")
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("clipboard is empty")
+		}
+		return textImage(text, width, textScale), nil
+	}
+	return nil, fmt.Errorf("clipboard holds neither an image nor text")
+}
@@ -0,0 +1,63 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+const collageGutter = 8 // blank dots between cells, both ways
+
+// collageImages places the images in rows of perRow equal cells, left to
+// right and top to bottom, and renders the sheet as a single print. Each
+// image gets its own header and footer; the margins go around the sheet.
+// --mode auto decides for the whole sheet.
+func collageImages(paths []string, perRow int, printMode PrintMode, opts imageOptions) ([]byte, int, PrintMode, error) {
+	cellWidth := (opts.margins.width() - collageGutter*(perRow-1)) / perRow
+
+	var rows []image.Image
+	var row []image.Image
+	for i, path := range paths {
+		img, err := decodeImage(path)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		opts.stamp = stampFor(path, i+1, len(paths))
+		row = append(row, layoutContent(img, cellWidth, opts))
+		if len(row) == perRow || i == len(paths)-1 {
+			if len(rows) > 0 {
+				rows = append(rows, imaging.New(opts.margins.width(), collageGutter, color.White))
+			}
+			rows = append(rows, collageRow(row, opts.margins.width(), cellWidth))
+			row = nil
+		}
+	}
+	sheet := applyMargins(stackImages(rows), opts.margins)
+	printMode, opts = resolveAutoMode(sheet, printMode, opts)
+	pixels, height, err := renderImage(sheet, printMode, opts)
+	return pixels, height, printMode, err
+}
+
+// collageRow pastes cells side by side, top aligned, on a white strip
+func collageRow(cells []image.Image, width, cellWidth int) image.Image {
+	height := 0
+	for _, c := range cells {
+		height = max(height, c.Bounds().Dy())
+	}
+	dst := imaging.New(width, height, color.White)
+	for i, c := range cells {
+		dst = imaging.Paste(dst, c, image.Pt(i*(cellWidth+collageGutter), 0))
+	}
+	return dst
+}
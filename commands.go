@@ -0,0 +1,55 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is a subcommand, invoked as "bleh [options] <name> [command options]"
+type command struct {
+	name  string
+	usage string // one line, shown after the command name in -h output
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func registerCommand(c *command) {
+	commands[c.name] = c
+}
+
+// flagSet returns a flag set for the command. All global options are
+// registered on it too, so they work both before and after the command name.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] %s %s\n\nCommand options:\n", os.Args[0], c.name, c.usage)
+		fs.VisitAll(func(f *flag.Flag) {
+			if flag.Lookup(f.Name) != nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "  --%-18s %s", f.Name, f.Usage)
+			if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+				fmt.Fprintf(os.Stderr, " (default %s)", f.DefValue)
+			}
+			fmt.Fprintln(os.Stderr)
+		})
+		fmt.Fprintf(os.Stderr, "\nGlobal options are also accepted, see %s -h\n", os.Args[0])
+	}
+	return fs
+}
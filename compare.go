@@ -0,0 +1,96 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const compareLabelHeight = glyphHeight + 8
+
+var compareCmd = &command{
+	name:  "compare",
+	usage: "[--methods a,b,...] [--max-height N] <image_path or ->",
+}
+
+func init() {
+	compareCmd.run = runCompare
+	registerCommand(compareCmd)
+}
+
+// runCompare prints the same image once per dither method, each segment
+// labeled with the method name, as a single job
+func runCompare(ctx context.Context, args []string) error {
+	fs := compareCmd.flagSet()
+	methods := fs.String("methods", "", "Comma-separated dither methods to compare (default: all)")
+	maxHeight := fs.Int("max-height", 200, "Crop each segment to at most this many lines, 0 for no limit")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one image")
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	opts.header, opts.footer = "", ""
+
+	names := ditherNames
+	if *methods != "" {
+		names = strings.Split(*methods, ",")
+	}
+
+	img, err := decodeImage(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	img = imaging.Resize(img, linePixels, 0, imaging.Lanczos)
+	if *maxHeight > 0 && img.Bounds().Dy() > *maxHeight {
+		img = imaging.CropCenter(img, linePixels, *maxHeight)
+	}
+
+	var pixels []byte
+	height := 0
+	for _, name := range names {
+		name = strings.TrimSpace(filepath.Base(name))
+		label := imaging.New(linePixels, compareLabelHeight, color.White)
+		drawText(label, 4, 4, name, color.Black)
+		pixels = append(pixels, packLabel(label, printMode, opts.tone)...)
+		height += compareLabelHeight
+
+		opts.ditherType = name
+		segment, segmentHeight, err := processImage(img, printMode, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		pixels = append(pixels, segment...)
+		height += segmentHeight
+	}
+
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// packLabel packs a black on white image directly, skipping processing
+func packLabel(img image.Image, printMode PrintMode, tone toneSpace) []byte {
+	if printMode == Mode4bpp {
+		return pack4Bit(img, tone, nil)
+	}
+	return packMono(img)
+}
@@ -0,0 +1,77 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+const separatorHeight = 24
+
+// separatorStyles draws the band placed between images with --concat; nil
+// means no band at all
+var separatorStyles = map[string]func() image.Image{
+	"none":   nil,
+	"space":  func() image.Image { return imaging.New(linePixels, separatorHeight, color.White) },
+	"line":   func() image.Image { return separatorLine(linePixels) },
+	"dashed": func() image.Image { return separatorLine(12) },
+}
+
+// separatorLine draws a 2-dot rule across the middle of a separator band,
+// broken into dashes of the given length
+func separatorLine(dash int) image.Image {
+	img := imaging.New(linePixels, separatorHeight, color.White)
+	y := separatorHeight/2 - 1
+	for x := 0; x < linePixels; x++ {
+		if (x/dash)%2 == 1 {
+			continue
+		}
+		img.Set(x, y, color.Black)
+		img.Set(x, y+1, color.Black)
+	}
+	return img
+}
+
+// concatImages lays out every image on its own, stacks them with separators
+// in between and renders the result as a single print, so only the whole
+// strip gets padded to the minimum length. --mode auto decides for the
+// whole strip.
+func concatImages(paths []string, printMode PrintMode, opts imageOptions) ([]byte, int, PrintMode, error) {
+	srcs := make([]sourceImage, len(paths))
+	for i, path := range paths {
+		img, err := decodeImage(path)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		srcs[i] = sourceImage{name: sourceName(path), img: img, taken: captureTime(path)}
+	}
+	return concatSources(srcs, printMode, opts)
+}
+
+// concatSources is concatImages for images already decoded
+func concatSources(srcs []sourceImage, printMode PrintMode, opts imageOptions) ([]byte, int, PrintMode, error) {
+	sep := separatorStyles[opts.separator]
+	parts := make([]image.Image, 0, 2*len(srcs))
+	for i, src := range srcs {
+		if i > 0 && sep != nil {
+			parts = append(parts, sep())
+		}
+		opts.stamp = stampInfo{file: src.name, page: i + 1, pages: len(srcs), taken: src.taken}
+		parts = append(parts, layoutImage(src.img, opts))
+	}
+	strip := stackImages(parts)
+	printMode, opts = resolveAutoMode(strip, printMode, opts)
+	pixels, height, err := renderImage(strip, printMode, opts)
+	return pixels, height, printMode, err
+}
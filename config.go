@@ -0,0 +1,45 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configDir returns bleh's directory inside the user config directory
+// (usually ~/.config/bleh), creating it if needed
+func configDir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, "bleh")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// printerKey names per-printer state files. Printers picked by MAC address
+// get their own files, everything else shares "default".
+func printerKey() string {
+	return printerKeyFor(address)
+}
+
+// printerKeyFor is printerKey for the printer at addr
+func printerKeyFor(addr string) string {
+	if addr == "" {
+		return "default"
+	}
+	return strings.ToLower(strings.ReplaceAll(addr, ":", ""))
+}
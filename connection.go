@@ -0,0 +1,412 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bleh/drivers"
+
+	ble "github.com/go-ble/ble"
+)
+
+// keepAliveInterval is how often a kept connection asks for the status, which
+// stops the printer from dropping an idle link or powering off
+const keepAliveInterval = 20 * time.Second
+
+// printerConn is a connection to the printer, subscribed to its notifications
+type printerConn struct {
+	client  ble.Client
+	model   *drivers.Model
+	driver  drivers.Driver
+	replies chan printerStatus // status replies
+	done    chan struct{}      // finished prints
+	counts  chan drivers.Count // counter replies
+	errs    chan string        // errors the printer pushed
+	quiet   atomic.Bool        // don't print what the printer says
+	lock    *printerLock       // held while connected
+}
+
+// onUnknownNotification gets the notifications the driver doesn't
+// understand. The default logs them; replace it to collect them instead.
+var onUnknownNotification = func(c *printerConn, data []byte) {
+	log.Printf("Unknown notification from the %s: % X", c.model.Name, data)
+}
+
+// openPrinter connects to the printer and subscribes to its notifications.
+// Status replies and finished prints are passed on through the channels,
+// anything else the printer says is printed.
+func openPrinter(ctx context.Context) (*printerConn, error) {
+	lock := &printerLock{}
+	if err := lock.take(ctx, printerKeyFor(printerAddress(ctx))); err != nil {
+		return nil, err
+	}
+	client, model, err := loadPrinter(ctx, lock)
+	if err != nil {
+		lock.unlock()
+		return nil, err
+	}
+	driver, err := drivers.Connect(client, model, transferFromFlags())
+	if err != nil {
+		client.CancelConnection()
+		lock.unlock()
+		return nil, withCause(errConnect, err)
+	}
+
+	c := &printerConn{
+		client:  client,
+		model:   model,
+		driver:  driver,
+		replies: make(chan printerStatus, 1),
+		done:    make(chan struct{}, 1),
+		counts:  make(chan drivers.Count, 1),
+		errs:    make(chan string, 1),
+		lock:    lock,
+	}
+	err = subToNotifs(client, driver.NotifyChar(), func(data []byte) {
+		if traceProtocol {
+			log.Printf("<- % X", data)
+		}
+		n := driver.Decode(data)
+		if n.Unknown {
+			onUnknownNotification(c, data)
+			return
+		}
+		if n.Error != "" {
+			select {
+			case c.errs <- n.Error:
+			default:
+			}
+		}
+		if n.Status != nil {
+			select {
+			case c.replies <- statusFrom(*n.Status):
+			default:
+			}
+		} else if n.Text != "" && !c.quiet.Load() {
+			fmt.Println(n.Text)
+		}
+		if n.Count != nil {
+			select {
+			case c.counts <- *n.Count:
+			default:
+			}
+		}
+		if n.Done {
+			select {
+			case c.done <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to subscribe to notifications: %v", err)
+	}
+	return c, nil
+}
+
+// send hands a job to the driver, once sure the printer can print it. Jobs
+// longer than the model takes are refused, unless --force sends them as
+// they are.
+func (c *printerConn) send(ctx context.Context, job printJob, pause time.Duration) error {
+	switch {
+	case job.mode == Mode4bpp && !c.model.Gray && mode != "auto":
+		return withCause(errBadInput, fmt.Errorf("the %s only prints 1bpp", c.model.Name))
+	case job.mode == Mode4bpp && !c.model.Gray:
+		// --mode auto picked 4bpp before the printer was known
+		log.Printf("The %s only prints 1bpp, printing in 1bpp", c.model.Name)
+		job.pixels, job.mode = grayTo1bpp(job.pixels, job.height), Mode1bpp
+	}
+	if linePixels > c.model.Width {
+		return withCause(errBadInput, fmt.Errorf("images are %d dots wide, the %s prints %d", linePixels, c.model.Name, c.model.Width))
+	}
+	if n := c.model.MaxJobLines; n > 0 && job.height > n && !force {
+		return withCause(errBadInput, fmt.Errorf("image is %d lines long, the %s prints at most %d at a time (--force to try anyway)", job.height, c.model.Name, n))
+	}
+	if speed > 0 && len(c.model.Speeds) < 2 && flagGiven("speed") {
+		log.Printf("Warning: no speeds of the %s are known to pick from, ignoring --speed", c.model.Name)
+	}
+	err := c.driver.Send(ctx, drivers.Job{
+		Pixels:    job.pixels,
+		Width:     linePixels,
+		Height:    job.height,
+		Gray:      job.mode == Mode4bpp,
+		Intensity: job.intensity,
+		Speed:     speedValue(c.model.Speeds),
+		Pause:     pause,
+	})
+	if err != nil {
+		return withCause(errTransfer, err)
+	}
+	return nil
+}
+
+// verify waits for the printer to finish the job just sent and asks for
+// its status after it, so that a job the paper ran out or the head
+// overheated during fails rather than passing for printed. A printer that
+// doesn't answer the status query only gets a warning.
+func (c *printerConn) verify(ctx context.Context, job printJob) error {
+	// Generous allowance, the head manages a few hundred lines per second
+	deadline := time.After(15*time.Second + time.Duration(job.height)*20*time.Millisecond)
+wait:
+	for {
+		select {
+		case <-c.done:
+			break wait
+		case msg := <-c.errs:
+			return fmt.Errorf("the printer reported an error: %s", msg)
+		case s := <-c.replies:
+			// Some printers say so right away when something goes wrong
+			if !s.ok {
+				return statusError(s, "during")
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("%w waiting for the printer to finish", errTimeout)
+		}
+	}
+	if noVerify {
+		return nil // jobs in a row still have to wait for each other
+	}
+	s, err := c.status(ctx)
+	if err != nil {
+		log.Printf("Warning: can't tell whether the print went through: %v", err)
+		return nil
+	}
+	if !s.ok {
+		return statusError(s, "after")
+	}
+	return nil
+}
+
+// statusError is the error for a status that isn't OK, found when (during
+// or after) a print
+func statusError(s printerStatus, when string) error {
+	switch s.state {
+	case "No paper":
+		return fmt.Errorf("%w %s the print, it may be cut short", errNoPaper, when)
+	case "Overheated":
+		return fmt.Errorf("%w %s the print, it may be cut short", errOverheated, when)
+	case "Low battery":
+		return withCause(errLowBattery, fmt.Errorf("printer reports a low battery %s the print, it may be faded or cut short", when))
+	}
+	return fmt.Errorf("printer reports an error %s the print (%s)", when, s.state)
+}
+
+func statusFrom(s drivers.Status) printerStatus {
+	return printerStatus{ok: s.OK, state: s.State, battery: s.Battery, temperature: s.Temperature}
+}
+
+func (c *printerConn) close() {
+	c.client.CancelConnection()
+	c.lock.unlock()
+}
+
+// drain drops replies and completions nobody waited for, so they aren't
+// taken for answers to what is sent next
+func (c *printerConn) drain() {
+	for {
+		select {
+		case <-c.replies:
+		case <-c.done:
+		case <-c.counts:
+		case <-c.errs:
+		default:
+			return
+		}
+	}
+}
+
+// status asks the printer for its status and waits for the reply
+func (c *printerConn) status(ctx context.Context) (printerStatus, error) {
+	c.drain()
+	if err := c.driver.Query(drivers.AskStatus); err != nil {
+		return printerStatus{}, err
+	}
+	select {
+	case s := <-c.replies:
+		return s, nil
+	case <-ctx.Done():
+		return printerStatus{}, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return printerStatus{}, fmt.Errorf("no status reply from the printer")
+	}
+}
+
+// chosenModel is the model picked with --model, or nil to detect it
+func chosenModel() (*drivers.Model, error) {
+	if modelName == "" || modelName == "auto" {
+		return nil, nil
+	}
+	m := drivers.Lookup(modelName)
+	if m == nil {
+		return nil, withCause(errBadInput, fmt.Errorf("unknown printer model %q, use auto or one of %s", modelName, strings.Join(drivers.Names(), ", ")))
+	}
+	return m, nil
+}
+
+// shared is the connection kept open between jobs with --stay-connected
+var shared *sharedConn
+
+// startKeepAlive starts keeping the shared connection alive, if there is
+// one. It is only called once the options that pick the printer are applied
+// and any scan for it is over, as its first connection scans too.
+func startKeepAlive(ctx context.Context) {
+	if shared != nil {
+		go shared.keepAlive(ctx, keepAliveInterval)
+	}
+}
+
+// printerTarget is the printer a job goes to, for commands that drive
+// several printers; jobs without one go to the printer -a picks or a scan
+// finds
+type printerTarget struct {
+	name    string
+	address string
+	mu      sync.Mutex  // one job at a time
+	shared  *sharedConn // the connection kept open with --stay-connected, or nil
+}
+
+type printerTargetKey struct{}
+
+// onPrinter sends the jobs printed under ctx to t
+func onPrinter(ctx context.Context, t *printerTarget) context.Context {
+	return context.WithValue(ctx, printerTargetKey{}, t)
+}
+
+// targetOf is the printer jobs under ctx go to, or nil for the usual one
+func targetOf(ctx context.Context) *printerTarget {
+	t, _ := ctx.Value(printerTargetKey{}).(*printerTarget)
+	return t
+}
+
+// printerAddress is the MAC address of the printer to connect to under ctx,
+// or "" to scan for one
+func printerAddress(ctx context.Context) string {
+	if t := targetOf(ctx); t != nil {
+		return t.address
+	}
+	return address
+}
+
+// sharedConn is a connection reused by every job, opened on first use and
+// again whenever it drops
+type sharedConn struct {
+	mu     sync.Mutex // one user at a time
+	conn   *printerConn
+	closed bool // for good, keepAlive stops
+}
+
+// withPrinter runs fn with a connection to the printer: the kept one with
+// --stay-connected, or one opened just for fn otherwise
+func withPrinter(ctx context.Context, fn func(*printerConn) error) error {
+	if t := targetOf(ctx); t != nil {
+		if t.shared != nil {
+			return t.shared.use(ctx, fn)
+		}
+	} else if shared != nil {
+		return shared.use(ctx, fn)
+	}
+	c, err := openPrinter(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	return fn(c)
+}
+
+func (s *sharedConn) use(ctx context.Context, fn func(*printerConn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		c, err := openPrinter(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = c
+	}
+	err := fn(s.conn)
+	if err != nil && !errors.Is(err, errBadInput) {
+		// Don't trust a connection something went wrong on
+		s.dropLocked()
+	}
+	return err
+}
+
+func (s *sharedConn) dropLocked() {
+	if s.conn != nil {
+		s.conn.close()
+		s.conn = nil
+	}
+}
+
+func (s *sharedConn) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropLocked()
+	s.closed = true
+}
+
+// keepAlive connects right away and then asks for the status every interval
+// until ctx is done, reconnecting when the link dropped. It doesn't wait
+// while a job is using the connection, that keeps it alive well enough.
+func (s *sharedConn) keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.mu.TryLock() {
+			if s.closed {
+				s.mu.Unlock()
+				return
+			}
+			s.ping(ctx)
+			s.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *sharedConn) ping(ctx context.Context) {
+	if s.conn != nil {
+		select {
+		case <-s.conn.client.Disconnected():
+			log.Println("Printer disconnected, reconnecting")
+			s.dropLocked()
+		default:
+		}
+	}
+	if s.conn == nil {
+		c, err := openPrinter(ctx)
+		if err != nil {
+			log.Printf("Keep-alive: %v", err)
+			return
+		}
+		s.conn = c
+		return
+	}
+	if _, err := s.conn.status(ctx); err != nil {
+		log.Printf("Keep-alive: %v, reconnecting on next use", err)
+		s.dropLocked()
+	}
+}
@@ -0,0 +1,236 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var contactCmd = &command{
+	name:  "contact",
+	usage: "[--name N] [--org O] [--title T] [--phone P] [--email E] [--url U] [--mecard] [file.vcf]",
+}
+
+func init() {
+	contactCmd.run = runContact
+	registerCommand(contactCmd)
+}
+
+// contact is what goes into a contact QR code
+type contact struct {
+	name, org, title, phone, email, url string
+}
+
+// runContact prints a contact as a QR code phones can add to their address
+// book, with the name under it
+func runContact(ctx context.Context, args []string) error {
+	fs := contactCmd.flagSet()
+	var flags contact
+	fs.StringVar(&flags.name, "name", "", "Full name")
+	fs.StringVar(&flags.org, "org", "", "Company or organization")
+	fs.StringVar(&flags.title, "title", "", "Job title")
+	fs.StringVar(&flags.phone, "phone", "", "Phone number")
+	fs.StringVar(&flags.email, "email", "", "Email address")
+	fs.StringVar(&flags.url, "url", "", "Website")
+	mecard := fs.Bool("mecard", false, "Encode as a MECARD, which makes a smaller code than a vCard but holds less")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most one .vcf file")
+	}
+	var c contact
+	if fs.NArg() == 1 {
+		var err error
+		if c, err = readVCard(fs.Arg(0)); err != nil {
+			return withCause(errBadInput, err)
+		}
+	}
+	// Flags override what the file says
+	for _, f := range []struct {
+		dst *string
+		val string
+	}{
+		{&c.name, flags.name}, {&c.org, flags.org}, {&c.title, flags.title},
+		{&c.phone, flags.phone}, {&c.email, flags.email}, {&c.url, flags.url},
+	} {
+		if f.val != "" {
+			*f.dst = f.val
+		}
+	}
+	if c.name == "" {
+		fs.Usage()
+		return fmt.Errorf("a contact needs a --name, or a .vcf file with one")
+	}
+
+	payload := c.vCard()
+	if *mecard {
+		payload = c.meCard()
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	// The layout is built directly, as a vCard spans several lines and @qr
+	// only takes one
+	l := &layout{width: opts.margins.width(), align: "center", scale: 1}
+	if err := l.graphic("qr", "", payload); err != nil {
+		return withCause(errBadInput, err)
+	}
+	l.bold = true
+	l.text(c.name)
+	l.bold = false
+	for _, s := range []string{c.title, c.org} {
+		if s != "" {
+			l.text(s)
+		}
+	}
+	opts.stamp.file = c.name
+	pixels, height, err := processImage(stackImages(l.bands), printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// vCard encodes c as a vCard 3.0
+func (c contact) vCard() string {
+	esc := strings.NewReplacer(`\`, `\\`, `,`, `\,`, `;`, `\;`, "\n", `\n`)
+	// The structured name is a guess, the last word being the family name
+	given, family := c.name, ""
+	if i := strings.LastIndexByte(c.name, ' '); i > 0 {
+		given, family = c.name[:i], c.name[i+1:]
+	}
+	lines := []string{"BEGIN:VCARD", "VERSION:3.0", "N:" + esc.Replace(family) + ";" + esc.Replace(given) + ";;;", "FN:" + esc.Replace(c.name)}
+	for _, f := range []struct{ key, val string }{
+		{"ORG", c.org}, {"TITLE", c.title}, {"TEL", c.phone}, {"EMAIL", c.email}, {"URL", c.url},
+	} {
+		if f.val != "" {
+			lines = append(lines, f.key+":"+esc.Replace(f.val))
+		}
+	}
+	lines = append(lines, "END:VCARD")
+	return strings.Join(lines, "\r\n")
+}
+
+// meCard encodes c as a MECARD, NTT Docomo's compact format. It has no
+// organization or title, those go in the note.
+func (c contact) meCard() string {
+	esc := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+	var b strings.Builder
+	name := esc.Replace(c.name)
+	if i := strings.LastIndexByte(c.name, ' '); i > 0 {
+		name = esc.Replace(c.name[i+1:]) + "," + esc.Replace(c.name[:i])
+	}
+	fmt.Fprintf(&b, "MECARD:N:%s;", name)
+	var note []string
+	for _, s := range []string{c.title, c.org} {
+		if s != "" {
+			note = append(note, s)
+		}
+	}
+	for _, f := range []struct{ key, val string }{
+		{"TEL", c.phone}, {"EMAIL", c.email}, {"URL", c.url}, {"NOTE", strings.Join(note, ", ")},
+	} {
+		if f.val != "" {
+			fmt.Fprintf(&b, "%s:%s;", f.key, esc.Replace(f.val))
+		}
+	}
+	b.WriteString(";")
+	return b.String()
+}
+
+// readVCard reads the first contact of a .vcf file. Only the fields that
+// fit in a QR code are kept, photos and the like are left out.
+func readVCard(path string) (contact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return contact{}, err
+	}
+	defer f.Close()
+
+	// Long lines are folded into lines starting with a space or tab
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return contact{}, err
+	}
+
+	unesc := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+	var c contact
+	var structured string
+	first := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = strings.TrimSpace(v)
+		}
+	}
+	inCard := false
+cards:
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		// Parameters and groups ("item1.TEL;TYPE=CELL") don't matter here
+		key, _, _ = strings.Cut(strings.ToUpper(key), ";")
+		if i := strings.LastIndexByte(key, '.'); i >= 0 {
+			key = key[i+1:]
+		}
+		switch {
+		case key == "BEGIN":
+			inCard = strings.EqualFold(value, "VCARD")
+		case key == "END" && inCard:
+			break cards
+		case !inCard:
+		case key == "FN":
+			first(&c.name, unesc.Replace(value))
+		case key == "N":
+			structured = value
+		case key == "ORG":
+			first(&c.org, unesc.Replace(strings.TrimRight(strings.ReplaceAll(value, ";", ", "), ", ")))
+		case key == "TITLE":
+			first(&c.title, unesc.Replace(value))
+		case key == "TEL":
+			first(&c.phone, strings.TrimPrefix(value, "tel:"))
+		case key == "EMAIL":
+			first(&c.email, value)
+		case key == "URL":
+			first(&c.url, unesc.Replace(value))
+		}
+	}
+	if c.name == "" && structured != "" {
+		// N is family;given;additional;prefix;suffix
+		parts := strings.Split(structured, ";")
+		if len(parts) > 1 {
+			c.name = strings.TrimSpace(parts[1] + " " + parts[0])
+		} else {
+			c.name = parts[0]
+		}
+	}
+	if c == (contact{}) {
+		return contact{}, fmt.Errorf("no contact in %s", path)
+	}
+	return c, nil
+}
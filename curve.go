@@ -0,0 +1,137 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/disintegration/imaging"
+)
+
+// toneCurve is a lookup table from the gray value we want on paper to the gray
+// value that has to be sent to get it, compensating for the head's non-linear
+// darkening. Curves are built by "bleh calibrate".
+type toneCurve struct {
+	Printer string     `json:"printer"`
+	Mode    string     `json:"mode"`
+	LUT     [256]uint8 `json:"lut"`
+}
+
+// apply maps every channel of img through the curve
+func (c *toneCurve) apply(img image.Image) image.Image {
+	return imaging.AdjustFunc(img, func(p color.NRGBA) color.NRGBA {
+		return color.NRGBA{R: c.LUT[p.R], G: c.LUT[p.G], B: c.LUT[p.B], A: p.A}
+	})
+}
+
+// defaultCurvePath is where the curve for the current printer and mode lives
+func defaultCurvePath(mode string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "curves", printerKey()+"-"+mode+".json"), nil
+}
+
+// loadToneCurve resolves the --curve flag: "none" disables calibration, an
+// empty value uses the saved curve for this printer and mode if there is one,
+// anything else is read as a curve file
+func loadToneCurve(spec, mode string) (*toneCurve, error) {
+	if spec == "none" {
+		return nil, nil
+	}
+	path := spec
+	if path == "" {
+		var err error
+		if path, err = defaultCurvePath(mode); err != nil {
+			return nil, nil // no config dir, so no saved curve either
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && spec == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tone curve: %v", err)
+	}
+	var c toneCurve
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse tone curve %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+func saveToneCurve(c *toneCurve, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// buildToneCurve inverts a measured step wedge. darkness[i] is how dark the
+// patch printed from nominal darkness i/(len-1) came out, on any scale that
+// grows with darkness. The resulting curve makes printed reflectance follow
+// the luminance of the input.
+func buildToneCurve(darkness []float64) (*toneCurve, error) {
+	n := len(darkness)
+	if n < 2 {
+		return nil, fmt.Errorf("need at least 2 measurements, got %d", n)
+	}
+
+	// Normalize between bare paper and the darkest patch, forcing the
+	// response to be monotonic so it can be inverted
+	lo, hi := darkness[0], darkness[0]
+	for _, m := range darkness {
+		hi = max(hi, m)
+	}
+	if hi-lo <= 0 {
+		return nil, fmt.Errorf("measurements show no darkening, check their order")
+	}
+	response := make([]float64, n)
+	running := 0.0
+	for i, m := range darkness {
+		running = max(running, (m-lo)/(hi-lo))
+		response[i] = running
+	}
+
+	c := &toneCurve{}
+	for g := 0; g < 256; g++ {
+		target := 1 - srgbToLinear(uint8(g))
+
+		// Find the nominal darkness that prints as dark as target
+		d := 1.0
+		for i := 0; i < n-1; i++ {
+			if target <= response[i+1] {
+				span := response[i+1] - response[i]
+				f := 0.0
+				if span > 0 {
+					f = (target - response[i]) / span
+				}
+				d = (float64(i) + max(0, f)) / float64(n-1)
+				break
+			}
+		}
+		c.LUT[g] = uint8(255 - min(255, max(0, int(d*255+0.5))))
+	}
+	return c, nil
+}
@@ -0,0 +1,62 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuildToneCurveLinear(t *testing.T) {
+	// A printer whose darkness grows linearly with the nominal darkness
+	// prints reflectance straight from the linear luminance
+	c, err := buildToneCurve([]float64{0, 0.25, 0.5, 0.75, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []int{0, 64, 128, 192, 255} {
+		want := srgbToLinear(uint8(g)) * 255
+		if math.Abs(float64(c.LUT[g])-want) > 1 {
+			t.Errorf("LUT[%d] = %d, want about %.1f", g, c.LUT[g], want)
+		}
+	}
+}
+
+func TestBuildToneCurveMonotonic(t *testing.T) {
+	// A noisy wedge that gets lighter again in the middle still gives a curve
+	// that never darkens as the input gets lighter
+	c, err := buildToneCurve([]float64{0.1, 0.5, 0.4, 0.9, 1.6, 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Black is as dark as the darkest patch, printed from 4/5
+	if c.LUT[0] != 51 || c.LUT[255] != 255 {
+		t.Errorf("LUT ends at %d and %d, want 51 and 255", c.LUT[0], c.LUT[255])
+	}
+	for g := 1; g < 256; g++ {
+		if c.LUT[g] < c.LUT[g-1] {
+			t.Fatalf("LUT[%d] = %d is below LUT[%d] = %d", g, c.LUT[g], g-1, c.LUT[g-1])
+		}
+	}
+}
+
+func TestBuildToneCurveErrors(t *testing.T) {
+	for _, darkness := range [][]float64{
+		nil,
+		{0.5},
+		{1, 1, 1},
+		{1, 0.5, 0},
+	} {
+		if _, err := buildToneCurve(darkness); err == nil {
+			t.Errorf("buildToneCurve(%v) succeeded, want an error", darkness)
+		}
+	}
+}
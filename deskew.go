@@ -0,0 +1,114 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Skew is found by turning the dark pixels of the image through a range of
+// angles and keeping the one where they pile up into the sharpest rows,
+// which is when the lines of text are level
+const (
+	maxSkew       = 15.0 // degrees either way
+	skewStep      = 0.5  // degrees, for the first pass
+	skewFineStep  = 0.05 // degrees, around the best of the first pass
+	minSkew       = 0.1  // degrees; less isn't worth resampling for
+	minSharpness  = 1.5  // times the average over all angles for a clear winner
+	deskewAnalyze = 800  // width the image is looked at, in pixels
+)
+
+// deskew rotates a photographed document so its lines of text are level.
+// The corners it turns in are white.
+func deskew(img image.Image) image.Image {
+	angle, ok := skewAngle(img)
+	if !ok {
+		log.Println("No lines of text found, not deskewing")
+		return img
+	}
+	if math.Abs(angle) < minSkew {
+		return img
+	}
+	log.Printf("Deskewing by %.1f°", angle)
+	return imaging.Rotate(img, angle, color.White)
+}
+
+// skewAngle returns the angle in degrees, counterclockwise, that levels the
+// text in img
+func skewAngle(img image.Image) (float64, bool) {
+	small := img
+	if img.Bounds().Dx() > deskewAnalyze {
+		small = imaging.Resize(img, deskewAnalyze, 0, imaging.Box)
+	}
+	// An adaptive threshold copes with the uneven light of a photo
+	g := toGray(small)
+	g = adaptiveThreshold(g, "sauvola", max(g.Bounds().Dx()/16, 15))
+
+	var xs, ys []float64
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if g.Pix[y*g.Stride+x] == 0 {
+				xs = append(xs, float64(x)-float64(w)/2)
+				ys = append(ys, float64(y)-float64(h)/2)
+			}
+		}
+	}
+	if len(xs) < 100 {
+		return 0, false
+	}
+
+	diagonal := int(math.Hypot(float64(w), float64(h))) + 1
+	rows := make([]int, diagonal)
+	// sharpness is how much the count of dark pixels jumps from one row to
+	// the next, after turning them by angle
+	sharpness := func(angle float64) float64 {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		clear(rows)
+		for i := range xs {
+			r := int(ys[i]*cos-xs[i]*sin) + diagonal/2
+			if r >= 0 && r < diagonal {
+				rows[r]++
+			}
+		}
+		s := 0.0
+		for i := 1; i < diagonal; i++ {
+			d := float64(rows[i] - rows[i-1])
+			s += d * d
+		}
+		return s
+	}
+	search := func(from, to, step float64) (best, bestScore, average float64) {
+		n := 0
+		for a := from; a <= to+step/2; a += step {
+			s := sharpness(a)
+			if s > bestScore {
+				best, bestScore = a, s
+			}
+			average += s
+			n++
+		}
+		return best, bestScore, average / float64(n)
+	}
+
+	// Without text, no angle stands out, or the best is as far as it goes
+	coarse, score, average := search(-maxSkew, maxSkew, skewStep)
+	if score < minSharpness*average || math.Abs(coarse) >= maxSkew {
+		return 0, false
+	}
+	fine, _, _ := search(coarse-skewStep, coarse+skewStep, skewFineStep)
+	return fine, true
+}
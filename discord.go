@@ -0,0 +1,184 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// discordBot polls one channel through the Discord REST API. Reading what
+// others write needs the Message Content intent enabled for the bot.
+type discordBot struct {
+	o      botOptions
+	client *http.Client
+}
+
+type discordMessage struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	Author  struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Bot      bool   `json:"bot"`
+	} `json:"author"`
+	Attachments []struct {
+		URL         string `json:"url"`
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+	} `json:"attachments"`
+}
+
+const discordPoll = 5 * time.Second
+
+func (b *discordBot) do(ctx context.Context, method, path string, body, result any) error {
+	header := http.Header{"Authorization": {"Bot " + b.o.token}}
+	err := botRequest(ctx, b.client, method, b.o.api+path, header, body, result)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("discord %s: %w", strings.Split(path, "?")[0], err)
+	}
+	return err
+}
+
+// snowflakeLess orders Discord IDs, which are growing decimal numbers too
+// large for some JSON decoders, so they come as strings
+func snowflakeLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// runDiscord prints the text and images posted in the channel until ctx is
+// done
+func runDiscord(ctx context.Context, o botOptions) error {
+	b := &discordBot{o: o, client: &http.Client{Timeout: 30 * time.Second}}
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/users/@me", nil, &me); err != nil {
+		return withCause(errConnect, err)
+	}
+	channel := "/channels/" + url.PathEscape(o.room) + "/messages"
+	// Start after the newest message, older ones aren't printed
+	var latest []discordMessage
+	if err := b.do(ctx, http.MethodGet, channel+"?limit=1", nil, &latest); err != nil {
+		return withCause(errConnect, err)
+	}
+	last := "0"
+	if len(latest) > 0 {
+		last = latest[0].ID
+	}
+	log.Printf("Discord bot %s is listening in channel %s, Ctrl-C to stop", me.Username, o.room)
+
+	for {
+		if sleepCtx(ctx, discordPoll) != nil {
+			return nil
+		}
+		var msgs []discordMessage
+		err := b.do(ctx, http.MethodGet, channel+"?limit=50&after="+last, nil, &msgs)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var httpErr *botHTTPError
+		if errors.As(err, &httpErr) && httpErr.status == http.StatusTooManyRequests {
+			log.Println("Discord is rate limiting the bot, slowing down")
+			if sleepCtx(ctx, 30*time.Second) != nil {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			if sleepCtx(ctx, 10*time.Second) != nil {
+				return nil
+			}
+			continue
+		}
+		// Newest come first
+		slices.SortFunc(msgs, func(x, y discordMessage) int {
+			if snowflakeLess(x.ID, y.ID) {
+				return -1
+			}
+			return 1
+		})
+		for _, m := range msgs {
+			last = m.ID
+			b.handle(ctx, m, channel)
+		}
+	}
+}
+
+func (b *discordBot) handle(ctx context.Context, m discordMessage, channel string) {
+	if m.Author.Bot {
+		return // including our own replies
+	}
+	sender := m.Author.Username
+	if len(b.o.allow) > 0 && !b.o.allowed(m.Author.ID) {
+		log.Printf("Ignoring a message from %s (%s), not in --allow", m.Author.ID, sender)
+		return
+	}
+	if reply, ok := botCommand(ctx, m.Content); ok {
+		b.reply(ctx, m, channel, reply)
+		return
+	}
+
+	printed := false
+	for _, a := range m.Attachments {
+		if !strings.HasPrefix(a.ContentType, "image/") {
+			continue
+		}
+		data, err := download(a.URL, "attachment")
+		if err == nil {
+			// The text goes under the first image, as its caption
+			caption := m.Content
+			if printed {
+				caption = ""
+			}
+			err = printReceivedData(ctx, data, caption, b.o.textScale, sender)
+		}
+		if err != nil {
+			log.Printf("Message from %s: %v", sender, err)
+			b.reply(ctx, m, channel, "Printing failed: "+err.Error())
+			return
+		}
+		printed = true
+	}
+	if !printed && strings.TrimSpace(m.Content) != "" {
+		if err := printReceivedText(ctx, m.Content, b.o.textScale, sender); err != nil {
+			log.Printf("Message from %s: %v", sender, err)
+			b.reply(ctx, m, channel, "Printing failed: "+err.Error())
+			return
+		}
+		printed = true
+	}
+	if printed {
+		b.reply(ctx, m, channel, "Printed.")
+	}
+}
+
+func (b *discordBot) reply(ctx context.Context, m discordMessage, channel, text string) {
+	msg := map[string]any{
+		"content":           text,
+		"message_reference": map[string]string{"message_id": m.ID},
+		"allowed_mentions":  map[string]any{"parse": []string{}},
+	}
+	if err := b.do(ctx, http.MethodPost, channel, msg, nil); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	dither "github.com/makeworld-the-better-one/dither"
+)
+
+// ditherNames lists every -d value, in the order they are presented
+var ditherNames = []string{
+	"none",
+	"floyd", "atkinson", "atkinson2", "jjn", "stucki", "burkes",
+	"sierra", "sierra2", "sierralite",
+	"bayer2x2", "bayer4x4", "bayer8x8", "bayer16x16",
+	"bluenoise", "bluenoise32", "bluenoise16",
+	"halftone",
+}
+
+// twoRowAtkinson is Atkinson's kernel without the pixel two rows down, which
+// keeps a bit more highlight detail and only needs one look-ahead row
+var twoRowAtkinson = dither.ErrorDiffusionMatrix{
+	{0, 0, 1.0 / 8, 1.0 / 8},
+	{1.0 / 8, 1.0 / 8, 1.0 / 8, 0},
+}
+
+// newDitherer returns a ditherer for the palette configured from opts.
+// orderedStrength scales ordered dithering and should shrink as the palette grows.
+func newDitherer(palette []color.Color, opts imageOptions, orderedStrength float32) (*dither.Ditherer, error) {
+	d := dither.NewDitherer(palette)
+	switch opts.ditherType {
+	case "floyd":
+		d.Matrix = dither.FloydSteinberg
+	case "atkinson":
+		d.Matrix = dither.Atkinson
+	case "atkinson2":
+		d.Matrix = twoRowAtkinson
+	case "jjn":
+		d.Matrix = dither.JarvisJudiceNinke
+	case "stucki":
+		d.Matrix = dither.Stucki
+	case "burkes":
+		d.Matrix = dither.Burkes
+	case "sierra":
+		d.Matrix = dither.Sierra
+	case "sierra2":
+		d.Matrix = dither.TwoRowSierra
+	case "sierralite":
+		d.Matrix = dither.SierraLite
+	case "bayer2x2":
+		d.Mapper = dither.Bayer(2, 2, orderedStrength)
+	case "bayer4x4":
+		d.Mapper = dither.Bayer(4, 4, orderedStrength)
+	case "bayer8x8":
+		d.Mapper = dither.Bayer(8, 8, orderedStrength)
+	case "bayer16x16":
+		d.Mapper = dither.Bayer(16, 16, orderedStrength)
+	case "bluenoise16":
+		d.Mapper = dither.PixelMapperFromMatrix(blueNoiseMatrix(16), orderedStrength)
+	case "bluenoise32":
+		d.Mapper = dither.PixelMapperFromMatrix(blueNoiseMatrix(32), orderedStrength)
+	case "bluenoise", "bluenoise64":
+		d.Mapper = dither.PixelMapperFromMatrix(blueNoiseMatrix(64), orderedStrength)
+	case "halftone":
+		d.Mapper = halftoneMapper(opts.lpi, opts.angle, orderedStrength)
+	default:
+		return nil, fmt.Errorf("unknown dither type: %s", opts.ditherType)
+	}
+	d.Serpentine = opts.serpentine
+	return d, nil
+}
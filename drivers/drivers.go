@@ -0,0 +1,230 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package drivers speaks the protocols of the printers Bleh! supports. Each
+// model is registered from its own file with a Model: how to recognize it,
+// which services it offers, how wide it prints, and how to make a Driver
+// for a new connection. The Driver builds the model's commands and parses
+// its notifications; everything else (finding the printer, image
+// processing, status checks) is left to the caller.
+package drivers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ble "github.com/go-ble/ble"
+)
+
+// Model is a family of printers speaking the same protocol
+type Model struct {
+	Name        string                      // for --model
+	Match       func(name string) bool      // recognizes the advertised name
+	Services    []ble.UUID                  // discovered on connect
+	Width       int                         // dots across the head: 384, 576, 832...
+	MaxJobLines int                         // longest job, 0 for no limit
+	Gray        bool                        // prints 4bpp
+	Speeds      []byte                      // print speed values, slowest first
+	New         func(*Link) (Driver, error) // picks its characteristics from the link
+}
+
+// Driver speaks a model's protocol over one connection
+type Driver interface {
+	// NotifyChar is where the printer's notifications come from
+	NotifyChar() *ble.Characteristic
+	// DataChar is where image data is written, or nil if image data is
+	// framed like any other command
+	DataChar() *ble.Characteristic
+	// Decode tells what a notification says about the printer
+	Decode(data []byte) Notification
+	// Send sends one print job
+	Send(ctx context.Context, job Job) error
+	// Query asks the printer for something. The answer comes as a
+	// notification.
+	Query(q Query) error
+	Feed(lines uint) error
+	Retract(lines uint) error
+}
+
+// Job is an image ready to print: packed lines, 1bpp with the leftmost dot
+// in the lowest bit, or 4bpp
+type Job struct {
+	Pixels    []byte
+	Width     int // dots
+	Height    int
+	Gray      bool // 4bpp
+	Intensity byte // 0-100
+	Speed     byte // one of the firmware's Speeds, 0 for its default
+	// Pause is waited after every PacingBatch lines to let a hot head cool
+	Pause time.Duration
+}
+
+// LineBytes is the size of one packed line of the job
+func (j Job) LineBytes() int {
+	if j.Gray {
+		return j.Width / 2
+	}
+	return j.Width / 8
+}
+
+// Notification is what the driver made of a notification
+type Notification struct {
+	Status  *Status // a status reply
+	Done    bool    // the last job finished printing
+	Count   *Count  // a counter reply
+	Error   string  // something the printer says went wrong, like a refused job
+	Text    string  // anything else worth showing
+	Unknown bool    // not understood, left to the caller
+}
+
+// Count is a printer's reply to AskCount
+type Count struct {
+	Lines  int // lines printed over the printer's life
+	Prints int // jobs printed since it was switched on
+}
+
+// Status is a printer's reply to a status query
+type Status struct {
+	OK          bool
+	State       string // what the printer is doing, or what is wrong with it
+	Battery     int    // percent, or Unknown
+	Temperature int    // °C, or Unknown
+}
+
+// Unknown is the battery or temperature of models that don't report it
+const Unknown = -1
+
+// Query is something the printer can be asked for
+type Query int
+
+const (
+	AskStatus Query = iota
+	AskBattery
+	AskVersion
+	AskPrintType
+	AskCount
+)
+
+var (
+	ErrUnsupported = errors.New("not supported by this printer model")
+	ErrGray        = errors.New("this printer only prints 1bpp")
+)
+
+// models lists every model, in the order they are tried when detecting the
+// model from the advertised name
+var models []*Model
+
+// Register adds a model. It is meant to be called from init.
+func Register(m *Model) {
+	models = append(models, m)
+}
+
+// Prefix matches advertised names starting with one of prefixes
+func Prefix(prefixes ...string) func(string) bool {
+	return func(name string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// genericServices are advertised by all sorts of BLE gadgets besides
+// printers, so a device isn't taken for a printer just for having one. Models
+// using them are only recognized by name.
+var genericServices = []ble.UUID{ble.MustParse("ff00")}
+
+// Detect finds the model a printer advertising name belongs to
+func Detect(name string) *Model {
+	return DetectAdvertised(name, nil)
+}
+
+// DetectAdvertised finds the model a printer advertising name and services
+// belongs to by its name. When several models match the name, the one whose
+// service is advertised wins.
+func DetectAdvertised(name string, services []ble.UUID) *Model {
+	var found *Model
+	for _, m := range models {
+		if m.Match == nil || !m.Match(name) {
+			continue
+		}
+		if found == nil {
+			found = m
+		}
+		for _, u := range m.Services {
+			if ble.Contains(services, u) {
+				return m
+			}
+		}
+	}
+	return found
+}
+
+// DetectServices finds the models a printer advertising services may be.
+// Families sharing a service UUID can't be told apart by it, so there may
+// be several. Generic services don't count.
+func DetectServices(services []ble.UUID) []*Model {
+	var found []*Model
+	for _, m := range models {
+		for _, u := range m.Services {
+			if ble.Contains(services, u) && !ble.Contains(genericServices, u) {
+				found = append(found, m)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Lookup finds a model by name, ignoring case
+func Lookup(name string) *Model {
+	for _, m := range models {
+		if strings.EqualFold(m.Name, name) {
+			return m
+		}
+	}
+	return nil
+}
+
+// Names lists the model names, sorted
+func Names() []string {
+	names := make([]string, len(models))
+	for i, m := range models {
+		names[i] = m.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Connect discovers the model's services on a new connection and makes its
+// driver
+func Connect(client ble.Client, m *Model, t Transfer) (Driver, error) {
+	services, err := client.DiscoverServices(m.Services)
+	if err != nil || len(services) == 0 {
+		return nil, fmt.Errorf("service discovery failed: %v", err)
+	}
+	link := &Link{Client: client, Transfer: t, chars: map[string]*ble.Characteristic{}}
+	for _, svc := range services {
+		chars, err := client.DiscoverCharacteristics(nil, svc)
+		if err != nil {
+			return nil, fmt.Errorf("characteristic discovery failed: %v", err)
+		}
+		for _, c := range chars {
+			link.chars[c.UUID.String()] = c
+		}
+	}
+	return m.New(link)
+}
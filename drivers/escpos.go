@@ -0,0 +1,72 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+)
+
+// Phomemo and Peripage printers take images as ESC/POS rasters (GS v 0):
+// a header with the size of the block, then its lines with the leftmost dot
+// in the highest bit.
+
+const rasterMaxBlockLines = 255 // lines per GS v 0 block
+
+// sendRaster sends a 1bpp job as raster blocks for a head headBytes wide,
+// centering narrower lines on it
+func sendRaster(ctx context.Context, write func(ctx context.Context, data ...byte) error, job Job, headBytes int) error {
+	jobBytes := job.LineBytes()
+	if jobBytes > headBytes {
+		return fmt.Errorf("image is %d dots wide, the printer only %d", job.Width, headBytes*8)
+	}
+	left := (headBytes - jobBytes) / 2
+
+	for top := 0; top < job.Height; top += rasterMaxBlockLines {
+		lines := min(rasterMaxBlockLines, job.Height-top)
+		block := []byte{0x1D, 0x76, 0x30, 0x00, byte(headBytes), byte(headBytes >> 8), byte(lines), byte(lines >> 8)}
+		if err := write(ctx, block...); err != nil {
+			return fmt.Errorf("line %d write failed: %v", top, err)
+		}
+		for y := top; y < top+lines; y++ {
+			line := make([]byte, headBytes)
+			for i, b := range job.Pixels[y*jobBytes : (y+1)*jobBytes] {
+				line[left+i] = reverseBits(b)
+			}
+			if err := write(ctx, line...); err != nil {
+				return fmt.Errorf("line %d write failed: %v", y, err)
+			}
+			if err := Pace(ctx, y, job.Pause); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// feedCommands feeds lines of paper with ESC J, up to 255 at a time
+func feedCommands(lines uint) []byte {
+	var cmds []byte
+	for ; lines > 0; lines -= min(lines, 255) {
+		cmds = append(cmds, 0x1B, 0x4A, byte(min(lines, 255)))
+	}
+	return cmds
+}
+
+// reverseBits turns a byte of 1bpp pixels, leftmost in the lowest bit, into
+// the order of ESC/POS rasters
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | b>>i&1
+	}
+	return r
+}
@@ -0,0 +1,232 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	ble "github.com/go-ble/ble"
+)
+
+// The older cat printers (GB01, GB02, GB03, GT01) take every command, image
+// lines included, on a single characteristic. Commands are framed like the
+// MXW01's with another header, lines go run-length encoded when that is
+// shorter, and the printer asks to pause sending when its buffer fills up.
+// They only print 1bpp and report neither battery nor temperature.
+//
+// The MX05, MX06, MX08 and MX10 speak the same protocol, but ignore the feed
+// and retract commands, so paper is fed by printing blank lines.
+
+var (
+	gbServiceUUID          = ble.MustParse("ae30")
+	gbWriteCharacteristic  = ble.MustParse("ae01")
+	gbNotifyCharacteristic = ble.MustParse("ae02")
+	gbCommandHeader        = []byte{0x51, 0x78}
+)
+
+const gbWidth = 384
+
+const (
+	gbRetract     = 0xA0
+	gbFeed        = 0xA1
+	gbLine        = 0xA2
+	gbDeviceState = 0xA3
+	gbQuality     = 0xA4
+	gbLattice     = 0xA6
+	gbFlowControl = 0xAE
+	gbEnergy      = 0xAF
+	gbApplyEnergy = 0xBE
+	gbLineRLE     = 0xBF
+)
+
+var (
+	gbLatticeStart = []byte{0xAA, 0x55, 0x17, 0x38, 0x44, 0x5F, 0x5F, 0x5F, 0x44, 0x38, 0x2C}
+	gbLatticeEnd   = []byte{0xAA, 0x55, 0x17, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x17}
+)
+
+// gbQuirks are the differences between models of the GB01 family
+type gbQuirks struct {
+	feedWithLines bool // feed by printing blank lines, retracting isn't possible
+}
+
+func init() {
+	for _, name := range []string{"GB01", "GB02", "GB03", "GT01"} {
+		Register(gbModel(name, gbQuirks{}))
+	}
+	for _, name := range []string{"MX05", "MX06", "MX08", "MX10"} {
+		Register(gbModel(name, gbQuirks{feedWithLines: true}))
+	}
+}
+
+func gbModel(name string, quirks gbQuirks) *Model {
+	return &Model{
+		Name:     name,
+		Match:    Prefix(name),
+		Services: []ble.UUID{gbServiceUUID},
+		Width:    gbWidth,
+		New: func(l *Link) (Driver, error) {
+			d := &gb01Driver{
+				link:      l,
+				quirks:    quirks,
+				writeChr:  l.Char(gbWriteCharacteristic),
+				notifyChr: l.Char(gbNotifyCharacteristic),
+			}
+			if d.writeChr == nil {
+				return nil, fmt.Errorf("missing required printer characteristics")
+			}
+			return d, nil
+		},
+	}
+}
+
+type gb01Driver struct {
+	link      *Link
+	quirks    gbQuirks
+	writeChr  *ble.Characteristic
+	notifyChr *ble.Characteristic
+	paused    atomic.Bool // the printer asked to stop sending for now
+	finishing atomic.Bool // the next state reply means the job is printed
+}
+
+func (d *gb01Driver) NotifyChar() *ble.Characteristic { return d.notifyChr }
+func (d *gb01Driver) DataChar() *ble.Characteristic   { return nil }
+
+func (d *gb01Driver) command(cmdId byte, payload ...byte) []byte {
+	return Frame(gbCommandHeader, cmdId, payload)
+}
+
+// write sends data in chunks, holding back while the printer asks to pause
+func (d *gb01Driver) write(ctx context.Context, data []byte) error {
+	return d.link.Write(ctx, d.writeChr, data, d.paused.Load)
+}
+
+func (d *gb01Driver) Send(ctx context.Context, job Job) error {
+	if job.Gray {
+		return ErrGray
+	}
+	fmt.Printf("Sending image: %dx%d lines\n", job.Width, job.Height)
+
+	// Intensity maps onto the heating energy, a 16 bit value
+	energy := int(job.Intensity) * 0xFFFF / 100
+	var start []byte
+	start = append(start, d.command(gbQuality, 0x33)...)
+	start = append(start, d.command(gbEnergy, byte(energy), byte(energy>>8))...)
+	start = append(start, d.command(gbApplyEnergy, 0x01)...)
+	start = append(start, d.command(gbLattice, gbLatticeStart...)...)
+	if err := d.write(ctx, start); err != nil {
+		return fmt.Errorf("print setup failed: %v", err)
+	}
+
+	n := job.LineBytes()
+	for y := 0; y < job.Height; y++ {
+		if err := d.write(ctx, gbLineCommand(job.Pixels[y*n:(y+1)*n])); err != nil {
+			return fmt.Errorf("line %d write failed: %v", y, err)
+		}
+		if err := Pace(ctx, y, job.Pause); err != nil {
+			return err
+		}
+	}
+
+	// The state reply comes once the printer got through everything before
+	d.finishing.Store(true)
+	end := append(d.command(gbLattice, gbLatticeEnd...), d.command(gbDeviceState, 0x00)...)
+	if err := d.write(ctx, end); err != nil {
+		return fmt.Errorf("print end failed: %v", err)
+	}
+	return nil
+}
+
+// gbLineCommand encodes one 1bpp line, run-length encoded if that is shorter
+func gbLineCommand(line []byte) []byte {
+	if rle := encodeRunLength(line); len(rle) < len(line) {
+		return Frame(gbCommandHeader, gbLineRLE, rle)
+	}
+	return Frame(gbCommandHeader, gbLine, line)
+}
+
+// encodeRunLength turns a 1bpp line into runs of up to 127 dots, one byte
+// each: the color in the top bit and the length below
+func encodeRunLength(line []byte) []byte {
+	var runs []byte
+	color, n := byte(0), 0
+	for x := 0; x < len(line)*8; x++ {
+		dot := line[x/8] >> (x % 8) & 1
+		if n > 0 && (dot != color || n == 127) {
+			runs = append(runs, color<<7|byte(n))
+			n = 0
+		}
+		color = dot
+		n++
+	}
+	return append(runs, color<<7|byte(n))
+}
+
+func (d *gb01Driver) Query(q Query) error {
+	if q != AskStatus {
+		return ErrUnsupported
+	}
+	return d.write(context.Background(), d.command(gbDeviceState, 0x00))
+}
+
+func (d *gb01Driver) Feed(lines uint) error {
+	if d.quirks.feedWithLines {
+		blank := gbLineCommand(make([]byte, gbWidth/8))
+		cmds := d.command(gbLattice, gbLatticeStart...)
+		for i := uint(0); i < lines; i++ {
+			cmds = append(cmds, blank...)
+		}
+		cmds = append(cmds, d.command(gbLattice, gbLatticeEnd...)...)
+		return d.write(context.Background(), cmds)
+	}
+	return d.write(context.Background(), d.command(gbFeed, byte(lines), byte(lines>>8)))
+}
+
+func (d *gb01Driver) Retract(lines uint) error {
+	if d.quirks.feedWithLines {
+		return ErrUnsupported
+	}
+	return d.write(context.Background(), d.command(gbRetract, byte(lines), byte(lines>>8)))
+}
+
+func (d *gb01Driver) Decode(data []byte) Notification {
+	if len(data) < 7 || data[0] != gbCommandHeader[0] || data[1] != gbCommandHeader[1] {
+		return Notification{Unknown: true}
+	}
+	switch data[2] {
+	case gbFlowControl:
+		d.paused.Store(data[6] != 0)
+		return Notification{}
+	case gbDeviceState:
+		s := decodeGBState(data[6])
+		return Notification{Status: &s, Done: d.finishing.Swap(false)}
+	default:
+		return Notification{Unknown: true}
+	}
+}
+
+func decodeGBState(state byte) Status {
+	s := Status{OK: true, State: "Standby", Battery: Unknown, Temperature: Unknown}
+	switch {
+	case state&0x01 != 0:
+		s.OK, s.State = false, "No paper"
+	case state&0x02 != 0:
+		s.OK, s.State = false, "Cover open"
+	case state&0x04 != 0:
+		s.OK, s.State = false, "Overheated"
+	case state&0x08 != 0:
+		s.OK, s.State = false, "Low battery"
+	case state&0x80 != 0:
+		s.State = "Printing"
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGB01Decode(t *testing.T) {
+	d := &gb01Driver{}
+	state := func(b byte) []byte { return d.command(gbDeviceState, b) }
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want Notification
+	}{
+		{"standby", state(0x00), Notification{Status: &Status{OK: true, State: "Standby", Battery: Unknown, Temperature: Unknown}}},
+		{"printing", state(0x80), Notification{Status: &Status{OK: true, State: "Printing", Battery: Unknown, Temperature: Unknown}}},
+		{"no paper", state(0x01), Notification{Status: &Status{State: "No paper", Battery: Unknown, Temperature: Unknown}}},
+		{"cover open", state(0x82), Notification{Status: &Status{State: "Cover open", Battery: Unknown, Temperature: Unknown}}},
+		{"flow control", d.command(gbFlowControl, 0x10), Notification{}},
+		{"unknown command", d.command(0x55, 0x00), Notification{Unknown: true}},
+		{"wrong magic", []byte{0x78, 0x51, gbDeviceState, 0, 1, 0, 0, 0, 0xFF}, Notification{Unknown: true}},
+		{"empty", nil, Notification{Unknown: true}},
+		{"truncated", state(0x00)[:6], Notification{Unknown: true}},
+	} {
+		if got := d.Decode(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Decode(% X) = %+v, want %+v", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestGB01DecodeFlowControl(t *testing.T) {
+	d := &gb01Driver{}
+	d.Decode(d.command(gbFlowControl, 0x10))
+	if !d.paused.Load() {
+		t.Errorf("paused = false after the printer asked to stop")
+	}
+	d.Decode(d.command(gbFlowControl, 0x00))
+	if d.paused.Load() {
+		t.Errorf("paused = true after the printer asked to go on")
+	}
+}
+
+func TestGB01DecodeDone(t *testing.T) {
+	d := &gb01Driver{}
+	d.finishing.Store(true)
+	if n := d.Decode(d.command(gbDeviceState, 0x00)); !n.Done {
+		t.Errorf("the state reply after a job isn't Done")
+	}
+	if n := d.Decode(d.command(gbDeviceState, 0x00)); n.Done {
+		t.Errorf("a later state reply is Done too")
+	}
+}
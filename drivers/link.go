@@ -0,0 +1,165 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ble "github.com/go-ble/ble"
+)
+
+// PacingBatch is how many lines are sent between the pauses of a job
+const PacingBatch = 32
+
+// Transfer controls how data is split into BLE writes
+type Transfer struct {
+	ChunkSize int           // bytes per write
+	Delay     time.Duration // after each write
+	Response  bool          // wait for the printer to acknowledge each write
+	// Trace, if set, sees everything written, one command or block of
+	// image data at a time
+	Trace func(data []byte)
+}
+
+// Link is the connection a driver talks over
+type Link struct {
+	Client   ble.Client
+	Transfer Transfer
+	chars    map[string]*ble.Characteristic
+}
+
+// Char is the discovered characteristic u, or nil
+func (l *Link) Char(u ble.UUID) *ble.Characteristic {
+	return l.chars[u.String()]
+}
+
+// Command writes a single short command, without waiting for a response
+func (l *Link) Command(chr *ble.Characteristic, cmd []byte) error {
+	if l.Transfer.Trace != nil {
+		l.Transfer.Trace(cmd)
+	}
+	return l.Client.WriteCharacteristic(chr, cmd, true)
+}
+
+// Write writes data to chr in chunks. While hold, if given, returns true,
+// sending waits.
+func (l *Link) Write(ctx context.Context, chr *ble.Characteristic, data []byte, hold func() bool) error {
+	t := l.Transfer
+	if t.ChunkSize < 1 {
+		return fmt.Errorf("invalid chunk size %d", t.ChunkSize)
+	}
+	if t.Trace != nil {
+		t.Trace(data)
+	}
+	for offset := 0; offset < len(data); offset += t.ChunkSize {
+		for hold != nil && hold() {
+			if err := sleep(ctx, 10*time.Millisecond); err != nil {
+				return err
+			}
+		}
+		end := min(offset+t.ChunkSize, len(data))
+		if err := l.Client.WriteCharacteristic(chr, data[offset:end], !t.Response); err != nil {
+			return err
+		}
+		if t.Delay > 0 {
+			time.Sleep(t.Delay)
+		}
+	}
+	return nil
+}
+
+// WriteLines writes data, lineLen bytes per line, to chr. A non-zero pause
+// is waited after every PacingBatch lines.
+func (l *Link) WriteLines(ctx context.Context, chr *ble.Characteristic, data []byte, lineLen int, pause time.Duration) error {
+	for y := 0; y < len(data)/lineLen; y++ {
+		if err := l.Write(ctx, chr, data[y*lineLen:(y+1)*lineLen], nil); err != nil {
+			return fmt.Errorf("line %d chunk write failed: %v", y, err)
+		}
+		if err := Pace(ctx, y, pause); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pace waits pause after every PacingBatch lines, line being the one just
+// sent
+func Pace(ctx context.Context, line int, pause time.Duration) error {
+	if pause > 0 && (line+1)%PacingBatch == 0 {
+		return sleep(ctx, pause)
+	}
+	return ctx.Err()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Frame frames a command the way the cat printer protocols do: header,
+// command, a zero byte, payload length, payload, CRC-8 of the payload, 0xFF
+func Frame(header []byte, cmdId byte, payload []byte) []byte {
+	cmd := append([]byte{}, header...)
+	cmd = append(cmd, cmdId, 0x00, byte(len(payload)&0xFF), byte(len(payload)>>8))
+	cmd = append(cmd, payload...)
+	cmd = append(cmd, crc8(payload))
+	cmd = append(cmd, 0xFF)
+	return cmd
+}
+
+func crc8(data []byte) byte {
+	table := [256]byte{
+		0x00, 0x07, 0x0e, 0x09, 0x1c, 0x1b, 0x12, 0x15,
+		0x38, 0x3f, 0x36, 0x31, 0x24, 0x23, 0x2a, 0x2d,
+		0x70, 0x77, 0x7e, 0x79, 0x6c, 0x6b, 0x62, 0x65,
+		0x48, 0x4f, 0x46, 0x41, 0x54, 0x53, 0x5a, 0x5d,
+		0xe0, 0xe7, 0xee, 0xe9, 0xfc, 0xfb, 0xf2, 0xf5,
+		0xd8, 0xdf, 0xd6, 0xd1, 0xc4, 0xc3, 0xca, 0xcd,
+		0x90, 0x97, 0x9e, 0x99, 0x8c, 0x8b, 0x82, 0x85,
+		0xa8, 0xaf, 0xa6, 0xa1, 0xb4, 0xb3, 0xba, 0xbd,
+		0xc7, 0xc0, 0xc9, 0xce, 0xdb, 0xdc, 0xd5, 0xd2,
+		0xff, 0xf8, 0xf1, 0xf6, 0xe3, 0xe4, 0xed, 0xea,
+		0xb7, 0xb0, 0xb9, 0xbe, 0xab, 0xac, 0xa5, 0xa2,
+		0x8f, 0x88, 0x81, 0x86, 0x93, 0x94, 0x9d, 0x9a,
+		0x27, 0x20, 0x29, 0x2e, 0x3b, 0x3c, 0x35, 0x32,
+		0x1f, 0x18, 0x11, 0x16, 0x03, 0x04, 0x0d, 0x0a,
+		0x57, 0x50, 0x59, 0x5e, 0x4b, 0x4c, 0x45, 0x42,
+		0x6f, 0x68, 0x61, 0x66, 0x73, 0x74, 0x7d, 0x7a,
+		0x89, 0x8e, 0x87, 0x80, 0x95, 0x92, 0x9b, 0x9c,
+		0xb1, 0xb6, 0xbf, 0xb8, 0xad, 0xaa, 0xa3, 0xa4,
+		0xf9, 0xfe, 0xf7, 0xf0, 0xe5, 0xe2, 0xeb, 0xec,
+		0xc1, 0xc6, 0xcf, 0xc8, 0xdd, 0xda, 0xd3, 0xd4,
+		0x69, 0x6e, 0x67, 0x60, 0x75, 0x72, 0x7b, 0x7c,
+		0x51, 0x56, 0x5f, 0x58, 0x4d, 0x4a, 0x43, 0x44,
+		0x19, 0x1e, 0x17, 0x10, 0x05, 0x02, 0x0b, 0x0c,
+		0x21, 0x26, 0x2f, 0x28, 0x3d, 0x3a, 0x33, 0x34,
+		0x4e, 0x49, 0x40, 0x47, 0x52, 0x55, 0x5c, 0x5b,
+		0x76, 0x71, 0x78, 0x7f, 0x6a, 0x6d, 0x64, 0x63,
+		0x3e, 0x39, 0x30, 0x37, 0x22, 0x25, 0x2c, 0x2b,
+		0x06, 0x01, 0x08, 0x0f, 0x1a, 0x1d, 0x14, 0x13,
+		0xae, 0xa9, 0xa0, 0xa7, 0xb2, 0xb5, 0xbc, 0xbb,
+		0x96, 0x91, 0x98, 0x9f, 0x8a, 0x8d, 0x84, 0x83,
+		0xde, 0xd9, 0xd0, 0xd7, 0xc2, 0xc5, 0xcc, 0xcb,
+		0xe6, 0xe1, 0xe8, 0xef, 0xfa, 0xfd, 0xf4, 0xf3}
+	crc := byte(0)
+	for _, b := range data {
+		crc = table[crc^b]
+	}
+	return crc
+}
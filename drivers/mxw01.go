@@ -0,0 +1,258 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	ble "github.com/go-ble/ble"
+)
+
+// The MXW01 takes commands on one characteristic and image data on another,
+// and reports a finished print
+
+var (
+	mainServiceUUID      = ble.MustParse("ae30")
+	printCharacteristic  = ble.MustParse("ae01")
+	notifyCharacteristic = ble.MustParse("ae02")
+	dataCharacteristic   = ble.MustParse("ae03")
+	printCommandHeader   = []byte{0x22, 0x21}
+)
+
+// mxw01DefaultSpeed is the speed the official app prints at
+const mxw01DefaultSpeed = 0x30
+
+// mxw01Speeds are the speed values known to work. The firmware takes a byte,
+// but captures only show the app's; add others once they are seen in use.
+var mxw01Speeds = []byte{mxw01DefaultSpeed}
+
+// MXW01 is the model unknown printers are treated as
+var MXW01 = &Model{
+	Name:        "MXW01",
+	Match:       Prefix("MXW01"),
+	Services:    []ble.UUID{mainServiceUUID},
+	Width:       384,
+	MaxJobLines: 0xFFFF,
+	Gray:        true,
+	Speeds:      mxw01Speeds,
+	New: func(l *Link) (Driver, error) {
+		d := &mxw01Driver{
+			link:      l,
+			printChr:  l.Char(printCharacteristic),
+			notifyChr: l.Char(notifyCharacteristic),
+			dataChr:   l.Char(dataCharacteristic),
+		}
+		if d.printChr == nil || d.dataChr == nil {
+			return nil, fmt.Errorf("missing required printer characteristics")
+		}
+		return d, nil
+	},
+}
+
+func init() {
+	Register(MXW01)
+}
+
+type mxw01Driver struct {
+	link      *Link
+	printChr  *ble.Characteristic
+	notifyChr *ble.Characteristic
+	dataChr   *ble.Characteristic
+}
+
+func (d *mxw01Driver) NotifyChar() *ble.Characteristic { return d.notifyChr }
+func (d *mxw01Driver) DataChar() *ble.Characteristic   { return d.dataChr }
+
+func (d *mxw01Driver) command(cmdId byte, payload ...byte) error {
+	return d.link.Command(d.printChr, Frame(printCommandHeader, cmdId, payload))
+}
+
+func (d *mxw01Driver) Send(ctx context.Context, job Job) error {
+	fmt.Printf("Sending image: %dx%d lines\n", job.Width, job.Height)
+
+	if err := d.command(0xA2, job.Intensity); err != nil {
+		return fmt.Errorf("intensity set failed: %v", err)
+	}
+
+	mode := byte(0x00)
+	if job.Gray {
+		mode = 0x02
+	}
+	speed := byte(mxw01DefaultSpeed)
+	if job.Speed != 0 {
+		speed = job.Speed
+	}
+	if err := d.command(0xA9, byte(job.Height&0xFF), byte(job.Height>>8), speed, mode); err != nil {
+		return fmt.Errorf("print command failed: %v", err)
+	}
+
+	if err := d.link.WriteLines(ctx, d.dataChr, job.Pixels, job.LineBytes(), job.Pause); err != nil {
+		return err
+	}
+
+	if err := d.command(0xAD, 0x00); err != nil {
+		return fmt.Errorf("flush failed: %v", err)
+	}
+	return nil
+}
+
+func (d *mxw01Driver) Query(q Query) error {
+	cmdId := map[Query]byte{
+		AskStatus:    0xA1,
+		AskBattery:   0xAB,
+		AskVersion:   0xB1,
+		AskPrintType: 0xB0,
+		AskCount:     0xA7,
+	}[q]
+	return d.command(cmdId, 0x00)
+}
+
+func (d *mxw01Driver) Feed(lines uint) error {
+	return d.command(0xA3, byte(lines&0xFF), byte(lines>>8))
+}
+
+func (d *mxw01Driver) Retract(lines uint) error {
+	return d.command(0xA4, byte(lines&0xFF), byte(lines>>8))
+}
+
+func (d *mxw01Driver) Decode(data []byte) Notification {
+	if len(data) < 6 || data[0] != 0x22 || data[1] != 0x21 {
+		return Notification{Unknown: true}
+	}
+
+	cmd := data[2]
+	dataLen := int(data[4]) | int(data[5])<<8
+
+	switch cmd {
+	case 0xA1: // GetStatus
+		if len(data) < 14 {
+			return Notification{Text: "Malformed status notification"}
+		}
+		s := decodeStatus(data)
+		return Notification{Status: &s}
+
+	case 0xA2, 0xAD: // SetIntensity and PrintDataFlush, acknowledged
+		return Notification{}
+
+	case 0xA3: // EjectPaper
+		return Notification{Text: "Ejecting paper..."}
+
+	case 0xA4: // RetractPaper
+		return Notification{Text: "Retracting paper..."}
+
+	case 0xA7: // QueryCount
+		// Not documented anywhere: the layout is inferred, a 32-bit count of
+		// lines and a 16-bit one of jobs, both little-endian. --trace-protocol
+		// shows the raw reply to check it against.
+		if len(data) >= 12 {
+			c := Count{
+				Lines:  int(binary.LittleEndian.Uint32(data[6:10])),
+				Prints: int(binary.LittleEndian.Uint16(data[10:12])),
+			}
+			return Notification{Count: &c, Text: fmt.Sprintf("Lines printed: %d, prints since power-on: %d", c.Lines, c.Prints)}
+		}
+
+	case 0xA9: // Print
+		if len(data) < 7 {
+			return Notification{Unknown: true}
+		}
+		if data[6] != 0 {
+			return Notification{Error: fmt.Sprintf("print request refused (code 0x%02X)", data[6]), Text: "Print status: Failure"}
+		}
+		return Notification{Text: "Print status: Ok"}
+
+	case 0xAA: // PrintComplete
+		return Notification{Done: true, Text: "Printing finished."}
+
+	case 0xAB: // BatteryLevel
+		if len(data) < 7 {
+			return Notification{Unknown: true}
+		}
+		return Notification{Text: fmt.Sprintf("Battery level: %d", data[6])}
+
+	case 0xAC: // CancelPrint
+		return Notification{Text: "Print cancelled."}
+
+	case 0xAE:
+		// Some firmware sends this while image data comes in, presumably
+		// about its buffer; what the payload means isn't known, so it is
+		// only shown by --trace-protocol
+		return Notification{}
+
+	case 0xB0: // GetPrintType
+		if len(data) < 7 {
+			return Notification{Unknown: true}
+		}
+		var t string
+		switch data[6] {
+		case 0x01:
+			t = `High pressure`
+		case 0xFF:
+			t = `Unknown`
+		default:
+			t = `Low pressure`
+		}
+		return Notification{Text: fmt.Sprintf("Print type: %s", t)}
+
+	case 0xB1: // GetVersion
+		if len(data) < 15 || len(data) < 6+dataLen {
+			return Notification{Unknown: true}
+		}
+		version := string(data[6 : 6+dataLen])
+		var t string
+		switch data[14] {
+		case 0x32:
+			t = `High pressure`
+		case 0x31:
+			t = `Low pressure`
+		default:
+			t = `Unknown`
+		}
+		return Notification{Text: fmt.Sprintf("Version: %s, Print type: %s", version, t)}
+
+	}
+	return Notification{Unknown: true}
+}
+
+func decodeStatus(data []byte) Status {
+	s := Status{
+		OK:          data[12] == 0,
+		State:       "Unknown",
+		Battery:     int(data[9]),
+		Temperature: int(data[10]),
+	}
+	statusCode := data[6]
+	errCode := data[13]
+	if s.OK {
+		switch statusCode {
+		case 0x0:
+			s.State = "Standby"
+		case 0x1:
+			s.State = "Printing"
+		case 0x2:
+			s.State = "Feeding paper"
+		case 0x3:
+			s.State = "Ejecting paper"
+		}
+	} else {
+		switch errCode {
+		case 0x1, 0x9:
+			s.State = "No paper"
+		case 0x4:
+			s.State = "Overheated"
+		case 0x8:
+			s.State = "Low battery"
+		}
+	}
+	return s
+}
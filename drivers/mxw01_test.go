@@ -0,0 +1,72 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mxw01Frame builds a notification as the printer sends it, with its
+// payload after the 6 byte header
+func mxw01Frame(cmd byte, payload ...byte) []byte {
+	return append([]byte{0x22, 0x21, cmd, 0x00, byte(len(payload)), byte(len(payload) >> 8)}, payload...)
+}
+
+func TestMXW01Decode(t *testing.T) {
+	d := &mxw01Driver{}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want Notification
+	}{
+		{"status", mxw01Frame(0xA1, 0x00, 0, 0, 80, 30, 0, 0x00, 0x00),
+			Notification{Status: &Status{OK: true, State: "Standby", Battery: 80, Temperature: 30}}},
+		{"status no paper", mxw01Frame(0xA1, 0x00, 0, 0, 80, 30, 0, 0x01, 0x01),
+			Notification{Status: &Status{State: "No paper", Battery: 80, Temperature: 30}}},
+		{"print ok", mxw01Frame(0xA9, 0x00), Notification{Text: "Print status: Ok"}},
+		{"print refused", mxw01Frame(0xA9, 0x01),
+			Notification{Error: "print request refused (code 0x01)", Text: "Print status: Failure"}},
+		{"done", mxw01Frame(0xAA), Notification{Done: true, Text: "Printing finished."}},
+		{"battery", mxw01Frame(0xAB, 57), Notification{Text: "Battery level: 57"}},
+		{"print type", mxw01Frame(0xB0, 0x01), Notification{Text: "Print type: High pressure"}},
+		{"count", mxw01Frame(0xA7, 0x10, 0x27, 0, 0, 0x05, 0),
+			Notification{Count: &Count{Lines: 10000, Prints: 5}, Text: "Lines printed: 10000, prints since power-on: 5"}},
+		{"version", mxw01Frame(0xB1, '1', '.', '9', '.', '3', '.', '1', '.', 0x32),
+			Notification{Text: "Version: 1.9.3.1.2, Print type: High pressure"}},
+		{"unknown command", mxw01Frame(0xEE, 0x00), Notification{Unknown: true}},
+		{"wrong magic", []byte{0x21, 0x22, 0xAA, 0, 0, 0}, Notification{Unknown: true}},
+
+		{"empty", nil, Notification{Unknown: true}},
+		{"truncated header", []byte{0x22, 0x21, 0xAB}, Notification{Unknown: true}},
+		{"truncated battery", mxw01Frame(0xAB), Notification{Unknown: true}},
+		{"truncated print", mxw01Frame(0xA9), Notification{Unknown: true}},
+		{"truncated print type", mxw01Frame(0xB0), Notification{Unknown: true}},
+		{"truncated version", mxw01Frame(0xB1, '1', '.', '9'), Notification{Unknown: true}},
+		{"version shorter than its length", append(mxw01Frame(0xB1, make([]byte, 9)...)[:6], 0x20), Notification{Unknown: true}},
+		{"truncated count", mxw01Frame(0xA7, 0x10, 0x27), Notification{Unknown: true}},
+		{"truncated status", mxw01Frame(0xA1, 0x00, 0), Notification{Text: "Malformed status notification"}},
+	} {
+		if got := d.Decode(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Decode(% X) = %+v, want %+v", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMXW01DecodeTruncated(t *testing.T) {
+	d := &mxw01Driver{}
+	for cmd := 0xA0; cmd <= 0xB2; cmd++ {
+		frame := mxw01Frame(byte(cmd), make([]byte, 16)...)
+		for n := 0; n <= len(frame); n++ {
+			d.Decode(frame[:n]) // must not panic
+		}
+	}
+}
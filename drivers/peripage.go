@@ -0,0 +1,133 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	ble "github.com/go-ble/ble"
+)
+
+// Peripage printers (A6, A9) have a 576-dot head. Both advertise as
+// PeriPage and nothing known tells them apart, so they are one model. Settings and queries are
+// vendor commands starting with 10 FF, images go as rasters. Replies carry no
+// header, so they are told apart by what was asked last. Only 1bpp is
+// supported, and only the battery is reported.
+
+var (
+	peripageServiceUUID          = ble.MustParse("ff00")
+	peripageWriteCharacteristic  = ble.MustParse("ff02")
+	peripageNotifyCharacteristic = ble.MustParse("ff01")
+)
+
+const peripageWidth = 576
+
+func init() {
+	Register(&Model{
+		Name:     "PeriPage",
+		Match:    Prefix("PeriPage"),
+		Services: []ble.UUID{peripageServiceUUID},
+		Width:    peripageWidth,
+		New:      newPeripage,
+	})
+}
+
+func newPeripage(l *Link) (Driver, error) {
+	d := &peripageDriver{
+		link:      l,
+		writeChr:  l.Char(peripageWriteCharacteristic),
+		notifyChr: l.Char(peripageNotifyCharacteristic),
+	}
+	if d.writeChr == nil {
+		return nil, fmt.Errorf("missing required printer characteristics")
+	}
+	return d, nil
+}
+
+type peripageDriver struct {
+	link      *Link
+	writeChr  *ble.Characteristic
+	notifyChr *ble.Characteristic
+	asked     atomic.Int32 // the Query the next reply answers
+	finishing atomic.Bool  // the next battery reply means the job is printed
+}
+
+func (d *peripageDriver) NotifyChar() *ble.Characteristic { return d.notifyChr }
+func (d *peripageDriver) DataChar() *ble.Characteristic   { return nil }
+
+func (d *peripageDriver) write(ctx context.Context, data ...byte) error {
+	return d.link.Write(ctx, d.writeChr, data, nil)
+}
+
+func (d *peripageDriver) Send(ctx context.Context, job Job) error {
+	if job.Gray {
+		return ErrGray
+	}
+	fmt.Printf("Sending image: %dx%d lines\n", job.Width, job.Height)
+
+	// Reset, then pick one of the three concentrations from the intensity
+	setup := []byte{0x10, 0xFF, 0xFE, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	setup = append(setup, 0x10, 0xFF, 0x10, 0x00, byte(min(2, int(job.Intensity)*3/100)))
+	if err := d.write(ctx, setup...); err != nil {
+		return fmt.Errorf("print setup failed: %v", err)
+	}
+
+	if err := sendRaster(ctx, d.write, job, peripageWidth/8); err != nil {
+		return err
+	}
+
+	// Feed the printout past the tear edge. The battery reply comes once the
+	// printer got through everything before.
+	d.finishing.Store(true)
+	d.asked.Store(int32(AskBattery))
+	if err := d.write(ctx, 0x1B, 0x4A, 0x40, 0x10, 0xFF, 0x50, 0xF1); err != nil {
+		return fmt.Errorf("print end failed: %v", err)
+	}
+	return nil
+}
+
+func (d *peripageDriver) Query(q Query) error {
+	var cmd []byte
+	switch q {
+	case AskStatus, AskBattery:
+		cmd = []byte{0x10, 0xFF, 0x50, 0xF1}
+	case AskVersion:
+		cmd = []byte{0x10, 0xFF, 0x20, 0xF1}
+	default:
+		return ErrUnsupported
+	}
+	d.asked.Store(int32(q))
+	return d.write(context.Background(), cmd...)
+}
+
+func (d *peripageDriver) Feed(lines uint) error {
+	return d.write(context.Background(), feedCommands(lines)...)
+}
+
+func (d *peripageDriver) Retract(lines uint) error {
+	return ErrUnsupported
+}
+
+func (d *peripageDriver) Decode(data []byte) Notification {
+	switch Query(d.asked.Load()) {
+	case AskStatus, AskBattery:
+		if len(data) < 2 {
+			break
+		}
+		s := Status{OK: true, State: "Standby", Battery: int(data[1]), Temperature: Unknown}
+		return Notification{Status: &s, Done: d.finishing.Swap(false)}
+	case AskVersion:
+		return Notification{Text: fmt.Sprintf("Version: %s", data)}
+	}
+	return Notification{Unknown: true}
+}
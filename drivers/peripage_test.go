@@ -0,0 +1,38 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPeriPageDecode(t *testing.T) {
+	d := &peripageDriver{}
+	for _, tt := range []struct {
+		name  string
+		asked Query
+		data  []byte
+		want  Notification
+	}{
+		{"status", AskStatus, []byte{0x00, 75}, Notification{Status: &Status{OK: true, State: "Standby", Battery: 75, Temperature: Unknown}}},
+		{"battery", AskBattery, []byte{0x00, 20, 0x00}, Notification{Status: &Status{OK: true, State: "Standby", Battery: 20, Temperature: Unknown}}},
+		{"version", AskVersion, []byte("V2.11_304dpi"), Notification{Text: "Version: V2.11_304dpi"}},
+		{"not asked", AskCount, []byte{0x00, 75}, Notification{Unknown: true}},
+		{"empty", AskStatus, nil, Notification{Unknown: true}},
+		{"truncated", AskBattery, []byte{0x00}, Notification{Unknown: true}},
+	} {
+		d.asked.Store(int32(tt.asked))
+		if got := d.Decode(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Decode(% X) = %+v, want %+v", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
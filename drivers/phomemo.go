@@ -0,0 +1,163 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	ble "github.com/go-ble/ble"
+)
+
+// Phomemo printers (M02, T02) talk ESC/POS with a few vendor commands
+// starting with 1F 11, and take images as rasters. The printer answers
+// queries with 1A <what> <value>.
+// Only 1bpp is supported, and the temperature isn't reported.
+
+var (
+	phomemoServiceUUID          = ble.MustParse("ff00")
+	phomemoWriteCharacteristic  = ble.MustParse("ff02")
+	phomemoNotifyCharacteristic = ble.MustParse("ff03")
+)
+
+const (
+	phomemoWidth = 384
+
+	phomemoReplyBattery = 0x04
+	phomemoReplyPaper   = 0x06
+	phomemoReplyDone    = 0x0F
+)
+
+func init() {
+	for _, name := range []string{"M02", "T02"} {
+		Register(&Model{
+			Name:     name,
+			Match:    Prefix(name),
+			Services: []ble.UUID{phomemoServiceUUID},
+			Width:    phomemoWidth,
+			New:      newPhomemo,
+		})
+	}
+}
+
+func newPhomemo(l *Link) (Driver, error) {
+	d := &phomemoDriver{
+		link:      l,
+		writeChr:  l.Char(phomemoWriteCharacteristic),
+		notifyChr: l.Char(phomemoNotifyCharacteristic),
+		battery:   Unknown,
+	}
+	if d.writeChr == nil {
+		return nil, fmt.Errorf("missing required printer characteristics")
+	}
+	return d, nil
+}
+
+type phomemoDriver struct {
+	link      *Link
+	writeChr  *ble.Characteristic
+	notifyChr *ble.Characteristic
+
+	mu        sync.Mutex
+	battery   int         // from the last battery reply, sent along with the status
+	finishing atomic.Bool // the next paper reply means the job is printed
+}
+
+func (d *phomemoDriver) NotifyChar() *ble.Characteristic { return d.notifyChr }
+func (d *phomemoDriver) DataChar() *ble.Characteristic   { return nil }
+
+func (d *phomemoDriver) write(ctx context.Context, data ...byte) error {
+	return d.link.Write(ctx, d.writeChr, data, nil)
+}
+
+func (d *phomemoDriver) Send(ctx context.Context, job Job) error {
+	if job.Gray {
+		return ErrGray
+	}
+	fmt.Printf("Sending image: %dx%d lines\n", job.Width, job.Height)
+
+	// Initialize, center, and set the density (1-8) from the intensity
+	density := byte(1 + int(job.Intensity)*7/100)
+	if err := d.write(ctx, 0x1B, 0x40, 0x1B, 0x61, 0x01, 0x1F, 0x11, 0x02, density); err != nil {
+		return fmt.Errorf("print setup failed: %v", err)
+	}
+
+	if err := sendRaster(ctx, d.write, job, phomemoWidth/8); err != nil {
+		return err
+	}
+
+	// Feed the printout past the tear edge. The paper reply comes once the
+	// printer got through everything before.
+	d.finishing.Store(true)
+	if err := d.write(ctx, 0x1B, 0x64, 0x02, 0x1B, 0x64, 0x02, 0x1F, 0x11, 0x11); err != nil {
+		return fmt.Errorf("print end failed: %v", err)
+	}
+	return nil
+}
+
+func (d *phomemoDriver) Query(q Query) error {
+	switch q {
+	case AskStatus:
+		// The status goes out with the paper reply, after the battery one
+		return d.write(context.Background(), 0x1F, 0x11, 0x08, 0x1F, 0x11, 0x11)
+	case AskBattery:
+		return d.write(context.Background(), 0x1F, 0x11, 0x08)
+	case AskVersion:
+		return d.write(context.Background(), 0x1F, 0x11, 0x07)
+	default:
+		return ErrUnsupported
+	}
+}
+
+func (d *phomemoDriver) Feed(lines uint) error {
+	return d.write(context.Background(), feedCommands(lines)...)
+}
+
+func (d *phomemoDriver) Retract(lines uint) error {
+	return ErrUnsupported
+}
+
+func (d *phomemoDriver) Decode(data []byte) Notification {
+	if len(data) < 3 || data[0] != 0x1A {
+		return Notification{Unknown: true}
+	}
+	switch data[1] {
+	case phomemoReplyBattery:
+		d.mu.Lock()
+		d.battery = int(data[2])
+		d.mu.Unlock()
+		return Notification{Text: fmt.Sprintf("Battery level: %d", data[2])}
+	case phomemoReplyPaper:
+		d.mu.Lock()
+		s := Status{OK: true, State: "Standby", Battery: d.battery, Temperature: Unknown}
+		d.mu.Unlock()
+		if data[2] == 0x88 {
+			s.OK, s.State = false, "No paper"
+		}
+		return Notification{Status: &s, Done: d.finishing.Swap(false)}
+	case phomemoReplyDone:
+		return Notification{Done: true, Text: "Printing finished."}
+	case 0x07:
+		return Notification{Text: fmt.Sprintf("Version: %d.%d.%d", data[2], at(data, 3), at(data, 4))}
+	default:
+		return Notification{Unknown: true}
+	}
+}
+
+// at returns data[i], or 0 past its end
+func at(data []byte, i int) byte {
+	if i < len(data) {
+		return data[i]
+	}
+	return 0
+}
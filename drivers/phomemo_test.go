@@ -0,0 +1,41 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package drivers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPhomemoDecode(t *testing.T) {
+	d := &phomemoDriver{battery: Unknown}
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want Notification
+	}{
+		{"paper before battery", []byte{0x1A, phomemoReplyPaper, 0x89}, Notification{Status: &Status{OK: true, State: "Standby", Battery: Unknown, Temperature: Unknown}}},
+		{"battery", []byte{0x1A, phomemoReplyBattery, 64}, Notification{Text: "Battery level: 64"}},
+		{"paper", []byte{0x1A, phomemoReplyPaper, 0x89}, Notification{Status: &Status{OK: true, State: "Standby", Battery: 64, Temperature: Unknown}}},
+		{"no paper", []byte{0x1A, phomemoReplyPaper, 0x88}, Notification{Status: &Status{State: "No paper", Battery: 64, Temperature: Unknown}}},
+		{"done", []byte{0x1A, phomemoReplyDone, 0x0C}, Notification{Done: true, Text: "Printing finished."}},
+		{"version", []byte{0x1A, 0x07, 1, 2, 3}, Notification{Text: "Version: 1.2.3"}},
+		{"short version", []byte{0x1A, 0x07, 1}, Notification{Text: "Version: 1.0.0"}},
+		{"unknown reply", []byte{0x1A, 0x55, 0x00}, Notification{Unknown: true}},
+		{"wrong magic", []byte{0x1B, phomemoReplyDone, 0x0C}, Notification{Unknown: true}},
+		{"empty", nil, Notification{Unknown: true}},
+		{"truncated", []byte{0x1A, phomemoReplyBattery}, Notification{Unknown: true}},
+	} {
+		if got := d.Decode(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Decode(% X) = %+v, want %+v", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// edgeBlur smooths the image before looking for edges, so texture and noise
+// don't come out as a scatter of short lines
+const edgeBlur = 1.4
+
+// outline renders img as line art, black lines on white where its edges are,
+// with the Canny method: Sobel gradients thinned to their ridges, kept where
+// strong and where weaker ones continue strong ones. The strong cutoff is
+// Otsu's threshold of the gradients, the weak one half of it.
+func outline(img image.Image) *image.Gray {
+	g := toGray(img)
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	smooth := gaussianBlur(g, edgeBlur)
+	at := func(x, y int) float64 {
+		x, y = min(max(x, 0), w-1), min(max(y, 0), h-1)
+		return smooth[y*w+x]
+	}
+
+	magnitude := make([]float64, w*h)
+	direction := make([]uint8, w*h) // 0 horizontal, 1 rising, 2 vertical, 3 falling
+	scaled := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			m := math.Hypot(gx, gy)
+			magnitude[y*w+x] = m
+			scaled.Pix[y*scaled.Stride+x] = uint8(min(m/4, 255))
+			angle := math.Mod(math.Atan2(gy, gx)*180/math.Pi+180, 180)
+			direction[y*w+x] = uint8(int(angle+22.5)/45) % 4
+		}
+	}
+
+	// Keep only the pixels where the gradient peaks across the edge
+	neighbours := [4][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}}
+	ridge := make([]float64, w*h)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			d := neighbours[direction[i]]
+			m := magnitude[i]
+			if m > magnitude[i+d[1]*w+d[0]] && m >= magnitude[i-d[1]*w-d[0]] {
+				ridge[i] = m
+			}
+		}
+	}
+
+	high := 4 * float64(otsuThreshold(scaled))
+	low := high / 2
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range out.Pix {
+		out.Pix[i] = 255
+	}
+	// Follow every strong edge through the weaker pixels it connects to
+	var stack []int
+	for i, m := range ridge {
+		if m < high || out.Pix[i] == 0 {
+			continue
+		}
+		out.Pix[i] = 0
+		stack = append(stack[:0], i)
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := j%w, j/w
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					k := ny*w + nx
+					if ridge[k] >= low && out.Pix[k] != 0 {
+						out.Pix[k] = 0
+						stack = append(stack, k)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// gaussianBlur blurs a gray image into floats; rounding back to 8 bits
+// would leave smooth gradients as steps, each step an edge
+func gaussianBlur(g *image.Gray, sigma float64) []float64 {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	rows := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 0.0
+			for i, k := range kernel {
+				sx := min(max(x+i-radius, 0), w-1)
+				v += k * float64(g.Pix[y*g.Stride+sx])
+			}
+			rows[y*w+x] = v
+		}
+	}
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 0.0
+			for i, k := range kernel {
+				sy := min(max(y+i-radius, 0), h-1)
+				v += k * rows[sy*w+x]
+			}
+			out[y*w+x] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+// Failure causes scripts can tell apart by the exit status. Everything else
+// exits with 1.
+var (
+	errBadInput        = errors.New("bad input")
+	errPrinterNotFound = errors.New("printer not found")
+	errConnect         = errors.New("connect failed")
+	errNoPaper         = errors.New("printer is out of paper")
+	errOverheated      = errors.New("printer is overheated")
+	errLowBattery      = errors.New("battery too low")
+	errTransfer        = errors.New("transfer failed")
+	errTimeout         = errors.New("timed out")
+)
+
+// exitCodes is checked in order, the first cause found in an error wins.
+// Keep the README's exit status table in sync.
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{errBadInput, 2},
+	{errPrinterNotFound, 3},
+	{errConnect, 4},
+	{errNoPaper, 5},
+	{errOverheated, 6},
+	{errLowBattery, 7},
+	{errTransfer, 8},
+	{errTimeout, 9},
+	{context.DeadlineExceeded, 9},
+	{context.Canceled, 130}, // interrupted, like a shell reports SIGINT
+}
+
+// causeError gives err one of the causes above without changing its message
+type causeError struct {
+	cause error
+	err   error
+}
+
+func (e causeError) Error() string   { return e.err.Error() }
+func (e causeError) Unwrap() []error { return []error{e.cause, e.err} }
+
+// withCause marks err as caused by cause, keeping nil errors nil
+func withCause(cause, err error) error {
+	if err == nil {
+		return nil
+	}
+	return causeError{cause: cause, err: err}
+}
+
+func exitCode(err error) int {
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return 1
+}
+
+// fatal logs msg and err and exits with the status for err's cause
+func fatal(msg string, err error) {
+	log.Printf("%s: %v", msg, err)
+	os.Exit(exitCode(err))
+}
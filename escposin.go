@@ -0,0 +1,497 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+)
+
+// ESC/POS input: the byte streams POS software sends to receipt printers are
+// played back into an image the way such a printer would have printed them.
+// Text, alignment, emphasis, character size, feeds and the GS v 0 and ESC *
+// bitmaps are honoured; barcodes, QR codes, cuts, code pages and the like are
+// skipped.
+
+const (
+	escByte = 0x1b
+	gsByte  = 0x1d
+	fsByte  = 0x1c
+	dleByte = 0x10
+)
+
+// Font A of a receipt printer is 12x24 dots in 30-dot lines, so receipts laid
+// out for 32 columns on 58 mm paper keep their columns
+const (
+	escposCellWidth   = 12
+	escposCellHeight  = 24
+	escposLineSpacing = 30
+)
+
+// Arguments taken by the ESC and GS commands that are read but not acted on
+var (
+	escposEscArgs = map[byte]int{
+		' ': 1, '-': 1, '<': 0, '=': 1, 'B': 2, 'M': 1, 'R': 1,
+		'S': 0, 'T': 1, 'U': 1, 'V': 1, 'c': 2, 'i': 0, 'm': 0, 'p': 3, 'r': 1,
+		't': 1, '{': 1, '$': 2, '\\': 2,
+	}
+	escposGsArgs = map[byte]int{
+		'B': 1, 'H': 1, 'I': 1, 'L': 2, 'P': 2, 'W': 2, 'a': 1, 'b': 1, 'f': 1,
+		'h': 1, 'r': 1, 'w': 1, '$': 2, '\\': 2,
+	}
+)
+
+// escposRenderer keeps the printer state while a stream is played back
+type escposRenderer struct {
+	width   int
+	parts   []image.Image
+	line    []escposRun // text of the current line
+	lineW   int         // dots of the line taken by the text
+	band    *image.Gray // ESC * graphics on the current line
+	align   int         // 0 left, 1 centre, 2 right
+	bold    bool
+	wide    int // character size multipliers
+	tall    int
+	spacing int // line spacing in dots
+	skipped map[string]bool
+}
+
+// escposRun is text of a line printed in one style
+type escposRun struct {
+	text       []byte
+	bold       bool
+	wide, tall int
+}
+
+// renderESCPOS plays an ESC/POS stream back onto paper width dots wide
+func renderESCPOS(data []byte, width int) (image.Image, error) {
+	r := &escposRenderer{width: width, skipped: map[string]bool{}}
+	r.reset()
+	for i := 0; i < len(data); {
+		n, err := r.command(data[i:])
+		if err != nil {
+			return nil, withCause(errBadInput, fmt.Errorf("ESC/POS at byte %d: %v", i, err))
+		}
+		i += n
+	}
+	r.newline()
+	if len(r.parts) == 0 {
+		return nil, withCause(errBadInput, fmt.Errorf("nothing printable in the ESC/POS stream"))
+	}
+	return globalThreshold(toGray(stackImages(r.parts)), 128), nil
+}
+
+func (r *escposRenderer) reset() {
+	r.align, r.bold, r.wide, r.tall = 0, false, 1, 1
+	r.spacing = escposLineSpacing
+}
+
+// escposNeed fails unless b holds at least n bytes
+func escposNeed(b []byte, n int) error {
+	if len(b) < n {
+		return fmt.Errorf("command cut short")
+	}
+	return nil
+}
+
+// command interprets the character or command at the start of b and returns
+// how many bytes it took
+func (r *escposRenderer) command(b []byte) (int, error) {
+	switch c := b[0]; c {
+	case '\n':
+		r.newline()
+	case '\f':
+		if len(r.line) > 0 || r.band != nil {
+			r.newline()
+		}
+	case '\t':
+		r.char(' ')
+		for r.lineW/escposCellWidth%8 != 0 {
+			r.char(' ')
+		}
+	case escByte:
+		return r.esc(b)
+	case gsByte:
+		return r.gs(b)
+	case fsByte:
+		if err := escposNeed(b, 2); err != nil {
+			return 0, err
+		}
+		switch b[1] {
+		case 'p':
+			r.skip("NV logos")
+			return 4, escposNeed(b, 4)
+		case 'C', '!', '-':
+			return 3, escposNeed(b, 3)
+		}
+		return 2, nil
+	case dleByte:
+		return 3, escposNeed(b, 3) // status requests and pulses
+	default:
+		if c >= ' ' {
+			r.char(c)
+		}
+	}
+	return 1, nil
+}
+
+// char adds c to the current line in the current style, wrapping like the
+// printer does when it doesn't fit
+func (r *escposRenderer) char(c byte) {
+	w := 0
+	if utf8.RuneStart(c) { // UTF-8 continuation bytes share their first byte's cell
+		w = escposCellWidth * r.wide
+		if r.lineW+w > r.width && r.lineW > 0 {
+			r.newline()
+		}
+	}
+	if n := len(r.line); n == 0 || r.line[n-1].bold != r.bold || r.line[n-1].wide != r.wide || r.line[n-1].tall != r.tall {
+		r.line = append(r.line, escposRun{bold: r.bold, wide: r.wide, tall: r.tall})
+	}
+	run := &r.line[len(r.line)-1]
+	run.text = append(run.text, c)
+	r.lineW += w
+}
+
+func (r *escposRenderer) esc(b []byte) (int, error) {
+	if err := escposNeed(b, 2); err != nil {
+		return 0, err
+	}
+	switch b[1] {
+	case '@':
+		r.reset()
+		return 2, nil
+	case 'a', 'E', 'G', '!', 'd', 'J', '3', '2':
+	case '*':
+		return r.bitImage(b)
+	default:
+		n, ok := escposEscArgs[b[1]]
+		if !ok {
+			r.skip(fmt.Sprintf("ESC %q", b[1]))
+		}
+		return 2 + n, escposNeed(b, 2+n)
+	}
+
+	if b[1] == '2' {
+		r.spacing = escposLineSpacing
+		return 2, nil
+	}
+	if err := escposNeed(b, 3); err != nil {
+		return 0, err
+	}
+	n := b[2]
+	switch b[1] {
+	case 'a':
+		r.align = int(n&3) % 3 // 0-2 or '0'-'2'
+	case 'E', 'G':
+		r.bold = n&1 == 1
+	case '!':
+		r.bold = n&0x08 != 0
+		r.tall, r.wide = 1, 1
+		if n&0x10 != 0 {
+			r.tall = 2
+		}
+		if n&0x20 != 0 {
+			r.wide = 2
+		}
+	case '3':
+		r.spacing = int(n)
+	case 'd':
+		if n == 0 {
+			r.flush()
+			break
+		}
+		r.newline()
+		if n > 1 {
+			r.feed(int(n-1) * r.spacing)
+		}
+	case 'J':
+		r.flush()
+		r.feed(int(n))
+	}
+	return 3, nil
+}
+
+func (r *escposRenderer) gs(b []byte) (int, error) {
+	if err := escposNeed(b, 2); err != nil {
+		return 0, err
+	}
+	switch b[1] {
+	case '!':
+		if err := escposNeed(b, 3); err != nil {
+			return 0, err
+		}
+		r.wide = min(int(b[2]>>4&7)+1, 4)
+		r.tall = min(int(b[2]&7)+1, 4)
+		return 3, nil
+	case 'v':
+		return r.rasterImage(b)
+	case 'V':
+		// Cuts end the current line, there's no knife to drive
+		if err := escposNeed(b, 3); err != nil {
+			return 0, err
+		}
+		r.flush()
+		if b[2] == 'A' || b[2] == 'B' { // with a feed before the cut
+			return 4, escposNeed(b, 4)
+		}
+		return 3, nil
+	case 'k':
+		r.skip("barcodes")
+		if err := escposNeed(b, 3); err != nil {
+			return 0, err
+		}
+		if b[2] <= 6 {
+			end := bytes.IndexByte(b[3:], 0)
+			if end < 0 {
+				return 0, fmt.Errorf("unterminated barcode")
+			}
+			return 3 + end + 1, nil
+		}
+		if err := escposNeed(b, 4); err != nil {
+			return 0, err
+		}
+		return 4 + int(b[3]), escposNeed(b, 4+int(b[3]))
+	case '(':
+		// GS ( fn pL pH, with QR codes and most newer functions
+		r.skip("GS ( functions like QR codes")
+		if err := escposNeed(b, 5); err != nil {
+			return 0, err
+		}
+		n := 5 + int(b[3]) + int(b[4])<<8
+		return n, escposNeed(b, n)
+	}
+	n, ok := escposGsArgs[b[1]]
+	if !ok {
+		r.skip(fmt.Sprintf("GS %q", b[1]))
+	}
+	return 2 + n, escposNeed(b, 2+n)
+}
+
+// rasterImage reads a GS v 0 m xL xH yL yH bitmap, x bytes by y lines with
+// the most significant bit leftmost and set bits black
+func (r *escposRenderer) rasterImage(b []byte) (int, error) {
+	if err := escposNeed(b, 8); err != nil {
+		return 0, err
+	}
+	if b[2] != '0' {
+		r.skip(fmt.Sprintf("GS v %q", b[2]))
+		return 3, nil
+	}
+	m := b[3] & 3
+	xBytes := int(b[4]) | int(b[5])<<8
+	lines := int(b[6]) | int(b[7])<<8
+	n := 8 + xBytes*lines
+	if err := escposNeed(b, n); err != nil {
+		return 0, err
+	}
+	img := image.NewGray(image.Rect(0, 0, xBytes*8, lines))
+	for y := 0; y < lines; y++ {
+		for x := 0; x < xBytes*8; x++ {
+			if b[8+y*xBytes+x/8]&(0x80>>(x%8)) == 0 {
+				img.Pix[y*img.Stride+x] = 255
+			}
+		}
+	}
+	var bitmap image.Image = img
+	if m != 0 {
+		// Double width and/or height
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		if m&1 != 0 {
+			w *= 2
+		}
+		if m&2 != 0 {
+			h *= 2
+		}
+		bitmap = imaging.Resize(img, w, h, imaging.NearestNeighbor)
+	}
+	r.flush()
+	r.place(bitmap)
+	return n, nil
+}
+
+// bitImage reads an ESC * m nL nH column image, in bands of 8 or 24 dots
+// with the most significant bit at the top. Bands printed one per line
+// make up taller images.
+func (r *escposRenderer) bitImage(b []byte) (int, error) {
+	if err := escposNeed(b, 5); err != nil {
+		return 0, err
+	}
+	rows := 8
+	if b[2] >= 32 {
+		rows = 24
+	}
+	cols := int(b[3]) | int(b[4])<<8
+	colBytes := rows / 8
+	n := 5 + cols*colBytes
+	if err := escposNeed(b, n); err != nil {
+		return 0, err
+	}
+	if len(r.line) > 0 {
+		r.flush()
+	}
+	// Bands on the same line continue to the right of each other
+	x0 := 0
+	if r.band != nil {
+		x0 = r.band.Rect.Dx()
+	}
+	band := image.NewGray(image.Rect(0, 0, x0+cols, max(rows, r.bandHeight())))
+	for i := range band.Pix {
+		band.Pix[i] = 255
+	}
+	if r.band != nil {
+		draw.Draw(band, r.band.Rect, r.band, image.Point{}, draw.Src)
+	}
+	data := b[5:n]
+	for x := 0; x < cols; x++ {
+		for y := 0; y < rows; y++ {
+			if data[x*colBytes+y/8]&(0x80>>(y%8)) != 0 {
+				band.Pix[y*band.Stride+x0+x] = 0
+			}
+		}
+	}
+	r.band = band
+	return n, nil
+}
+
+func (r *escposRenderer) bandHeight() int {
+	if r.band == nil {
+		return 0
+	}
+	return r.band.Rect.Dy()
+}
+
+// newline prints the current line and advances the paper by the line spacing
+func (r *escposRenderer) newline() {
+	switch {
+	case r.band != nil:
+		h := r.band.Rect.Dy()
+		r.place(r.band)
+		r.band = nil
+		r.feed(r.spacing - h)
+	case len(r.line) > 0:
+		line := r.textLine()
+		r.place(line)
+		r.line, r.lineW = nil, 0
+		r.feed(r.spacing - line.Bounds().Dy())
+	default:
+		r.feed(max(r.spacing, escposCellHeight*r.tall))
+	}
+}
+
+// flush prints what is pending on the current line without a line feed
+func (r *escposRenderer) flush() {
+	if r.band != nil || len(r.line) > 0 {
+		r.newline()
+	}
+}
+
+// textLine draws the current line in the built-in font blown up to the
+// printer's character cells, characters of all sizes standing on the same
+// baseline
+func (r *escposRenderer) textLine() image.Image {
+	texts := make([]string, len(r.line))
+	w, h := 0, 0
+	for i, run := range r.line {
+		texts[i] = escposText(run.text)
+		w += utf8.RuneCountInString(texts[i]) * escposCellWidth * run.wide
+		h = max(h, escposCellHeight*run.tall)
+	}
+	line := imaging.New(w, h, color.White)
+	x := 0
+	for i, run := range r.line {
+		text := texts[i]
+		small := image.NewNRGBA(image.Rect(0, 0, textWidth(text), glyphHeight))
+		drawText(small, 0, 0, text, color.Black)
+		w := utf8.RuneCountInString(text) * escposCellWidth * run.wide
+		big := imaging.Resize(small, w, escposCellHeight*run.tall, imaging.Linear)
+		at := image.Rect(x, h-big.Rect.Dy(), x+w, h)
+		draw.Draw(line, at, big, image.Point{}, draw.Over)
+		if run.bold {
+			// Emphasis is the text struck again a bit to the right
+			draw.Draw(line, at.Add(image.Pt(1+run.wide, 0)), big, image.Point{}, draw.Over)
+		}
+		x += w
+	}
+	return line
+}
+
+// escposText decodes text sent to the printer. Receipts are mostly ASCII, and
+// the rest either UTF-8 from modern software or in a code page Latin-1 is the
+// best guess for.
+func escposText(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// place puts img on the paper, aligned and shrunk to fit the width
+func (r *escposRenderer) place(img image.Image) {
+	if img.Bounds().Dx() > r.width {
+		img = imaging.Resize(img, r.width, 0, imaging.Box)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	row := imaging.New(r.width, h, color.White)
+	x := (r.width - w) * r.align / 2
+	draw.Draw(row, image.Rect(x, 0, x+w, h), img, img.Bounds().Min, draw.Src)
+	r.parts = append(r.parts, row)
+}
+
+// feed advances the paper by dots blank lines
+func (r *escposRenderer) feed(dots int) {
+	if dots > 0 {
+		r.parts = append(r.parts, imaging.New(r.width, dots, color.White))
+	}
+}
+
+// skip notes, once per kind, that the stream asks for something that isn't
+// rendered
+func (r *escposRenderer) skip(what string) {
+	if !r.skipped[what] {
+		r.skipped[what] = true
+		log.Printf("ESC/POS: skipping %s", what)
+	}
+}
+
+// looksLikeESCPOS tells whether data is an ESC/POS stream rather than text
+func looksLikeESCPOS(data []byte) bool {
+	return bytes.IndexByte(data, escByte) >= 0 || bytes.IndexByte(data, gsByte) >= 0
+}
+
+// loadESCPOS plays back the ESC/POS stream in path, "-" for stdin, at the
+// print width
+func loadESCPOS(path string, width int) (image.Image, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return renderESCPOS(data, width)
+}
@@ -0,0 +1,127 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Just enough EXIF to find out when a JPEG photo was taken
+
+// EXIF lives in the APP1 segment near the start of the file, which can't be
+// larger than 64 KiB
+const exifSearchBytes = 128 << 10
+
+const (
+	tagExifIFD          = 0x8769
+	tagDateTime         = 0x0132
+	tagDateTimeOriginal = 0x9003
+)
+
+// captureTime returns when the photo at path was taken according to its
+// EXIF data, or the zero time for anything but a local JPEG file that has it
+func captureTime(path string) time.Time {
+	if _, ok := archiveMembers[path]; ok || path == "-" || isURL(path) {
+		return time.Time{}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, exifSearchBytes))
+	if err != nil {
+		return time.Time{}
+	}
+	return exifTime(data)
+}
+
+// exifTime reads the DateTimeOriginal of the JPEG in data, or failing that
+// the DateTime it was last changed
+func exifTime(data []byte) time.Time {
+	tiff := exifSegment(data)
+	if len(tiff) < 8 {
+		return time.Time{}
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}
+	}
+
+	// entry returns the value, or offset to it, and the count of a tag in
+	// the IFD at off
+	entry := func(off uint32, tag uint16) (value, count uint32, ok bool) {
+		if int(off)+2 > len(tiff) {
+			return 0, 0, false
+		}
+		n := int(order.Uint16(tiff[off:]))
+		for i := 0; i < n; i++ {
+			e := int(off) + 2 + 12*i
+			if e+12 > len(tiff) {
+				break
+			}
+			if order.Uint16(tiff[e:]) == tag {
+				return order.Uint32(tiff[e+8:]), order.Uint32(tiff[e+4:]), true
+			}
+		}
+		return 0, 0, false
+	}
+	stamp := func(off uint32, tag uint16) time.Time {
+		at, n, ok := entry(off, tag)
+		if !ok || n < 19 || int(at)+int(n) > len(tiff) {
+			return time.Time{}
+		}
+		s := strings.TrimRight(string(tiff[at:at+n]), "\x00 ")
+		t, err := time.ParseInLocation("2006:01:02 15:04:05", s, time.Local)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+
+	ifd0 := order.Uint32(tiff[4:])
+	if exifIFD, _, ok := entry(ifd0, tagExifIFD); ok {
+		if t := stamp(exifIFD, tagDateTimeOriginal); !t.IsZero() {
+			return t
+		}
+	}
+	return stamp(ifd0, tagDateTime)
+}
+
+// exifSegment returns the TIFF structure in the EXIF segment of a JPEG
+func exifSegment(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || size < 2 || i+2+size > len(data) {
+			break // image data follows, there's no EXIF before it
+		}
+		payload := data[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:]
+		}
+		i += 2 + size
+	}
+	return nil
+}
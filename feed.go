@@ -0,0 +1,225 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var feedCmd = &command{
+	name:  "feed",
+	usage: "[--items N] [--summary chars] [--no-qr] <url or file>",
+}
+
+func init() {
+	feedCmd.run = runFeed
+	registerCommand(feedCmd)
+}
+
+// feedDoc is an RSS 2.0, RSS 1.0 (RDF) or Atom document. Elements are
+// matched by local name, so namespaces don't matter.
+type feedDoc struct {
+	XMLName xml.Name
+	Title   string      `xml:"title"` // Atom
+	Channel feedChannel `xml:"channel"`
+	Items   []feedEntry `xml:"item"`  // RSS 1.0 puts items next to the channel
+	Entries []feedEntry `xml:"entry"` // Atom
+}
+
+type feedChannel struct {
+	Title string      `xml:"title"`
+	Items []feedEntry `xml:"item"`
+}
+
+type feedEntry struct {
+	Title       string     `xml:"title"`
+	Links       []feedLink `xml:"link"`
+	Description string     `xml:"description"`
+	Summary     string     `xml:"summary"`
+	Content     string     `xml:"content"`
+}
+
+// feedLink is an RSS link, in its text, or an Atom one, in href
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+// link is the address of the entry itself, skipping Atom links to
+// comments, enclosures and the like
+func (e feedEntry) link() string {
+	for _, l := range e.Links {
+		if l.Href == "" {
+			if s := strings.TrimSpace(l.Text); s != "" {
+				return s
+			}
+		} else if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// summary is the entry's description as plain text
+func (e feedEntry) summary() string {
+	for _, s := range []string{e.Description, e.Summary, e.Content} {
+		if s = plainText(s); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+var htmlTag = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// plainText strips the markup from HTML and puts it on one line
+func plainText(s string) string {
+	s = html.UnescapeString(htmlTag.ReplaceAllString(s, " "))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// runFeed prints the title, summary and a QR code of the link of the latest
+// items of an RSS or Atom feed
+func runFeed(ctx context.Context, args []string) error {
+	fs := feedCmd.flagSet()
+	items := fs.Int("items", 5, "How many items to print, newest first")
+	summaryChars := fs.Int("summary", 200, "Shorten summaries to this many characters, 0 to leave them out")
+	noQR := fs.Bool("no-qr", false, "Leave out the QR codes of the item links")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one feed")
+	}
+	if *items < 1 {
+		return withCause(errBadInput, fmt.Errorf("--items must be at least 1"))
+	}
+	src := fs.Arg(0)
+	var data []byte
+	var err error
+	if isURL(src) {
+		data, err = download(src, "feed")
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return err
+	}
+	title, entries, err := parseFeed(data)
+	if err != nil {
+		return withCause(errBadInput, fmt.Errorf("failed to parse %s: %v", src, err))
+	}
+	if len(entries) > *items {
+		entries = entries[:*items]
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	img, err := renderLayout(feedLayout(title, entries, *summaryChars, !*noQR), opts.margins.width(), "")
+	if err != nil {
+		return err
+	}
+	opts.stamp.file = sourceName(src)
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// parseFeed returns the title and entries of an RSS or Atom feed
+func parseFeed(data []byte) (string, []feedEntry, error) {
+	var doc feedDoc
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	dec.CharsetReader = latin1Reader
+	if err := dec.Decode(&doc); err == io.EOF {
+		return "", nil, fmt.Errorf("not an RSS or Atom feed")
+	} else if err != nil {
+		return "", nil, err
+	}
+	switch doc.XMLName.Local {
+	case "rss":
+		return plainText(doc.Channel.Title), doc.Channel.Items, nil
+	case "RDF":
+		return plainText(doc.Channel.Title), doc.Items, nil
+	case "feed":
+		return plainText(doc.Title), doc.Entries, nil
+	}
+	return "", nil, fmt.Errorf("not an RSS or Atom feed")
+}
+
+// latin1Reader decodes the feeds still served as ISO-8859-1, the only
+// encoding besides UTF-8 that's common enough to bother with
+func latin1Reader(charset string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "us-ascii":
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", charset)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, c := range data {
+		b.WriteRune(rune(c))
+	}
+	return strings.NewReader(b.String()), nil
+}
+
+// feedLayout lays the entries out one after another, in the language of
+// receipt templates
+func feedLayout(title string, entries []feedEntry, summaryChars int, qr bool) string {
+	var b strings.Builder
+	if title != "" {
+		fmt.Fprintf(&b, "@center\n@size 2\n%s\n@normal\n", layoutText(title))
+	}
+	if len(entries) == 0 {
+		b.WriteString("@center\nNo items\n")
+	}
+	for _, e := range entries {
+		fmt.Fprintf(&b, "@line\n@left\n@bold\n%s\n@normal\n", layoutText(plainText(e.Title)))
+		if s := e.summary(); s != "" && summaryChars > 0 {
+			fmt.Fprintf(&b, "%s\n", layoutText(shorten(s, summaryChars)))
+		}
+		if link := e.link(); link != "" && qr {
+			fmt.Fprintf(&b, "@center\n@qr size=20mm %s\n", link)
+		}
+	}
+	return b.String()
+}
+
+// shorten cuts s to at most n characters, at a word boundary when there's
+// one, marking the cut with "..."
+func shorten(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)[:max(n-3, 0)]
+	if i := strings.LastIndexByte(string(r), ' '); i > 0 {
+		return string(r)[:i] + "..."
+	}
+	return string(r) + "..."
+}
@@ -0,0 +1,84 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	fetchTimeout  = 30 * time.Second
+	fetchMaxBytes = 32 << 20
+)
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// fetchImage downloads and decodes the image at rawURL, refusing anything
+// larger than fetchMaxBytes
+func fetchImage(rawURL string) (image.Image, error) {
+	data, err := download(rawURL, "image")
+	if err != nil {
+		return nil, err
+	}
+	return decodeImageFromReader(bytes.NewReader(data))
+}
+
+// download fetches rawURL, refusing anything larger than fetchMaxBytes. what
+// names it in errors.
+func download(rawURL, what string) ([]byte, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", what, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", what, resp.Status)
+	}
+	return readLimited(resp, what)
+}
+
+// readLimited reads a response body of at most fetchMaxBytes
+func readLimited(resp *http.Response, what string) ([]byte, error) {
+	if resp.ContentLength > fetchMaxBytes {
+		return nil, fmt.Errorf("%s is too large (%d bytes, limit %d)", what, resp.ContentLength, fetchMaxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", what, err)
+	}
+	if len(data) > fetchMaxBytes {
+		return nil, fmt.Errorf("%s is too large (limit %d bytes)", what, fetchMaxBytes)
+	}
+	return data, nil
+}
+
+// sourceName is the file name shown for an input in headers and footers
+func sourceName(src string) string {
+	if isURL(src) {
+		if u, err := url.Parse(src); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			return path.Base(u.Path)
+		}
+		return src
+	}
+	return filepath.Base(src)
+}
@@ -0,0 +1,322 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Filters applied once the image is at print resolution, before it's
+// dithered, and descreening, which has to see the original pixels
+
+// sharpenRadius is the blur of the unsharp mask, in dots. Downscaling blurs
+// over about one output dot, so that's what sharpening undoes.
+const sharpenRadius = 1.0
+
+// sharpen is an unsharp mask: each pixel moves away from its blurred
+// surroundings by amount times the difference, which crisps up the text and
+// line edges Lanczos softens. 0 leaves img as it is.
+func sharpen(img image.Image, amount float64) image.Image {
+	if amount <= 0 {
+		return img
+	}
+	src := imaging.Clone(img)
+	blurred := imaging.Blur(src, sharpenRadius)
+	for i := range src.Pix {
+		if i%4 == 3 {
+			continue // alpha
+		}
+		v := float64(src.Pix[i]) + amount*(float64(src.Pix[i])-float64(blurred.Pix[i]))
+		src.Pix[i] = uint8(min(255, max(0, v+0.5)))
+	}
+	return src
+}
+
+// Histogram equalization spreads the grays an image uses over the whole
+// range, so a washed out photo doesn't end up as a few blobs in 1bpp
+const (
+	claheTiles     = 8   // tiles across and down the image
+	claheClipLimit = 2.0 // how many times the average a bin may hold
+)
+
+// equalize maps every gray through the histogram of the whole image
+func equalize(img image.Image) image.Image {
+	g := toGray(img)
+	lut := equalizeLUT(histogram(g.Pix, g.Stride, g.Bounds()), 0)
+	for i, v := range g.Pix {
+		g.Pix[i] = lut[v]
+	}
+	return g
+}
+
+// clahe equalizes each of claheTiles x claheTiles tiles on its own, with
+// every histogram bin clipped to claheClipLimit times the average so flat
+// areas don't turn into noise, and blends the neighbouring tiles' mappings
+// so no seams show
+func clahe(img image.Image) image.Image {
+	g := toGray(img)
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	tw, th := (w+claheTiles-1)/claheTiles, (h+claheTiles-1)/claheTiles
+	cols, rows := (w+tw-1)/tw, (h+th-1)/th
+
+	luts := make([][256]uint8, cols*rows)
+	for ty := 0; ty < rows; ty++ {
+		for tx := 0; tx < cols; tx++ {
+			r := image.Rect(tx*tw, ty*th, min((tx+1)*tw, w), min((ty+1)*th, h))
+			luts[ty*cols+tx] = equalizeLUT(histogram(g.Pix, g.Stride, r), claheClipLimit)
+		}
+	}
+
+	out := image.NewGray(g.Bounds())
+	for y := 0; y < h; y++ {
+		// Tile centers around the pixel and how far along it is between them
+		fy := (float64(y)+0.5)/float64(th) - 0.5
+		y0 := min(max(int(fy), 0), rows-1)
+		y1 := min(y0+1, rows-1)
+		wy := min(max(fy-float64(y0), 0), 1)
+		for x := 0; x < w; x++ {
+			fx := (float64(x)+0.5)/float64(tw) - 0.5
+			x0 := min(max(int(fx), 0), cols-1)
+			x1 := min(x0+1, cols-1)
+			wx := min(max(fx-float64(x0), 0), 1)
+
+			v := g.Pix[y*g.Stride+x]
+			top := (1-wx)*float64(luts[y0*cols+x0][v]) + wx*float64(luts[y0*cols+x1][v])
+			bottom := (1-wx)*float64(luts[y1*cols+x0][v]) + wx*float64(luts[y1*cols+x1][v])
+			out.Pix[y*out.Stride+x] = uint8((1-wy)*top + wy*bottom + 0.5)
+		}
+	}
+	return out
+}
+
+func histogram(pix []uint8, stride int, r image.Rectangle) [256]int {
+	var hist [256]int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			hist[pix[y*stride+x]]++
+		}
+	}
+	return hist
+}
+
+// equalizeLUT turns a histogram into the mapping that flattens it. With a
+// clip limit, bins above limit times the average are cut down and what was
+// cut is shared out over all the bins.
+func equalizeLUT(hist [256]int, limit float64) [256]uint8 {
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	var lut [256]uint8
+	if total == 0 {
+		return lut
+	}
+	if limit > 0 {
+		clip := max(1, int(limit*float64(total)/256))
+		excess := 0
+		for i, n := range hist {
+			if n > clip {
+				excess += n - clip
+				hist[i] = clip
+			}
+		}
+		for i := range hist {
+			hist[i] += excess / 256
+			if i < excess%256 {
+				hist[i]++
+			}
+		}
+	}
+	sum := 0
+	for i, n := range hist {
+		sum += n
+		lut[i] = uint8((255*sum + total/2) / total)
+	}
+	return lut
+}
+
+// Denoising smooths out sensor noise, which error diffusion would otherwise
+// turn into speckle
+const (
+	bilateralRadius = 2    // dots around each pixel it is averaged with
+	bilateralSpace  = 1.5  // falloff with distance, in dots
+	bilateralRange  = 24.0 // falloff with difference in gray, so edges stay
+)
+
+// denoise runs the --denoise filter, "median" or "bilateral", over a gray image
+func denoise(img image.Image, filter string) image.Image {
+	switch filter {
+	case "median":
+		return median(toGray(img))
+	case "bilateral":
+		return bilateral(toGray(img))
+	}
+	return img
+}
+
+// median replaces every pixel with the median of its 3x3 neighbourhood,
+// which removes salt and pepper noise without blurring edges
+func median(g *image.Gray) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	out := image.NewGray(g.Bounds())
+	window := make([]uint8, 0, 9)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			window = window[:0]
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					sx, sy := min(max(x+dx, 0), w-1), min(max(y+dy, 0), h-1)
+					window = append(window, g.Pix[sy*g.Stride+sx])
+				}
+			}
+			slices.Sort(window)
+			out.Pix[y*out.Stride+x] = window[4]
+		}
+	}
+	return out
+}
+
+// bilateral averages every pixel with its neighbours, weighting them less the
+// further away and the more different they are, so noise in flat areas is
+// smoothed while edges are left alone
+func bilateral(g *image.Gray) *image.Gray {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	var rangeWeight [256]float64
+	for d := range rangeWeight {
+		rangeWeight[d] = math.Exp(-float64(d*d) / (2 * bilateralRange * bilateralRange))
+	}
+	const side = 2*bilateralRadius + 1
+	var spaceWeight [side * side]float64
+	for dy := -bilateralRadius; dy <= bilateralRadius; dy++ {
+		for dx := -bilateralRadius; dx <= bilateralRadius; dx++ {
+			d2 := float64(dx*dx + dy*dy)
+			spaceWeight[(dy+bilateralRadius)*side+dx+bilateralRadius] = math.Exp(-d2 / (2 * bilateralSpace * bilateralSpace))
+		}
+	}
+
+	out := image.NewGray(g.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := g.Pix[y*g.Stride+x]
+			var sum, total float64
+			for dy := -bilateralRadius; dy <= bilateralRadius; dy++ {
+				for dx := -bilateralRadius; dx <= bilateralRadius; dx++ {
+					sx, sy := min(max(x+dx, 0), w-1), min(max(y+dy, 0), h-1)
+					n := g.Pix[sy*g.Stride+sx]
+					weight := spaceWeight[(dy+bilateralRadius)*side+dx+bilateralRadius] * rangeWeight[absDiff(n, v)]
+					sum += weight * float64(n)
+					total += weight
+				}
+			}
+			out.Pix[y*out.Stride+x] = uint8(sum/total + 0.5)
+		}
+	}
+	return out
+}
+
+// Descreening blurs away the dots a scanned print was halftoned with. Left
+// in, they beat against the print resolution and the new dither as moiré.
+const (
+	minScreenPeriod = 3   // pixels; anything finer is taken for noise
+	maxScreenPeriod = 24  // pixels, a coarse screen scanned at 600 dpi
+	screenPeak      = 0.2 // how far above the dip before it a peak has to rise
+	autoScreen      = -1  // --descreen auto, as a period
+)
+
+// parseDescreen reads --descreen: "auto" to find the screen in the image,
+// the dot spacing in pixels of the scan, or "" for none, which is 0
+func parseDescreen(s string) (float64, error) {
+	switch s {
+	case "", "none":
+		return 0, nil
+	case "auto":
+		return autoScreen, nil
+	}
+	period, err := strconv.ParseFloat(s, 64)
+	if err != nil || period < 2 {
+		return 0, fmt.Errorf("Invalid descreen. Use 'auto' or the halftone dot spacing in pixels, 2 or more.")
+	}
+	return period, nil
+}
+
+// descreen blurs img just enough to wipe out a halftone screen of the given
+// period in pixels, or of the period screenPeriod finds for autoScreen. A
+// Gaussian of half the period leaves under 1% of the screen.
+func descreen(img image.Image, period float64) image.Image {
+	if period == autoScreen {
+		p, ok := screenPeriod(toGray(img))
+		if !ok {
+			log.Println("No halftone screen found, not descreening")
+			return img
+		}
+		log.Printf("Halftone screen found, dots every %d pixels", p)
+		period = float64(p)
+	}
+	return imaging.Blur(img, period/2)
+}
+
+// screenPeriod looks for the regular spacing of halftone dots along the
+// rows: the shortest shift at which a row lines up with itself, averaged
+// over a sample of rows
+func screenPeriod(g *image.Gray) (int, bool) {
+	w, h := g.Bounds().Dx(), g.Bounds().Dy()
+	if w < 4*maxScreenPeriod {
+		return 0, false
+	}
+	var corr [maxScreenPeriod + 2]float64
+	rows := 0
+	step := max(1, h/64)
+	row := make([]float64, w)
+	for y := 0; y < h; y += step {
+		mean := 0.0
+		for x := range row {
+			row[x] = float64(g.Pix[y*g.Stride+x])
+			mean += row[x]
+		}
+		mean /= float64(w)
+		variance := 0.0
+		for x := range row {
+			row[x] -= mean
+			variance += row[x] * row[x]
+		}
+		if variance == 0 {
+			continue
+		}
+		rows++
+		for lag := 1; lag < len(corr); lag++ {
+			sum := 0.0
+			for x := 0; x+lag < w; x++ {
+				sum += row[x] * row[x+lag]
+			}
+			corr[lag] += sum / variance
+		}
+	}
+	if rows == 0 {
+		return 0, false
+	}
+	// The screen shows as the first clear peak after the correlation dips
+	trough := corr[1]
+	for lag := 2; lag <= maxScreenPeriod; lag++ {
+		trough = min(trough, corr[lag])
+		if lag >= minScreenPeriod && corr[lag] > corr[lag-1] && corr[lag] >= corr[lag+1] &&
+			(corr[lag]-trough)/float64(rows) > screenPeak {
+			return lag, true
+		}
+	}
+	return 0, false
+}
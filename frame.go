@@ -0,0 +1,118 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// A --frame takes frameInset dots on every side: the border in the outer
+// part and white space between it and the image
+const frameInset = 14
+
+// frameStyles draws the border of a frame onto the edges of dst; nil means
+// no frame at all
+var frameStyles = map[string]func(dst draw.Image){
+	"none": nil,
+	"line": func(dst draw.Image) { frameRect(dst, 1, 2, 0) },
+	"double": func(dst draw.Image) {
+		frameRect(dst, 0, 2, 0)
+		frameRect(dst, 5, 2, 0)
+	},
+	"dashed":    func(dst draw.Image) { frameRect(dst, 1, 2, 8) },
+	"scalloped": frameScallops,
+}
+
+// frameWidth is how much narrower than the paper a framed image has to be
+func frameWidth(style string) int {
+	if frameStyles[style] == nil {
+		return 0
+	}
+	return 2 * frameInset
+}
+
+// frameImage puts img in the frame of the given style
+func frameImage(img image.Image, style string) image.Image {
+	drawFrame := frameStyles[style]
+	if drawFrame == nil {
+		return img
+	}
+	b := img.Bounds()
+	out := imaging.New(b.Dx()+2*frameInset, b.Dy()+2*frameInset, color.White)
+	draw.Draw(out, b.Sub(b.Min).Add(image.Pt(frameInset, frameInset)), img, b.Min, draw.Src)
+	drawFrame(out)
+	return out
+}
+
+// frameRect draws a rectangle thick dots wide, off dots in from the edges
+// of dst, broken into dashes of the given length unless it's 0
+func frameRect(dst draw.Image, off, thick, dash int) {
+	b := dst.Bounds()
+	on := func(pos int) bool { return dash == 0 || (pos/dash)%2 == 0 }
+	for x := b.Min.X + off; x < b.Max.X-off; x++ {
+		if !on(x - b.Min.X - off) {
+			continue
+		}
+		for t := 0; t < thick; t++ {
+			dst.Set(x, b.Min.Y+off+t, color.Black)
+			dst.Set(x, b.Max.Y-off-1-t, color.Black)
+		}
+	}
+	for y := b.Min.Y + off; y < b.Max.Y-off; y++ {
+		if !on(y - b.Min.Y - off) {
+			continue
+		}
+		for t := 0; t < thick; t++ {
+			dst.Set(b.Min.X+off+t, y, color.Black)
+			dst.Set(b.Max.X-off-1-t, y, color.Black)
+		}
+	}
+}
+
+// frameScallops draws a line with half discs bulging out of it all around,
+// spaced so that every side ends on a whole one
+func frameScallops(dst draw.Image) {
+	const r = 4
+	b := dst.Bounds()
+	frameRect(dst, r, 1, 0)
+	disc := func(cx, cy int) {
+		for y := -r; y <= r; y++ {
+			for x := -r; x <= r; x++ {
+				px, py := cx+x, cy+y
+				// Only the half outside the line
+				outside := px < b.Min.X+r || px >= b.Max.X-r || py < b.Min.Y+r || py >= b.Max.Y-r
+				if x*x+y*y <= r*r && outside {
+					dst.Set(px, py, color.Black)
+				}
+			}
+		}
+	}
+	along := func(length int, fn func(pos int)) {
+		n := max(1, int(math.Round(float64(length)/(2*r))))
+		for i := 0; i <= n; i++ {
+			fn(i * length / n)
+		}
+	}
+	w, h := b.Dx()-2*r-1, b.Dy()-2*r-1
+	along(w, func(pos int) {
+		disc(b.Min.X+r+pos, b.Min.Y+r)
+		disc(b.Min.X+r+pos, b.Max.Y-r-1)
+	})
+	along(h, func(pos int) {
+		disc(b.Min.X+r, b.Min.Y+r+pos)
+		disc(b.Max.X-r-1, b.Min.Y+r+pos)
+	})
+}
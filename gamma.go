@@ -0,0 +1,173 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// toneSpace selects whether grayscale conversion and quantization work on the
+// encoded pixel values (the historical behaviour) or in linear light, where
+// printed dot coverage is proportional to the original luminance.
+//
+// The dither library always diffuses error in linear RGB and decodes its input
+// and palette as sRGB, so everything handed to it stays sRGB encoded.
+type toneSpace struct {
+	linear bool
+	gamma  float64 // input transfer: 0 for the sRGB curve, otherwise a pure power law
+}
+
+// decodeInput converts a source channel value to linear light
+func (t toneSpace) decodeInput(v uint8) float64 {
+	if t.gamma > 0 {
+		return math.Pow(float64(v)/255, t.gamma)
+	}
+	return srgbToLinear(v)
+}
+
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(l float64) uint8 {
+	l = max(0, min(1, l))
+	var c float64
+	if l <= 0.0031308 {
+		c = l * 12.92
+	} else {
+		c = 1.055*math.Pow(l, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(c * 255))
+}
+
+// grayscale converts img to gray, keeping alpha. In linear mode the luminance
+// is computed from linearized channels with Rec. 709 weights.
+func (t toneSpace) grayscale(img image.Image) image.Image {
+	if !t.linear {
+		return imaging.Grayscale(img)
+	}
+
+	var lut [256]float64
+	for i := range lut {
+		lut[i] = t.decodeInput(uint8(i))
+	}
+
+	src := imaging.Clone(img)
+	dst := image.NewNRGBA(src.Bounds())
+	for i := 0; i < len(src.Pix); i += 4 {
+		l := 0.2126*lut[src.Pix[i]] + 0.7152*lut[src.Pix[i+1]] + 0.0722*lut[src.Pix[i+2]]
+		y := linearToSRGB(l)
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = y, y, y, src.Pix[i+3]
+	}
+	return dst
+}
+
+// linearize maps gray values to linear light so that thresholds split the
+// image at a given reflectance rather than a given code value
+func (t toneSpace) linearize(img *image.Gray) *image.Gray {
+	if !t.linear {
+		return img
+	}
+	out := image.NewGray(img.Bounds())
+	for i, v := range img.Pix {
+		out.Pix[i] = uint8(math.Round(srgbToLinear(v) * 255))
+	}
+	return out
+}
+
+// palette4 returns the colors of the printer gray levels used, lightest
+// first: all 16 when levels is nil. In linear mode the levels are evenly
+// spaced in dot coverage instead of code values.
+func (t toneSpace) palette4(levels []byte) []color.Color {
+	if levels == nil {
+		levels = allLevels
+	}
+	palette := make([]color.Color, len(levels))
+	for i, l := range levels {
+		if t.linear {
+			palette[i] = color.Gray{Y: linearToSRGB(1 - float64(l)/15)}
+		} else {
+			palette[i] = color.Gray{Y: 255 - l*17}
+		}
+	}
+	return palette
+}
+
+var allLevels = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// evenLevels spreads n printer gray levels evenly from white to black
+func evenLevels(n int) []byte {
+	levels := make([]byte, n)
+	for i := range levels {
+		levels[i] = byte(math.Round(float64(i) * 15 / float64(n-1)))
+	}
+	return levels
+}
+
+// parseLevels reads a --palette list of printer gray levels (0-15, 0 being
+// white) into ascending order
+func parseLevels(s string) ([]byte, error) {
+	var levels []byte
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || v < 0 || v > 15 {
+			return nil, fmt.Errorf("Invalid palette %q. Use gray levels 0-15 separated by commas, e.g. 0,5,10,15.", s)
+		}
+		levels = append(levels, byte(v))
+	}
+	slices.Sort(levels)
+	levels = slices.Compact(levels)
+	if len(levels) < 2 {
+		return nil, fmt.Errorf("Invalid palette %q. Use at least two different gray levels.", s)
+	}
+	return levels, nil
+}
+
+// nearestLevel snaps a printer gray level to the closest one in levels
+func nearestLevel(l byte, levels []byte) byte {
+	if levels == nil {
+		return l
+	}
+	best := levels[0]
+	for _, v := range levels[1:] {
+		if absDiff(v, l) < absDiff(best, l) {
+			best = v
+		}
+	}
+	return best
+}
+
+func absDiff(a, b byte) byte {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// level4 maps a gray value to a 4bpp printer level, 0 being white
+func (t toneSpace) level4(y uint8) byte {
+	if !t.linear {
+		return (255 - y) >> 4
+	}
+	return byte(math.Round((1 - srgbToLinear(y)) * 15))
+}
@@ -0,0 +1,77 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const gridGutter = 12
+
+// writePreviewGrid renders img once per dither method (rows) in 1bpp and
+// 4bpp (columns), each cell labeled, and saves the sheet as a PNG
+func writePreviewGrid(path string, img image.Image, opts imageOptions, methods []string) error {
+	modes := []struct {
+		name string
+		mode PrintMode
+	}{{"1bpp", Mode1bpp}, {"4bpp", Mode4bpp}}
+
+	var rows []image.Image
+	for _, method := range methods {
+		method = strings.TrimSpace(method)
+		opts.ditherType = method
+		var cells []image.Image
+		width, height := 0, 0
+		for _, m := range modes {
+			// Saved curves are per mode
+			curve, err := loadToneCurve(curvePath, m.name)
+			if err != nil {
+				return err
+			}
+			opts.curve = curve
+			pixels, h, err := processImage(img, m.mode, opts)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", m.name, method, err)
+			}
+			cell := stackImages([]image.Image{
+				gridLabel(m.name + " " + method),
+				styledPreview(previewImage(pixels, h, m.mode)),
+			})
+			cells = append(cells, cell)
+			width = max(width, cell.Bounds().Dx())
+			height = max(height, cell.Bounds().Dy())
+		}
+
+		row := imaging.New(len(cells)*(width+gridGutter)+gridGutter, height+gridGutter, color.White)
+		for i, cell := range cells {
+			row = imaging.Paste(row, cell, image.Pt(gridGutter+i*(width+gridGutter), gridGutter))
+		}
+		rows = append(rows, row)
+	}
+
+	if err := imaging.Save(stackImages(rows), path); err != nil {
+		return fmt.Errorf("failed to write preview grid: %v", err)
+	}
+	log.Printf("Preview grid written to %s\n", path)
+	return nil
+}
+
+func gridLabel(s string) image.Image {
+	label := imaging.New(linePixels, glyphHeight+6, color.White)
+	drawText(label, 0, 2, s, color.Black)
+	return label
+}
@@ -0,0 +1,239 @@
+//go:build gui
+
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+// The GUI is only built with -tags gui, as it pulls in Fyne and needs cgo
+// and OpenGL headers.
+
+var guiCmd = &command{
+	name:  "gui",
+	usage: "[image]",
+}
+
+func init() {
+	guiCmd.run = runGUI
+	registerCommand(guiCmd)
+}
+
+// guiState is what the window works on: the loaded image and its last
+// processed form
+type guiState struct {
+	source    image.Image
+	name      string
+	pixels    []byte
+	height    int
+	printMode PrintMode
+	crop      cropState
+}
+
+func runGUI(ctx context.Context, args []string) error {
+	fs := guiCmd.flagSet()
+	fs.Parse(args)
+
+	a := app.NewWithID("io.github.igna503.bleh")
+	w := a.NewWindow("Bleh!")
+	st := guiState{crop: noCrop}
+
+	preview := canvas.NewImageFromImage(nil)
+	preview.FillMode = canvas.ImageFillContain
+	preview.SetMinSize(fyne.NewSize(float32(linePixels), 400))
+	hint := widget.NewLabel("Drop an image here or use Open…")
+	status := widget.NewLabel("")
+	status.Wrapping = fyne.TextWrapWord
+
+	modeSelect := widget.NewSelect([]string{"1bpp", "4bpp", "auto"}, nil)
+	modeSelect.SetSelected(mode)
+	ditherSelect := widget.NewSelect(append([]string{"auto"}, ditherNames...), nil)
+	ditherSelect.SetSelected(ditherType)
+	intensityLabel := widget.NewLabel(strconv.Itoa(intensity) + "%")
+	intensitySlider := widget.NewSlider(0, 100)
+	intensitySlider.Step = 1
+	intensitySlider.SetValue(float64(intensity))
+
+	// refresh reprocesses the image with the current settings
+	refresh := func() {
+		mode, ditherType = modeSelect.Selected, ditherSelect.Selected
+		if st.source == nil {
+			return
+		}
+		printMode, opts, err := imageOptionsFromFlags()
+		if err != nil {
+			status.SetText(err.Error())
+			return
+		}
+		opts.stamp.file = st.name
+		source := st.crop.apply(st.source)
+		printMode, opts = resolveAutoMode(source, printMode, opts)
+		pixels, height, err := processImage(source, printMode, opts)
+		if err != nil {
+			status.SetText(err.Error())
+			return
+		}
+		st.pixels, st.height, st.printMode = pixels, height, printMode
+		preview.Image = styledPreview(previewImage(pixels, height, printMode))
+		preview.Refresh()
+		hint.SetText(fmt.Sprintf("%s: %d lines (%.1f mm)", st.name, height, float64(height)*25.4/dpi))
+	}
+	var resetCrop func()
+	load := func(path string) {
+		img, err := decodeImage(path)
+		if err != nil {
+			status.SetText(err.Error())
+			return
+		}
+		st.source, st.name = img, sourceName(path)
+		resetCrop()
+	}
+
+	// Framing: zoom in to crop or out to leave room at the sides, drag the
+	// preview to move the image, turn it by any angle
+	zoomSlider := widget.NewSlider(0.25, 4)
+	zoomSlider.Step = 0.05
+	zoomSlider.SetValue(1)
+	angleSlider := widget.NewSlider(-180, 180)
+	angleSlider.Step = 1
+	zoomSlider.OnChanged = func(v float64) {
+		st.crop.zoom = v
+		refresh()
+	}
+	angleSlider.OnChanged = func(v float64) {
+		st.crop.angle = v
+		refresh()
+	}
+	rotateButton := widget.NewButton("Rotate 90°", func() {
+		angle := angleSlider.Value + 90
+		if angle > 180 {
+			angle -= 360
+		}
+		angleSlider.SetValue(angle)
+	})
+	resetCrop = func() {
+		st.crop = noCrop
+		zoomSlider.Value, angleSlider.Value = 1, 0
+		zoomSlider.Refresh()
+		angleSlider.Refresh()
+		refresh()
+	}
+	resetButton := widget.NewButton("Reset framing", resetCrop)
+	area := newCropArea(preview, func(dx, dy float32) {
+		if st.pixels == nil {
+			return
+		}
+		// The preview is scaled to fit, keeping its aspect ratio
+		size := preview.Size()
+		pw, ph := float32(linePixels), float32(st.height)
+		scale := min(size.Width/pw, size.Height/ph)
+		st.crop.drag(dx, dy, pw*scale, ph*scale)
+		refresh()
+	})
+
+	modeSelect.OnChanged = func(string) { refresh() }
+	ditherSelect.OnChanged = func(string) { refresh() }
+	intensitySlider.OnChanged = func(v float64) {
+		intensity = int(v)
+		intensityLabel.SetText(strconv.Itoa(intensity) + "%")
+	}
+
+	openButton := widget.NewButton("Open…", func() {
+		d := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil || r == nil {
+				return
+			}
+			r.Close()
+			load(r.URI().Path())
+		}, w)
+		d.SetFilter(storage.NewExtensionFileFilter([]string{
+			".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp", ".tif", ".tiff", ".pbm", ".pgm", ".ppm", ".pnm",
+		}))
+		d.Show()
+	})
+
+	var printButton *widget.Button
+	printButton = widget.NewButton("Print", func() {
+		if st.pixels == nil {
+			status.SetText("Nothing to print yet.")
+			return
+		}
+		printButton.Disable()
+		status.SetText("Printing…")
+		pixels, height, printMode := st.pixels, st.height, st.printMode
+		name := st.name
+		go func() {
+			defer printButton.Enable()
+			err := printJobs(ctx, []printJob{{pixels: pixels, height: height, mode: printMode, intensity: intensityByte()}})
+			if err != nil {
+				status.SetText("Print failed: " + err.Error())
+				desktopNotify("Print failed", err.Error(), true)
+				return
+			}
+			status.SetText("Printed.")
+			desktopNotify("Print complete", name, false)
+		}()
+	})
+
+	var notifier statusNotifier
+	statusButton := widget.NewButton("Printer status", func() {
+		status.SetText("Asking the printer…")
+		go func() {
+			s, err := queryStatus(ctx)
+			if err != nil {
+				status.SetText(err.Error())
+				return
+			}
+			status.SetText(s.String())
+			notifier.update(s)
+		}()
+	})
+
+	settings := widget.NewForm(
+		widget.NewFormItem("Mode", modeSelect),
+		widget.NewFormItem("Dither", ditherSelect),
+		widget.NewFormItem("Intensity", container.NewBorder(nil, nil, nil, intensityLabel, intensitySlider)),
+		widget.NewFormItem("Zoom", zoomSlider),
+		widget.NewFormItem("Angle", angleSlider),
+	)
+	side := container.NewVBox(openButton, settings, container.NewGridWithColumns(2, rotateButton, resetButton), printButton, statusButton, status)
+	w.SetContent(container.NewBorder(nil, hint, nil, side, container.NewScroll(area)))
+
+	w.SetOnDropped(func(_ fyne.Position, uris []fyne.URI) {
+		if len(uris) > 0 {
+			load(uris[0].Path())
+		}
+	})
+
+	if fs.NArg() > 0 {
+		load(fs.Arg(0))
+	}
+	go func() {
+		<-ctx.Done() // Ctrl-C in the terminal it was started from
+		a.Quit()
+	}()
+	w.Resize(fyne.NewSize(800, 600))
+	w.ShowAndRun()
+	return nil
+}
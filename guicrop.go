@@ -0,0 +1,96 @@
+//go:build gui
+
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+	"github.com/disintegration/imaging"
+)
+
+// cropState is how the GUI frames the source image on the paper: turned
+// clockwise by angle degrees, then seen through a window zoom times smaller
+// than the image. With zoom above 1 the window crops, below 1 the image is
+// narrower than the paper. pan moves the window across the slack, from -1
+// (left or top) to 1 (right or bottom).
+type cropState struct {
+	zoom       float64
+	panX, panY float64
+	angle      float64
+}
+
+var noCrop = cropState{zoom: 1}
+
+// apply returns img as seen through the window
+func (c cropState) apply(img image.Image) image.Image {
+	if c == noCrop {
+		return img
+	}
+	if c.angle != 0 {
+		img = imaging.Rotate(img, -c.angle, color.White)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	ww := max(1, int(float64(w)/c.zoom))
+	wh := h
+	if c.zoom > 1 {
+		// Shrinking only makes room at the sides, not paper above and below
+		wh = max(1, int(float64(h)/c.zoom))
+	}
+	x := int(float64(w-ww) * (c.panX + 1) / 2)
+	y := int(float64(h-wh) * (c.panY + 1) / 2)
+	out := imaging.New(ww, wh, color.White)
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min.Add(image.Pt(x, y)), draw.Src)
+	return out
+}
+
+// drag moves the window so the image follows a drag of dx, dy on a preview
+// shown w by h big
+func (c *cropState) drag(dx, dy, w, h float32) {
+	if c.zoom == 1 {
+		return
+	}
+	clamp := func(v float64) float64 { return min(1, max(-1, v)) }
+	if w > 0 {
+		c.panX = clamp(c.panX - 2*float64(dx)/(float64(w)*(c.zoom-1)))
+	}
+	if h > 0 && c.zoom > 1 {
+		c.panY = clamp(c.panY - 2*float64(dy)/(float64(h)*(c.zoom-1)))
+	}
+}
+
+// cropArea shows the preview and reports drags on it, for panning
+type cropArea struct {
+	widget.BaseWidget
+	content fyne.CanvasObject
+	onDrag  func(dx, dy float32)
+}
+
+func newCropArea(content fyne.CanvasObject, onDrag func(dx, dy float32)) *cropArea {
+	a := &cropArea{content: content, onDrag: onDrag}
+	a.ExtendBaseWidget(a)
+	return a
+}
+
+func (a *cropArea) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(a.content)
+}
+
+func (a *cropArea) Dragged(e *fyne.DragEvent) {
+	a.onDrag(e.Dragged.DX, e.Dragged.DY)
+}
+
+func (a *cropArea) DragEnd() {} // every step of the drag was applied already
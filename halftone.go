@@ -0,0 +1,52 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+
+	dither "github.com/makeworld-the-better-one/dither"
+)
+
+// halftoneMapper builds a clustered-dot screen with lpi cells per inch rotated
+// by angle degrees. Dots grow from the center of each cell as the input gets
+// darker, which thermal heads reproduce far more reliably than the isolated
+// pixels left by error diffusion.
+func halftoneMapper(lpi, angle float64, strength float32) dither.PixelMapper {
+	cell := float64(dpi) / lpi
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+
+	return func(x, y int, r, g, b uint8) (uint8, uint8, uint8) {
+		u := (float64(x)*cos + float64(y)*sin) / cell
+		v := (-float64(x)*sin + float64(y)*cos) / cell
+		u -= math.Floor(u)
+		v -= math.Floor(v)
+
+		// Round-dot spot function: 0 in the middle of the cell, 1 in the corners
+		spot := (2 - math.Cos(2*math.Pi*u) - math.Cos(2*math.Pi*v)) / 4
+		// Kept within half a level like the Bayer matrices, so that white
+		// and black stay solid
+		offset := float32(spot-0.5) * strength * 254
+
+		return clampAdd(r, offset), clampAdd(g, offset), clampAdd(b, offset)
+	}
+}
+
+func clampAdd(v uint8, offset float32) uint8 {
+	f := float32(v) + offset
+	if f < 0 {
+		return 0
+	}
+	if f > 255 {
+		return 255
+	}
+	return uint8(f)
+}
@@ -0,0 +1,172 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxHexdumpBytes keeps a dump of a whole disk image from using up the roll;
+// --length asks for more on purpose
+const maxHexdumpBytes = 64 << 10
+
+var hexdumpCmd = &command{
+	name:  "hexdump",
+	usage: "[--skip N] [--length N] <file or ->",
+}
+
+func init() {
+	hexdumpCmd.run = runHexdump
+	registerCommand(hexdumpCmd)
+}
+
+// runHexdump prints a file as a classic offset, hex and ASCII dump
+func runHexdump(ctx context.Context, args []string) error {
+	fs := hexdumpCmd.flagSet()
+	skip := fs.Int64("skip", 0, "Start this many bytes into the file")
+	length := fs.Int64("length", 0, "Dump at most this many bytes (default: up to 64 KiB)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one file")
+	}
+	if *skip < 0 || *length < 0 {
+		return withCause(errBadInput, fmt.Errorf("--skip and --length can't be negative"))
+	}
+
+	data, err := readDumpInput(fs.Arg(0), *skip, *length)
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	opts.stamp.file = sourceName(fs.Arg(0))
+	img := hexdumpImage(data, *skip, opts.margins.width())
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// readDumpInput reads length bytes of path, or stdin for "-", from offset
+// skip on. Without a length it refuses more than maxHexdumpBytes.
+func readDumpInput(path string, skip, length int64) ([]byte, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	if _, err := io.CopyN(io.Discard, r, skip); err != nil && err != io.EOF {
+		return nil, err
+	}
+	limit := length
+	if limit == 0 {
+		limit = maxHexdumpBytes + 1
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit))
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 && len(data) > maxHexdumpBytes {
+		return nil, fmt.Errorf("more than %d KiB to dump, pick a part with --skip and --length", maxHexdumpBytes>>10)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("nothing to dump")
+	}
+	return data, nil
+}
+
+// hexdumpLines formats data like hexdump -C, perLine bytes to a line with
+// offsets counted from start. Runs of identical lines collapse into a "*".
+func hexdumpLines(data []byte, start int64, perLine int) []string {
+	var lines []string
+	var previous []byte
+	repeated := false
+	for i := 0; i < len(data); i += perLine {
+		chunk := data[i:min(i+perLine, len(data))]
+		if len(chunk) == perLine && bytes.Equal(chunk, previous) {
+			if !repeated {
+				lines = append(lines, "*")
+				repeated = true
+			}
+			continue
+		}
+		previous, repeated = chunk, false
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%08x ", start+int64(i))
+		for j := 0; j < perLine; j++ {
+			if j%8 == 0 {
+				b.WriteByte(' ')
+			}
+			if j < len(chunk) {
+				fmt.Fprintf(&b, "%02x ", chunk[j])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteByte(' ')
+		for _, c := range chunk {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		lines = append(lines, b.String())
+	}
+	return append(lines, fmt.Sprintf("%08x", start+int64(len(data))))
+}
+
+// hexdumpLineChars is how many characters a dump line of perLine bytes takes
+func hexdumpLineChars(perLine int) int {
+	return 9 + perLine/8 + 3*perLine + 1 + perLine
+}
+
+// hexdumpImage renders the dump black on white, exactly width dots wide: as
+// many bytes to a line as fit, 16, 8 or 4, with the glyphs spread out over
+// any width left
+func hexdumpImage(data []byte, start int64, width int) image.Image {
+	perLine := 16
+	for perLine > 4 && hexdumpLineChars(perLine)*glyphWidth > width {
+		perLine /= 2
+	}
+	chars := hexdumpLineChars(perLine)
+	cell := max(width/chars, 1)
+	left := max((width-cell*chars)/2, 0)
+
+	lines := hexdumpLines(data, start, perLine)
+	lineHeight := glyphHeight + 2
+	img := imaging.New(width, len(lines)*lineHeight, color.White)
+	for i, line := range lines {
+		for j, c := range line {
+			drawText(img, left+j*cell+(cell-glyphWidth)/2, i*lineHeight, string(c), color.Black)
+		}
+	}
+	return img
+}
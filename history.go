@@ -0,0 +1,327 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// The history lives in <config dir>/history: index.jsonl has one line per
+// job, and every job keeps its packed buffer (<id>.bin, in --output-raw
+// format) and a small thumbnail (<id>.png) next to it.
+
+const historyThumbWidth = 128
+
+// jobSource describes where the current print came from, for the history
+var jobSource string
+
+type historyEntry struct {
+	ID        int               `json:"id"`
+	Time      time.Time         `json:"time"`
+	Source    string            `json:"source"`
+	Printer   string            `json:"printer"`
+	Mode      string            `json:"mode"`
+	Intensity int               `json:"intensity"`
+	Lines     int               `json:"lines"`
+	LengthMM  float64           `json:"length_mm"`
+	Settings  map[string]string `json:"settings,omitempty"` // options that differ from their defaults
+	Error     string            `json:"error,omitempty"`    // why the job failed after it was sent
+}
+
+var historyCmd = &command{
+	name:  "history",
+	usage: "list | show <id> | reprint <id>",
+}
+
+var reprintCmd = &command{
+	name:  "reprint",
+	usage: "",
+}
+
+func init() {
+	historyCmd.run = runHistory
+	registerCommand(historyCmd)
+	reprintCmd.run = runReprint
+	registerCommand(reprintCmd)
+}
+
+func historyDir() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "history")
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+func loadHistory() ([]historyEntry, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, "index.jsonl"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	in := bufio.NewScanner(f)
+	for in.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(in.Bytes(), &e); err != nil {
+			continue // keep going past a damaged line
+		}
+		entries = append(entries, e)
+	}
+	return entries, in.Err()
+}
+
+func findHistoryEntry(id int) (historyEntry, error) {
+	entries, err := loadHistory()
+	if err != nil {
+		return historyEntry{}, err
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return historyEntry{}, fmt.Errorf("no job %d in the history", id)
+}
+
+// recordMu keeps jobs finishing on several printers at once from
+// overwriting each other's records
+var recordMu sync.Mutex
+
+// recordJob does the bookkeeping for a job that was just printed on the
+// printer of ctx: paper usage and, unless --no-history is set, the job
+// history. A job that failed after it was sent is recorded with jobErr.
+// Failing to do so never fails the print, it is only logged.
+func recordJob(ctx context.Context, pixels []byte, height int, mode PrintMode, intensity byte, jobErr error) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	key := printerKeyFor(printerAddress(ctx))
+	if err := addPaperUsage(key, height); err != nil {
+		log.Printf("Failed to record paper usage: %v", err)
+	}
+	if noHistory {
+		return
+	}
+	if err := appendHistory(pixels, height, mode, intensity, key, jobErr); err != nil {
+		log.Printf("Failed to record print history: %v", err)
+	}
+}
+
+func appendHistory(pixels []byte, height int, mode PrintMode, intensity byte, printer string, jobErr error) error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+
+	e := historyEntry{
+		ID:        1,
+		Time:      time.Now(),
+		Source:    jobSource,
+		Printer:   printer,
+		Mode:      "1bpp",
+		Intensity: int(intensity),
+		Lines:     height,
+		LengthMM:  float64(height) * 25.4 / dpi,
+		Settings:  changedSettings(),
+	}
+	if len(entries) > 0 {
+		e.ID = entries[len(entries)-1].ID + 1
+	}
+	if mode == Mode4bpp {
+		e.Mode = "4bpp"
+	}
+	if jobErr != nil {
+		e.Error = jobErr.Error()
+	}
+
+	data, err := encodeRawBuffer(pixels, height, mode)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.bin", e.ID)), data, 0o644); err != nil {
+		return err
+	}
+	thumb := imaging.Resize(previewImage(pixels, height, mode), historyThumbWidth, 0, imaging.Box)
+	if err := imaging.Save(thumb, filepath.Join(dir, fmt.Sprintf("%d.png", e.ID))); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "index.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// changedSettings collects every option that is not at its default, no
+// matter whether it was given before or after a command name
+func changedSettings() map[string]string {
+	settings := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) > 1 && f.Value.String() != f.DefValue {
+			settings[f.Name] = f.Value.String()
+		}
+	})
+	return settings
+}
+
+func runHistory(ctx context.Context, args []string) error {
+	fs := historyCmd.flagSet()
+	fs.Parse(args)
+
+	switch fs.Arg(0) {
+	case "list", "":
+		return listHistory()
+	case "show", "reprint":
+		id, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			fs.Usage()
+			return fmt.Errorf("expected a job id")
+		}
+		e, err := findHistoryEntry(id)
+		if err != nil {
+			return err
+		}
+		if fs.Arg(0) == "show" {
+			return showHistoryEntry(e)
+		}
+		return reprintHistoryEntry(ctx, e)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown history command %q", fs.Arg(0))
+	}
+}
+
+// runReprint sends the most recent job again, for when the paper jammed
+func runReprint(ctx context.Context, args []string) error {
+	fs := reprintCmd.flagSet()
+	fs.Parse(args)
+
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("nothing to reprint, the job history is empty")
+	}
+	return reprintHistoryEntry(ctx, entries[len(entries)-1])
+}
+
+func listHistory() error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No jobs printed yet.")
+		return nil
+	}
+	for _, e := range entries {
+		failed := ""
+		if e.Error != "" {
+			failed = "  (failed)"
+		}
+		fmt.Printf("%4d  %s  %s  %3d%%  %6.1f mm  %s%s\n",
+			e.ID, e.Time.Format("2006-01-02 15:04"), e.Mode, e.Intensity, e.LengthMM, e.Source, failed)
+	}
+	return nil
+}
+
+func showHistoryEntry(e historyEntry) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Job:       %d\n", e.ID)
+	fmt.Printf("Printed:   %s\n", e.Time.Format(time.RFC1123))
+	fmt.Printf("Source:    %s\n", e.Source)
+	fmt.Printf("Printer:   %s\n", e.Printer)
+	fmt.Printf("Mode:      %s at %d%% intensity\n", e.Mode, e.Intensity)
+	fmt.Printf("Length:    %d lines (%.1f mm)\n", e.Lines, e.LengthMM)
+	if e.Error != "" {
+		fmt.Printf("Failed:    %s\n", e.Error)
+	}
+	if len(e.Settings) > 0 {
+		names := make([]string, 0, len(e.Settings))
+		for name := range e.Settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var parts []string
+		for _, name := range names {
+			parts = append(parts, fmt.Sprintf("--%s=%s", name, e.Settings[name]))
+		}
+		fmt.Printf("Settings:  %s\n", strings.Join(parts, " "))
+	}
+	thumbPath := filepath.Join(dir, fmt.Sprintf("%d.png", e.ID))
+	fmt.Printf("Thumbnail: %s\n", thumbPath)
+
+	// Show the thumbnail right away when attached to a terminal
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		if thumb, err := imaging.Open(thumbPath); err == nil {
+			w := bufio.NewWriter(os.Stdout)
+			writeHalfBlocks(w, thumb, historyThumbWidth)
+			return w.Flush()
+		}
+	}
+	return nil
+}
+
+// reprintHistoryEntry sends a stored buffer again, exactly as it was
+// printed, or previews it with -o
+func reprintHistoryEntry(ctx context.Context, e historyEntry) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	pixels, height, mode, err := loadRawBuffer(filepath.Join(dir, fmt.Sprintf("%d.bin", e.ID)), 0, true, 0)
+	if err != nil {
+		return err
+	}
+	jobSource = fmt.Sprintf("reprint of job %d (%s)", e.ID, e.Source)
+	if previewOnly() {
+		return previewOrPrint(ctx, pixels, height, mode)
+	}
+	log.Printf("Reprinting job %d at %d%% intensity", e.ID, e.Intensity)
+	return printJobs(ctx, []printJob{{pixels: pixels, height: height, mode: mode, intensity: byte(e.Intensity)}})
+}
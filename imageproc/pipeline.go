@@ -0,0 +1,230 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package imageproc chains image transforms into a Pipeline. A pipeline is
+// built step by step, or read from a YAML list of steps:
+//
+//	# photo.yaml
+//	- rotate: 90
+//	- crop: {x: 40, y: 0, width: 800}
+//	- contrast: 15
+//	- invert
+//
+// Steps are looked up by name among the registered transforms. The generic
+// ones are registered here; callers add their own with Register, or
+// RegisterFilter for those without parameters.
+package imageproc
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Transform changes an image. Parameters are checked when the step is
+// built, so applying a transform can't fail.
+type Transform func(image.Image) image.Image
+
+// Builder makes a transform from a step's parameters
+type Builder func(p *Params) (Transform, error)
+
+var builders = map[string]Builder{}
+
+// Register makes a transform available to pipelines under name. It is
+// meant to be called from init.
+func Register(name string, b Builder) {
+	builders[name] = b
+}
+
+// RegisterFilter makes a transform without parameters available under
+// name, for programs using bleh's packages to add filters of their own
+func RegisterFilter(name string, fn func(image.Image) image.Image) {
+	Register(name, plain(fn))
+}
+
+// Names lists the registered transforms, sorted
+func Names() []string {
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Step is a transform of a pipeline, with the parameters it was built from
+type Step struct {
+	Name      string
+	Params    map[string]any
+	transform Transform
+}
+
+// Pipeline is an ordered list of transforms
+type Pipeline struct {
+	Steps []Step
+}
+
+// Add appends the registered transform name, built with params. A single
+// value, as in "rotate: 90", is passed as the parameter "value".
+func (p *Pipeline) Add(name string, params map[string]any) error {
+	b, ok := builders[name]
+	if !ok {
+		return fmt.Errorf("unknown step %q", name)
+	}
+	ps := &Params{values: params, used: map[string]bool{}}
+	t, err := b(ps)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if err := ps.unused(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	p.Steps = append(p.Steps, Step{Name: name, Params: params, transform: t})
+	return nil
+}
+
+// Then appends a transform that isn't registered
+func (p *Pipeline) Then(name string, t Transform) *Pipeline {
+	p.Steps = append(p.Steps, Step{Name: name, transform: t})
+	return p
+}
+
+// Apply runs img through every step in order
+func (p *Pipeline) Apply(img image.Image) image.Image {
+	for _, s := range p.Steps {
+		img = s.transform(img)
+	}
+	return img
+}
+
+// Parse reads a pipeline from a YAML list of steps. Each step is a name, or
+// a name mapped to a value or to named parameters.
+func Parse(data []byte) (*Pipeline, error) {
+	var items []any
+	if err := yaml.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	p := &Pipeline{}
+	for i, item := range items {
+		var name string
+		var params map[string]any
+		switch v := item.(type) {
+		case string:
+			name = v
+		case map[string]any:
+			if len(v) != 1 {
+				return nil, fmt.Errorf("step %d: expected one name, got %d", i+1, len(v))
+			}
+			for k, value := range v {
+				name = k
+				if m, ok := value.(map[string]any); ok {
+					params = m
+				} else if value != nil {
+					params = map[string]any{"value": value}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("step %d: expected a name or a name with parameters", i+1)
+		}
+		if err := p.Add(name, params); err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+	}
+	return p, nil
+}
+
+// Load reads a pipeline file
+func Load(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Params are the parameters of a step being built. Parameters nobody asked
+// for are reported as errors, to catch typos.
+type Params struct {
+	values map[string]any
+	used   map[string]bool
+}
+
+func (p *Params) lookup(names []string) (string, any, bool) {
+	for _, name := range names {
+		if v, ok := p.values[name]; ok {
+			p.used[name] = true
+			return name, v, true
+		}
+	}
+	return "", nil, false
+}
+
+// Float returns the first of the named parameters given, or def
+func (p *Params) Float(def float64, names ...string) (float64, error) {
+	name, v, ok := p.lookup(names)
+	if !ok {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("%s must be a number, not %v", name, v)
+}
+
+// Int returns the first of the named parameters given, or def
+func (p *Params) Int(def int, names ...string) (int, error) {
+	name, v, ok := p.lookup(names)
+	if !ok {
+		return def, nil
+	}
+	if n, ok := v.(int); ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("%s must be a whole number, not %v", name, v)
+}
+
+// String returns the first of the named parameters given, or def
+func (p *Params) String(def string, names ...string) (string, error) {
+	name, v, ok := p.lookup(names)
+	if !ok {
+		return def, nil
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case int, float64, bool:
+		return fmt.Sprint(s), nil
+	}
+	return "", fmt.Errorf("%s must be a value, not %v", name, v)
+}
+
+func (p *Params) unused() error {
+	var names []string
+	for name := range p.values {
+		if !p.used[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown parameter %s", names[0])
+}
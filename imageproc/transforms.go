@@ -0,0 +1,163 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package imageproc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	Register("rotate", rotate)
+	Register("flip", flip)
+	Register("crop", crop)
+	Register("resize", resize)
+	Register("contrast", adjust(imaging.AdjustContrast, -100, 100))
+	Register("brightness", adjust(imaging.AdjustBrightness, -100, 100))
+	Register("gamma", gamma)
+	Register("sharpen", sigma(imaging.Sharpen, 1))
+	Register("blur", sigma(imaging.Blur, 1))
+	Register("invert", plain(func(img image.Image) image.Image { return imaging.Invert(img) }))
+	Register("grayscale", plain(func(img image.Image) image.Image { return imaging.Grayscale(img) }))
+}
+
+// plain builds transforms without parameters
+func plain(t Transform) Builder {
+	return func(p *Params) (Transform, error) {
+		return t, nil
+	}
+}
+
+// rotate turns the image clockwise by degrees, filling the corners left
+// by angles other than right ones with white
+func rotate(p *Params) (Transform, error) {
+	deg, err := p.Float(90, "degrees", "value")
+	if err != nil {
+		return nil, err
+	}
+	switch deg {
+	case 90, -270:
+		return func(img image.Image) image.Image { return imaging.Rotate270(img) }, nil
+	case 180, -180:
+		return func(img image.Image) image.Image { return imaging.Rotate180(img) }, nil
+	case 270, -90:
+		return func(img image.Image) image.Image { return imaging.Rotate90(img) }, nil
+	}
+	return func(img image.Image) image.Image { return imaging.Rotate(img, -deg, color.White) }, nil
+}
+
+// flip mirrors the image horizontally (left to right) or vertically
+func flip(p *Params) (Transform, error) {
+	dir, err := p.String("horizontal", "direction", "value")
+	if err != nil {
+		return nil, err
+	}
+	switch dir {
+	case "horizontal", "h":
+		return func(img image.Image) image.Image { return imaging.FlipH(img) }, nil
+	case "vertical", "v":
+		return func(img image.Image) image.Image { return imaging.FlipV(img) }, nil
+	}
+	return nil, fmt.Errorf("direction must be horizontal or vertical, not %q", dir)
+}
+
+// crop keeps the rectangle at x, y of width by height pixels. A width or
+// height of 0 reaches the edge of the image, and the rectangle is clipped
+// to it.
+func crop(p *Params) (Transform, error) {
+	var x, y, w, h int
+	var err error
+	for _, v := range []struct {
+		dst  *int
+		name string
+	}{{&x, "x"}, {&y, "y"}, {&w, "width"}, {&h, "height"}} {
+		if *v.dst, err = p.Int(0, v.name); err != nil {
+			return nil, err
+		}
+		if *v.dst < 0 {
+			return nil, fmt.Errorf("%s can't be negative", v.name)
+		}
+	}
+	return func(img image.Image) image.Image {
+		b := img.Bounds()
+		r := image.Rect(b.Min.X+x, b.Min.Y+y, b.Max.X, b.Max.Y)
+		if w > 0 {
+			r.Max.X = r.Min.X + w
+		}
+		if h > 0 {
+			r.Max.Y = r.Min.Y + h
+		}
+		if r = r.Intersect(b); r.Empty() {
+			return img // nothing left, which is more likely a mistake
+		}
+		return imaging.Crop(img, r)
+	}, nil
+}
+
+// resize scales the image to width by height pixels; with one of them 0
+// it keeps the aspect ratio
+func resize(p *Params) (Transform, error) {
+	w, err := p.Int(0, "width", "value")
+	if err != nil {
+		return nil, err
+	}
+	h, err := p.Int(0, "height")
+	if err != nil {
+		return nil, err
+	}
+	if w <= 0 && h <= 0 || w < 0 || h < 0 {
+		return nil, fmt.Errorf("give a positive width, height or both")
+	}
+	return func(img image.Image) image.Image { return imaging.Resize(img, w, h, imaging.Lanczos) }, nil
+}
+
+// adjust builds transforms taking a percentage from lo to hi
+func adjust(fn func(image.Image, float64) *image.NRGBA, lo, hi float64) Builder {
+	return func(p *Params) (Transform, error) {
+		pct, err := p.Float(0, "percent", "value")
+		if err != nil {
+			return nil, err
+		}
+		if pct < lo || pct > hi {
+			return nil, fmt.Errorf("percent must be from %g to %g", lo, hi)
+		}
+		return func(img image.Image) image.Image { return fn(img, pct) }, nil
+	}
+}
+
+// gamma darkens the mid tones below 1 and lightens them above
+func gamma(p *Params) (Transform, error) {
+	g, err := p.Float(1, "gamma", "value")
+	if err != nil {
+		return nil, err
+	}
+	if g <= 0 {
+		return nil, fmt.Errorf("gamma must be positive")
+	}
+	return func(img image.Image) image.Image { return imaging.AdjustGamma(img, g) }, nil
+}
+
+// sigma builds transforms taking a gaussian radius
+func sigma(fn func(image.Image, float64) *image.NRGBA, def float64) Builder {
+	return func(p *Params) (Transform, error) {
+		s, err := p.Float(def, "sigma", "value")
+		if err != nil {
+			return nil, err
+		}
+		if s <= 0 {
+			return nil, fmt.Errorf("sigma must be positive")
+		}
+		return func(img image.Image) image.Image { return fn(img, s) }, nil
+	}
+}
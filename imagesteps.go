@@ -0,0 +1,94 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+
+	"bleh/imageproc"
+
+	"github.com/disintegration/imaging"
+)
+
+// The processing steps of bleh's own that --pipeline files and --filter can
+// use besides the generic ones of imageproc
+
+func init() {
+	imageproc.Register("dither", ditherStep)
+	imageproc.Register("threshold", thresholdStep)
+	imageproc.Register("denoise", denoiseStep)
+	imageproc.RegisterFilter("equalize", equalize)
+	imageproc.RegisterFilter("clahe", clahe)
+	imageproc.RegisterFilter("deskew", deskew)
+	imageproc.Register("trim", trimStep)
+}
+
+// ditherStep makes the image black and white at the print width, so that
+// the steps after it (and the printer) get the dots as they were dithered
+func ditherStep(p *imageproc.Params) (imageproc.Transform, error) {
+	method, err := p.String("floyd", "method", "value")
+	if err != nil {
+		return nil, err
+	}
+	if method == "none" || method == "auto" || !slices.Contains(ditherNames, method) {
+		return nil, fmt.Errorf("unknown dither method %q", method)
+	}
+	d, err := newDitherer([]color.Color{color.Black, color.White},
+		imageOptions{ditherType: method, serpentine: serpentine, lpi: halftoneLPI, angle: halftoneAngle}, 1.0)
+	if err != nil {
+		return nil, err
+	}
+	return func(img image.Image) image.Image {
+		return d.DitherCopy(imaging.Grayscale(imaging.Resize(img, linePixels, 0, imaging.Lanczos)))
+	}, nil
+}
+
+// thresholdStep makes the image black and white like --threshold
+func thresholdStep(p *imageproc.Params) (imageproc.Transform, error) {
+	level, err := p.String("auto", "level", "value")
+	if err != nil {
+		return nil, err
+	}
+	window, err := p.Int(0, "window")
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseThreshold(level, window)
+	if err != nil {
+		return nil, err
+	}
+	return func(img image.Image) image.Image { return t.binarize(img) }, nil
+}
+
+func denoiseStep(p *imageproc.Params) (imageproc.Transform, error) {
+	filter, err := p.String("median", "filter", "value")
+	if err != nil {
+		return nil, err
+	}
+	if filter != "median" && filter != "bilateral" {
+		return nil, fmt.Errorf("filter must be median or bilateral, not %q", filter)
+	}
+	return func(img image.Image) image.Image { return denoise(img, filter) }, nil
+}
+
+func trimStep(p *imageproc.Params) (imageproc.Transform, error) {
+	level, err := p.Int(240, "level", "value")
+	if err != nil {
+		return nil, err
+	}
+	if level < 0 || level > 255 {
+		return nil, fmt.Errorf("level must be from 0 to 255")
+	}
+	return func(img image.Image) image.Image { return trimWhitespace(img, uint8(level)) }, nil
+}
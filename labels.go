@@ -0,0 +1,197 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/disintegration/imaging"
+	"gopkg.in/yaml.v3"
+)
+
+var labelsCmd = &command{
+	name:  "labels",
+	usage: "--template t.yaml --data rows.csv [--start N] [--end N]",
+}
+
+func init() {
+	labelsCmd.run = runLabels
+	registerCommand(labelsCmd)
+}
+
+// labelTemplate is a --template file. A file that isn't YAML is taken as the
+// layout on its own.
+type labelTemplate struct {
+	Height string `yaml:"height"` // every label this long, for die-cut labels
+	Layout string `yaml:"layout"` // in the language of receipt templates
+}
+
+// runLabels prints one label per CSV row, each its own job, with the
+// columns available to the template by their header names
+func runLabels(ctx context.Context, args []string) error {
+	fs := labelsCmd.flagSet()
+	templatePath := fs.String("template", "", "Label template: YAML with height and layout, or a bare layout")
+	dataPath := fs.String("data", "", "CSV file with a header row, one label per following row, '-' for stdin")
+	start := fs.Int("start", 1, "First row to print, counting from 1 after the header")
+	end := fs.Int("end", 0, "Last row to print (default: the last one)")
+	fs.Parse(args)
+
+	if *templatePath == "" || *dataPath == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("expected --template and --data")
+	}
+
+	tmpl, height, err := loadLabelTemplate(*templatePath)
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	rows, err := readCSVRows(*dataPath)
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	if *end == 0 {
+		*end = len(rows)
+	}
+	if *start < 1 || *end > len(rows) || *start > *end {
+		return withCause(errBadInput, fmt.Errorf("rows %d to %d asked for, the data has 1 to %d", *start, *end, len(rows)))
+	}
+	rows = rows[*start-1 : *end]
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(*templatePath)
+	render := func(i int) (printJob, error) {
+		img, err := renderTemplate(tmpl, rows[i], opts.margins.width(), dir)
+		if err != nil {
+			return printJob{}, withCause(errBadInput, fmt.Errorf("row %d: %v", *start+i, err))
+		}
+		if img, err = fitLabel(img, height); err != nil {
+			return printJob{}, withCause(errBadInput, fmt.Errorf("row %d: %v", *start+i, err))
+		}
+		opts := opts
+		opts.stamp = stampInfo{file: sourceName(*dataPath), page: i + 1, pages: len(rows)}
+		pixels, h, err := processImage(img, printMode, opts)
+		return printJob{pixels: pixels, height: h, mode: printMode, intensity: intensityByte()}, err
+	}
+
+	if previewOnly() {
+		// Previews show the labels one after another, as they come out
+		var pixels []byte
+		height := 0
+		for i := range rows {
+			job, err := render(i)
+			if err != nil {
+				return err
+			}
+			pixels, height = append(pixels, job.pixels...), height+job.height
+		}
+		return previewOrPrint(ctx, pixels, height, printMode)
+	}
+	log.Printf("Printing %d labels", len(rows))
+	return printJobQueue(ctx, prepareJobs(ctx, len(rows), render), len(rows))
+}
+
+// loadLabelTemplate reads a label template and the height labels are cut
+// to, 0 for as long as they come out
+func loadLabelTemplate(path string) (*template.Template, int, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	t := labelTemplate{Layout: string(src)}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		t = labelTemplate{}
+		if err := yaml.Unmarshal(src, &t); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if t.Layout == "" {
+			return nil, 0, fmt.Errorf("%s has no layout", path)
+		}
+	}
+	height := 0
+	if t.Height != "" {
+		if height, err = parseLength(t.Height); err != nil {
+			return nil, 0, fmt.Errorf("label height: %v", err)
+		}
+	}
+	tmpl, err := parseTemplate(filepath.Base(path), t.Layout)
+	return tmpl, height, err
+}
+
+// readCSVRows reads a CSV file into one map per row, from header name to
+// value
+func readCSVRows(path string) ([]map[string]string, error) {
+	records, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[strings.TrimSpace(name)] = ""
+			if i < len(record) {
+				row[strings.TrimSpace(name)] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readCSV reads a CSV file, or stdin for "-", that has a header row and at
+// least one row of data
+func readCSV(path string) ([][]string, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // short rows leave the last columns empty
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s needs a header row and at least one row of data", path)
+	}
+	return records, nil
+}
+
+// fitLabel pads a label out to height dots, for labels of a fixed length.
+// One that doesn't fit is an error rather than running onto the next label.
+func fitLabel(img image.Image, height int) (image.Image, error) {
+	h := img.Bounds().Dy()
+	if height == 0 || h == height {
+		return img, nil
+	}
+	if h > height {
+		return nil, fmt.Errorf("label comes out %.1f mm long, more than its %.1f mm", float64(h)*25.4/dpi, float64(height)*25.4/dpi)
+	}
+	dst := imaging.New(img.Bounds().Dx(), height, color.White)
+	return imaging.Paste(dst, img, image.Pt(0, (height-h)/2)), nil
+}
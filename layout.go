@@ -0,0 +1,133 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// margins around the image, in printer dots
+type margins struct {
+	top, bottom, left, right int
+}
+
+// parseLength reads a length in dots ("12" or "12px") or millimeters ("5mm")
+func parseLength(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(s, "mm"):
+		s = strings.TrimSuffix(s, "mm")
+		unit = dpi / 25.4
+	case strings.HasSuffix(s, "px"):
+		s = strings.TrimSuffix(s, "px")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid length %q, use e.g. 16, 16px or 2mm", s)
+	}
+	return int(math.Round(v * unit)), nil
+}
+
+func parseMargins(top, bottom, left, right string) (margins, error) {
+	var m margins
+	for _, f := range []struct {
+		dst *int
+		val string
+	}{{&m.top, top}, {&m.bottom, bottom}, {&m.left, left}, {&m.right, right}} {
+		v, err := parseLength(f.val)
+		if err != nil {
+			return margins{}, err
+		}
+		*f.dst = v
+	}
+	if m.left+m.right >= linePixels {
+		return margins{}, fmt.Errorf("left and right margins leave no room for the image")
+	}
+	return m, nil
+}
+
+// width is the number of dots left for the image between the side margins
+func (m margins) width() int {
+	return linePixels - m.left - m.right
+}
+
+// applyMargins surrounds img, which must already be m.width() wide, with
+// white, producing an image exactly linePixels wide
+func applyMargins(img image.Image, m margins) image.Image {
+	if m == (margins{}) {
+		return img
+	}
+	dst := imaging.New(linePixels, img.Bounds().Dy()+m.top+m.bottom, color.White)
+	return imaging.Paste(dst, img, image.Pt(m.left, m.top))
+}
+
+// size is the --width-mm and --height-mm of the image in dots, 0 where not
+// given
+type size struct {
+	width, height int
+}
+
+func parseSize(widthMM, heightMM float64, m margins) (size, error) {
+	if widthMM < 0 || heightMM < 0 {
+		return size{}, fmt.Errorf("Invalid size. Use positive --width-mm and --height-mm values.")
+	}
+	s := size{int(math.Round(widthMM * dpi / 25.4)), int(math.Round(heightMM * dpi / 25.4))}
+	if s.width > m.width() {
+		return size{}, fmt.Errorf("Invalid size. --width-mm %g is wider than the %.1f mm print width.", widthMM, float64(m.width())*25.4/dpi)
+	}
+	return s, nil
+}
+
+// scale scales img to the size, centered on a white strip width dots wide.
+// Without a size, img fills the width. With both dimensions it is fitted in
+// and centered on a label of that size.
+func (s size) scale(img image.Image, width int) image.Image {
+	if s == (size{}) {
+		return imaging.Resize(img, width, 0, imaging.Lanczos)
+	}
+	w, h := min(s.width, width), s.height
+	b := img.Bounds()
+	var scaled image.Image
+	switch {
+	case h == 0:
+		scaled = imaging.Resize(img, w, 0, imaging.Lanczos)
+	case w == 0:
+		// As tall as asked, unless that makes it wider than the paper
+		if b.Dx()*h > b.Dy()*width {
+			scaled = imaging.Resize(img, width, 0, imaging.Lanczos)
+			log.Printf("Warning: at %d dots tall the image would be wider than the paper, scaled to the print width instead", h)
+		} else {
+			scaled = imaging.Resize(img, 0, h, imaging.Lanczos)
+		}
+	default:
+		if b.Dx()*h > b.Dy()*w {
+			scaled = imaging.Resize(img, w, 0, imaging.Lanczos)
+		} else {
+			scaled = imaging.Resize(img, 0, h, imaging.Lanczos)
+		}
+	}
+
+	height := scaled.Bounds().Dy()
+	if s.height > 0 && s.width > 0 {
+		height = h
+	}
+	dst := imaging.New(width, height, color.White)
+	return imaging.PasteCenter(dst, scaled)
+}
@@ -0,0 +1,72 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Two bleh runs writing to the same printer at once would interleave their
+// data into one garbled print, so a connection holds a lock on the printer
+// for as long as it is open: an flock on a file in the temporary directory,
+// shared by all users, which the kernel drops when the process dies. The
+// file holds the pid of the run that has it, for the error of the one
+// giving up after --lock-timeout.
+// The lock is named after the address the scan found, which -a only
+// anticipates. A printer doesn't advertise while connected, so runs
+// without -a would only scan in vain while another has it: they take
+// turns on one more lock of their own first. A run finding a lock taken
+// tries again every lockPollInterval. Systems without flock go without
+// the lock.
+
+// printerLock is the locks held for a connection, released with unlock
+type printerLock struct {
+	keys  []string
+	files []*os.File // none where locking isn't supported
+}
+
+func lockPath(key string) string {
+	return filepath.Join(os.TempDir(), "bleh-"+key+".lock")
+}
+
+// holds tells whether the lock on key is already taken by l
+func (l *printerLock) holds(key string) bool {
+	for _, k := range l.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *printerLock) unlock() {
+	for _, f := range l.files {
+		f.Close() // closing drops the flock
+	}
+	l.keys, l.files = nil, nil
+}
+
+// lockHolder describes the run holding the lock at path, by the pid it wrote
+func lockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if pid, perr := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && perr == nil {
+		return "pid " + strconv.Itoa(pid)
+	}
+	return "another bleh run, " + path + " is locked"
+}
+
+func errPrinterBusy(path string) error {
+	return withCause(errConnect, fmt.Errorf("printer busy (held by %s)", lockHolder(path)))
+}
@@ -0,0 +1,77 @@
+//go:build unix
+
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// lockPollInterval is how often a run waiting for the printer tries the lock
+// again. flock can block, but not in a way ctx can interrupt.
+const lockPollInterval = 250 * time.Millisecond
+
+// take adds the lock named key to l, waiting up to --lock-timeout for the
+// run holding it to finish, or not at all with --no-wait
+func (l *printerLock) take(ctx context.Context, key string) error {
+	if l.holds(key) {
+		return nil
+	}
+	path := lockPath(key)
+	// Writable by everyone so that users can share a file someone else
+	// created, and read-only where it isn't: that is enough to lock it
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err == nil {
+		f.Chmod(0o666) // past the umask, only works on a file of our own
+	} else if errors.Is(err, fs.ErrPermission) {
+		f, err = os.OpenFile(path, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %v", err)
+	}
+	deadline := time.Now().Add(lockTimeout)
+	waiting := false
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// Fails on a read-only file, which then names no holder
+			f.Truncate(0)
+			f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+			l.keys, l.files = append(l.keys, key), append(l.files, f)
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if noWait || (lockTimeout > 0 && time.Now().After(deadline)) {
+			f.Close()
+			return errPrinterBusy(path)
+		}
+		if !waiting {
+			log.Printf("Printer is busy (held by %s), waiting for it to finish...", lockHolder(path))
+			waiting = true
+		}
+		if err := sleepCtx(ctx, lockPollInterval); err != nil {
+			f.Close()
+			return err
+		}
+	}
+}
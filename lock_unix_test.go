@@ -0,0 +1,58 @@
+//go:build unix
+
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrinterLockBusy(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	saved := lockTimeout
+	defer func() { lockTimeout = saved }()
+	lockTimeout = 2 * lockPollInterval
+
+	held := &printerLock{}
+	if err := held.take(context.Background(), "aabbccddeeff"); err != nil {
+		t.Fatalf("take = %v", err)
+	}
+	if err := held.take(context.Background(), "aabbccddeeff"); err != nil {
+		t.Errorf("take of a lock already held = %v, want nil", err)
+	}
+
+	other := &printerLock{}
+	start := time.Now()
+	err := other.take(context.Background(), "aabbccddeeff")
+	want := fmt.Sprintf("printer busy (held by pid %d)", os.Getpid())
+	if !errors.Is(err, errConnect) || !strings.Contains(err.Error(), want) {
+		t.Errorf("take of a busy lock = %v, want %q", err, want)
+	}
+	if waited := time.Since(start); waited < lockTimeout {
+		t.Errorf("take gave up after %v, before --lock-timeout %v", waited, lockTimeout)
+	}
+
+	if err := other.take(context.Background(), "default"); err != nil {
+		t.Errorf("take of another lock = %v", err)
+	}
+	held.unlock()
+	if err := other.take(context.Background(), "aabbccddeeff"); err != nil {
+		t.Errorf("take after unlock = %v", err)
+	}
+	other.unlock()
+}
@@ -0,0 +1,583 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var mailCmd = &command{
+	name:  "mail",
+	usage: "--server host[:port] --user U --allow addr,... [--mailbox INBOX] [--interval 1m] [--body] [--authserv-id ID]",
+}
+
+func init() {
+	mailCmd.run = runMail
+	registerCommand(mailCmd)
+}
+
+// mailOptions are the settings of the mailbox watcher
+type mailOptions struct {
+	server, user, password, mailbox string
+	tls                             bool
+	allow                           []string // addresses, or @domain for a whole domain
+	authservID                      string   // whose Authentication-Results to trust, "" for the topmost
+	unauthenticated                 bool     // print without a passing DKIM, SPF or DMARC result
+	body                            bool
+	textScale                       int
+}
+
+// mailBodyLimit is how much of a message body gets printed, in characters
+const mailBodyLimit = 3000
+
+// runMail watches an IMAP mailbox and prints the image and PDF attachments
+// of unread messages from allowed senders, which are then marked as read
+func runMail(ctx context.Context, args []string) error {
+	fs := mailCmd.flagSet()
+	server := fs.String("server", "", "IMAP server, host or host:port")
+	user := fs.String("user", "", "User name to log in with")
+	password := fs.String("password", "", "Password (default: $BLEH_MAIL_PASSWORD)")
+	mailbox := fs.String("mailbox", "INBOX", "Mailbox to watch")
+	allow := fs.String("allow", "", "Comma-separated sender addresses, or @domain, allowed to print, once the mail server vouches for them")
+	authservID := fs.String("authserv-id", "", "Only trust Authentication-Results added by this mail server (default: the topmost one)")
+	unauthenticated := fs.Bool("unauthenticated", false, "Print mail from allowed senders the server didn't authenticate too: forged senders will print")
+	interval := fs.Duration("interval", time.Minute, "How often to check for new mail")
+	body := fs.Bool("body", false, "Print the subject and text of messages too, not only attachments")
+	noTLS := fs.Bool("no-tls", false, "Connect without TLS, e.g. to a local mail bridge")
+	textScale := fs.Int("text-scale", 1, "Font scale for message text")
+	fs.Parse(args)
+
+	o := mailOptions{
+		server: *server, user: *user, password: *password, mailbox: *mailbox,
+		tls: !*noTLS, body: *body, textScale: *textScale,
+		authservID: strings.ToLower(*authservID), unauthenticated: *unauthenticated,
+	}
+	if o.password == "" {
+		o.password = os.Getenv("BLEH_MAIL_PASSWORD")
+	}
+	for _, a := range strings.Split(*allow, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			o.allow = append(o.allow, a)
+		}
+	}
+	if o.server == "" || o.user == "" {
+		fs.Usage()
+		return fmt.Errorf("expected --server and --user")
+	}
+	if o.password == "" {
+		return fmt.Errorf("a password is required, from --password or $BLEH_MAIL_PASSWORD")
+	}
+	if len(o.allow) == 0 {
+		return fmt.Errorf("--allow is required, anyone can send mail")
+	}
+	if *interval < 10*time.Second {
+		return fmt.Errorf("--interval must be at least 10s")
+	}
+	if o.textScale < 1 || o.textScale > 8 {
+		return fmt.Errorf("--text-scale must be between 1 and 8")
+	}
+	if _, _, err := net.SplitHostPort(o.server); err != nil {
+		port := "993"
+		if !o.tls {
+			port = "143"
+		}
+		o.server = net.JoinHostPort(o.server, port)
+	}
+
+	c, err := dialIMAP(ctx, o)
+	if err != nil {
+		return withCause(errConnect, err)
+	}
+	log.Printf("Watching %s on %s, Ctrl-C to stop", o.mailbox, o.server)
+	// Messages that aren't printed stay unread, this keeps them from being
+	// looked at again every time. UIDs only mean the same messages for as
+	// long as the mailbox keeps its UIDVALIDITY.
+	skipped := map[string]bool{}
+	validity := c.uidValidity
+	for {
+		if c == nil {
+			c, err = dialIMAP(ctx, o)
+		}
+		if err == nil && c.uidValidity != validity {
+			skipped, validity = map[string]bool{}, c.uidValidity
+		}
+		if err == nil {
+			err = checkMail(ctx, c, o, skipped)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Println(err)
+			if c != nil {
+				c.close()
+				c = nil
+			}
+		}
+		if sleepCtx(ctx, *interval) != nil {
+			return nil // the connection closes with ctx
+		}
+	}
+}
+
+// checkMail prints the unread messages in the mailbox. A message is only
+// marked as read once printed, so one the printer failed on is tried again.
+func checkMail(ctx context.Context, c *imapConn, o mailOptions, skipped map[string]bool) error {
+	lines, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return err
+	}
+	var uids []string
+	unseen := map[string]bool{}
+	for _, l := range lines {
+		if rest, ok := strings.CutPrefix(l.text, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+	for _, uid := range uids {
+		unseen[uid] = true
+	}
+	// Messages read or deleted meanwhile won't come up again
+	for uid := range skipped {
+		if !unseen[uid] {
+			delete(skipped, uid)
+		}
+	}
+	for _, uid := range uids {
+		if skipped[uid] {
+			continue
+		}
+		lines, err := c.command("UID FETCH " + uid + " (BODY.PEEK[])")
+		if err != nil {
+			return err
+		}
+		var raw []byte
+		for _, l := range lines {
+			if len(l.literals) > 0 {
+				raw = l.literals[0]
+			}
+		}
+		if raw == nil {
+			log.Printf("Skipping message %s, too large or empty", uid)
+			skipped[uid] = true
+			continue
+		}
+		printed, err := printMail(ctx, raw, o)
+		if err != nil {
+			return err
+		}
+		if !printed {
+			skipped[uid] = true
+			continue
+		}
+		if _, err := c.command("UID STORE " + uid + ` +FLAGS.SILENT (\Seen)`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mailAllowed tells whether addr is in allow, itself or by its domain
+func mailAllowed(allow []string, addr string) bool {
+	addr = strings.ToLower(addr)
+	for _, a := range allow {
+		if a == addr || strings.HasPrefix(a, "@") && strings.HasSuffix(addr, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// authResult matches a method and its result in Authentication-Results,
+// authComment the comments that may be anywhere in it
+var (
+	authResult  = regexp.MustCompile(`^(dkim|spf|dmarc)=(\w+)`)
+	authComment = regexp.MustCompile(`\([^)]*\)`)
+)
+
+// mailAuthenticated tells whether the mail server vouches for domain being
+// the sender's: a DKIM signature of it, SPF for it or DMARC for the From
+// header passed. Senders can write Authentication-Results of their own, so
+// only the ones of authservID count, or the topmost, which the server
+// receiving the mail added, if that's "".
+func mailAuthenticated(h mail.Header, domain, authservID string) bool {
+	for i, v := range h["Authentication-Results"] {
+		results := strings.Split(strings.ToLower(authComment.ReplaceAllString(v, "")), ";")
+		if authservID == "" && i > 0 {
+			break
+		}
+		if id := strings.Fields(results[0]); authservID != "" && (len(id) == 0 || id[0] != authservID) {
+			continue
+		}
+		for _, r := range results[1:] {
+			fields := strings.Fields(r)
+			if len(fields) == 0 {
+				continue
+			}
+			m := authResult.FindStringSubmatch(fields[0])
+			if m == nil || m[2] != "pass" {
+				continue
+			}
+			for _, f := range fields[1:] {
+				prop, value, _ := strings.Cut(f, "=")
+				switch prop {
+				case "header.d", "header.i", "header.from", "smtp.mailfrom":
+				default:
+					continue
+				}
+				if at := strings.LastIndex(value, "@"); at >= 0 {
+					value = value[at+1:]
+				}
+				if value == domain || strings.HasSuffix(domain, "."+value) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// printMail prints a message from an allowed sender and tells whether
+// there was anything to print. Only printer errors are returned; parts
+// that can't be read are logged and left out.
+func printMail(ctx context.Context, raw []byte, o mailOptions) (bool, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		log.Printf("Skipping a message that can't be parsed: %v", err)
+		return false, nil
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		log.Printf("Skipping a message without a sender: %v", err)
+		return false, nil
+	}
+	if !mailAllowed(o.allow, from.Address) {
+		log.Printf("Ignoring mail from %s, not in --allow", from.Address)
+		return false, nil
+	}
+	// The From header is easily forged, which SPF and DKIM checking by the
+	// mail server is meant to catch
+	_, domain, _ := strings.Cut(strings.ToLower(from.Address), "@")
+	if !o.unauthenticated && !mailAuthenticated(msg.Header, domain, o.authservID) {
+		log.Printf("Ignoring mail from %s, the server didn't authenticate it (see --authserv-id)", from.Address)
+		return false, nil
+	}
+	parts, err := mailParts(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body, 0)
+	if err != nil {
+		log.Printf("Mail from %s: %v", from.Address, err)
+	}
+
+	_, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return false, err
+	}
+	printed := false
+	if o.body {
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil {
+			subject = msg.Header.Get("Subject")
+		}
+		text := mailText(parts)
+		if text != "" || subject != "" {
+			text = strings.TrimSpace(subject + "\n\n" + shorten(text, mailBodyLimit))
+			if err := printReceived(ctx, textImage(text, opts.margins.width(), o.textScale), from.Address); err != nil {
+				return false, err
+			}
+			printed = true
+		}
+	}
+	for _, p := range parts {
+		switch p.kind() {
+		case "image":
+			img, err := decodeImageFromReader(bytes.NewReader(p.data))
+			if err != nil {
+				log.Printf("Mail from %s, %s: %v", from.Address, p.filename, err)
+				continue
+			}
+			if err := printReceived(ctx, img, from.Address); err != nil {
+				return false, err
+			}
+			printed = true
+		case "pdf":
+			pages, err := renderPDF(ctx, p.data, opts.margins.width())
+			if err != nil {
+				log.Printf("Mail from %s, %s: %v", from.Address, p.filename, err)
+				continue
+			}
+			for _, page := range pages {
+				if err := printReceived(ctx, page, from.Address); err != nil {
+					return false, err
+				}
+			}
+			printed = true
+		}
+	}
+	if !printed {
+		log.Printf("Nothing to print in mail from %s", from.Address)
+	}
+	return printed, nil
+}
+
+// mailPart is a leaf of a MIME message, decoded
+type mailPart struct {
+	mediaType string
+	charset   string
+	filename  string
+	attached  bool
+	data      []byte
+}
+
+var imageExt = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|webp|bmp|tiff?|p[bgpn]m)$`)
+
+// kind is "image", "pdf" or "text" for the parts that can be printed
+func (p mailPart) kind() string {
+	switch {
+	case strings.HasPrefix(p.mediaType, "image/"):
+		return "image"
+	case p.mediaType == "application/pdf":
+		return "pdf"
+	case p.mediaType == "application/octet-stream" && imageExt.MatchString(p.filename):
+		return "image"
+	case p.mediaType == "application/octet-stream" && strings.EqualFold(filepath.Ext(p.filename), ".pdf"):
+		return "pdf"
+	case strings.HasPrefix(p.mediaType, "text/") && !p.attached:
+		return "text"
+	}
+	return ""
+}
+
+// mailParts decodes a message body into its leaf parts
+func mailParts(contentType, encoding, disposition string, body io.Reader, depth int) ([]mailPart, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", nil // the default when there's none
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth > 10 {
+			return nil, fmt.Errorf("message nested too deep")
+		}
+		var parts []mailPart
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := r.NextRawPart()
+			if err == io.EOF {
+				return parts, nil
+			} else if err != nil {
+				return parts, err
+			}
+			sub, err := mailParts(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"),
+				p.Header.Get("Content-Disposition"), p, depth+1)
+			parts = append(parts, sub...)
+			if err != nil {
+				return parts, err
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s part: %v", mediaType, err)
+	}
+	p := mailPart{mediaType: mediaType, charset: params["charset"], filename: params["name"], data: data}
+	if d, dparams, err := mime.ParseMediaType(disposition); err == nil {
+		p.attached = d == "attachment"
+		if dparams["filename"] != "" {
+			p.filename = dparams["filename"]
+		}
+	}
+	return []mailPart{p}, nil
+}
+
+// mailText is the text of a message, its plain text part or, failing that,
+// its HTML one without the markup
+func mailText(parts []mailPart) string {
+	var html string
+	for _, p := range parts {
+		if p.kind() != "text" {
+			continue
+		}
+		text := string(p.data)
+		if p.charset != "" && !strings.EqualFold(p.charset, "utf-8") {
+			if r, err := latin1Reader(p.charset, bytes.NewReader(p.data)); err == nil {
+				data, _ := io.ReadAll(r)
+				text = string(data)
+			}
+		}
+		switch p.mediaType {
+		case "text/plain":
+			return strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+		case "text/html":
+			if html == "" {
+				html = plainText(text)
+			}
+		}
+	}
+	return html
+}
+
+// imapConn is a minimal IMAP4rev1 client, for the few commands needed to
+// find, fetch and flag messages
+type imapConn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	tag         int
+	stop        func() bool
+	uidValidity string // of the selected mailbox
+}
+
+// imapLine is an untagged reply line with the literals ({n} and n bytes)
+// that were in it. Literals over fetchMaxBytes are skipped and come as nil.
+type imapLine struct {
+	text     string
+	literals [][]byte
+}
+
+func dialIMAP(ctx context.Context, o mailOptions) (*imapConn, error) {
+	d := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", o.server)
+	if err != nil {
+		return nil, err
+	}
+	if o.tls {
+		host, _, _ := net.SplitHostPort(o.server)
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	// Closing the connection is what interrupts a read in progress
+	c.stop = context.AfterFunc(ctx, func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	greeting, err := c.readLine()
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("no greeting from %s: %v", o.server, err)
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") {
+		c.close()
+		return nil, fmt.Errorf("%s refused the connection: %s", o.server, greeting.text)
+	}
+	if _, err := c.command("LOGIN " + imapQuote(o.user) + " " + imapQuote(o.password)); err != nil {
+		c.close()
+		return nil, err
+	}
+	lines, err := c.command("SELECT " + imapQuote(o.mailbox))
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	for _, l := range lines {
+		if m := uidValidity.FindStringSubmatch(l.text); m != nil {
+			c.uidValidity = m[1]
+		}
+	}
+	return c, nil
+}
+
+var uidValidity = regexp.MustCompile(`\[UIDVALIDITY (\d+)\]`)
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// command sends a command and returns the untagged replies to it, failing
+// unless it completes with OK
+func (c *imapConn) command(cmd string) ([]imapLine, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+	name, _, _ := strings.Cut(cmd, " ")
+	if name == "UID" {
+		name = strings.Fields(cmd)[1]
+	}
+	var lines []imapLine
+	for {
+		l, err := c.readLine()
+		if err != nil {
+			return nil, fmt.Errorf("imap %s: %v", name, err)
+		}
+		status, ok := strings.CutPrefix(l.text, tag+" ")
+		if !ok {
+			lines = append(lines, l)
+			continue
+		}
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("imap %s failed: %s", name, status)
+		}
+		return lines, nil
+	}
+}
+
+var imapLiteral = regexp.MustCompile(`\{(\d+)\}$`)
+
+// readLine reads a reply line, with the literals in it
+func (c *imapConn) readLine() (imapLine, error) {
+	var l imapLine
+	for {
+		s, err := c.r.ReadString('\n')
+		if err != nil {
+			return l, err
+		}
+		s = strings.TrimRight(s, "\r\n")
+		l.text += s
+		m := imapLiteral.FindStringSubmatch(s)
+		if m == nil {
+			return l, nil
+		}
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return l, err
+		}
+		if n > fetchMaxBytes {
+			if _, err := io.CopyN(io.Discard, c.r, n); err != nil {
+				return l, err
+			}
+			l.literals = append(l.literals, nil)
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return l, err
+		}
+		l.literals = append(l.literals, data)
+	}
+}
+
+func (c *imapConn) close() {
+	c.stop()
+	c.conn.Close()
+}
@@ -0,0 +1,81 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMailAllowed(t *testing.T) {
+	allow := []string{"me@example.com", "@family.org"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"me@example.com", true},
+		{"Me@Example.com", true},
+		{"you@example.com", false},
+		{"aunt@family.org", true},
+		{"aunt@notfamily.org", false},
+		{"family.org@evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := mailAllowed(allow, tt.addr); got != tt.want {
+			t.Errorf("mailAllowed(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// headerWith parses a message whose headers are the given
+// Authentication-Results, topmost first
+func headerWith(t *testing.T, results ...string) mail.Header {
+	var raw strings.Builder
+	for _, r := range results {
+		raw.WriteString("Authentication-Results: " + r + "\r\n")
+	}
+	raw.WriteString("From: me@example.com\r\n\r\nbody")
+	msg, err := mail.ReadMessage(strings.NewReader(raw.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Header
+}
+
+func TestMailAuthenticated(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []string
+		domain     string
+		authservID string
+		want       bool
+	}{
+		{"dkim pass", []string{"mx.example.net; dkim=pass header.d=example.com"}, "example.com", "", true},
+		{"spf pass", []string{"mx.example.net; spf=pass smtp.mailfrom=me@example.com"}, "example.com", "", true},
+		{"dmarc pass", []string{"mx.example.net; dmarc=pass header.from=example.com"}, "example.com", "", true},
+		{"dkim fail", []string{"mx.example.net; dkim=fail header.d=example.com"}, "example.com", "", false},
+		{"other domain", []string{"mx.example.net; dkim=pass header.d=evil.com"}, "example.com", "", false},
+		{"parent domain signs", []string{"mx.example.net; dkim=pass header.d=example.com"}, "mail.example.com", "", true},
+		{"subdomain doesn't vouch for parent", []string{"mx.example.net; dkim=pass header.d=mail.example.com"}, "example.com", "", false},
+		{"pass only in a comment", []string{"mx.example.net; dkim=fail (dkim=pass header.d=example.com) header.d=example.com"}, "example.com", "", false},
+		{"several results", []string{"mx.example.net; spf=fail smtp.mailfrom=example.com; dkim=pass header.d=example.com"}, "example.com", "", true},
+		{"forged below the topmost", []string{"mx.example.net; dkim=none", "mx.example.net; dkim=pass header.d=example.com"}, "example.com", "", false},
+		{"trusted server below", []string{"relay.example.org; dkim=none", "mx.example.net; dkim=pass header.d=example.com"}, "example.com", "mx.example.net", true},
+		{"untrusted server", []string{"evil.example; dkim=pass header.d=example.com"}, "example.com", "mx.example.net", false},
+		{"none", nil, "example.com", "", false},
+	}
+	for _, tt := range tests {
+		if got := mailAuthenticated(headerWith(t, tt.results...), tt.domain, tt.authservID); got != tt.want {
+			t.Errorf("%s: mailAuthenticated = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
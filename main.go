@@ -11,7 +11,9 @@ You should have received a copy of the GNU General Public License along with Foo
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
@@ -21,54 +23,204 @@ import (
 	_ "image/png"
 	"io"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
+	"bleh/drivers"
+	"bleh/imageproc"
+
 	"github.com/disintegration/imaging"
 	ble "github.com/go-ble/ble"
-	"github.com/go-ble/ble/linux"
-	dither "github.com/makeworld-the-better-one/dither"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
-const minLines = 86 // firmware refuses to print anything shorter
+const defaultMinLines = 86 // firmware refuses to print anything shorter
 
 var (
-	mainServiceUUID      = ble.MustParse("ae30")
-	printCharacteristic  = ble.MustParse("ae01")
-	notifyCharacteristic = ble.MustParse("ae02")
-	dataCharacteristic   = ble.MustParse("ae03")
-	targetPrinterName    = "MXW01"
-	scanTimeout          = 10 * time.Second
-	printCommandHeader   = []byte{0x22, 0x21}
-	printCommandFooter   = byte(0xFF)
-	intensity            int
-	mode                 string
-	ditherType           string
-	getStatus            bool
-	getBattery           bool
-	getVersion           bool
-	getPrintType         bool
-	getQueryCount        bool
-	ejectPaper           uint
-	retractPaper         uint
-	outputPath           string
-	address              string
-	version              = "dev"
+	scanTimeout     = 10 * time.Second
+	intensity       int
+	mode            string
+	ditherType      string
+	serpentine      bool
+	halftoneLPI     float64
+	halftoneAngle   float64
+	thresholdValue  string
+	linearLight     bool
+	grayLevels      int
+	paletteLevels   string
+	inputGamma      float64
+	denoiseFilter   string
+	edgesOnly       bool
+	doubleStrike    bool
+	descreenSpec    string
+	sharpenAmount   float64
+	equalizeHist    bool
+	claheEnabled    bool
+	curvePath       string
+	background      string
+	deskewImage     bool
+	trim            bool
+	trimLevel       int
+	marginTop       string
+	marginBottom    string
+	marginLeft      string
+	marginRight     string
+	widthMM         float64
+	heightMM        float64
+	padPosition     string
+	minLines        int
+	feedLines       int
+	headerText      string
+	footerText      string
+	stampDateOn     bool
+	frameStyle      string
+	stampCorner     string
+	captionText     string
+	captionFont     string
+	captionSize     int
+	concat          bool
+	separator       string
+	nUp             int
+	raw1bpp         bool
+	raw4bpp         bool
+	rawInput        bool
+	outputRaw       string
+	thresholdWindow int
+	getStatus       bool
+	getBattery      bool
+	getVersion      bool
+	getPrintType    bool
+	getQueryCount   bool
+	ejectPaper      uint
+	retractPaper    uint
+	outputPath      string
+	outputFormat    string
+	noHistory       bool
+	archiveDir      string
+	noVerify        bool
+	traceProtocol   bool
+	passiveScan     bool
+	scanDuplicates  bool
+	noWait          bool
+	lockTimeout     time.Duration
+	presetName      string
+	savePresetName  string
+	pipelinePath    string
+	scriptPath      string
+	scriptMaxSteps  uint64
+	filterNames     string
+	stdinFormat     string
+	escposPath      string
+	jsonOutput      bool
+	minBattery      int
+	timeout         time.Duration
+	chunkSize       int
+	stayConnected   bool
+	modelName       string
+	speed           int
+	quality         string
+	force           bool
+	chunkDelay      time.Duration
+	writeResponse   bool
+	previewTerm     string
+	previewStyle    string
+	previewGrid     string
+	gridDithers     string
+	address         string
+	version         = "dev"
 )
 
 func init() {
 	flag.IntVar(&intensity, "intensity", 80, "Print intensity (0-100)")
 	flag.IntVar(&intensity, "i", 80, "Print intensity (0-100)")
 
-	flag.StringVar(&mode, "mode", "1bpp", "Print mode: 1bpp or 4bpp")
-	flag.StringVar(&mode, "m", "1bpp", "Print mode: 1bpp or 4bpp")
-
-	flag.StringVar(&ditherType, "dither", "none", "Dither method: none, floyd, bayer2x2, bayer4x4, bayer8x8, bayer16x16, atkinson, jjn")
-	flag.StringVar(&ditherType, "d", "none", "Dither method: none, floyd, bayer2x2, bayer4x4, bayer8x8, bayer16x16, atkinson, jjn")
+	flag.StringVar(&quality, "quality", "", "Preset for mode, dither, speed and intensity: draft, normal or photo")
+	flag.StringVar(&presetName, "preset", "", "Use the processing options saved under this name with --save-preset")
+	flag.StringVar(&savePresetName, "save-preset", "", "Save the processing options in effect under this name")
+	flag.IntVar(&speed, "speed", 0, "Print speed from 1 (slow, darker) to 5 (fast, lighter), 0 for the printer's default")
+
+	flag.StringVar(&mode, "mode", "1bpp", "Print mode: 1bpp, 4bpp or auto")
+	flag.StringVar(&mode, "m", "1bpp", "Print mode: 1bpp, 4bpp or auto")
+
+	flag.StringVar(&ditherType, "dither", "none", "Dither method (see -h for the full list)")
+	flag.StringVar(&ditherType, "d", "none", "Dither method (see -h for the full list)")
+
+	flag.BoolVar(&serpentine, "serpentine", false, "Use serpentine traversal for error-diffusion dithers")
+
+	flag.Float64Var(&halftoneLPI, "lpi", 45, "Halftone screen frequency in lines per inch")
+	flag.Float64Var(&halftoneAngle, "angle", 45, "Halftone screen angle in degrees")
+
+	flag.StringVar(&thresholdValue, "threshold", "128", "1bpp threshold: 0-255, auto, sauvola or bradley")
+	flag.StringVar(&thresholdValue, "t", "128", "1bpp threshold: 0-255, auto, sauvola or bradley")
+	flag.IntVar(&thresholdWindow, "threshold-window", 0, "Neighbourhood size in pixels for adaptive thresholds (0 = automatic)")
+
+	flag.BoolVar(&linearLight, "linear", false, "Convert to grayscale and quantize in linear light")
+	flag.Float64Var(&inputGamma, "input-gamma", 0, "Input gamma for --linear (0 = sRGB curve)")
+	flag.IntVar(&grayLevels, "levels", 16, "Gray levels for 4bpp: 2, 4, 8 or 16")
+	flag.StringVar(&paletteLevels, "palette", "", "4bpp gray levels to use, 0-15 separated by commas (overrides --levels)")
+
+	flag.StringVar(&descreenSpec, "descreen", "", "Blur away the halftone dots of scanned prints: auto or their spacing in pixels")
+	flag.StringVar(&denoiseFilter, "denoise", "", "Denoise before dithering: median or bilateral")
+	flag.BoolVar(&edgesOnly, "edges", false, "Print the outlines of the image as line art instead of its shades")
+	flag.Float64Var(&sharpenAmount, "sharpen", 0, "Unsharp mask amount applied after scaling, e.g. 0.5 to 2 (0 = off)")
+	flag.BoolVar(&equalizeHist, "equalize", false, "Equalize the histogram to use the whole gray range")
+	flag.BoolVar(&claheEnabled, "clahe", false, "Adaptive histogram equalization (CLAHE) for local contrast")
+
+	flag.BoolVar(&doubleStrike, "double-strike", false, "Print every line twice at half the vertical resolution, for darker blacks")
+
+	flag.StringVar(&curvePath, "curve", "", "Tone curve file, or 'none' (default: saved curve for this printer)")
+
+	flag.StringVar(&background, "background", "white", "Background for transparent images: white, black or #rrggbb")
+
+	flag.StringVar(&pipelinePath, "pipeline", "", "YAML file listing transforms to run on the image first (rotate, crop, contrast, dither...)")
+	flag.StringVar(&filterNames, "filter", "", "Comma-separated filters to run on the image after --pipeline, e.g. invert,sharpen")
+	flag.StringVar(&scriptPath, "script", "", "Starlark file with hooks run on every image and job (on_image, on_before_print, on_complete)")
+	flag.Uint64Var(&scriptMaxSteps, "script-max-steps", 100_000_000, "Steps a single --script hook call may take before it is stopped, 0 for no limit")
+	flag.BoolVar(&deskewImage, "deskew", false, "Straighten photographed documents so their text is level")
+	flag.BoolVar(&trim, "trim", false, "Crop white borders before scaling")
+	flag.IntVar(&trimLevel, "trim-level", 240, "Gray level (0-255) from which pixels count as white for --trim")
+
+	flag.StringVar(&marginTop, "margin-top", "0", "Blank space above the image, in dots or mm (e.g. 3mm)")
+	flag.StringVar(&marginBottom, "margin-bottom", "0", "Blank space below the image, in dots or mm")
+	flag.StringVar(&marginLeft, "margin-left", "0", "Blank space left of the image, in dots or mm")
+	flag.StringVar(&marginRight, "margin-right", "0", "Blank space right of the image, in dots or mm")
+	flag.Float64Var(&widthMM, "width-mm", 0, "Print the image this many millimeters wide")
+	flag.Float64Var(&heightMM, "height-mm", 0, "Print the image this many millimeters tall")
+	flag.Float64Var(&dpi, "dpi", defaultDPI, "Print head resolution used to convert millimeters to dots")
+	flag.StringVar(&padPosition, "pad", "bottom", "Where short images are padded to the minimum length: top, bottom or center")
+	flag.IntVar(&minLines, "min-lines", defaultMinLines, "Pad images shorter than this many lines")
+	flag.IntVar(&feedLines, "feed", 0, "Blank lines to add after the image so it clears the tear bar")
+
+	flag.StringVar(&headerText, "header", "", "Text printed above the image ({file}, {date}, {time}, {datetime}, {page}, {pages})")
+	flag.StringVar(&footerText, "footer", "", "Text printed below the image (same placeholders as --header)")
+	flag.BoolVar(&stampDateOn, "stamp-date", false, "Write when the photo was taken (from its EXIF data) or else the current date and time in a corner")
+	flag.StringVar(&stampCorner, "stamp-corner", "bottom-right", "Corner for --stamp-date: "+strings.Join(dateCorners, ", "))
+	flag.StringVar(&frameStyle, "frame", "none", "Border around the image: none, line, double, dashed or scalloped")
+	flag.StringVar(&captionText, "caption", "", "A line of text centered beneath the image (same placeholders as --header)")
+	flag.StringVar(&captionFont, "caption-font", "regular", "Typeface of the caption: regular, bold, mono, bitmap or a TrueType/OpenType file")
+	flag.IntVar(&captionSize, "caption-size", 32, "Height of the caption line in dots")
+
+	flag.BoolVar(&concat, "concat", false, "Print all given images as one continuous job")
+	flag.StringVar(&stdinFormat, "stdin-format", "image", "What \"-\" reads: image, or a stream of them as tar or frames (each preceded by its length)")
+	flag.StringVar(&escposPath, "escpos", "", "Print an ESC/POS byte stream from this file (\"-\" for stdin), as a receipt printer would")
+	flag.StringVar(&separator, "separator", "none", "Separator between --concat images: none, space, line or dashed")
+	flag.IntVar(&nUp, "up", 1, "Images per row (1, 2 or 4) for thumbnails and small labels")
+
+	flag.BoolVar(&raw1bpp, "raw-1bpp", false, "Input is an already packed 1bpp buffer, printed as is")
+	flag.BoolVar(&raw4bpp, "raw-4bpp", false, "Input is an already packed 4bpp buffer, printed as is")
+	flag.BoolVar(&rawInput, "raw", false, "Input is a buffer saved with --output-raw, printed as is")
 
 	flag.BoolVar(&getStatus, "status", false, "Query printer status")
 	flag.BoolVar(&getStatus, "s", false, "Query printer status")
+	flag.BoolVar(&jsonOutput, "json", false, "Print status and paper usage as JSON (--status, status and paper commands)")
 
 	flag.BoolVar(&getBattery, "battery", false, "Query battery level")
 	flag.BoolVar(&getBattery, "b", false, "Query battery level")
@@ -79,8 +231,8 @@ func init() {
 	flag.BoolVar(&getPrintType, "printtype", false, "Query print type")
 	flag.BoolVar(&getPrintType, "p", false, "Query print type")
 
-	flag.BoolVar(&getQueryCount, "querycount", false, "Query internal counter")
-	flag.BoolVar(&getQueryCount, "q", false, "Query internal counter")
+	flag.BoolVar(&getQueryCount, "querycount", false, "Query the lines and jobs printed")
+	flag.BoolVar(&getQueryCount, "q", false, "Query the lines and jobs printed")
 
 	flag.UintVar(&ejectPaper, "eject", 0, "Eject paper by N lines")
 	flag.UintVar(&ejectPaper, "E", 0, "Eject paper by N lines")
@@ -90,140 +242,319 @@ func init() {
 
 	flag.StringVar(&outputPath, "o", "", "Output PNG preview instead of printing (specify output path)")
 	flag.StringVar(&outputPath, "output", "", "Output PNG preview instead of printing (specify output path)")
+	flag.StringVar(&outputRaw, "output-raw", "", "Save the packed printer buffer with a small header instead of printing")
+	flag.StringVar(&previewTerm, "preview-term", "", "Show the result in the terminal instead of printing: auto, kitty, sixel or blocks")
+	flag.StringVar(&previewStyle, "preview-style", "plain", "Preview look: plain, or paper to simulate thermal paper")
+	flag.StringVar(&previewGrid, "preview-grid", "", "Save a PNG comparing 1bpp and 4bpp with several dither methods instead of printing")
+	flag.StringVar(&gridDithers, "grid-dithers", "none,floyd,atkinson,bayer8x8,bluenoise,halftone", "Comma-separated dither methods for --preview-grid")
+	flag.StringVar(&outputFormat, "output-format", "", "Preview format: png, pbm, pgm or pnm (default: from the file extension)")
+
+	flag.IntVar(&minBattery, "min-battery", 0, "Refuse to print below this battery level (percent)")
+
+	flag.DurationVar(&timeout, "timeout", 0, "Give up if the whole run takes longer than this (e.g. 2m)")
+	flag.BoolVar(&noWait, "no-wait", false, "Fail instead of waiting when another bleh run is using the printer")
+	flag.DurationVar(&lockTimeout, "lock-timeout", 5*time.Minute, "Give up waiting for another bleh run using the printer after this long (0 for no limit)")
+	flag.BoolVar(&passiveScan, "passive-scan", false, "Only listen for advertisements, without asking printers for scan responses")
+	flag.BoolVar(&scanDuplicates, "scan-duplicates", false, "Report every advertisement while scanning, not only the first from each device")
+
+	flag.IntVar(&chunkSize, "chunk-size", 20, "Bytes per BLE write when sending image data")
+	flag.DurationVar(&chunkDelay, "chunk-delay", 6*time.Millisecond, "Pause after every BLE write of image data")
+	flag.BoolVar(&writeResponse, "write-response", false, "Wait for the printer to acknowledge every write of image data")
+
+	flag.BoolVar(&force, "force", false, "Send jobs longer than the printer is known to take as they are")
+	flag.BoolVar(&stayConnected, "stay-connected", false, "Keep the printer connection open between jobs (gui and tray)")
+
+	flag.BoolVar(&noHistory, "no-history", false, "Don't record this print in the job history")
+	flag.StringVar(&archiveDir, "archive-dir", "", "Save a PNG of every successful print, with the job details, in this directory")
+	flag.BoolVar(&traceProtocol, "trace-protocol", false, "Log every command sent to the printer and every notification from it, in hex")
+	flag.BoolVar(&noVerify, "no-verify", false, "Don't ask for the printer status after a print to check it went through")
+
+	flag.StringVar(&modelName, "model", "auto", "Printer model, or auto to detect it from the advertised name")
 
 	flag.StringVar(&address, "a", "", "Connect to printer by MAC address")
 	flag.StringVar(&address, "address", "", "Connect to printer by MAC address")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Bleh! Cat Printer Utility for MXW01, version %s\n", version)
+		fmt.Fprintf(os.Stderr, "Bleh! Thermal Printer Utility, version %s\n", version)
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] <image_path or ->\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] <command> [command options]\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, `
 Options:
   -h, --help               Show this help message
   -a, --address <mac>      Connect to printer by MAC address
+      --model <name>       Printer model: MXW01, GB01, GB02, GB03, GT01, MX05, MX06, MX08,
+                           MX10, M02, T02 or PeriPage (default "auto", detected from the
+                           advertised name)
   -i, --intensity int      Print intensity (0-100) (default 80)
-  -m, --mode string        Print mode: 1bpp or 4bpp (default "1bpp")
-  -d, --dither string      Dither method: none, floyd, bayer2x2, bayer4x4, bayer8x8, bayer16x16, atkinson, jjn (default "none")
-  -s, --status             Query printer status
+      --quality <preset>   Set mode, dither, speed and intensity at once: draft (1bpp, no
+                           dither, fast, light), normal (1bpp, floyd) or photo (4bpp,
+                           floyd, slow, dark). Options given on their own win
+      --preset <name>      Use processing options saved with --save-preset; options given
+                           on their own win
+      --save-preset <name> Save the processing options in effect (mode, dither, intensity,
+                           threshold, curve, margins, size...) under this name
+      --speed int          Print speed from 1 (slowest, darkest) to 5 (fastest, lightest);
+                           slower gives the head more time to heat every line (default 0,
+                           the printer's own). Only for models with several known speeds
+  -m, --mode string        Print mode: 1bpp, 4bpp or auto, which picks 4bpp for photos
+                           and 1bpp for text and line art (default "1bpp")
+  -d, --dither string      Dither method (default "none", "auto" with --mode auto):
+                             auto: none for line art, floyd or atkinson for photos
+                             error diffusion: floyd, atkinson, atkinson2, jjn, stucki,
+                                              burkes, sierra, sierra2, sierralite
+                             ordered: bayer2x2, bayer4x4, bayer8x8, bayer16x16,
+                                      bluenoise (64x64), bluenoise32, bluenoise16
+                             clustered dot: halftone (see --lpi and --angle)
+      --serpentine         Alternate scan direction on each row for error-diffusion dithers
+      --lpi float          Halftone screen frequency in lines per inch (default 45)
+      --angle float        Halftone screen angle in degrees (default 45)
+  -t, --threshold <mode>   1bpp black/white cutoff: 0-255, "auto" (Otsu's method),
+                           or "sauvola"/"bradley" for adaptive local thresholding.
+                           Only used without dithering (default 128)
+      --threshold-window int
+                           Window size in pixels for sauvola/bradley (default: automatic)
+      --linear             Convert to grayscale and quantize in linear light, so printed
+                           dot coverage follows the real luminance of midtones
+      --input-gamma float  Input transfer curve for --linear: 0 for sRGB (default),
+                           or a power-law gamma such as 2.2 or 1.0 for linear data
+      --levels int         Gray levels for 4bpp: 2, 4, 8 or 16 (default 16). Fewer levels
+                           with a dither often look better than 16 the printer can't
+                           tell apart
+      --palette <levels>   4bpp gray levels to use, spaced as you like: 0 (white) to 15
+                           (black) separated by commas, e.g. 0,4,9,15 (overrides --levels)
+      --descreen <spacing> Blur away the halftone dots of a scanned newspaper or comic
+                           before scaling, so they don't turn into moiré: "auto" to
+                           find them, or their spacing in pixels of the scan
+      --denoise <filter>   Smooth out camera noise before dithering, which would turn it
+                           into speckle: "median" (3x3, for specks) or "bilateral"
+                           (for grain, keeps edges)
+      --edges              Print the outlines found in the image (Canny edge detection)
+                           as black line art, often more legible than dithered photos
+      --sharpen float      Sharpen after scaling to the print width with an unsharp mask
+                           of this amount, e.g. 0.5 to 2, to crisp up text and line art
+                           the downscale softened (default 0, off)
+      --equalize           Spread the grays of the image over the whole range (histogram
+                           equalization), for washed out or dark photos
+      --clahe              Like --equalize but per region and contrast limited (CLAHE),
+                           bringing out detail in low-contrast photos
+      --double-strike      Print every line twice, at half the vertical resolution, so
+                           the head heats each dot twice and blacks come out darker on
+                           paper that prints faint
+      --curve <file|none>  Tone curve to apply before quantization (default: the curve
+                           saved by "bleh calibrate" for this printer and mode, if any)
+      --background <color> Color transparent areas are composited onto: white, black
+                           or #rrggbb (default white)
+      --pipeline <file>    Run the image through the steps listed in a YAML file first,
+                           e.g. rotate, crop, contrast, dither or invert (see README)
+      --filter <names>     Filters to run on the image, in order, separated by commas:
+                           any step of --pipeline with its defaults (invert, grayscale,
+                           equalize, sharpen...), or one registered through imageproc
+      --script <file>      Starlark file with hooks to change images and jobs, or skip
+                           or route them (see README)
+      --script-max-steps <n>
+                           Stop a script hook that takes more than n Starlark steps
+                           (default 100000000, 0 for no limit)
+      --deskew             Straighten photographed receipts and documents so the text
+                           comes out level (the corners turned in are white)
+      --trim               Crop uniform white or near-white borders before scaling
+      --trim-level int     Pixels at least this light (0-255) count as border (default 240)
+      --margin-top <len>   Blank space above the image, in dots or millimeters (e.g. 3mm)
+      --margin-bottom <len>
+                           Blank space below the image
+      --margin-left <len>  Blank space left of the image; the image is scaled to fit
+      --margin-right <len> Blank space right of the image; the image is scaled to fit
+      --width-mm float     Print the image this many millimeters wide, centered
+      --height-mm float    Print the image this many millimeters tall; with --width-mm,
+                           it is fitted in and centered on a label of that size
+      --dpi float          Print head resolution used for millimeters (default 203)
+      --pad <where>        Where short images get padded up to the printer's minimum
+                           length: top, bottom or center (default bottom)
+      --min-lines int      Minimum print length in lines; shorter images are padded
+                           (default 86, the shortest job the firmware accepts)
+      --feed int           Blank lines to feed after the image so it clears the tear bar
+      --header <text>      Text printed above the image. Placeholders: {file}, {date},
+                           {time}, {datetime}, {page}, {pages}; "\n" starts a new line
+      --footer <text>      Text printed below the image, same placeholders as --header
+      --stamp-date         Write when the photo was taken, from its EXIF data, or else the
+                           current date and time in small text in a corner of the image
+      --stamp-corner <c>   bottom-right (default), bottom-left, top-right or top-left
+      --frame <style>      Border around the image and its caption, within the print width:
+                           none (default), line, double, dashed or scalloped
+      --caption <text>     A line of text centered beneath the image, same placeholders as
+                           --header; set smaller when it doesn't fit
+      --caption-font <f>   regular (default), bold, mono, bitmap or a TrueType/OpenType file
+      --caption-size int   Height of the caption line in dots (default 32, 4 mm)
+      --concat             Stack all given images and print them as a single job
+      --separator <style>  Between --concat images: none, space, line or dashed (default none)
+      --stdin-format <fmt> What "-" reads: a single image (default), or a stream of them,
+                           printed as they arrive: tar, or frames (each image preceded by
+                           its length as a 4-byte big-endian number)
+      --escpos <file>      Print an ESC/POS byte stream ("-" for stdin) the way a receipt
+                           printer would: text, alignment, bold, sizes and bitmaps
+      --up int             Place 2 or 4 images side by side per row, all in one job (default 1)
+      --raw-1bpp           Input is an already packed buffer (48 bytes per line, LSB first)
+      --raw-4bpp           Input is an already packed buffer (192 bytes per line, high nibble
+                           first); the height follows from the size, no processing is done
+      --raw                Input is a buffer saved with --output-raw; mode and height come
+                           from its header
+      --min-battery int    Refuse to print when the battery is below this level (percent).
+                           bleh warns below 20% anyway, as low voltage fades prints
+      --timeout <duration> Give up when the whole run (scan, connect, transfer and waiting
+                           for the print to finish) takes longer than this, e.g. 90s or 2m
+      --no-wait            Fail right away when another bleh run is using the printer,
+                           instead of waiting for it to finish
+      --lock-timeout <duration>
+                           Give up waiting for another bleh run using the printer after
+                           this long (default 5m, 0 for no limit)
+      --passive-scan       Only listen while scanning, without asking for scan responses;
+                           printers are then mostly recognized by their service
+      --scan-duplicates    Look at every advertisement, not only the first of each device,
+                           for printers that only send their name now and then
+      --chunk-size int     Bytes per BLE write of image data (default 20, at most the
+                           negotiated MTU minus 3). See "bleh bench" for tuning
+      --chunk-delay <duration>
+                           Pause after every write of image data (default 6ms)
+      --write-response     Wait for an acknowledgement of every write: slower, but the
+                           adapter can't drop data when the printer falls behind
+      --force              Send jobs longer than the printer is known to take as they are,
+                           instead of refusing them
+      --stay-connected     Keep the connection open between jobs, asking for the status
+                           every 20s to keep it alive, so jobs don't wait for a new scan
+                           and connect (useful with gui and tray)
+      --no-history         Don't record this print in the job history
+      --archive-dir <dir>  Save every successful print in dir as a PNG of exactly what was
+                           sent, with the time, source, printer and settings in it
+      --trace-protocol     Log every command to and notification from the printer in hex
+      --no-verify          Don't ask for the status after a print, which fails the run
+                           when the paper ran out or the head overheated during it
+  -s, --status             Query printer status and paper usage
+      --json               Print status and paper usage, or the counter, as JSON (with
+                           --status or --querycount and the status and paper commands)
   -b, --battery            Query battery level
   -v, --version            Query printer version
   -p, --printtype          Query print type
-  -q, --querycount         Query internal counter
+  -q, --querycount         Query the lines and jobs printed
   -E, --eject uint         Eject paper by N lines
   -R, --retract uint       Retract paper by N lines
   -o, --output <file>      Output PNG preview instead of printing.
                            If <file> is "-", writes PNG to stdout.
-  <image_path or ->        Path to PNG/JPG to print, or '-' for stdin`)
+      --output-raw <file>  Save the packed printer buffer, with a header giving mode and
+                           height, instead of printing ("-" for stdout)
+      --preview-term <p>   Show the result in the terminal instead of printing, using the
+                           kitty graphics protocol, sixel, or half "blocks" (or "auto")
+      --preview-style <s>  "plain" (default) or "paper": cream paper, slightly spread dots
+                           and the roll edges, like real prints (PNG and terminal previews)
+      --preview-grid <file>
+                           Save a labeled PNG comparing 1bpp and 4bpp with the dither
+                           methods from --grid-dithers instead of printing
+      --grid-dithers <list>
+                           Comma-separated methods for --preview-grid
+                           (default "none,floyd,atkinson,bayer8x8,bluenoise,halftone")
+      --output-format <fmt>
+                           Preview format: png, pbm, pgm or pnm (PBM for 1bpp, PGM for
+                           4bpp). Default: from the file extension, otherwise png
+  <image_path or ->        Image to print (PNG, JPEG, GIF, WebP, BMP, TIFF or PBM/PGM/PPM),
+                           as a path, an http(s) URL or '-' for stdin. Several images
+                           are printed one job each, unless --concat or --up is given.
+                           A .zip or .tar stands for the images in it, in name order
+
+Commands (use "<command> -h" for their options):
+  calibrate                Print a step wedge and build a tone curve from its measurements
+  testpage                 Print test patterns: --intensity-sweep or --head
+  compare <image>          Print an image once per dither method, labeled, to compare them
+  clipboard                Print the image or text on the clipboard (needs wl-paste or xclip)
+  receipt <template>       Fill a receipt or label template in with --data (JSON or
+                           YAML) and print it
+  labels                   Print a label per row of a --data CSV file with a --template,
+                           --start and --end pick the rows
+  agenda <calendar>        Print today's events (--week, --days N for more) from an
+                           .ics file or URL, or a CalDAV calendar
+  banner <text>            Print text in letters as tall as the paper is wide, running
+                           down its length (--font, --fill)
+  ruler                    Print a --length 30cm ruler in centimeters and inches, exact to
+                           --dpi
+  template <pattern>       Print notebook paper: grid, dots, lines or music-staff, for
+                           --length 20cm
+  puzzle maze|sudoku       Print a random puzzle (--difficulty easy|medium|hard,
+                           --solution)
+  chart <data.csv>         Print a line or bar chart (--type) of the columns of a CSV
+                           file, or a --sparkline
+  wifi --ssid X --pass Y   Print a QR code that joins a Wi-Fi network, with its name and
+                           password under it
+  contact [file.vcf]       Print a contact QR code from --name, --phone, --email... or a
+                           .vcf file, with the name under it
+  todo <file>              Print a todo.txt or Markdown task list as a checklist
+  weather                  Print the current weather and a forecast for --lat and --lon
+                           from Open-Meteo, with icons (--days N)
+  feed <url>               Print the latest headlines of an RSS or Atom feed, with
+                           summaries and QR codes of the links (--items N)
+  hexdump <file>           Print an offset, hex and ASCII dump of a file; --skip and
+                           --length pick the part
+  bot telegram|matrix|discord
+                           Print the photos, stickers and text sent to a chat bot, from
+                           the chats in --allow, or posted in a Matrix room or Discord
+                           channel (--room)
+  mail --server S --user U --allow A
+                           Print the attachments of mail sent by --allow to a mailbox
+  relay [--listen :9100]   Print images, PDFs and text sent over TCP, one job per
+                           connection
+  history list|show|reprint <id>
+                           List, inspect or reprint past jobs
+  reprint                  Print the most recent job again, exactly as it was sent
+  status                   Show printer status; --watch keeps polling every --interval
+                           and reports changes (as JSON lines with --json)
+  bench                    Measure transfer throughput for several chunk sizes, delays
+                           and write modes, to tune --chunk-size and --chunk-delay
+  paper                    Show paper usage; --new-roll after changing the roll,
+                           --roll-length <m> to set the roll length
+  gui [image]              Open the desktop GUI (builds with -tags gui only)
+  tray                     Sit in the system tray with printer status, clipboard and
+                           file printing, and paper eject (builds with -tags gui only)`)
 	}
 }
 
-func parseNotification(data []byte) {
-	if len(data) < 2 || data[0] != 0x22 || data[1] != 0x21 {
-		fmt.Printf("Invalid notification header, raw: % X", data)
-		return
-	}
-
-	cmd := data[2]
-	dataLen := int(data[4]) | int(data[5])<<8
-
-	switch cmd {
-	case 0xA1: // GetStatus
-		battery := data[9]
-		temp := data[10]
-		statusOk := data[12] == 0
-		statusCode := data[6]
-		errCode := data[13]
-
-		statusMsg := "Unknown"
-		if statusOk {
-			switch statusCode {
-			case 0x0:
-				statusMsg = "Standby"
-			case 0x1:
-				statusMsg = "Printing"
-			case 0x2:
-				statusMsg = "Feeding paper"
-			case 0x3:
-				statusMsg = "Ejecting paper"
-			}
-		} else {
-			switch errCode {
-			case 0x1, 0x9:
-				statusMsg = "No paper"
-			case 0x4:
-				statusMsg = "Overheated"
-			case 0x8:
-				statusMsg = "Low battery"
-			}
-		}
-
-		fmt.Printf("Status: %v (%s), Battery: %d, Temp: %d\n", statusOk, statusMsg, battery, temp)
-
-	case 0xA3: // EjectPaper
-		fmt.Println("Ejecting paper...")
-
-	case 0xA4: // RetractPaper
-		fmt.Println("Retracting paper...")
-
-	case 0xA7: // QueryCount
-		if len(data) >= 12 {
-			fmt.Printf("Query count: % X\n", data[6:12])
-		}
+// dpi is the print head resolution, about 8 dots per mm. --dpi corrects it
+// for printers that come out a little off.
+var dpi float64 = defaultDPI
 
-	case 0xA9: // Print
-		printOk := data[6] == 0
-		fmt.Printf("Print status: %s\n", map[bool]string{true: "Ok", false: "Failure"}[printOk])
+const defaultDPI = 203
 
-	case 0xAA: // PrintComplete
-		fmt.Println("Printing finished.")
-
-	case 0xAB: // BatteryLevel
-		fmt.Printf("Battery level: %d\n", data[6])
-
-	case 0xB0: // GetPrintType
-		var t string
-		switch data[6] {
-		case 0x01:
-			t = `High pressure`
-		case 0xFF:
-			t = `"Unknown`
-		default:
-			t = `Low pressure`
-		}
-		fmt.Printf("Print type: %s\n", t)
+// The print width, in dots and in bytes of a 1bpp line. It is the head width
+// of the model picked with --model or found by detectPrintWidth, and 384
+// where the model is only detected once the images are processed.
+var (
+	linePixels   = defaultLinePixels
+	bytesPerLine = defaultLinePixels / 8
+)
 
-	case 0xB1: // GetVersion
-		if len(data) < 14+dataLen {
-			fmt.Println("Malformed version notification")
-			return
-		}
-		version := string(data[6 : 6+dataLen])
-		var t string
-		switch data[14] {
-		case 0x32:
-			t = `High pressure`
-		case 0x31:
-			t = `Low pressure`
-		default:
-			t = `Unknown`
-		}
-		fmt.Printf("Version: %s, Print type: %s\n", version, t)
+const defaultLinePixels = 384
 
-	default:
-		fmt.Printf("Received notification for unknown command: 0x%02X\n", cmd)
+// setPrintWidth sets the print width to the head width of the chosen model
+func setPrintWidth() error {
+	m, err := chosenModel()
+	if err != nil || m == nil {
+		return err
 	}
+	linePixels, bytesPerLine = m.Width, m.Width/8
+	return nil
 }
 
-const (
-	linePixels   = 384
-	bytesPerLine = linePixels / 8
-)
-
 // decodeImage loads an image from a given path or stdin ("-")
 func decodeImage(path string) (image.Image, error) {
+	img, err := openImage(path)
+	return img, withCause(errBadInput, err)
+}
+
+func openImage(path string) (image.Image, error) {
+	if m, ok := archiveMembers[path]; ok {
+		return m.open()
+	}
 	if path == "-" {
 		return decodeImageFromReader(os.Stdin)
 	}
+	if isURL(path) {
+		return fetchImage(path)
+	}
 	img, err := imaging.Open(path, imaging.AutoOrientation(true))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open image %q: %v", path, err)
@@ -231,111 +562,194 @@ func decodeImage(path string) (image.Image, error) {
 	return img, nil
 }
 
-// decodeImageFromReader reads and decodes an image from any io.Reader
+// maxImagePixels is the largest image decodeImageFromReader decodes. Images
+// may come from anyone over the network, and a small file can claim a size
+// that takes gigabytes to decode.
+const maxImagePixels = 50_000_000
+
+// decodeImageFromReader reads and decodes an image from any io.Reader,
+// checking its size from the header first
 func decodeImageFromReader(r io.Reader) (image.Image, error) {
-	img, _, err := image.Decode(r)
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("decode error: %v", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, withCause(errBadInput, fmt.Errorf("image is %dx%d, more than %d megapixels", cfg.Width, cfg.Height, maxImagePixels/1_000_000))
+	}
+	img, _, err := image.Decode(io.MultiReader(&header, r))
 	if err != nil {
 		return nil, fmt.Errorf("decode error: %v", err)
 	}
 	return img, nil
 }
 
-// loadImageMonoFromImage processes an image.Image to 1bpp packed byte format
-func loadImageMonoFromImage(img image.Image, ditherType string) ([]byte, int, error) {
-	ratio := float64(img.Bounds().Dx()) / float64(img.Bounds().Dy())
-	height := int(float64(linePixels) / ratio)
+// imageOptions bundles the settings that control how an image is turned into
+// printer pixels
+type imageOptions struct {
+	autoMode   bool // --mode auto, picked per image by resolveAutoMode
+	ditherType string
+	serpentine bool
+	lpi        float64 // halftone screen frequency
+	angle      float64 // halftone screen angle in degrees
+	threshold  thresholdSpec
+	descreen   float64 // halftone dot spacing in source pixels, autoScreen or 0 for none
+	denoise    string  // "median", "bilateral" or "" for none
+	edges      bool    // print the outlines of the image as line art
+	sharpen    float64 // unsharp mask amount, 0 for none
+	contrast   string  // "equalize", "clahe" or "" for none
+	double     bool    // print every line twice, processed at half height
+	tone       toneSpace
+	levels     []byte      // 4bpp gray levels used, nil for all 16
+	curve      *toneCurve  // printer calibration, nil for none
+	background color.Color // shows through transparent pixels
+	deskew     bool        // level the lines of text of photographed documents
+	trim       bool        // crop light borders before scaling
+	trimLevel  uint8       // pixels at least this light count as border
+	margins    margins
+	size       size   // physical size asked for, in dots
+	pad        string // where padding up to minLines goes: top, bottom or center
+	minLines   int
+	feed       int // blank lines after the image
+	header     string
+	footer     string
+	caption    captionStyle
+	dateCorner string              // where --stamp-date goes, "" for no date stamp
+	frame      string              // one of frameStyles
+	stamp      stampInfo           // values for the header and footer placeholders
+	separator  string              // drawn between --concat images
+	pipeline   *imageproc.Pipeline // --pipeline steps, run on the image first
+}
+
+// scaleToPrint resizes img to the print width and converts it to gray,
+// then denoises, sharpens, equalizes and calibrates it, ready to be dithered
+func scaleToPrint(img image.Image, opts imageOptions) (image.Image, int) {
+	// Rounded rather than truncated, so an image that's already linePixels
+	// wide isn't resampled a line shorter, which would blur and shift it
+	b := img.Bounds()
+	height := int(math.Round(float64(b.Dy()*linePixels) / float64(b.Dx())))
+	if opts.double {
+		height = (height + 1) / 2 // renderImage prints every line twice
+	}
 	img = imaging.Resize(img, linePixels, height, imaging.Lanczos)
-	img = imaging.Grayscale(img)
+	img = opts.tone.grayscale(img)
+	img = denoise(img, opts.denoise)
+	if opts.edges {
+		img = outline(img)
+	}
+	img = sharpen(img, opts.sharpen)
+	switch opts.contrast {
+	case "equalize":
+		img = equalize(img)
+	case "clahe":
+		img = clahe(img)
+	}
+	if opts.curve != nil {
+		img = opts.curve.apply(img)
+	}
+	return img, height
+}
 
-	if ditherType != "none" {
+// loadImageMonoFromImage processes an image.Image to 1bpp packed byte format
+func loadImageMonoFromImage(img image.Image, opts imageOptions) ([]byte, int, error) {
+	img, height := scaleToPrint(img, opts)
+
+	if opts.ditherType != "none" {
 		palette := []color.Color{color.Black, color.White}
-		d := dither.NewDitherer(palette)
-		switch ditherType {
-		case "floyd":
-			d.Matrix = dither.FloydSteinberg
-		case "bayer2x2":
-			d.Mapper = dither.Bayer(2, 2, 1.0)
-		case "bayer4x4":
-			d.Mapper = dither.Bayer(4, 4, 1.0)
-		case "bayer8x8":
-			d.Mapper = dither.Bayer(8, 8, 1.0)
-		case "bayer16x16":
-			d.Mapper = dither.Bayer(16, 16, 1.0)
-		case "atkinson":
-			d.Matrix = dither.Atkinson
-		case "jjn":
-			d.Matrix = dither.JarvisJudiceNinke
-		default:
-			return nil, 0, fmt.Errorf("unknown dither type: %s", ditherType)
+		d, err := newDitherer(palette, opts, 1.0)
+		if err != nil {
+			return nil, 0, err
 		}
 		img = d.DitherCopy(img)
 	} else {
 		img = imaging.AdjustContrast(img, 10)
+		img = opts.threshold.binarize(opts.tone.linearize(toGray(img)))
 	}
 
+	return packMono(img), height, nil
+}
+
+// packMono packs a black and white image, linePixels wide, into 1bpp lines
+func packMono(img image.Image) []byte {
+	bounds := img.Bounds()
+	height := bounds.Dy()
 	pixels := make([]byte, (linePixels*height)/8)
 	for y := 0; y < height; y++ {
 		for x := 0; x < linePixels; x++ {
-			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
 			if gray.Y < 128 {
 				idx := (y*linePixels + x) / 8
 				pixels[idx] |= 1 << (x % 8)
 			}
 		}
 	}
-
-	return pixels, height, nil
+	return pixels
 }
 
 // loadImage4BitFromImage processes an image.Image to 4bpp packed byte format
-func loadImage4BitFromImage(img image.Image, ditherType string) ([]byte, int, error) {
-	ratio := float64(img.Bounds().Dx()) / float64(img.Bounds().Dy())
-	height := int(float64(linePixels) / ratio)
-	img = imaging.Resize(img, linePixels, height, imaging.Lanczos)
-	img = imaging.Grayscale(img)
-
-	palette := make([]color.Color, 16)
-	for i := 0; i < 16; i++ {
-		v := uint8(i * 17)
-		palette[i] = color.Gray{Y: 255 - v}
-	}
-
-	if ditherType != "none" {
-		d := dither.NewDitherer(palette)
-		switch ditherType {
-		case "floyd":
-			d.Matrix = dither.FloydSteinberg
-		case "bayer2x2":
-			d.Mapper = dither.Bayer(2, 2, 0.2)
-		case "bayer4x4":
-			d.Mapper = dither.Bayer(4, 4, 0.2)
-		case "bayer8x8":
-			d.Mapper = dither.Bayer(8, 8, 0.2)
-		case "bayer16x16":
-			d.Mapper = dither.Bayer(16, 16, 0.2)
-		case "atkinson":
-			d.Matrix = dither.Atkinson
-		case "jjn":
-			d.Matrix = dither.JarvisJudiceNinke
-		default:
-			return nil, 0, fmt.Errorf("unknown dither type: %s", ditherType)
+func loadImage4BitFromImage(img image.Image, opts imageOptions) ([]byte, int, error) {
+	img, height := scaleToPrint(img, opts)
+
+	palette := opts.tone.palette4(opts.levels)
+
+	if opts.ditherType != "none" {
+		d, err := newDitherer(palette, opts, 0.2)
+		if err != nil {
+			return nil, 0, err
 		}
 		img = d.DitherCopy(img)
 	}
 
-	width := linePixels
+	return pack4Bit(img, opts.tone, opts.levels), height, nil
+}
+
+// pack4Bit packs a gray image, linePixels wide, into 4bpp lines, snapping
+// every pixel to the closest of levels (nil for all 16)
+func pack4Bit(img image.Image, tone toneSpace, levels []byte) []byte {
+	bounds := img.Bounds()
+	width, height := linePixels, bounds.Dy()
 	pixels := make([]byte, (width*height)/2)
 
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
-			level := (255 - gray.Y) >> 4 // 0..15, inverted logic
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			level := nearestLevel(tone.level4(gray.Y), levels) // 0..15, inverted logic
 			idx := (y*width + x) >> 1
 			shift := uint(((x & 1) ^ 1) << 2)
 			pixels[idx] |= level << shift
 		}
 	}
-	return pixels, height, nil
+	return pixels
+}
+
+// strikeTwice repeats every line of packed pixels. The head prints the
+// second one while still warm from the first, so it comes out darker than
+// two lines of different dots would.
+func strikeTwice(pixels []byte, height, lineBytes int) ([]byte, int) {
+	out := make([]byte, 0, 2*len(pixels))
+	for y := 0; y < height; y++ {
+		line := pixels[y*lineBytes : (y+1)*lineBytes]
+		out = append(append(out, line...), line...)
+	}
+	return out, 2 * height
+}
+
+// grayTo1bpp turns 4bpp pixels into 1bpp ones with an ordered dither, for
+// printers that can't print grays
+func grayTo1bpp(pixels []byte, height int) []byte {
+	bayer := [4][4]int{{0, 8, 2, 10}, {12, 4, 14, 6}, {3, 11, 1, 9}, {15, 7, 13, 5}}
+	mono := make([]byte, linePixels*height/8)
+	for y := 0; y < height; y++ {
+		for x := 0; x < linePixels; x++ {
+			i := y*linePixels + x
+			level := int(pixels[i>>1]>>uint(((x&1)^1)<<2)) & 0x0F
+			if level*16 > bayer[y&3][x&3]*15+7 {
+				mono[i/8] |= 1 << (x % 8)
+			}
+		}
+	}
+	return mono
 }
 
 // Extend sendImageToPrinter to handle 4-bit mode
@@ -346,74 +760,51 @@ const (
 	Mode4bpp PrintMode = 0x02
 )
 
-func sendImageBufferToPrinter(client ble.Client, dataChr, printChr *ble.Characteristic, pixels []byte, height int, mode PrintMode, intensity byte) error {
-	fmt.Printf("Sending image: %dx%d lines\n", linePixels, height)
-
-	cmd := buildCommand(0xA2, []byte{intensity})
-	if err := client.WriteCharacteristic(printChr, cmd, true); err != nil {
-		return fmt.Errorf("intensity set failed: %v", err)
-	}
-
-	param := []byte{
-		byte(height & 0xFF), byte(height >> 8),
-		0x30,
-		byte(mode),
-	}
-	cmd = buildCommand(0xA9, param)
-	if err := client.WriteCharacteristic(printChr, cmd, true); err != nil {
-		return fmt.Errorf("print command failed: %v", err)
-	}
-
-	bytesPerLine := linePixels / 8
-	if mode == Mode4bpp {
-		bytesPerLine = linePixels / 2
-	}
-
-	mtu := 20
-	for y := 0; y < height; y++ {
-		slice := pixels[y*bytesPerLine : (y+1)*bytesPerLine]
-		for offset := 0; offset < len(slice); offset += mtu {
-			end := offset + mtu
-			if end > len(slice) {
-				end = len(slice)
-			}
-			chunk := slice[offset:end]
-			if err := client.WriteCharacteristic(dataChr, chunk, true); err != nil {
-				return fmt.Errorf("line %d chunk write failed: %v", y, err)
-			}
-			time.Sleep(6 * time.Millisecond)
-		}
+func transferFromFlags() drivers.Transfer {
+	t := drivers.Transfer{ChunkSize: chunkSize, Delay: chunkDelay, Response: writeResponse}
+	if traceProtocol {
+		t.Trace = func(data []byte) { log.Printf("-> %s", traceHex(data)) }
 	}
+	return t
+}
 
-	cmd = buildCommand(0xAD, []byte{0x00})
-	if err := client.WriteCharacteristic(printChr, cmd, true); err != nil {
-		return fmt.Errorf("flush failed: %v", err)
+// traceHex formats data for --trace-protocol, cutting image data short
+func traceHex(data []byte) string {
+	if len(data) > 64 {
+		return fmt.Sprintf("% X ... (%d bytes)", data[:32], len(data))
 	}
-
-	return nil
+	return fmt.Sprintf("% X", data)
 }
 
-func padImageToMinLines(img image.Image, minLines int) image.Image {
+// padImageToMinLines adds white lines so the image is at least minLines tall.
+// pad says where they go: "bottom" (the default), "top" or "center".
+func padImageToMinLines(img image.Image, minLines int, pad string) image.Image {
 	bounds := img.Bounds()
 	if bounds.Dy() >= minLines {
 		return img
 	}
 	// Create a new white image
 	dst := imaging.New(bounds.Dx(), minLines, color.White)
-	// Paste the original image at the top
-	dst = imaging.Paste(dst, img, image.Pt(0, 0))
+	// Paste the original image according to where the padding should go
+	y := 0
+	switch pad {
+	case "top":
+		y = minLines - bounds.Dy()
+	case "center":
+		y = (minLines - bounds.Dy()) / 2
+	}
+	dst = imaging.Paste(dst, img, image.Pt(0, y))
 	return dst
 }
 
-func sendSimpleCommand(client ble.Client, printChr *ble.Characteristic, cmdId byte) error {
-	cmd := buildCommand(cmdId, []byte{0x00})
-	return client.WriteCharacteristic(printChr, cmd, true)
-}
-
-func sendLineCommand(client ble.Client, printChr *ble.Characteristic, cmdId byte, lines uint) error {
-	param := []byte{byte(lines & 0xFF), byte(lines >> 8)}
-	cmd := buildCommand(cmdId, param)
-	return client.WriteCharacteristic(printChr, cmd, true)
+// addFeed appends lines of white below the image
+func addFeed(img image.Image, lines int) image.Image {
+	if lines <= 0 {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := imaging.New(bounds.Dx(), bounds.Dy()+lines, color.White)
+	return imaging.Paste(dst, img, image.Pt(0, 0))
 }
 
 func renderPreviewFrom1bpp(pixels []byte, width, height int) image.Image {
@@ -446,10 +837,15 @@ func renderPreviewFrom4bpp(pixels []byte, width, height int) image.Image {
 	return img
 }
 
-func findPrinter(ctx context.Context) (ble.Advertisement, error) {
+// findPrinter scans for a printer of model want, or of any known model if
+// want is nil, recognized by its name or the service it advertises. With -a,
+// or a job sent to a given printer, the printer at that address is used
+// whatever it is.
+func findPrinter(ctx context.Context, want *drivers.Model) (ble.Advertisement, error) {
 	var addr ble.Addr
 	var adv ble.Advertisement
 
+	address := printerAddress(ctx)
 	if address != "" {
 		log.Printf("Connecting directly to MAC address: %s", address)
 		addr = ble.NewAddr(address)
@@ -458,57 +854,71 @@ func findPrinter(ctx context.Context) (ble.Advertisement, error) {
 
 	ctxScan, cancel := context.WithTimeout(ctx, scanTimeout)
 	log.Println("Scanning for printer...")
-	err := ble.Scan(ctxScan, false, func(a ble.Advertisement) {
-		if address != "" {
-			if a.Addr().String() == addr.String() { // Wonder why this works and not direct comparison
-				adv = a
-				cancel()
-			}
-		} else if a.LocalName() == targetPrinterName {
+	err := ble.Scan(ctxScan, scanDuplicates, func(a ble.Advertisement) {
+		if adv == nil {
 			adv = a
 			cancel()
 		}
-	}, nil)
+	}, func(a ble.Advertisement) bool {
+		if address != "" {
+			return a.Addr().String() == addr.String() // Wonder why this works and not direct comparison
+		}
+		return advertisesModel(a, want)
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil && err != context.Canceled {
-		return nil, fmt.Errorf("scan error, %v", err)
+		return nil, withCause(errPrinterNotFound, fmt.Errorf("scan error, %v", err))
 	}
 	if adv == nil {
-		return nil, fmt.Errorf("printer not found")
+		return nil, errPrinterNotFound
 	}
 	log.Println("Found target printer with address:", adv.Addr().String())
 	return adv, nil
 }
 
-func discoverChars(client ble.Client) (*ble.Characteristic, *ble.Characteristic, *ble.Characteristic, error) {
-	var printChr, notifyChr, dataChr *ble.Characteristic
-	services, err := client.DiscoverServices([]ble.UUID{mainServiceUUID})
-	if err != nil || len(services) == 0 {
-		return nil, nil, nil, fmt.Errorf("service discovery failed: %v", err)
+// advertisesModel tells whether a is from a printer of model want, or of
+// any known model if want is nil
+func advertisesModel(a ble.Advertisement, want *drivers.Model) bool {
+	services := append(a.Services(), a.OverflowService()...)
+	if m := drivers.DetectAdvertised(a.LocalName(), services); m != nil {
+		return want == nil || m == want
 	}
-	svc := services[0]
-	chars, err := client.DiscoverCharacteristics(nil, svc)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("characteristic discovery failed: %v", err)
+	if a.LocalName() != "" && want == nil {
+		return false // named, but nothing we know
 	}
-	for _, c := range chars {
-		switch c.UUID.String() {
-		case printCharacteristic.String():
-			printChr = c
-		case notifyCharacteristic.String():
-			notifyChr = c
-		case dataCharacteristic.String():
-			dataChr = c
+	for _, m := range drivers.DetectServices(services) {
+		if want == nil || m == want {
+			return true
 		}
 	}
-	return printChr, notifyChr, dataChr, nil
+	return false
 }
 
-func subToNotifs(client ble.Client, notifyChr *ble.Characteristic) error {
+// modelByService guesses the model of a printer that was found by the
+// service it advertises, preferring the MXW01 like for unknown names
+func modelByService(a ble.Advertisement) *drivers.Model {
+	found := drivers.DetectServices(append(a.Services(), a.OverflowService()...))
+	if len(found) == 0 {
+		return nil
+	}
+	model := found[0]
+	for _, m := range found {
+		if m == drivers.MXW01 {
+			model = m
+		}
+	}
+	log.Printf("Printer %q found by its %s service, assuming it is a %s (use --model to pick another)", a.LocalName(), model.Services[0], model.Name)
+	return model
+}
+
+// subToNotifs subscribes to printer notifications and hands each one to
+// onNotify
+func subToNotifs(client ble.Client, notifyChr *ble.Characteristic, onNotify func([]byte)) error {
 	if notifyChr != nil {
 		_, _ = client.DiscoverDescriptors(nil, notifyChr)
-		err := client.Subscribe(notifyChr, false, func(b []byte) {
-			parseNotification(b)
-		})
+		err := client.Subscribe(notifyChr, false, onNotify)
 		if err != nil {
 			return fmt.Errorf("%v", err)
 		} else {
@@ -520,52 +930,630 @@ func subToNotifs(client ble.Client, notifyChr *ble.Characteristic) error {
 	return nil
 }
 
-func loadAndProcessImage(imagePath string, printMode PrintMode, ditherType string) ([]byte, int, error) {
+func loadAndProcessImage(imagePath string, printMode PrintMode, opts imageOptions) ([]byte, int, PrintMode, error) {
 	img, err := decodeImage(imagePath)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	opts.stamp = stampFor(imagePath, 1, 1)
+	printMode, opts = resolveAutoMode(img, printMode, opts)
+	pixels, height, err := processImage(img, printMode, opts)
+	return pixels, height, printMode, err
+}
+
+// processImage converts a decoded image to packed printer pixels
+func processImage(img image.Image, printMode PrintMode, opts imageOptions) ([]byte, int, error) {
+	return renderImage(layoutImage(img, opts), printMode, opts)
+}
+
+// layoutImage flattens, trims and scales img to the print width and adds the
+// header, footer and margins
+func layoutImage(img image.Image, opts imageOptions) image.Image {
+	return applyMargins(layoutContent(img, opts.margins.width(), opts), opts.margins)
+}
+
+// layoutContent is layoutImage without the margins, scaling img to width dots
+func layoutContent(img image.Image, width int, opts imageOptions) image.Image {
+	img = flattenAlpha(img, opts.background)
+	if opts.pipeline != nil {
+		img = opts.pipeline.Apply(img)
+	}
+	if opts.deskew {
+		img = deskew(img)
+	}
+	if opts.trim {
+		img = trimWhitespace(img, opts.trimLevel)
+	}
+	if opts.descreen != 0 {
+		img = descreen(img, opts.descreen)
+	}
+	// Scale to the print width first so text, margins and padding are in dots
+	img = opts.size.scale(img, width-frameWidth(opts.frame))
+	img = stampDate(img, opts.dateCorner, opts.stamp)
+	img = addCaption(img, opts.caption, opts.stamp)
+	img = frameImage(img, opts.frame)
+	return stampText(img, opts.header, opts.footer, opts.stamp)
+}
 
+// renderImage pads a laid out image to a printable length and converts it to
+// packed printer pixels
+func renderImage(img image.Image, printMode PrintMode, opts imageOptions) ([]byte, int, error) {
+	img, err := scriptImageHook(img, printMode, opts.stamp)
 	if err != nil {
-		log.Fatalf("Image load error: %v", err)
+		return nil, 0, err
+	}
+	img = padImageToMinLines(img, opts.minLines, opts.pad)
+	img = addFeed(img, opts.feed)
+	if opts.ditherType == "auto" {
+		opts.ditherType = autoDither(img, printMode)
+		log.Printf("Auto dither: %s", opts.ditherType)
 	}
-	img = padImageToMinLines(img, minLines)
 	var pixels []byte
 	var height int
 
 	// Convert image to the desired format
 	switch printMode {
 	case Mode1bpp:
-		pixels, height, err = loadImageMonoFromImage(img, ditherType)
+		pixels, height, err = loadImageMonoFromImage(img, opts)
 	case Mode4bpp:
-		pixels, height, err = loadImage4BitFromImage(img, ditherType)
+		pixels, height, err = loadImage4BitFromImage(img, opts)
 	}
 	if err != nil {
 		return nil, 0, fmt.Errorf("image conversion error: %v", err)
 	}
+	if opts.double {
+		pixels, height = strikeTwice(pixels, height, lineBytes(printMode))
+	}
 
 	return pixels, height, nil
 }
 
-func loadPrinter() (ble.Client, *ble.Characteristic, *ble.Characteristic, *ble.Characteristic, error) {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
+// imageOptionsFromFlags validates the processing flags shared by plain
+// printing and all subcommands
+func imageOptionsFromFlags() (PrintMode, imageOptions, error) {
+	printMode, opts, err := parseImageFlags()
+	return printMode, opts, withCause(errBadInput, err)
+}
+
+func parseImageFlags() (PrintMode, imageOptions, error) {
+	// --mode auto starts out as 1bpp until resolveAutoMode sees the image
+	autoMode := mode == "auto"
+	printMode := Mode1bpp
+	if !autoMode {
+		var err error
+		if printMode, err = parsePrintMode(mode); err != nil {
+			return 0, imageOptions{}, err
+		}
+	}
+	dither := ditherType
+	if autoMode && !flagGiven("dither", "d") {
+		dither = "auto"
+	}
+
+	if halftoneLPI <= 0 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid halftone screen frequency. Use a positive --lpi value.")
+	}
+
+	threshold, err := parseThreshold(thresholdValue, thresholdWindow)
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+
+	curve, err := loadToneCurve(curvePath, mode)
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+
+	bg, err := parseBackground(background)
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+
+	if trimLevel < 0 || trimLevel > 255 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid trim level. Use 0-255.")
+	}
+
+	if speed < 0 || speed > maxSpeed {
+		return 0, imageOptions{}, fmt.Errorf("Invalid speed. Use 1-5, or 0 for the printer's default.")
+	}
+
+	var levels []byte
+	switch {
+	case paletteLevels != "":
+		if levels, err = parseLevels(paletteLevels); err != nil {
+			return 0, imageOptions{}, err
+		}
+	case grayLevels == 2 || grayLevels == 4 || grayLevels == 8:
+		levels = evenLevels(grayLevels)
+	case grayLevels != 16:
+		return 0, imageOptions{}, fmt.Errorf("Invalid number of gray levels. Use 2, 4, 8 or 16.")
+	}
+
+	descreen, err := parseDescreen(descreenSpec)
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+
+	switch denoiseFilter {
+	case "", "none", "median", "bilateral":
+	default:
+		return 0, imageOptions{}, fmt.Errorf("Invalid denoise filter. Use 'median' or 'bilateral'.")
+	}
+
+	if sharpenAmount < 0 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid sharpening. Use 0 or a positive --sharpen amount.")
+	}
+
+	contrast := ""
+	switch {
+	case equalizeHist && claheEnabled:
+		return 0, imageOptions{}, fmt.Errorf("Invalid contrast enhancement. Use either --equalize or --clahe.")
+	case equalizeHist:
+		contrast = "equalize"
+	case claheEnabled:
+		contrast = "clahe"
+	}
+
+	if dpi <= 0 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid resolution. Use a positive --dpi value.")
+	}
+
+	m, err := parseMargins(marginTop, marginBottom, marginLeft, marginRight)
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+
+	size, err := parseSize(widthMM, heightMM, m)
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+
+	switch padPosition {
+	case "top", "bottom", "center":
+	default:
+		return 0, imageOptions{}, fmt.Errorf("Invalid padding position. Use 'top', 'bottom' or 'center'.")
+	}
+
+	if minLines < 1 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid minimum length. Use a positive --min-lines value.")
+	}
+	if minLines < defaultMinLines {
+		log.Printf("Warning: the firmware may refuse prints shorter than %d lines", defaultMinLines)
+	}
+
+	if feedLines < 0 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid feed. Use 0 or more lines.")
+	}
+
+	switch previewTerm {
+	case "", "auto", "kitty", "sixel", "blocks":
+	default:
+		return 0, imageOptions{}, fmt.Errorf("Invalid terminal preview. Use 'auto', 'kitty', 'sixel' or 'blocks'.")
+	}
+
+	if previewStyle != "plain" && previewStyle != "paper" {
+		return 0, imageOptions{}, fmt.Errorf("Invalid preview style. Use 'plain' or 'paper'.")
+	}
+
+	switch outputFormat {
+	case "", "png", "pbm", "pgm", "pnm":
+	default:
+		return 0, imageOptions{}, fmt.Errorf("Invalid output format. Use 'png', 'pbm', 'pgm' or 'pnm'.")
+	}
+
+	if (raw1bpp && raw4bpp) || (rawInput && (raw1bpp || raw4bpp)) {
+		return 0, imageOptions{}, fmt.Errorf("Use only one of --raw, --raw-1bpp and --raw-4bpp.")
+	}
+
+	if nUp != 1 && nUp != 2 && nUp != 4 {
+		return 0, imageOptions{}, fmt.Errorf("Invalid --up value. Use 1, 2 or 4.")
+	}
+
+	if _, ok := separatorStyles[separator]; !ok {
+		return 0, imageOptions{}, fmt.Errorf("Invalid separator. Use 'none', 'space', 'line' or 'dashed'.")
+	}
+	if _, ok := frameStyles[frameStyle]; !ok {
+		return 0, imageOptions{}, fmt.Errorf("Invalid frame. Use 'none', 'line', 'double', 'dashed' or 'scalloped'.")
+	}
+
+	pipeline, err := imagePipeline()
+	if err != nil {
+		return 0, imageOptions{}, err
+	}
+	caption, err := captionFromFlags()
+	if err != nil {
+		return 0, imageOptions{}, withCause(errBadInput, err)
+	}
+	dateCorner := ""
+	if stampDateOn {
+		if !slices.Contains(dateCorners, stampCorner) {
+			return 0, imageOptions{}, withCause(errBadInput, fmt.Errorf("invalid --stamp-corner %q, use %s", stampCorner, strings.Join(dateCorners, ", ")))
+		}
+		dateCorner = stampCorner
+	}
+
+	return printMode, imageOptions{
+		autoMode:   autoMode,
+		ditherType: dither,
+		serpentine: serpentine,
+		lpi:        halftoneLPI,
+		angle:      halftoneAngle,
+		threshold:  threshold,
+		descreen:   descreen,
+		denoise:    denoiseFilter,
+		edges:      edgesOnly,
+		sharpen:    sharpenAmount,
+		contrast:   contrast,
+		double:     doubleStrike,
+		tone:       toneSpace{linear: linearLight, gamma: inputGamma},
+		levels:     levels,
+		curve:      curve,
+		background: bg,
+		deskew:     deskewImage,
+		trim:       trim,
+		trimLevel:  uint8(trimLevel),
+		margins:    m,
+		size:       size,
+		pad:        padPosition,
+		minLines:   minLines,
+		feed:       feedLines,
+		header:     headerText,
+		footer:     footerText,
+		caption:    caption,
+		dateCorner: dateCorner,
+		frame:      frameStyle,
+		stamp:      stampInfo{page: 1, pages: 1},
+		separator:  separator,
+		pipeline:   pipeline,
+	}, nil
+}
+
+// imagePipeline is the --pipeline file followed by the --filter chain, or
+// nil for neither
+func imagePipeline() (*imageproc.Pipeline, error) {
+	if pipelinePath == "" && filterNames == "" {
+		return nil, nil
+	}
+	pipeline := &imageproc.Pipeline{}
+	if pipelinePath != "" {
+		var err error
+		if pipeline, err = imageproc.Load(pipelinePath); err != nil {
+			return nil, fmt.Errorf("Invalid --pipeline: %v", err)
+		}
+	}
+	for _, name := range strings.Split(filterNames, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if err := pipeline.Add(name, nil); err != nil {
+			return nil, fmt.Errorf("Invalid --filter: %v. Available: %s", err, strings.Join(imageproc.Names(), ", "))
+		}
+	}
+	return pipeline, nil
+}
+
+func parsePrintMode(mode string) (PrintMode, error) {
+	switch mode {
+	case "1bpp":
+		return Mode1bpp, nil
+	case "4bpp":
+		return Mode4bpp, nil
+	default:
+		return 0, fmt.Errorf("Invalid mode. Use '1bpp', '4bpp' or 'auto'.")
+	}
+}
+
+// maxSpeed is the fastest --speed
+const maxSpeed = 5
+
+// speedValue maps --speed onto the speed values the firmware takes, slowest
+// first, or 0 for its default
+func speedValue(speeds []byte) byte {
+	if speed == 0 || len(speeds) == 0 {
+		return 0
+	}
+	return speeds[(speed-1)*(len(speeds)-1)/(maxSpeed-1)]
+}
+
+func intensityByte() byte {
+	i := max(intensity, 0)
+	i = min(i, 100)
+	return byte(i)
+}
+
+// writePreview renders packed pixels back to a PNG at path, or stdout for "-"
+func writePreview(path string, pixels []byte, height int, printMode PrintMode) error {
+	previewImg := previewImage(pixels, height, printMode)
+	var out io.Writer
+	if path == "-" {
+		out = os.Stdout
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	format := previewFormat(path, printMode)
+	var err error
+	switch format {
+	case "pbm":
+		err = encodePBM(out, previewImg)
+	case "pgm":
+		err = encodePGM(out, previewImg)
+	default:
+		err = imaging.Encode(out, styledPreview(previewImg), imaging.PNG)
+	}
+	if err != nil {
+		return err
+	}
+	if path != "-" {
+		log.Printf("Preview %s written to %s\n", strings.ToUpper(format), path)
+	}
+	return nil
+}
+
+// previewOnly reports whether the output goes somewhere other than the printer
+func previewOnly() bool {
+	return outputPath != "" || outputRaw != "" || previewTerm != "" || previewGrid != ""
+}
+
+// previewImage renders packed pixels back to a grayscale image
+func previewImage(pixels []byte, height int, printMode PrintMode) image.Image {
+	if printMode == Mode4bpp {
+		return renderPreviewFrom4bpp(pixels, linePixels, height)
+	}
+	return renderPreviewFrom1bpp(pixels, linePixels, height)
+}
+
+// previewFormat picks the preview file format from --output-format, or else
+// the file extension. "pnm" means PBM for 1bpp and PGM for 4bpp.
+func previewFormat(path string, printMode PrintMode) string {
+	format := outputFormat
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	if format == "pnm" {
+		if printMode == Mode1bpp {
+			return "pbm"
+		}
+		return "pgm"
+	}
+	if format == "pbm" || format == "pgm" {
+		return format
+	}
+	return "png"
+}
+
+// printBuffer connects to the printer and prints one processed image
+func printBuffer(ctx context.Context, pixels []byte, height int, printMode PrintMode) error {
+	job := printJob{pixels: pixels, height: height, mode: printMode, intensity: intensityByte()}
+	ctx, info, ok, err := scriptBeforePrint(ctx, &job, false)
+	if err != nil || !ok {
+		return err
+	}
+	err = withPrinter(ctx, func(c *printerConn) error {
+		pause, err := checkPrinter(ctx, c)
+		if err != nil {
+			return err
+		}
+		c.drain()
+		if err := c.send(ctx, job, pause); err != nil {
+			return err
+		}
+		if !noVerify {
+			err = c.verify(ctx, job)
+		}
+		// Paper that came out counts, whether the job failed halfway or not
+		recordJob(ctx, pixels, height, printMode, job.intensity, err)
+		if err == nil {
+			archiveJob(ctx, c, job)
+		}
+		return err
+	})
+	scriptComplete(info, err)
+	return err
+}
+
+// printJob is one processed image and the intensity to print it at
+type printJob struct {
+	pixels    []byte
+	height    int
+	mode      PrintMode
+	intensity byte
+}
+
+// printJobs prints several images over a single connection. Settings like
+// intensity only change between jobs, so each one has to finish printing
+// before the next is sent.
+func printJobs(ctx context.Context, jobs []printJob) error {
+	queue := make(chan preparedJob, len(jobs))
+	for _, job := range jobs {
+		queue <- preparedJob{job: job}
+	}
+	close(queue)
+	return printJobQueue(ctx, queue, len(jobs))
+}
+
+// printJobQueue prints the total jobs coming from queue as they become
+// ready, 0 if not known beforehand. The connection is set up while the first
+// one is still processing.
+func printJobQueue(ctx context.Context, queue <-chan preparedJob, total int) error {
+	return withPrinter(ctx, func(c *printerConn) error {
+		for i := 0; ; i++ {
+			var next preparedJob
+			var ok bool
+			select {
+			case next, ok = <-queue:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if !ok {
+				return nil
+			}
+			if next.err != nil {
+				return next.err
+			}
+			job := next.job
+			_, info, ok, err := scriptBeforePrint(ctx, &job, true)
+			if err != nil {
+				return fmt.Errorf("job %d: %w", i+1, err)
+			} else if !ok {
+				continue
+			}
+			if total > 0 {
+				log.Printf("Printing job %d of %d", i+1, total)
+			} else {
+				log.Printf("Printing job %d", i+1)
+			}
+			err = printQueuedJob(ctx, c, job)
+			scriptComplete(info, err)
+			if err != nil {
+				return fmt.Errorf("job %d: %w", i+1, err)
+			}
+		}
+	})
+}
+
+// printQueuedJob prints one job of printJobQueue
+func printQueuedJob(ctx context.Context, c *printerConn, job printJob) error {
+	// The head heats up over a batch, so check before every job
+	pause, err := checkPrinter(ctx, c)
+	if err != nil {
+		return err
+	}
+	c.drain()
+	if err := c.send(ctx, job, pause); err != nil {
+		return err
+	}
+	// Paper that came out counts, whether the job failed halfway or not
+	err = c.verify(ctx, job)
+	recordJob(ctx, job.pixels, job.height, job.mode, job.intensity, err)
+	if err == nil {
+		archiveJob(ctx, c, job)
+	}
+	return err
+}
+
+// previewOrPrint writes pixels to the -o preview if one was requested and
+// prints them otherwise
+func previewOrPrint(ctx context.Context, pixels []byte, height int, printMode PrintMode) error {
+	if outputRaw != "" {
+		if err := writeRawBuffer(outputRaw, pixels, height, printMode); err != nil {
+			return err
+		}
+	}
+	if previewTerm != "" {
+		if err := writeTermPreview(os.Stdout, previewTerm, pixels, height, printMode); err != nil {
+			return err
+		}
+	}
+	if outputPath != "" {
+		return writePreview(outputPath, pixels, height, printMode)
+	}
+	if previewOnly() {
+		return nil
+	}
+	return printBuffer(ctx, pixels, height, printMode)
+}
+
+// scanPrinter finds the printer and tells its model
+func scanPrinter(ctx context.Context) (ble.Advertisement, *drivers.Model, error) {
+	want, err := chosenModel()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Initialize BLE device
-	d, err := linux.NewDevice()
+	d, err := openAdapter()
 	if err != nil {
-		log.Fatalf("Failed to open BLE device: %v", err)
+		return nil, nil, err
 	}
 	ble.SetDefaultDevice(d)
 
 	// Find printer
-	adv, err := findPrinter(ctx)
+	adv, err := findPrinter(ctx, want)
 	if err != nil {
-		log.Fatalf("Failed to find printer: %v", err)
+		return nil, nil, err
+	}
+	model := want
+	if model == nil {
+		model = drivers.DetectAdvertised(adv.LocalName(), append(adv.Services(), adv.OverflowService()...))
+	}
+	if model == nil {
+		model = modelByService(adv)
+	}
+	if model == nil {
+		log.Printf("Unknown printer %q, assuming an MXW01 (use --model to pick another)", adv.LocalName())
+		model = drivers.MXW01
+	}
+	log.Println("Printer model:", model.Name)
+	return adv, model, nil
+}
+
+// detected is the printer detectPrintWidth found, for the first connection
+// to use rather than scanning again
+var detected struct {
+	adv   ble.Advertisement
+	model *drivers.Model
+}
+
+// detectPrintWidth finds the printer before any image is processed, when the
+// model isn't picked with --model, and sets the print width to its head
+func detectPrintWidth(ctx context.Context) error {
+	if modelName != "" && modelName != "auto" {
+		return nil
+	}
+	adv, model, err := scanPrinter(ctx)
+	if err != nil {
+		return err
+	}
+	detected.adv, detected.model = adv, model
+	linePixels, bytesPerLine = model.Width, model.Width/8
+	return nil
+}
+
+// checkOptionsForAnyWidth validates the processing options before the print
+// width is known, so that a mistake in them doesn't wait for a scan. Options
+// that depend on the width are held against the widest head there is; they
+// are checked for the printer's own once it is found.
+func checkOptionsForAnyWidth() error {
+	saved := linePixels
+	defer func() { linePixels = saved }()
+	for _, name := range drivers.Names() {
+		linePixels = max(linePixels, drivers.Lookup(name).Width)
+	}
+	_, _, err := imageOptionsFromFlags()
+	return err
+}
+
+// loadPrinter finds the printer, adds the lock on it to lock and connects to
+// it. ctx bounds the scan and the connection attempt.
+func loadPrinter(ctx context.Context, lock *printerLock) (ble.Client, *drivers.Model, error) {
+	adv, model := detected.adv, detected.model
+	detected.adv, detected.model = nil, nil
+	if adv == nil || targetOf(ctx) != nil {
+		var err error
+		if adv, model, err = scanPrinter(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := lock.take(ctx, printerKeyFor(adv.Addr().String())); err != nil {
+		return nil, nil, err
+	}
+	if model.Width > linePixels {
+		log.Printf("Images are printed %d dots wide, centered on the %d-dot head (use --model %s for the full width)", linePixels, model.Width, model.Name)
 	}
 
 	// Connect to printer
 	log.Println("Connecting...")
 	client, err := ble.Dial(ctx, adv.Addr())
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
 	if err != nil {
-		log.Fatalf("Connect failed: %v", err)
+		return nil, nil, withCause(errConnect, fmt.Errorf("connect failed: %v", err))
 	}
 
 	// Negotiate large MTU if possible
@@ -576,202 +1564,295 @@ func loadPrinter() (ble.Client, *ble.Characteristic, *ble.Characteristic, *ble.C
 		log.Printf("Negotiated ATT MTU: %d", mtu)
 	}
 
-	// Discover services and characteristics
-	printChr, notifyChr, dataChr, err := discoverChars(client)
-	if err != nil {
-		log.Fatalf("Characteristic discovery failed: %v", err)
-	}
-
-	return client, printChr, notifyChr, dataChr, nil
+	return client, model, nil
 }
 
 func main() {
 	flag.Parse()
+	jobSource = strings.Join(flag.Args(), " ")
 
+	// One context for the whole run, so Ctrl-C or --timeout stop the scan,
+	// the connection and the transfer wherever they are
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop() // a second Ctrl-C kills right away
+	}()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		startWatchdog(timeout + watchdogGrace)
+	}
 	if outputPath != "-" {
-		log.Println("Bleh! Cat Printer Utility for MXW01, version", version)
+		log.Println("Bleh! Thermal Printer Utility, version", version)
+	}
+	if err := applyPresets(); err != nil {
+		fatal("Invalid preset", withCause(errBadInput, err))
+	}
+	if scriptPath != "" {
+		var err error
+		if hooks, err = loadScript(scriptPath); err != nil {
+			fatal("Failed to load script", withCause(errBadInput, err))
+		}
+	}
+	if err := setPrintWidth(); err != nil {
+		fatal("Invalid --model", err)
+	}
+	if err := applyQuality(); err != nil {
+		fatal("Bad options", withCause(errBadInput, err))
+	}
+	if stayConnected && !previewOnly() {
+		shared = &sharedConn{}
+		defer shared.close()
+	}
+
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		startKeepAlive(ctx)
+		if err := cmd.run(ctx, flag.Args()[1:]); err != nil {
+			fatal(cmd.name+" failed", err)
+		}
+		log.Println("Done!")
+		return
+	}
+
+	if getStatus && jsonOutput {
+		if err := printStatusJSON(ctx); err != nil {
+			fatal("Failed to query status", err)
+		}
+		return
+	}
+	if getQueryCount && jsonOutput {
+		if err := printCountJSON(ctx); err != nil {
+			fatal("Failed to query the counter", err)
+		}
+		return
 	}
 
 	needNotifications := getStatus || getBattery || getVersion || getPrintType || getQueryCount || ejectPaper > 0 || retractPaper > 0
 
-	needPrinter := needNotifications || (flag.NArg() > 0 && outputPath == "")
+	needPrinter := needNotifications || ((flag.NArg() > 0 || escposPath != "") && !previewOnly())
 
-	if !needPrinter && outputPath == "" {
+	if !needPrinter && !previewOnly() {
 		log.Println("Nothing to do. Use -h for help.")
 		log.Println("Done!")
 		return
 	}
 
-	// Get print mode
-	var printMode PrintMode
-	switch mode {
-	case "1bpp":
-		printMode = Mode1bpp
-	case "4bpp":
-		printMode = Mode4bpp
-	default:
-		fmt.Println("Invalid mode. Use '1bpp' or '4bpp'.")
-		return
+	if needPrinter && !needNotifications && !previewOnly() {
+		if err := checkOptionsForAnyWidth(); err != nil {
+			fatal("Bad options", err)
+		}
+		if err := detectPrintWidth(ctx); err != nil {
+			fatal("Failed to find printer", err)
+		}
+	}
+	if needPrinter {
+		startKeepAlive(ctx)
 	}
 
-	// Get image path
-	imagePath := flag.Arg(0)
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		fatal("Bad options", err)
+	}
 
-	pixels, height, err := []byte(nil), int(0), error(nil)
+	// Get image paths, with archives standing for the images in them
+	images, err := expandArchives(flag.Args())
+	if err != nil {
+		fatal("Failed to read archive", withCause(errBadInput, err))
+	}
+	imagePath := ""
+	if len(images) > 0 {
+		imagePath = images[0]
+	}
 
-	if imagePath != "" {
-		pixels, height, err = loadAndProcessImage(imagePath, printMode, ditherType)
-		if err != nil {
-			log.Fatalf("Failed to load and process image: %v", err)
+	if stdinFormat != "image" {
+		if stdinFormat != "tar" && stdinFormat != "frames" {
+			fatal("Invalid --stdin-format", withCause(errBadInput, fmt.Errorf("use image, tar or frames")))
+		}
+		if len(images) != 1 || imagePath != "-" || nUp > 1 || previewGrid != "" {
+			fatal("Invalid --stdin-format", withCause(errBadInput, fmt.Errorf("streams are read from \"-\" as the only image, without --up or --preview-grid")))
 		}
+		if err := printStream(ctx, os.Stdin, stdinFormat, printMode, opts); err != nil {
+			fatal("Failed to print images", err)
+		}
+		log.Println("Done!")
+		return
 	}
 
-	if outputPath != "" {
-		var previewImg image.Image
-		switch printMode {
-		case Mode1bpp:
-			previewImg = renderPreviewFrom1bpp(pixels, linePixels, height)
-		case Mode4bpp:
-			previewImg = renderPreviewFrom4bpp(pixels, linePixels, height)
-		}
-		var out io.Writer
-		if outputPath == "-" {
-			out = os.Stdout
-		} else {
-			f, err := os.Create(outputPath)
-			if err != nil {
-				log.Fatalf("Failed to create output file: %v", err)
-			}
-			defer f.Close()
-			out = f
-		}
-		err = imaging.Encode(out, previewImg, imaging.PNG)
+	if previewGrid != "" {
+		img, err := decodeImage(imagePath)
 		if err != nil {
-			log.Fatalf("Failed to write PNG preview: %v", err)
+			fatal("Image load error", err)
 		}
-		if outputPath != "-" {
-			log.Printf("Preview PNG written to %s\n", outputPath)
+		opts.stamp.file = sourceName(imagePath)
+		if err := writePreviewGrid(previewGrid, img, opts, strings.Split(gridDithers, ",")); err != nil {
+			fatal("Failed to write preview grid", err)
 		}
 		return
 	}
 
-	if needPrinter {
-		client, printChr, notifyChr, dataChr, err := loadPrinter()
-
-		defer client.CancelConnection()
+	pixels, height := []byte(nil), int(0)
+	// Several images without --concat or --up are printed one job each
+	batch := len(images) > 1 && !concat && nUp <= 1 && !previewOnly()
 
+	if batch {
+		// Processed in printImages, while the printer works
+	} else if escposPath != "" {
+		if len(images) > 0 {
+			fatal("Invalid --escpos", withCause(errBadInput, fmt.Errorf("an ESC/POS stream is printed on its own, without images")))
+		}
+		img, err := loadESCPOS(escposPath, opts.margins.width())
+		if err != nil {
+			fatal("Failed to read ESC/POS", err)
+		}
+		opts.stamp.file = sourceName(escposPath)
+		printMode = Mode1bpp
+		pixels, height, err = processImage(img, printMode, opts)
 		if err != nil {
-			log.Fatalf("Failed to load printer: %v", err)
+			fatal("Failed to process ESC/POS", err)
+		}
+	} else if rawInput || raw1bpp || raw4bpp {
+		if raw4bpp {
+			printMode = Mode4bpp
+		} else if raw1bpp {
+			printMode = Mode1bpp
 		}
+		pixels, height, printMode, err = loadRawBuffer(imagePath, printMode, rawInput, opts.minLines)
+		if err != nil {
+			fatal("Failed to load raw buffer", withCause(errBadInput, err))
+		}
+	} else if nUp > 1 {
+		pixels, height, printMode, err = collageImages(images, nUp, printMode, opts)
+		if err != nil {
+			fatal("Failed to lay out images", err)
+		}
+	} else if len(images) > 1 {
+		// Previews of a batch show its pages one after another
+		pixels, height, printMode, err = concatImages(images, printMode, opts)
+		if err != nil {
+			fatal("Failed to combine images", err)
+		}
+	} else if imagePath != "" {
+		pixels, height, printMode, err = loadAndProcessImage(imagePath, printMode, opts)
+		if err != nil {
+			fatal("Failed to load and process image", err)
+		}
+	}
 
-		if needNotifications {
-			// Subscribe to notifications
-			err := subToNotifs(client, notifyChr)
-			if err != nil {
-				log.Fatalf("Failed to subscribe to notifications: %v", err)
-			}
+	if outputRaw != "" {
+		if err := writeRawBuffer(outputRaw, pixels, height, printMode); err != nil {
+			fatal("Failed to write raw buffer", err)
+		}
+	}
+	if previewTerm != "" {
+		if err := writeTermPreview(os.Stdout, previewTerm, pixels, height, printMode); err != nil {
+			fatal("Failed to show preview", err)
+		}
+	}
+	if outputPath != "" {
+		if err := writePreview(outputPath, pixels, height, printMode); err != nil {
+			fatal("Failed to write PNG preview", err)
+		}
+	}
+	if previewOnly() {
+		return
+	}
 
-			// TODO: check if the firmware allows more than one command at a time
-			// Also find a neater way to handle this
-			if getStatus {
-				sendSimpleCommand(client, printChr, 0xA1)
-			}
-			if getBattery {
-				sendSimpleCommand(client, printChr, 0xAB)
-			}
-			if getVersion {
-				sendSimpleCommand(client, printChr, 0xB1)
-			}
-			if getPrintType {
-				sendSimpleCommand(client, printChr, 0xB0)
-			}
-			if getQueryCount {
-				sendSimpleCommand(client, printChr, 0xA7)
-			}
-			if ejectPaper > 0 {
-				sendLineCommand(client, printChr, 0xA3, ejectPaper)
-			}
-			if retractPaper > 0 {
-				sendLineCommand(client, printChr, 0xA4, retractPaper)
-			}
-			log.Println("Waiting for notifications...")
-			time.Sleep(2 * time.Second)
+	if needNotifications {
+		if len(images) > 0 {
+			fatal("Refusing to print and query at the same time due to a firmware bug", withCause(errBadInput, fmt.Errorf("please run print and query commands separately")))
+		}
+		if err := queryPrinter(ctx); err != nil {
+			fatal("Failed to query printer", err)
+		}
+		return
+	}
 
-			if flag.NArg() < 1 {
-				return // no image to print
-			} else {
-				log.Fatalf("Refusing to print and query at the same time due to a firmware bug. Please run print and query commands separately.")
-			}
+	if batch {
+		if err := printImages(ctx, images, printMode, opts); err != nil {
+			fatal("Failed to print images", err)
 		}
-		if printChr == nil {
-			log.Fatalf("Missing required print characteristic")
+	} else if needPrinter {
+		if err := printBuffer(ctx, pixels, height, printMode); err != nil {
+			fatal("Failed to print image", err)
 		}
+	}
 
-		i := max(intensity, 0)
-		i = min(i, 100)
-		intensityByte := byte(i)
+	log.Println("Done!")
+}
 
-		if dataChr == nil {
-			log.Fatalf("Missing required data characteristic")
+// queryPrinter sends the queries and paper commands asked for on the command
+// line and prints the replies as they come in
+func queryPrinter(ctx context.Context) error {
+	return withPrinter(ctx, func(c *printerConn) error {
+		if getStatus {
+			s, err := c.status(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Println(s)
 		}
 
-		err = sendImageBufferToPrinter(client, dataChr, printChr, pixels, height, printMode, intensityByte)
+		// TODO: check if the firmware allows more than one command at a time
+		// Also find a neater way to handle this
+		var err error
+		if getBattery {
+			err = errors.Join(err, c.driver.Query(drivers.AskBattery))
+		}
+		if getVersion {
+			err = errors.Join(err, c.driver.Query(drivers.AskVersion))
+		}
+		if getPrintType {
+			err = errors.Join(err, c.driver.Query(drivers.AskPrintType))
+		}
+		if getQueryCount {
+			err = errors.Join(err, c.driver.Query(drivers.AskCount))
+		}
+		if ejectPaper > 0 {
+			err = errors.Join(err, c.driver.Feed(ejectPaper))
+		}
+		if retractPaper > 0 {
+			err = errors.Join(err, c.driver.Retract(retractPaper))
+		}
 		if err != nil {
-			log.Fatalf("Failed to print image: %v", err)
+			return err
+		}
+		log.Println("Waiting for notifications...")
+		if err := sleepCtx(ctx, 2*time.Second); err != nil {
+			return err
 		}
-	}
 
-	log.Println("Done!")
+		if getStatus {
+			if u, err := currentPaperUsage(); err == nil {
+				fmt.Printf("Paper: %.2f m printed, about %.2f m left on the roll\n", u.TotalMM/1000, u.report().RollRemaining/1000)
+			}
+		}
+		return nil
+	})
 }
 
-func buildCommand(cmdId byte, payload []byte) []byte {
-	cmd := append([]byte{}, printCommandHeader...)
-	cmd = append(cmd, cmdId)
-	cmd = append(cmd, 0x00) // reserved
-	cmd = append(cmd, byte(len(payload)&0xFF), byte(len(payload)>>8))
-	cmd = append(cmd, payload...)
-	cmd = append(cmd, calculateCRC8(payload))
-	cmd = append(cmd, printCommandFooter)
-	return cmd
-}
-
-func calculateCRC8(data []byte) byte {
-	table := [256]byte{
-		0x00, 0x07, 0x0e, 0x09, 0x1c, 0x1b, 0x12, 0x15,
-		0x38, 0x3f, 0x36, 0x31, 0x24, 0x23, 0x2a, 0x2d,
-		0x70, 0x77, 0x7e, 0x79, 0x6c, 0x6b, 0x62, 0x65,
-		0x48, 0x4f, 0x46, 0x41, 0x54, 0x53, 0x5a, 0x5d,
-		0xe0, 0xe7, 0xee, 0xe9, 0xfc, 0xfb, 0xf2, 0xf5,
-		0xd8, 0xdf, 0xd6, 0xd1, 0xc4, 0xc3, 0xca, 0xcd,
-		0x90, 0x97, 0x9e, 0x99, 0x8c, 0x8b, 0x82, 0x85,
-		0xa8, 0xaf, 0xa6, 0xa1, 0xb4, 0xb3, 0xba, 0xbd,
-		0xc7, 0xc0, 0xc9, 0xce, 0xdb, 0xdc, 0xd5, 0xd2,
-		0xff, 0xf8, 0xf1, 0xf6, 0xe3, 0xe4, 0xed, 0xea,
-		0xb7, 0xb0, 0xb9, 0xbe, 0xab, 0xac, 0xa5, 0xa2,
-		0x8f, 0x88, 0x81, 0x86, 0x93, 0x94, 0x9d, 0x9a,
-		0x27, 0x20, 0x29, 0x2e, 0x3b, 0x3c, 0x35, 0x32,
-		0x1f, 0x18, 0x11, 0x16, 0x03, 0x04, 0x0d, 0x0a,
-		0x57, 0x50, 0x59, 0x5e, 0x4b, 0x4c, 0x45, 0x42,
-		0x6f, 0x68, 0x61, 0x66, 0x73, 0x74, 0x7d, 0x7a,
-		0x89, 0x8e, 0x87, 0x80, 0x95, 0x92, 0x9b, 0x9c,
-		0xb1, 0xb6, 0xbf, 0xb8, 0xad, 0xaa, 0xa3, 0xa4,
-		0xf9, 0xfe, 0xf7, 0xf0, 0xe5, 0xe2, 0xeb, 0xec,
-		0xc1, 0xc6, 0xcf, 0xc8, 0xdd, 0xda, 0xd3, 0xd4,
-		0x69, 0x6e, 0x67, 0x60, 0x75, 0x72, 0x7b, 0x7c,
-		0x51, 0x56, 0x5f, 0x58, 0x4d, 0x4a, 0x43, 0x44,
-		0x19, 0x1e, 0x17, 0x10, 0x05, 0x02, 0x0b, 0x0c,
-		0x21, 0x26, 0x2f, 0x28, 0x3d, 0x3a, 0x33, 0x34,
-		0x4e, 0x49, 0x40, 0x47, 0x52, 0x55, 0x5c, 0x5b,
-		0x76, 0x71, 0x78, 0x7f, 0x6a, 0x6d, 0x64, 0x63,
-		0x3e, 0x39, 0x30, 0x37, 0x22, 0x25, 0x2c, 0x2b,
-		0x06, 0x01, 0x08, 0x0f, 0x1a, 0x1d, 0x14, 0x13,
-		0xae, 0xa9, 0xa0, 0xa7, 0xb2, 0xb5, 0xbc, 0xbb,
-		0x96, 0x91, 0x98, 0x9f, 0x8a, 0x8d, 0x84, 0x83,
-		0xde, 0xd9, 0xd0, 0xd7, 0xc2, 0xc5, 0xcc, 0xcb,
-		0xe6, 0xe1, 0xe8, 0xef, 0xfa, 0xfd, 0xf4, 0xf3}
-	crc := byte(0)
-	for _, b := range data {
-		crc = table[crc^b]
-	}
-	return crc
+// watchdogGrace is how long after --timeout the watchdog gives the run to
+// notice its context has expired and clean up
+const watchdogGrace = 10 * time.Second
+
+// startWatchdog ends the process once d has passed. Not every BLE call takes
+// a context, and one stuck in a write would otherwise hang an unattended run
+// forever.
+func startWatchdog(d time.Duration) {
+	time.AfterFunc(d, func() {
+		log.Printf("Timed out after %v", d)
+		os.Exit(exitCode(errTimeout))
+	})
+}
+
+// sleepCtx sleeps for d, or returns early with ctx's error when it's done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
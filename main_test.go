@@ -0,0 +1,43 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestDecodeImageFromReader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodeImageFromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(3, 2) {
+		t.Errorf("decoded a %v image, want 3x2", size)
+	}
+}
+
+func TestDecodeImageFromReaderTooLarge(t *testing.T) {
+	// A GIF header claiming 65535x65535, which is all it takes to ask
+	// for 4 gigapixels
+	header := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	_, err := decodeImageFromReader(bytes.NewReader(header))
+	if !errors.Is(err, errBadInput) {
+		t.Errorf("decodeImageFromReader = %v, want a bad input error", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matrixBot follows one room through the Matrix client-server API's /sync
+type matrixBot struct {
+	o      botOptions
+	client *http.Client
+	user   string // the bot's own user ID, whose messages are skipped
+	room   string // room ID
+	txn    int
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	EventID string `json:"event_id"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+		URL     string `json:"url"`
+	} `json:"content"`
+}
+
+func (b *matrixBot) do(ctx context.Context, method, path string, body, result any) error {
+	header := http.Header{"Authorization": {"Bearer " + b.o.token}}
+	err := botRequest(ctx, b.client, method, b.o.api+path, header, body, result)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("matrix %s: %w", strings.Split(path, "?")[0], err)
+	}
+	return err
+}
+
+// runMatrix joins the room and prints the text and images posted in it
+// until ctx is done
+func runMatrix(ctx context.Context, o botOptions) error {
+	b := &matrixBot{o: o, client: &http.Client{Timeout: 60 * time.Second}}
+	var me struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &me); err != nil {
+		return withCause(errConnect, err)
+	}
+	b.user = me.UserID
+	// Joining works with aliases too and is a no-op for rooms already joined
+	var joined struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/_matrix/client/v3/join/"+url.PathEscape(o.room), struct{}{}, &joined); err != nil {
+		return withCause(errConnect, err)
+	}
+	b.room = joined.RoomID
+
+	filter, _ := json.Marshal(map[string]any{
+		"room": map[string]any{
+			"rooms":    []string{b.room},
+			"timeline": map[string]any{"limit": 50, "types": []string{"m.room.message"}},
+			"state":    map[string]any{"types": []string{}},
+		},
+		"presence":     map[string]any{"types": []string{}},
+		"account_data": map[string]any{"types": []string{}},
+	})
+	// The first sync only finds where the room is at, so that older
+	// messages aren't printed
+	since := ""
+	for first := true; ; first = false {
+		q := url.Values{"filter": {string(filter)}, "timeout": {"30000"}}
+		if first {
+			q.Set("timeout", "0")
+		}
+		if since != "" {
+			q.Set("since", since)
+		}
+		var sync struct {
+			NextBatch string `json:"next_batch"`
+			Rooms     struct {
+				Join map[string]struct {
+					Timeline struct {
+						Events []matrixEvent `json:"events"`
+					} `json:"timeline"`
+				} `json:"join"`
+			} `json:"rooms"`
+		}
+		err := b.do(ctx, http.MethodGet, "/_matrix/client/v3/sync?"+q.Encode(), nil, &sync)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Println(err)
+			if sleepCtx(ctx, 10*time.Second) != nil {
+				return nil
+			}
+			continue
+		}
+		if first {
+			log.Printf("Matrix bot %s is listening in %s, Ctrl-C to stop", b.user, o.room)
+		} else {
+			for _, ev := range sync.Rooms.Join[b.room].Timeline.Events {
+				b.handle(ctx, ev)
+			}
+		}
+		since = sync.NextBatch
+	}
+}
+
+func (b *matrixBot) handle(ctx context.Context, ev matrixEvent) {
+	if ev.Type != "m.room.message" || ev.Sender == b.user {
+		return
+	}
+	if len(b.o.allow) > 0 && !b.o.allowed(ev.Sender) {
+		log.Printf("Ignoring a message from %s, not in --allow", ev.Sender)
+		return
+	}
+	if reply, ok := botCommand(ctx, ev.Content.Body); ok {
+		b.reply(ctx, ev, reply)
+		return
+	}
+
+	var err error
+	switch ev.Content.MsgType {
+	case "m.text":
+		err = printReceivedText(ctx, ev.Content.Body, b.o.textScale, ev.Sender)
+	case "m.image":
+		var data []byte
+		if data, err = b.media(ctx, ev.Content.URL); err == nil {
+			err = printReceivedData(ctx, data, "", b.o.textScale, ev.Sender)
+		}
+	default:
+		return // files, locations and such are other conversation
+	}
+	if err != nil {
+		log.Printf("Message from %s: %v", ev.Sender, err)
+		b.reply(ctx, ev, "Printing failed: "+err.Error())
+		return
+	}
+	b.reply(ctx, ev, "Printed.")
+}
+
+// media downloads an mxc:// URL, with the authenticated media API and, on
+// servers from before it, the old unauthenticated one
+func (b *matrixBot) media(ctx context.Context, mxc string) ([]byte, error) {
+	server, id, ok := strings.Cut(strings.TrimPrefix(mxc, "mxc://"), "/")
+	if !ok || !strings.HasPrefix(mxc, "mxc://") {
+		return nil, fmt.Errorf("bad media URL %q", mxc)
+	}
+	path := "/" + url.PathEscape(server) + "/" + url.PathEscape(id)
+	data, err := b.download(ctx, "/_matrix/client/v1/media/download"+path)
+	var httpErr *botHTTPError
+	if errors.As(err, &httpErr) && (httpErr.status == http.StatusNotFound || httpErr.status == http.StatusBadRequest) {
+		data, err = b.download(ctx, "/_matrix/media/v3/download"+path)
+	}
+	return data, err
+}
+
+func (b *matrixBot) download(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.o.api+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.o.token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := readLimited(resp, "image")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &botHTTPError{status: resp.StatusCode, body: string(data)}
+	}
+	return data, nil
+}
+
+// reply posts a notice, which bots don't answer, in reply to ev
+func (b *matrixBot) reply(ctx context.Context, ev matrixEvent, text string) {
+	b.txn++
+	txn := strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.Itoa(b.txn)
+	msg := map[string]any{
+		"msgtype":      "m.notice",
+		"body":         text,
+		"m.relates_to": map[string]any{"m.in_reply_to": map[string]string{"event_id": ev.EventID}},
+	}
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(b.room) + "/send/m.room.message/" + txn
+	if err := b.do(ctx, http.MethodPut, path, msg, nil); err != nil {
+		log.Println(err)
+	}
+}
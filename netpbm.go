@@ -0,0 +1,283 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// NetPBM support: every variant (P1-P6) can be read, and previews can be
+// written as PBM (P4) or PGM (P5)
+
+func init() {
+	for _, f := range []struct{ name, magic string }{
+		{"pbm", "P1"}, {"pgm", "P2"}, {"ppm", "P3"},
+		{"pbm", "P4"}, {"pgm", "P5"}, {"ppm", "P6"},
+	} {
+		image.RegisterFormat(f.name, f.magic, decodeNetpbm, decodeNetpbmConfig)
+	}
+}
+
+type netpbmHeader struct {
+	magic         byte // '1' to '6'
+	width, height int
+	maxval        int
+}
+
+func (h netpbmHeader) channels() int {
+	switch h.magic {
+	case '3', '6':
+		return 3
+	}
+	return 1
+}
+
+func (h netpbmHeader) colorModel() color.Model {
+	switch {
+	case h.channels() == 3 && h.maxval > 255:
+		return color.RGBA64Model
+	case h.channels() == 3:
+		return color.RGBAModel
+	case h.maxval > 255:
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+// netpbmToken reads the next whitespace separated header token, skipping
+// # comments
+func netpbmToken(r *bufio.Reader) (string, error) {
+	var tok []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(tok) > 0 {
+				return string(tok), nil
+			}
+			return "", err
+		}
+		switch {
+		case c == '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(tok) > 0 {
+				return string(tok), nil
+			}
+		default:
+			tok = append(tok, c)
+		}
+	}
+}
+
+func netpbmInt(r *bufio.Reader) (int, error) {
+	tok, err := netpbmToken(r)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(tok)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("netpbm: invalid number %q", tok)
+	}
+	return v, nil
+}
+
+func readNetpbmHeader(r *bufio.Reader) (netpbmHeader, error) {
+	var h netpbmHeader
+	magic, err := netpbmToken(r)
+	if err != nil {
+		return h, err
+	}
+	if len(magic) != 2 || magic[0] != 'P' || magic[1] < '1' || magic[1] > '6' {
+		return h, errors.New("netpbm: not a PBM, PGM or PPM file")
+	}
+	h.magic = magic[1]
+	if h.width, err = netpbmInt(r); err != nil {
+		return h, err
+	}
+	if h.height, err = netpbmInt(r); err != nil {
+		return h, err
+	}
+	h.maxval = 1
+	if h.magic != '1' && h.magic != '4' {
+		if h.maxval, err = netpbmInt(r); err != nil {
+			return h, err
+		}
+		if h.maxval < 1 || h.maxval > 65535 {
+			return h, fmt.Errorf("netpbm: invalid maxval %d", h.maxval)
+		}
+	}
+	return h, nil
+}
+
+func decodeNetpbmConfig(r io.Reader) (image.Config, error) {
+	h, err := readNetpbmHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
+
+func decodeNetpbm(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readNetpbmHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if h.magic == '1' || h.magic == '4' {
+		return decodePBM(br, h)
+	}
+
+	// Samples of every other variant, scaled to 16 bits
+	next := func() (int, error) {
+		switch {
+		case h.magic <= '3':
+			return netpbmInt(br)
+		case h.maxval > 255:
+			hi, err := br.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			lo, err := br.ReadByte()
+			return int(hi)<<8 | int(lo), err
+		default:
+			b, err := br.ReadByte()
+			return int(b), err
+		}
+	}
+	sample := func() (uint16, error) {
+		v, err := next()
+		if err != nil {
+			return 0, fmt.Errorf("netpbm: truncated image data: %v", err)
+		}
+		return uint16(min(v, h.maxval) * 65535 / h.maxval), nil
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	if h.channels() == 1 {
+		img := image.NewGray16(rect)
+		for y := 0; y < h.height; y++ {
+			for x := 0; x < h.width; x++ {
+				v, err := sample()
+				if err != nil {
+					return nil, err
+				}
+				img.SetGray16(x, y, color.Gray16{Y: v})
+			}
+		}
+		return img, nil
+	}
+	img := image.NewRGBA64(rect)
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			var c [3]uint16
+			for i := range c {
+				if c[i], err = sample(); err != nil {
+					return nil, err
+				}
+			}
+			img.SetRGBA64(x, y, color.RGBA64{R: c[0], G: c[1], B: c[2], A: 0xFFFF})
+		}
+	}
+	return img, nil
+}
+
+// decodePBM reads bitmap data, where 1 is black
+func decodePBM(r *bufio.Reader, h netpbmHeader) (image.Image, error) {
+	img := image.NewGray(image.Rect(0, 0, h.width, h.height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	rowBytes := (h.width + 7) / 8
+	row := make([]byte, rowBytes)
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			var black bool
+			if h.magic == '1' {
+				c, err := nextPBMBit(r)
+				if err != nil {
+					return nil, err
+				}
+				black = c == '1'
+			} else {
+				if x == 0 {
+					if _, err := io.ReadFull(r, row); err != nil {
+						return nil, fmt.Errorf("netpbm: truncated image data: %v", err)
+					}
+				}
+				black = row[x/8]&(0x80>>uint(x%8)) != 0
+			}
+			if black {
+				img.Pix[y*img.Stride+x] = 0
+			}
+		}
+	}
+	return img, nil
+}
+
+// nextPBMBit returns the next '0' or '1' of a plain PBM, which may or may not
+// be separated by whitespace
+func nextPBMBit(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("netpbm: truncated image data: %v", err)
+		}
+		switch c {
+		case '0', '1':
+			return c, nil
+		case '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// encodePBM writes img as a binary PBM, with everything darker than mid gray
+// set to black
+func encodePBM(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P4\n%d %d\n", b.Dx(), b.Dy())
+	row := make([]byte, (b.Dx()+7)/8)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		clear(row)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 128 {
+				i := x - b.Min.X
+				row[i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
+
+// encodePGM writes img as an 8-bit binary PGM
+func encodePGM(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n%d %d\n255\n", b.Dx(), b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			bw.WriteByte(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,68 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"os/exec"
+)
+
+// desktopNotify shows a freedesktop notification through notify-send, or
+// gdbus if that is missing. Failures are only logged: notifications are a
+// nicety and the session bus may well be absent.
+func desktopNotify(summary, body string, urgent bool) {
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("notify-send"); err == nil {
+		cmd = exec.Command("notify-send", "--app-name=Bleh!", "--icon=printer", "--urgency="+urgency, summary, body)
+	} else if _, err := exec.LookPath("gdbus"); err == nil {
+		cmd = exec.Command("gdbus", "call", "--session",
+			"--dest", "org.freedesktop.Notifications",
+			"--object-path", "/org/freedesktop/Notifications",
+			"--method", "org.freedesktop.Notifications.Notify",
+			"Bleh!", "0", "printer", summary, body, "[]", "{}", "-1")
+	} else {
+		log.Printf("%s: %s", summary, body)
+		return
+	}
+	if err := cmd.Run(); err != nil {
+		log.Printf("Desktop notification failed: %v", err)
+	}
+}
+
+// statusNotifier raises a notification when the printer runs out of paper,
+// overheats or runs low on battery, once per occurrence
+type statusNotifier struct {
+	last string
+}
+
+func (n *statusNotifier) update(s printerStatus) {
+	if s.ok {
+		n.last = ""
+		return
+	}
+	if s.state == n.last {
+		return
+	}
+	n.last = s.state
+	switch s.state {
+	case "No paper":
+		desktopNotify("Printer is out of paper", "Load a new roll to continue printing.", true)
+	case "Overheated":
+		desktopNotify("Printer overheated", "Let it cool down for a few minutes.", true)
+	case "Low battery":
+		desktopNotify("Printer battery low", "Charge the printer soon.", false)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// Thermal paper look for --preview-style paper. The head doesn't reach the
+// edges of the roll (48 mm of 57 mm on most), so the paper is drawn wider
+// than the print.
+var (
+	paperColor   = color.NRGBA{R: 247, G: 243, B: 230, A: 255}
+	paperInk     = color.NRGBA{R: 38, G: 36, B: 44, A: 255}
+	paperOutside = color.NRGBA{R: 120, G: 120, B: 120, A: 255}
+)
+
+const (
+	paperEdgeMM  = 4.5 // paper beyond each side of the head
+	paperTopGap  = 16  // paper shown above and below the print
+	paperDotGain = 0.6 // blur sigma; dots bleed into their neighbours
+)
+
+// simulatePaper renders a preview the way it comes out of the printer: ink
+// that is not quite black, slightly spread dots on cream paper, and the
+// edges of the roll
+func simulatePaper(img image.Image) image.Image {
+	ink := toGray(imaging.Blur(img, paperDotGain))
+	b := ink.Bounds()
+	paperWidth := b.Dx() + 2*int(paperEdgeMM*dpi/25.4)
+	left := (paperWidth - b.Dx()) / 2
+
+	dst := imaging.New(paperWidth+8, b.Dy()+2*paperTopGap, paperOutside)
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		for x := 4; x < paperWidth+4; x++ {
+			dst.SetNRGBA(x, y, paperColor)
+		}
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			d := min(1, 1.1*(1-float64(ink.Pix[y*ink.Stride+x])/255))
+			dst.SetNRGBA(4+left+x, paperTopGap+y, color.NRGBA{
+				R: mix(paperColor.R, paperInk.R, d),
+				G: mix(paperColor.G, paperInk.G, d),
+				B: mix(paperColor.B, paperInk.B, d),
+				A: 255,
+			})
+		}
+	}
+	return dst
+}
+
+func mix(a, b uint8, t float64) uint8 {
+	return uint8(float64(a)*(1-t) + float64(b)*t + 0.5)
+}
+
+// styledPreview applies --preview-style to a rendered preview
+func styledPreview(img image.Image) image.Image {
+	if previewStyle == "paper" {
+		return simulatePaper(img)
+	}
+	return img
+}
@@ -0,0 +1,171 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Paper usage is kept per printer in <config dir>/paper.json, both in total
+// and since the last roll change
+
+const (
+	defaultRollLengthMM = 6000 // a common 57 x 30 mm roll
+	rollWarnFraction    = 0.9
+)
+
+type paperUsage struct {
+	TotalMM      float64 `json:"total_mm"`
+	RollMM       float64 `json:"roll_mm"`        // used since the roll was changed
+	RollLengthMM float64 `json:"roll_length_mm"` // 0 means defaultRollLengthMM
+}
+
+func (u paperUsage) rollLength() float64 {
+	if u.RollLengthMM > 0 {
+		return u.RollLengthMM
+	}
+	return defaultRollLengthMM
+}
+
+var paperCmd = &command{
+	name:  "paper",
+	usage: "[--new-roll] [--roll-length meters]",
+}
+
+func init() {
+	paperCmd.run = runPaper
+	registerCommand(paperCmd)
+}
+
+func paperUsagePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "paper.json"), nil
+}
+
+func loadPaperUsage() (map[string]paperUsage, error) {
+	path, err := paperUsagePath()
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]paperUsage{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return usage, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return usage, nil
+}
+
+func savePaperUsage(usage map[string]paperUsage) error {
+	path, err := paperUsagePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// currentPaperUsage returns the counters of the selected printer
+func currentPaperUsage() (paperUsage, error) {
+	usage, err := loadPaperUsage()
+	if err != nil {
+		return paperUsage{}, err
+	}
+	return usage[printerKey()], nil
+}
+
+// addPaperUsage counts lines of paper printed by the printer with key and
+// warns when the roll is probably about to run out
+func addPaperUsage(key string, lines int) error {
+	usage, err := loadPaperUsage()
+	if err != nil {
+		return err
+	}
+	u := usage[key]
+	mm := float64(lines) * 25.4 / dpi
+	u.TotalMM += mm
+	u.RollMM += mm
+	usage[key] = u
+	if err := savePaperUsage(usage); err != nil {
+		return err
+	}
+
+	if u.RollMM >= rollWarnFraction*u.rollLength() {
+		log.Printf("Warning: %.1f m of this %.1f m roll used, it may run out soon (run 'bleh paper --new-roll' after changing it)",
+			u.RollMM/1000, u.rollLength()/1000)
+	}
+	return nil
+}
+
+func runPaper(ctx context.Context, args []string) error {
+	fs := paperCmd.flagSet()
+	newRoll := fs.Bool("new-roll", false, "Reset the roll counter after loading a new roll")
+	rollLength := fs.Float64("roll-length", 0, "Length of the rolls you use, in meters")
+	fs.Parse(args)
+
+	usage, err := loadPaperUsage()
+	if err != nil {
+		return err
+	}
+	u := usage[printerKey()]
+	if *newRoll || *rollLength > 0 {
+		if *newRoll {
+			u.RollMM = 0
+		}
+		if *rollLength > 0 {
+			u.RollLengthMM = *rollLength * 1000
+		}
+		usage[printerKey()] = u
+		if err := savePaperUsage(usage); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(u.report())
+	}
+	fmt.Printf("Printed in total: %.2f m\n", u.TotalMM/1000)
+	fmt.Printf("Current roll:     %.2f of %.1f m used (%.0f%%)\n",
+		u.RollMM/1000, u.rollLength()/1000, 100*u.RollMM/u.rollLength())
+	return nil
+}
+
+// paperReport is paper usage as shown by --json
+type paperReport struct {
+	TotalMM       float64 `json:"total_mm"`
+	RollUsedMM    float64 `json:"roll_used_mm"`
+	RollLengthMM  float64 `json:"roll_length_mm"`
+	RollRemaining float64 `json:"roll_remaining_mm"`
+}
+
+func (u paperUsage) report() paperReport {
+	return paperReport{
+		TotalMM:       u.TotalMM,
+		RollUsedMM:    u.RollMM,
+		RollLengthMM:  u.rollLength(),
+		RollRemaining: max(0, u.rollLength()-u.RollMM),
+	}
+}
@@ -0,0 +1,137 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+var paperTemplateCmd = &command{
+	name:  "template",
+	usage: "[--length 20cm] [--spacing L] grid|dots|lines|music-staff",
+}
+
+func init() {
+	paperTemplateCmd.run = runPaperTemplate
+	registerCommand(paperTemplateCmd)
+}
+
+var paperPatterns = []string{"grid", "dots", "lines", "music-staff"}
+
+// Default spacings in mm: a 5 mm grid, college ruled lines and staff lines
+// the size of a pocket notebook's
+var patternSpacing = map[string]float64{
+	"grid":        5,
+	"dots":        5,
+	"lines":       7.1,
+	"music-staff": 1.75,
+}
+
+// runPaperTemplate prints notebook paper patterns
+func runPaperTemplate(ctx context.Context, args []string) error {
+	fs := paperTemplateCmd.flagSet()
+	length := fs.String("length", "20cm", "Length of paper to fill, in cm, mm or in")
+	spacing := fs.String("spacing", "", "Grid, dot or line pitch, or staff line distance for music-staff, in mm or dots (default depends on the pattern)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || !slices.Contains(paperPatterns, fs.Arg(0)) {
+		fs.Usage()
+		return fmt.Errorf("expected one pattern of %s", strings.Join(paperPatterns, ", "))
+	}
+	pattern := fs.Arg(0)
+	mm, err := parsePaperLength(*length)
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	pitch := patternSpacing[pattern] * dpi / 25.4
+	if *spacing != "" {
+		n, err := parseLength(*spacing)
+		if err != nil {
+			return withCause(errBadInput, err)
+		}
+		pitch = float64(n)
+	}
+	if pitch < 4 {
+		return withCause(errBadInput, fmt.Errorf("--spacing must be at least 4 dots (0.5mm)"))
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	img := patternImage(pattern, pitch, int(math.Round(mm*dpi/25.4)), opts.margins.width())
+	opts.stamp.file = pattern
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// patternImage draws a paper pattern width by height dots with pitch dots
+// between its lines or dots. Lines are one dot thin and placed on the
+// nearest line to keep the pitch even on average.
+func patternImage(pattern string, pitch float64, height, width int) image.Image {
+	img := imaging.New(width, height, color.White)
+	hline := func(y int) {
+		draw.Draw(img, image.Rect(0, y, width, y+1), image.Black, image.Point{}, draw.Src)
+	}
+	// Grid columns and dots are centered across the paper
+	across := int(float64(width-1) / pitch)
+	left := (width - 1 - int(float64(across)*pitch)) / 2
+	at := func(i int) int { return int(math.Round(float64(i) * pitch)) }
+
+	switch pattern {
+	case "grid":
+		for i := 0; at(i) < height; i++ {
+			hline(at(i))
+		}
+		for i := 0; i <= across; i++ {
+			x := left + at(i)
+			draw.Draw(img, image.Rect(x, 0, x+1, height), image.Black, image.Point{}, draw.Src)
+		}
+	case "dots":
+		// Rows start half a pitch down, so none is cut off at the top
+		for j := 0; ; j++ {
+			y := int(math.Round((float64(j) + 0.5) * pitch))
+			if y+2 > height {
+				break
+			}
+			for i := 0; i <= across; i++ {
+				x := left + at(i)
+				draw.Draw(img, image.Rect(x, y, x+2, y+2), image.Black, image.Point{}, draw.Src)
+			}
+		}
+	case "lines":
+		// No line at the very top, where the paper gets cut
+		for i := 1; at(i) < height; i++ {
+			hline(at(i))
+		}
+	case "music-staff":
+		// Five lines, with a gap of four staff heights between staves
+		staff := 4 * pitch
+		for top := staff; top+staff < float64(height); top += 2 * staff {
+			for i := 0; i < 5; i++ {
+				hline(int(math.Round(top + float64(i)*pitch)))
+			}
+		}
+	}
+	return img
+}
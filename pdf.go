@@ -0,0 +1,68 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PDFs are rendered with pdftoppm from Poppler, one image per page, this
+// many pages at most
+const maxPDFPages = 20
+
+// renderPDF renders the pages of a PDF width dots wide
+func renderPDF(ctx context.Context, data []byte, width int) ([]image.Image, error) {
+	if _, err := exec.LookPath("pdftoppm"); err != nil {
+		return nil, fmt.Errorf("printing PDFs needs pdftoppm, install poppler-utils (Linux) or poppler (macOS)")
+	}
+	dir, err := os.MkdirTemp("", "bleh-pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-l", strconv.Itoa(maxPDFPages),
+		"-scale-to-x", strconv.Itoa(width), "-scale-to-y", "-1", "-", filepath.Join(dir, "page"))
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// page-1.png or page-01.png and so on, padded alike in one run
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pages)
+	var imgs []image.Image
+	for _, p := range pages {
+		img, err := decodeImage(p)
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("PDF has no pages")
+	}
+	return imgs, nil
+}
@@ -0,0 +1,72 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// pipelineDepth is how many processed jobs may wait for the printer. Sending
+// a job takes longer than processing the next, so one is enough to keep the
+// printer busy without holding a whole batch in memory.
+const pipelineDepth = 1
+
+// preparedJob is a job ready to print, or the error that stopped processing
+type preparedJob struct {
+	job printJob
+	err error
+}
+
+// prepareJobs processes jobs 0 to n-1 with prepare in the background, while
+// the printer works through the ones before. It stops at the first error,
+// which is passed on, or when ctx is done.
+func prepareJobs(ctx context.Context, n int, prepare func(i int) (printJob, error)) <-chan preparedJob {
+	queue := make(chan preparedJob, pipelineDepth)
+	go func() {
+		defer close(queue)
+		for i := 0; i < n; i++ {
+			job, err := prepare(i)
+			if err != nil {
+				err = fmt.Errorf("job %d: %w", i+1, err)
+			}
+			select {
+			case queue <- preparedJob{job: job, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return queue
+}
+
+// printImages prints every image as its own job, stamped with its page
+// number, processing the next one while the current one is sent
+func printImages(ctx context.Context, paths []string, printMode PrintMode, opts imageOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := prepareJobs(ctx, len(paths), func(i int) (printJob, error) {
+		img, err := decodeImage(paths[i])
+		if err != nil {
+			return printJob{}, err
+		}
+		opts := opts
+		opts.stamp = stampFor(paths[i], i+1, len(paths))
+		printMode, opts := resolveAutoMode(img, printMode, opts)
+		pixels, height, err := processImage(img, printMode, opts)
+		return printJob{pixels: pixels, height: height, mode: printMode, intensity: intensityByte()}, err
+	})
+	return printJobQueue(ctx, queue, len(paths))
+}
@@ -0,0 +1,135 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Presets are named bundles of processing options kept in
+// <config dir>/presets.json. --save-preset stores the ones in effect,
+// --preset sets them again, except where given on the command line.
+
+// presetFlags are the options a preset holds
+var presetFlags = []string{
+	"intensity", "quality", "speed", "mode", "dither", "serpentine", "lpi", "angle",
+	"threshold", "threshold-window", "linear", "input-gamma", "levels", "palette",
+	"descreen", "denoise", "edges", "sharpen", "equalize", "clahe", "double-strike",
+	"curve", "pipeline", "filter", "script", "script-max-steps", "background", "deskew", "trim", "trim-level",
+	"margin-top", "margin-bottom", "margin-left", "margin-right",
+	"width-mm", "height-mm", "pad", "min-lines", "feed", "caption-font", "caption-size",
+	"stamp-date", "stamp-corner", "frame",
+}
+
+// shortFlags are the one-letter forms of preset options
+var shortFlags = map[string]string{"i": "intensity", "m": "mode", "d": "dither", "t": "threshold"}
+
+func presetsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "presets.json"), nil
+}
+
+func loadPresets() (map[string]map[string]string, error) {
+	path, err := presetsPath()
+	if err != nil {
+		return nil, err
+	}
+	presets := map[string]map[string]string{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return presets, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return presets, nil
+}
+
+func savePresets(presets map[string]map[string]string) error {
+	path, err := presetsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(presets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// applyPresets sets the options of --preset that weren't given explicitly,
+// then stores the options in effect as --save-preset
+func applyPresets() error {
+	if presetName == "" && savePresetName == "" {
+		return nil
+	}
+	presets, err := loadPresets()
+	if err != nil {
+		return err
+	}
+
+	if presetName != "" {
+		p, ok := presets[presetName]
+		if !ok {
+			names := make([]string, 0, len(presets))
+			for name := range presets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if len(names) == 0 {
+				return fmt.Errorf("no preset %q, save one with --save-preset", presetName)
+			}
+			return fmt.Errorf("no preset %q, there are: %s", presetName, strings.Join(names, ", "))
+		}
+		given := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			given[f.Name] = true
+			if long, ok := shortFlags[f.Name]; ok {
+				given[long] = true
+			}
+		})
+		for name, value := range p {
+			if given[name] {
+				continue
+			}
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("preset %q: bad --%s %q: %v", presetName, name, value, err)
+			}
+		}
+	}
+
+	if savePresetName != "" {
+		p := map[string]string{}
+		for _, name := range presetFlags {
+			if f := flag.Lookup(name); f != nil && f.Value.String() != f.DefValue {
+				p[name] = f.Value.String()
+			}
+		}
+		presets[savePresetName] = p
+		if err := savePresets(presets); err != nil {
+			return err
+		}
+		log.Printf("Saved preset %q", savePresetName)
+	}
+	return nil
+}
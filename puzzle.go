@@ -0,0 +1,316 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+var puzzleCmd = &command{
+	name:  "puzzle",
+	usage: "[--difficulty easy|medium|hard] [--seed N] [--solution] maze|sudoku",
+}
+
+func init() {
+	puzzleCmd.run = runPuzzle
+	registerCommand(puzzleCmd)
+}
+
+// Maze cell sizes and sudoku clue counts per difficulty
+var (
+	mazeCellSize = map[string]int{"easy": 24, "medium": 16, "hard": 10}
+	sudokuClues  = map[string]int{"easy": 40, "medium": 32, "hard": 26}
+)
+
+// runPuzzle prints a random maze or sudoku sized to the paper width, and its
+// solution below it with --solution
+func runPuzzle(ctx context.Context, args []string) error {
+	fs := puzzleCmd.flagSet()
+	difficulty := fs.String("difficulty", "medium", "easy, medium or hard")
+	seed := fs.Int64("seed", 0, "Generate the puzzle of this number again, 0 for a new one")
+	solution := fs.Bool("solution", false, "Print the solution below the puzzle, after a cut line")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (fs.Arg(0) != "maze" && fs.Arg(0) != "sudoku") {
+		fs.Usage()
+		return fmt.Errorf("expected maze or sudoku")
+	}
+	if _, ok := sudokuClues[*difficulty]; !ok {
+		return withCause(errBadInput, fmt.Errorf("invalid --difficulty %q, use easy, medium or hard", *difficulty))
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano() % 1000000
+	}
+	log.Printf("Puzzle %d, print it again with --seed %d", *seed, *seed)
+	rng := rand.New(rand.NewSource(*seed))
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	width := opts.margins.width()
+	var puzzle, solved image.Image
+	switch fs.Arg(0) {
+	case "maze":
+		m := newMaze(rng, width/mazeCellSize[*difficulty])
+		puzzle, solved = m.image(width, false), m.image(width, true)
+	case "sudoku":
+		grid, full := newSudoku(rng, sudokuClues[*difficulty])
+		puzzle, solved = sudokuImage(grid, grid, width), sudokuImage(full, grid, width)
+	}
+	img := puzzle
+	if *solution {
+		l := &layout{width: width, align: "center", scale: 1}
+		l.rule(true)
+		l.text("Solution")
+		img = stackImages(append([]image.Image{puzzle}, append(l.bands, solved)...))
+	}
+	opts.stamp.file = fs.Arg(0) + " " + strconv.FormatInt(*seed, 10)
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// maze is a square grid of cells carved into a perfect maze: every cell can
+// be reached from every other one in exactly one way
+type maze struct {
+	n     int
+	right []bool // open wall to the right of each cell
+	down  []bool // open wall below each cell
+}
+
+// newMaze carves an n by n maze with a randomized depth-first search, which
+// makes long winding corridors
+func newMaze(rng *rand.Rand, n int) *maze {
+	n = max(n, 2)
+	m := &maze{n: n, right: make([]bool, n*n), down: make([]bool, n*n)}
+	visited := make([]bool, n*n)
+	stack := []int{0}
+	visited[0] = true
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		x, y := c%n, c/n
+		var next []int
+		for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			nx, ny := x+d[0], y+d[1]
+			if nx >= 0 && nx < n && ny >= 0 && ny < n && !visited[ny*n+nx] {
+				next = append(next, ny*n+nx)
+			}
+		}
+		if len(next) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		nc := next[rng.Intn(len(next))]
+		switch nc - c {
+		case 1:
+			m.right[c] = true
+		case -1:
+			m.right[nc] = true
+		case n:
+			m.down[c] = true
+		case -n:
+			m.down[nc] = true
+		}
+		visited[nc] = true
+		stack = append(stack, nc)
+	}
+	return m
+}
+
+// path returns the cells from the top left entrance to the bottom right exit
+func (m *maze) path() []int {
+	n := m.n
+	from := make([]int, n*n)
+	for i := range from {
+		from[i] = -1
+	}
+	from[0] = 0
+	queue := []int{0}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		var open []int
+		if c%n < n-1 && m.right[c] {
+			open = append(open, c+1)
+		}
+		if c%n > 0 && m.right[c-1] {
+			open = append(open, c-1)
+		}
+		if c/n < n-1 && m.down[c] {
+			open = append(open, c+n)
+		}
+		if c/n > 0 && m.down[c-n] {
+			open = append(open, c-n)
+		}
+		for _, o := range open {
+			if from[o] < 0 {
+				from[o] = c
+				queue = append(queue, o)
+			}
+		}
+	}
+	path := []int{n*n - 1}
+	for c := n*n - 1; c != 0; c = from[c] {
+		path = append(path, from[c])
+	}
+	return path
+}
+
+// image draws the maze width dots wide, with the solution path if solved
+func (m *maze) image(width int, solved bool) image.Image {
+	cell := (width - 2) / m.n
+	left := (width - cell*m.n) / 2
+	img := imaging.New(width, cell*m.n+2, color.White)
+	box := func(x0, y0, x1, y1 int) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.Black, image.Point{}, draw.Src)
+	}
+	// Outer walls, open at the entrance and the exit
+	size := cell * m.n
+	box(left, 0, left+size+2, 2)
+	box(left, size, left+size+2, size+2)
+	box(left, cell, left+2, size+2)
+	box(left+size, 0, left+size+2, size-cell)
+	for c := 0; c < m.n*m.n; c++ {
+		x, y := left+c%m.n*cell, c/m.n*cell
+		if c%m.n < m.n-1 && !m.right[c] {
+			box(x+cell, y, x+cell+2, y+cell+2)
+		}
+		if c/m.n < m.n-1 && !m.down[c] {
+			box(x, y+cell, x+cell+2, y+cell+2)
+		}
+	}
+	if solved {
+		dot := max(cell/3, 2)
+		for _, c := range m.path() {
+			x, y := left+c%m.n*cell+cell/2+1, c/m.n*cell+cell/2+1
+			box(x-dot/2, y-dot/2, x-dot/2+dot, y-dot/2+dot)
+		}
+	}
+	return img
+}
+
+// newSudoku fills a random valid grid and takes digits away for as long as
+// the puzzle keeps a single solution, down to clues digits. It returns the
+// puzzle, with 0 for blanks, and the solution.
+func newSudoku(rng *rand.Rand, clues int) ([81]int, [81]int) {
+	var full [81]int
+	fillSudoku(&full, rng)
+	grid := full
+	left := 81
+	for _, i := range rng.Perm(81) {
+		if left <= clues {
+			break
+		}
+		digit := grid[i]
+		grid[i] = 0
+		if sudokuSolutions(&grid, 2) != 1 {
+			grid[i] = digit
+			continue
+		}
+		left--
+	}
+	return grid, full
+}
+
+// sudokuFits tells whether digit can go in cell i of g
+func sudokuFits(g *[81]int, i, digit int) bool {
+	row, col := i/9, i%9
+	box := row/3*27 + col/3*3
+	for k := 0; k < 9; k++ {
+		if g[row*9+k] == digit || g[k*9+col] == digit || g[box+k/3*9+k%3] == digit {
+			return false
+		}
+	}
+	return true
+}
+
+// fillSudoku completes g by backtracking, trying digits in a random order
+func fillSudoku(g *[81]int, rng *rand.Rand) bool {
+	i := 0
+	for i < 81 && g[i] != 0 {
+		i++
+	}
+	if i == 81 {
+		return true
+	}
+	for _, d := range rng.Perm(9) {
+		if sudokuFits(g, i, d+1) {
+			g[i] = d + 1
+			if fillSudoku(g, rng) {
+				return true
+			}
+		}
+	}
+	g[i] = 0
+	return false
+}
+
+// sudokuSolutions counts the solutions of g, stopping at limit
+func sudokuSolutions(g *[81]int, limit int) int {
+	i := 0
+	for i < 81 && g[i] != 0 {
+		i++
+	}
+	if i == 81 {
+		return 1
+	}
+	count := 0
+	for d := 1; d <= 9 && count < limit; d++ {
+		if sudokuFits(g, i, d) {
+			g[i] = d
+			count += sudokuSolutions(g, limit-count)
+		}
+	}
+	g[i] = 0
+	return count
+}
+
+// sudokuImage draws grid width dots wide. Digits that aren't clues, which
+// only the solution has, are drawn smaller.
+func sudokuImage(grid, clues [81]int, width int) image.Image {
+	cell := (width - 3) / 9
+	left := (width - cell*9) / 2
+	img := imaging.New(width, cell*9+3, color.White)
+	for k := 0; k <= 9; k++ {
+		t := 1
+		if k%3 == 0 {
+			t = 3
+		}
+		draw.Draw(img, image.Rect(left+k*cell, 0, left+k*cell+t, cell*9+3), image.Black, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(left, k*cell, left+cell*9+3, k*cell+t), image.Black, image.Point{}, draw.Src)
+	}
+	for i, d := range grid {
+		if d == 0 {
+			continue
+		}
+		scale := max(cell/(glyphHeight+6), 1)
+		if clues[i] == 0 {
+			scale = max(scale-1, 1)
+		}
+		x := left + i%9*cell + (cell-glyphWidth*scale)/2 + 1
+		y := i/9*cell + (cell-glyphHeight*scale)/2 + 1
+		drawTextScaled(img, x, y, strconv.Itoa(d), color.Black, scale)
+	}
+	return img
+}
@@ -0,0 +1,72 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// qualityPreset is what --quality sets, for the options not given on their
+// own
+type qualityPreset struct {
+	mode      string
+	dither    string
+	speed     int
+	intensity int
+}
+
+var qualityPresets = map[string]qualityPreset{
+	"draft":  {mode: "1bpp", dither: "none", speed: maxSpeed, intensity: 60},
+	"normal": {mode: "1bpp", dither: "floyd", speed: 3, intensity: 80},
+	"photo":  {mode: "4bpp", dither: "floyd", speed: 1, intensity: 90},
+}
+
+// applyQuality sets the options of the --quality preset that weren't given
+// explicitly
+func applyQuality() error {
+	if quality == "" {
+		return nil
+	}
+	p, ok := qualityPresets[quality]
+	if !ok {
+		return fmt.Errorf("Invalid quality. Use 'draft', 'normal' or 'photo'.")
+	}
+
+	if !flagGiven("mode", "m") {
+		mode = p.mode
+		// Grays only where the chosen model prints them
+		if m, _ := chosenModel(); m != nil && !m.Gray {
+			mode = "1bpp"
+		}
+	}
+	if !flagGiven("dither", "d") {
+		ditherType = p.dither
+	}
+	if !flagGiven("speed") {
+		speed = p.speed
+	}
+	if !flagGiven("intensity", "i") {
+		intensity = p.intensity
+	}
+	return nil
+}
+
+// flagGiven tells whether any of the named flags was on the command line
+func flagGiven(names ...string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			given = given || f.Name == name
+		}
+	})
+	return given
+}
@@ -0,0 +1,271 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var relayCmd = &command{
+	name:  "relay",
+	usage: "[--listen :9100] [--idle 10s] [--text-scale N] [--tokens T,...] [--rate N/period] [--max-queue N] [--max-new N] [--printers [name=]MAC,... [--policy P]]",
+}
+
+func init() {
+	relayCmd.run = runRelay
+	registerCommand(relayCmd)
+}
+
+// relay is a running print server and its settings
+type relay struct {
+	idle      time.Duration
+	textScale int
+	gate      *relayGate
+	pool      *printerPool // nil to print on the usual printer
+}
+
+// runRelay accepts print jobs over raw TCP, like the JetDirect port 9100 of
+// network printers: each connection sends one image, PDF or text file and
+// closes, or stops sending for --idle
+func runRelay(ctx context.Context, args []string) error {
+	fs := relayCmd.flagSet()
+	listen := fs.String("listen", ":9100", "Address to listen on")
+	idle := fs.Duration("idle", 10*time.Second, "End a job when its sender has been quiet this long")
+	textScale := fs.Int("text-scale", 1, "Font scale for text jobs")
+	tokens := fs.String("tokens", "", "Comma-separated tokens, one of which jobs must start with (default: $BLEH_RELAY_TOKENS)")
+	rate := fs.String("rate", "", "Jobs allowed per token, or per address without tokens, e.g. 20/h")
+	maxQueue := fs.Int("max-queue", 10, "Jobs that may be waiting or printing at once, 0 for no limit")
+	maxNew := fs.Int("max-new", 20, "Connections that may be sending their header at once, before they count as jobs, 0 for no limit")
+	printers := fs.String("printers", "", "Comma-separated printers to print on, MAC addresses, each optionally named name=MAC; the first is the default")
+	policy := fs.String("policy", "default", "Where jobs that don't name a printer go: default, round-robin or failover")
+	fs.Parse(args)
+	if *textScale < 1 || *textScale > 8 {
+		return fmt.Errorf("--text-scale must be between 1 and 8")
+	}
+	if *idle < time.Second {
+		return fmt.Errorf("--idle must be at least 1s")
+	}
+	if *maxQueue < 0 || *maxNew < 0 {
+		return fmt.Errorf("--max-queue and --max-new must not be negative")
+	}
+	if *tokens == "" {
+		*tokens = os.Getenv("BLEH_RELAY_TOKENS")
+	}
+	gate := &relayGate{maxQueue: *maxQueue, maxNew: *maxNew, recent: map[string][]time.Time{}}
+	for _, t := range strings.Split(*tokens, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			gate.tokens = append(gate.tokens, t)
+		}
+	}
+	var err error
+	if *rate != "" {
+		if gate.rate, gate.per, err = parseRate(*rate); err != nil {
+			return err
+		}
+	}
+	r := &relay{idle: *idle, textScale: *textScale, gate: gate}
+	if *printers != "" {
+		if r.pool, err = newPrinterPool(*printers, *policy); err != nil {
+			return err
+		}
+		r.pool.keepConnected(ctx)
+		defer r.pool.close()
+	} else if *policy != "default" {
+		return fmt.Errorf("--policy needs several --printers")
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { l.Close() })
+	log.Printf("Relaying print jobs sent to %s, Ctrl-C to stop", l.Addr())
+	if len(gate.tokens) == 0 {
+		log.Println("Warning: no --tokens, anyone who can reach the relay can print")
+	}
+	for {
+		conn, err := l.Accept()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			err := r.job(ctx, conn)
+			if ctx.Err() != nil {
+				return
+			}
+			name := conn.RemoteAddr().String()
+			if err != nil {
+				log.Printf("Job from %s: %v", name, err)
+				fmt.Fprintf(conn, "Error: %v\n", err)
+				return
+			}
+			fmt.Fprintln(conn, "Printed.")
+		}()
+	}
+}
+
+// job reads a job from conn and prints it, if its sender may. Only a sender
+// that authenticated takes a place in the queue.
+func (r *relay) job(ctx context.Context, conn net.Conn) error {
+	in := bufio.NewReader(conn)
+	header, key, err := r.admit(conn, in)
+	if err != nil {
+		return err
+	}
+	if header["PRINTER"] != "" && r.pool == nil {
+		return withCause(errBadInput, fmt.Errorf("the relay has no --printers to pick from"))
+	}
+	if !r.gate.enter() {
+		return errQueueFull
+	}
+	defer r.gate.leave()
+	if !r.gate.allow(key) {
+		return errRateLimited
+	}
+
+	data, err := readJob(conn, in, r.idle)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("no data")
+	}
+	name := conn.RemoteAddr().String()
+	if r.pool == nil {
+		return printReceivedJob(ctx, data, r.textScale, name)
+	}
+	return r.pool.print(ctx, header["PRINTER"], func(ctx context.Context) error {
+		return printReceivedJob(ctx, data, r.textScale, name)
+	})
+}
+
+// admit reads the header of a job and checks its token, returning the
+// header and the key the sender's jobs are counted under
+func (r *relay) admit(conn net.Conn, in *bufio.Reader) (map[string]string, string, error) {
+	if !r.gate.arrive() {
+		return nil, "", errTooManyNew
+	}
+	defer r.gate.settle()
+	header, err := readRelayHeader(conn, in, r.idle)
+	if err != nil {
+		return nil, "", err
+	}
+	key, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if len(r.gate.tokens) > 0 {
+		if key, err = r.gate.authenticate(header["TOKEN"]); err != nil {
+			return nil, "", err
+		}
+	}
+	return header, key, nil
+}
+
+// readRelayHeader reads the lines a job may start with, TOKEN <token> and
+// PRINTER <name>, by their keyword. The whole header has to arrive within
+// idle, however slowly it is sent.
+func readRelayHeader(conn net.Conn, in *bufio.Reader, idle time.Duration) (map[string]string, error) {
+	header := map[string]string{}
+	conn.SetReadDeadline(time.Now().Add(idle))
+	for {
+		start, _ := in.Peek(len("PRINTER "))
+		if !bytes.HasPrefix(start, []byte("TOKEN ")) && !bytes.HasPrefix(start, []byte("PRINTER ")) {
+			return header, nil
+		}
+		line, err := in.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, withCause(errBadInput, fmt.Errorf("header line too long"))
+		} else if err != nil {
+			return nil, err
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(string(line)), " ")
+		header[key] = strings.TrimSpace(value)
+	}
+}
+
+// readJob reads up to fetchMaxBytes from in, what comes from conn, until
+// the sender closes its side of the connection or sends nothing for idle
+func readJob(conn net.Conn, in io.Reader, idle time.Duration) ([]byte, error) {
+	var buf bytes.Buffer
+	chunk := make([]byte, 32<<10)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		n, err := in.Read(chunk)
+		buf.Write(chunk[:n])
+		if buf.Len() > fetchMaxBytes {
+			return nil, withCause(errBadInput, fmt.Errorf("job is too large (limit %d bytes)", fetchMaxBytes))
+		}
+		switch {
+		case err == nil:
+		case err == io.EOF, errors.Is(err, os.ErrDeadlineExceeded):
+			return buf.Bytes(), nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// printReceivedJob prints data whose type isn't known: a PDF, an image or
+// UTF-8 text
+func printReceivedJob(ctx context.Context, data []byte, textScale int, name string) error {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		_, opts, err := imageOptionsFromFlags()
+		if err != nil {
+			return err
+		}
+		pages, err := renderPDF(ctx, data, opts.margins.width())
+		if err != nil {
+			return err
+		}
+		for _, page := range pages {
+			if err := printReceived(ctx, page, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case isImage(data):
+		return printReceivedData(ctx, data, "", textScale, name)
+	case looksLikeESCPOS(data):
+		_, opts, err := imageOptionsFromFlags()
+		if err != nil {
+			return err
+		}
+		img, err := renderESCPOS(data, opts.margins.width())
+		if err != nil {
+			return err
+		}
+		return printReceived(ctx, img, name)
+	case utf8.Valid(data):
+		return printReceivedText(ctx, string(data), textScale, name)
+	}
+	return withCause(errBadInput, fmt.Errorf("not an image, PDF, ESC/POS or text"))
+}
+
+// isImage tells whether data starts like an image of a known format
+func isImage(data []byte) bool {
+	_, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return err == nil
+}
@@ -0,0 +1,56 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRelayHeader(t *testing.T) {
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	for _, tt := range []struct {
+		in     string
+		header map[string]string
+		rest   string
+	}{
+		{"hello\n", map[string]string{}, "hello\n"},
+		{"TOKEN abc\nhello", map[string]string{"TOKEN": "abc"}, "hello"},
+		{"TOKEN abc \r\nPRINTER 2\n\x89PNG", map[string]string{"TOKEN": "abc", "PRINTER": "2"}, "\x89PNG"},
+		{"PRINTER kitchen\nTOKEN x\n", map[string]string{"PRINTER": "kitchen", "TOKEN": "x"}, ""},
+		{"TOKENS abc\n", map[string]string{}, "TOKENS abc\n"},
+		{"", map[string]string{}, ""},
+	} {
+		in := bufio.NewReader(strings.NewReader(tt.in))
+		header, err := readRelayHeader(conn, in, time.Second)
+		rest, _ := io.ReadAll(in)
+		if err != nil || !reflect.DeepEqual(header, tt.header) || string(rest) != tt.rest {
+			t.Errorf("readRelayHeader(%q) = %v, %v, rest %q, want %v, rest %q", tt.in, header, err, rest, tt.header, tt.rest)
+		}
+	}
+
+	in := bufio.NewReaderSize(strings.NewReader("TOKEN "+strings.Repeat("a", 64)+"\n"), 16)
+	if _, err := readRelayHeader(conn, in, time.Second); !errors.Is(err, errBadInput) {
+		t.Errorf("readRelayHeader with a long line = %v, want errBadInput", err)
+	}
+	in = bufio.NewReader(strings.NewReader("TOKEN abc"))
+	if _, err := readRelayHeader(conn, in, time.Second); err != io.EOF {
+		t.Errorf("readRelayHeader with an unfinished line = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A shared relay is kept from wasting paper by tokens, which jobs start
+// with as a line of their own ("TOKEN abc123"), a number of jobs each token
+// (or address, without tokens) may print in a period, and a limit on the
+// jobs waiting at once. Connections still sending their header count against
+// a limit of their own, so idle ones can't take the queue from real jobs.
+
+var (
+	errTooManyNew  = errors.New("too many connections starting at once, try again later")
+	errQueueFull   = errors.New("too many jobs waiting, try again later")
+	errRateLimited = errors.New("too many jobs, try again later")
+	errBadToken    = errors.New("jobs must start with a line TOKEN <token>")
+)
+
+type relayGate struct {
+	tokens   []string
+	rate     int // jobs per period, 0 for no limit
+	per      time.Duration
+	maxQueue int // 0 for no limit
+	maxNew   int // connections yet to send their header, 0 for no limit
+
+	mu       sync.Mutex
+	queued   int
+	newConns int
+	recent   map[string][]time.Time // accepted jobs within the last period, by token or address
+}
+
+// enter takes a place in the queue, unless it is full
+func (g *relayGate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.maxQueue > 0 && g.queued >= g.maxQueue {
+		return false
+	}
+	g.queued++
+	return true
+}
+
+func (g *relayGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.queued--
+}
+
+// arrive counts a connection that has yet to send its header and
+// authenticate, unless there are too many already
+func (g *relayGate) arrive() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.maxNew > 0 && g.newConns >= g.maxNew {
+		return false
+	}
+	g.newConns++
+	return true
+}
+
+// settle is called once the header of an arrived connection was read
+func (g *relayGate) settle() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.newConns--
+}
+
+// allow counts a job for key and tells whether it is within the rate
+func (g *relayGate) allow(key string) bool {
+	if g.rate == 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	recent := g.recent[key][:0]
+	for _, t := range g.recent[key] {
+		if now.Sub(t) < g.per {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= g.rate {
+		g.recent[key] = recent
+		return false
+	}
+	g.recent[key] = append(recent, now)
+	return true
+}
+
+// authenticate checks the token a job came with and returns its name for
+// the rate limit, "token 1" and so on
+func (g *relayGate) authenticate(token string) (string, error) {
+	if token == "" {
+		return "", errBadToken
+	}
+	for i, t := range g.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return "token " + strconv.Itoa(i+1), nil
+		}
+	}
+	return "", fmt.Errorf("unknown token")
+}
+
+// parseRate parses a rate limit like 20/h: a number of jobs per s, m, h or
+// d, or per a duration like 10m
+func parseRate(s string) (int, time.Duration, error) {
+	bad := withCause(errBadInput, fmt.Errorf("bad --rate %q, expected jobs per period like 20/h", s))
+	n, period, ok := strings.Cut(s, "/")
+	jobs, err := strconv.Atoi(n)
+	if !ok || err != nil || jobs < 1 {
+		return 0, 0, bad
+	}
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+	if per, ok := units[period]; ok {
+		return jobs, per, nil
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return 0, 0, bad
+	}
+	return jobs, per, nil
+}
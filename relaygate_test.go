@@ -0,0 +1,99 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		jobs int
+		per  time.Duration
+	}{
+		{"20/h", 20, time.Hour},
+		{"1/s", 1, time.Second},
+		{"5/m", 5, time.Minute},
+		{"100/d", 100, 24 * time.Hour},
+		{"3/10m", 3, 10 * time.Minute},
+	} {
+		jobs, per, err := parseRate(tt.in)
+		if err != nil || jobs != tt.jobs || per != tt.per {
+			t.Errorf("parseRate(%q) = %d, %v, %v, want %d, %v", tt.in, jobs, per, err, tt.jobs, tt.per)
+		}
+	}
+	for _, in := range []string{"", "20", "/h", "0/h", "-1/h", "x/h", "20/", "20/w", "20/-1m", "20/0s"} {
+		if _, _, err := parseRate(in); !errors.Is(err, errBadInput) {
+			t.Errorf("parseRate(%q) = %v, want errBadInput", in, err)
+		}
+	}
+}
+
+func TestRelayGateAllow(t *testing.T) {
+	g := &relayGate{rate: 2, per: time.Hour, recent: map[string][]time.Time{}}
+	for i, want := range []bool{true, true, false, false} {
+		if got := g.allow("token 1"); got != want {
+			t.Errorf("allow #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if !g.allow("token 2") {
+		t.Errorf("allow for another key = false, want true")
+	}
+
+	// jobs older than the period no longer count
+	g.recent["token 1"] = []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(-time.Minute)}
+	if !g.allow("token 1") {
+		t.Errorf("allow after the period = false, want true")
+	}
+	if g.allow("token 1") {
+		t.Errorf("allow over the rate = true, want false")
+	}
+
+	unlimited := &relayGate{}
+	for i := 0; i < 10; i++ {
+		if !unlimited.allow("x") {
+			t.Fatalf("allow without a rate = false, want true")
+		}
+	}
+}
+
+func TestRelayGateQueue(t *testing.T) {
+	g := &relayGate{maxQueue: 1, maxNew: 1}
+	if !g.enter() || g.enter() {
+		t.Errorf("enter with maxQueue 1 should admit one job")
+	}
+	g.leave()
+	if !g.enter() {
+		t.Errorf("enter after leave = false, want true")
+	}
+	if !g.arrive() || g.arrive() {
+		t.Errorf("arrive with maxNew 1 should admit one connection")
+	}
+	g.settle()
+	if !g.arrive() {
+		t.Errorf("arrive after settle = false, want true")
+	}
+}
+
+func TestRelayGateAuthenticate(t *testing.T) {
+	g := &relayGate{tokens: []string{"abc", "def"}}
+	if name, err := g.authenticate("def"); err != nil || name != "token 2" {
+		t.Errorf("authenticate(%q) = %q, %v, want \"token 2\"", "def", name, err)
+	}
+	for _, token := range []string{"", "ab", "abcd", "ABC"} {
+		if _, err := g.authenticate(token); err == nil {
+			t.Errorf("authenticate(%q) succeeded, want an error", token)
+		}
+	}
+}
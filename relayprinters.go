@@ -0,0 +1,179 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// printerPool is the printers a relay prints on, each of which can be
+// printing a job of its own at the same time
+type printerPool struct {
+	printers []*printerTarget // the first is the default
+	policy   string           // for jobs that don't name a printer: default, round-robin or failover
+
+	mu   sync.Mutex
+	next int // where round-robin starts next
+}
+
+// newPrinterPool parses --printers, [name=]MAC,... and checks --policy
+func newPrinterPool(list, policy string) (*printerPool, error) {
+	switch policy {
+	case "default", "round-robin", "failover":
+	default:
+		return nil, withCause(errBadInput, fmt.Errorf("unknown --policy %q, use default, round-robin or failover", policy))
+	}
+	p := &printerPool{policy: policy}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(item, "=")
+		if !ok {
+			name, addr = item, item
+		}
+		if name == "" || addr == "" {
+			return nil, withCause(errBadInput, fmt.Errorf("bad --printers entry %q, expected [name=]MAC", item))
+		}
+		if p.lookup(name) != nil {
+			return nil, withCause(errBadInput, fmt.Errorf("printer %q is in --printers twice", name))
+		}
+		p.printers = append(p.printers, &printerTarget{name: name, address: addr})
+	}
+	if len(p.printers) == 0 {
+		return nil, withCause(errBadInput, fmt.Errorf("--printers has no printers"))
+	}
+	return p, nil
+}
+
+func (p *printerPool) lookup(name string) *printerTarget {
+	for _, t := range p.printers {
+		if strings.EqualFold(t.name, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// keepConnected gives every printer a connection of its own with
+// --stay-connected, in place of the one for the usual printer
+func (p *printerPool) keepConnected(ctx context.Context) {
+	if shared == nil {
+		return
+	}
+	shared.close()
+	shared = nil
+	for _, t := range p.printers {
+		t.shared = &sharedConn{}
+		go t.shared.keepAlive(onPrinter(ctx, t), keepAliveInterval)
+	}
+}
+
+func (p *printerPool) close() {
+	for _, t := range p.printers {
+		if t.shared != nil {
+			t.shared.close()
+		}
+	}
+}
+
+// print runs job on the printer named, or on one the policy picks. With
+// round-robin or failover a job moves on to the next printer when one fails
+// for a reason another printer may not have, like being out of paper.
+func (p *printerPool) print(ctx context.Context, name string, job func(context.Context) error) error {
+	var order []*printerTarget
+	if name != "" {
+		t := p.lookup(name)
+		if t == nil {
+			names := make([]string, len(p.printers))
+			for i, t := range p.printers {
+				names[i] = t.name
+			}
+			return withCause(errBadInput, fmt.Errorf("no printer %q, there are %s", name, strings.Join(names, ", ")))
+		}
+		order = []*printerTarget{t}
+	} else {
+		order = p.order()
+	}
+
+	tried := map[*printerTarget]bool{}
+	for {
+		t := claimPrinter(order, tried)
+		if t == nil {
+			return fmt.Errorf("all printers failed")
+		}
+		tried[t] = true
+		if len(p.printers) > 1 {
+			log.Printf("Job goes to %s", t.name)
+		}
+		err := job(onPrinter(ctx, t))
+		t.mu.Unlock()
+		if err == nil || name != "" || p.policy == "default" || !printerTrouble(err) {
+			return err
+		}
+		if len(tried) == len(order) {
+			return err
+		}
+		log.Printf("%s: %v, trying the next printer", t.name, err)
+	}
+}
+
+// order is the order to try the printers in for a job
+func (p *printerPool) order() []*printerTarget {
+	switch p.policy {
+	case "default":
+		return p.printers[:1]
+	case "failover":
+		return p.printers
+	}
+	p.mu.Lock()
+	start := p.next
+	p.next = (p.next + 1) % len(p.printers)
+	p.mu.Unlock()
+	return append(p.printers[start:len(p.printers):len(p.printers)], p.printers[:start]...)
+}
+
+// claimPrinter takes the first idle printer in order that wasn't tried
+// yet, or waits for the first one if all are busy
+func claimPrinter(order []*printerTarget, tried map[*printerTarget]bool) *printerTarget {
+	var first *printerTarget
+	for _, t := range order {
+		if tried[t] {
+			continue
+		}
+		if first == nil {
+			first = t
+		}
+		if t.mu.TryLock() {
+			return t
+		}
+	}
+	if first != nil {
+		first.mu.Lock()
+	}
+	return first
+}
+
+// printerTrouble tells whether err is about the printer, not the job
+func printerTrouble(err error) bool {
+	for _, cause := range []error{errPrinterNotFound, errConnect, errNoPaper, errOverheated, errLowBattery, errTransfer, errTimeout} {
+		if errors.Is(err, cause) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// Bots and other long-running receivers print whatever arrives as a job of
+// its own, processed with the global options, one at a time
+
+var receivedMu sync.Mutex
+
+// Text that comes in is refused past these, so that a sender can't run out
+// the roll: its length is checked before it is laid out, its height after
+const (
+	maxTextJobBytes = 16 << 10
+	maxTextJobLines = 8000 // a meter of paper
+)
+
+// printReceived prints an image that came in from name, e.g. a chat
+func printReceived(ctx context.Context, img image.Image, name string) error {
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	opts.stamp.file = name
+	printMode, opts = resolveAutoMode(img, printMode, opts)
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	// Jobs sent to a given printer are already its only one
+	if targetOf(ctx) == nil {
+		receivedMu.Lock()
+		defer receivedMu.Unlock()
+	}
+	log.Printf("Printing from %s", name)
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// printReceivedText prints text that came in from name in the built-in
+// font blown up by scale
+func printReceivedText(ctx context.Context, text string, scale int, name string) error {
+	if len(text) > maxTextJobBytes {
+		return withCause(errBadInput, fmt.Errorf("text is too long (limit %d bytes)", maxTextJobBytes))
+	}
+	_, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	img := textImage(text, opts.margins.width(), scale)
+	if h := img.Bounds().Dy(); h > maxTextJobLines {
+		return withCause(errBadInput, fmt.Errorf("text is too long, %d lines printed (limit %d)", h, maxTextJobLines))
+	}
+	return printReceived(ctx, img, name)
+}
+
+// printReceivedData decodes and prints an image, with its caption under it
+func printReceivedData(ctx context.Context, data []byte, caption string, textScale int, name string) error {
+	img, err := decodeImageFromReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if caption != "" {
+		_, opts, err := imageOptionsFromFlags()
+		if err != nil {
+			return err
+		}
+		width := opts.margins.width()
+		img = stackImages([]image.Image{imaging.Resize(img, width, 0, imaging.Lanczos), textImage(caption, width, textScale)})
+	}
+	return printReceived(ctx, img, name)
+}
@@ -0,0 +1,30 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPrintReceivedTextTooLong(t *testing.T) {
+	for name, text := range map[string]string{
+		"bytes": strings.Repeat("a", maxTextJobBytes+1),
+		"lines": strings.Repeat("\n", maxTextJobLines/10),
+	} {
+		err := printReceivedText(context.Background(), text, 1, "test")
+		if !errors.Is(err, errBadInput) || !strings.Contains(err.Error(), "too long") {
+			t.Errorf("%s: printReceivedText = %v, want it refused as too long", name, err)
+		}
+	}
+}
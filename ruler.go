@@ -0,0 +1,146 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+var rulerCmd = &command{
+	name:  "ruler",
+	usage: "[--length 30cm] [--units metric|imperial|both]",
+}
+
+func init() {
+	rulerCmd.run = runRuler
+	registerCommand(rulerCmd)
+}
+
+// Tick lengths across the paper, in dots
+const (
+	rulerTickShort  = 16
+	rulerTickMedium = 28
+	rulerTickLong   = 44
+)
+
+// runRuler prints a ruler along the paper, dot-exact at --dpi: centimeters
+// and millimeters on the left edge, inches and sixteenths on the right
+func runRuler(ctx context.Context, args []string) error {
+	fs := rulerCmd.flagSet()
+	length := fs.String("length", "30cm", "Ruler length, in cm, mm or in")
+	units := fs.String("units", "both", "metric, imperial or both")
+	fs.Parse(args)
+
+	mm, err := parsePaperLength(*length)
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	if *units != "metric" && *units != "imperial" && *units != "both" {
+		return withCause(errBadInput, fmt.Errorf("invalid --units %q, use metric, imperial or both", *units))
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	if opts.size != (size{}) {
+		return withCause(errBadInput, fmt.Errorf("a ruler can't be scaled, leave out --width-mm and --height-mm"))
+	}
+	img := rulerImage(mm, *units, opts.margins.width())
+	opts.stamp.file = "ruler"
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// maxPaperMM is the longest ruler or paper pattern printed, 10 m
+const maxPaperMM = 10000
+
+// parsePaperLength reads a length like 30cm, 150mm or 12in as millimeters
+func parsePaperLength(s string) (float64, error) {
+	v := strings.TrimSpace(strings.ToLower(s))
+	unit := 0.0
+	for _, u := range []struct {
+		suffix string
+		mm     float64
+	}{{"mm", 1}, {"cm", 10}, {"in", 25.4}, {`"`, 25.4}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, unit = strings.TrimSuffix(v, u.suffix), u.mm
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if unit == 0 || err != nil || n <= 0 || n*unit > maxPaperMM {
+		return 0, fmt.Errorf("invalid length %q, use e.g. 30cm, 150mm or 12in, up to %d m", s, maxPaperMM/1000)
+	}
+	return n * unit, nil
+}
+
+// rulerImage draws a ruler mm long and width dots wide, with every tick
+// rounded to the nearest line at the current resolution
+func rulerImage(mm float64, units string, width int) image.Image {
+	dots := func(mm float64) int { return int(math.Round(mm * dpi / 25.4)) }
+	img := imaging.New(width, dots(mm)+glyphHeight, color.White)
+	// Ticks are 2 lines thick, starting on their exact line
+	tick := func(y, x0, x1 int) {
+		draw.Draw(img, image.Rect(x0, y, x1, y+2), image.Black, image.Point{}, draw.Src)
+	}
+
+	// The start line runs across, to cut along
+	tick(0, 0, width)
+	if units != "imperial" {
+		for i := 1; float64(i) <= mm; i++ {
+			y := dots(float64(i))
+			switch {
+			case i%10 == 0:
+				tick(y, 0, rulerTickLong)
+				drawText(img, rulerTickLong+4, y-glyphHeight/2, strconv.Itoa(i/10), color.Black)
+			case i%5 == 0:
+				tick(y, 0, rulerTickMedium)
+			default:
+				tick(y, 0, rulerTickShort)
+			}
+		}
+		drawText(img, rulerTickLong+4, 4, "cm", color.Black)
+	}
+	if units != "metric" {
+		for i := 1; float64(i)*25.4/16 <= mm; i++ {
+			y := dots(float64(i) * 25.4 / 16)
+			switch {
+			case i%16 == 0:
+				tick(y, width-rulerTickLong, width)
+				label := strconv.Itoa(i / 16)
+				drawText(img, width-rulerTickLong-4-textWidth(label), y-glyphHeight/2, label, color.Black)
+			case i%8 == 0:
+				tick(y, width-rulerTickMedium-8, width)
+			case i%4 == 0:
+				tick(y, width-rulerTickMedium, width)
+			case i%2 == 0:
+				tick(y, width-rulerTickShort-6, width)
+			default:
+				tick(y, width-rulerTickShort, width)
+			}
+		}
+		drawText(img, width-rulerTickLong-4-textWidth("in"), 4, "in", color.Black)
+	}
+	return img
+}
@@ -0,0 +1,306 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"sync"
+
+	"bleh/imageproc"
+
+	"github.com/disintegration/imaging"
+	"go.starlark.net/starlark"
+)
+
+// --script loads a Starlark file (a small dialect of Python) that can
+// define any of these hooks:
+//
+//	on_image(image, info)   gets every image laid out at the print width
+//	                        and returns it changed, or None to keep it
+//	on_before_print(job)    may change job["intensity"] (0-100) or
+//	                        job["printer"] (an address), or return False
+//	                        to skip the job
+//	on_complete(job, error) is told how a job went; error is None or a
+//	                        message
+//
+// Images have width and height, apply(step, value, **params) to run an
+// imageproc step on them and watermark(text, position, scale) to write on
+// them; both return a new image.
+
+// scriptHooks is a loaded script. Starlark threads aren't safe for
+// concurrent use, so hooks are called one at a time. In a batch on_image runs
+// on the goroutine processing the next job while on_before_print and
+// on_complete run on the one printing, so a slow or stuck hook holds up
+// both; --script-max-steps bounds every call.
+type scriptHooks struct {
+	mu      sync.Mutex
+	thread  *starlark.Thread
+	globals starlark.StringDict
+}
+
+var hooks *scriptHooks // nil without --script
+
+func loadScript(path string) (*scriptHooks, error) {
+	thread := &starlark.Thread{
+		Name:  "bleh",
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("Script: %s", msg) },
+	}
+	limitSteps(thread)
+	globals, err := starlark.ExecFile(thread, path, nil, nil)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	known := map[string]bool{"on_image": true, "on_before_print": true, "on_complete": true}
+	found := false
+	for name, v := range globals {
+		if _, ok := v.(starlark.Callable); ok && known[name] {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s defines none of on_image, on_before_print and on_complete", path)
+	}
+	return &scriptHooks{thread: thread, globals: globals}, nil
+}
+
+// limitSteps lets the next call on thread take --script-max-steps steps,
+// counting from the ones it took before
+func limitSteps(thread *starlark.Thread) {
+	thread.Uncancel()
+	if scriptMaxSteps > 0 {
+		thread.SetMaxExecutionSteps(thread.ExecutionSteps() + scriptMaxSteps)
+	}
+}
+
+// scriptError includes where a script failed
+func scriptError(err error) error {
+	var e *starlark.EvalError
+	if errors.As(err, &e) {
+		return fmt.Errorf("script: %s", e.Backtrace())
+	}
+	return fmt.Errorf("script: %v", err)
+}
+
+// call runs the hook name if the script defines it
+func (h *scriptHooks) call(name string, args ...starlark.Value) (starlark.Value, bool, error) {
+	if h == nil {
+		return nil, false, nil
+	}
+	fn, ok := h.globals[name].(starlark.Callable)
+	if !ok {
+		return nil, false, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	limitSteps(h.thread)
+	v, err := starlark.Call(h.thread, fn, args, nil)
+	if err != nil {
+		return nil, true, scriptError(err)
+	}
+	return v, true, nil
+}
+
+// scriptImageHook runs on_image on a laid out image
+func scriptImageHook(img image.Image, printMode PrintMode, info stampInfo) (image.Image, error) {
+	d := starlark.NewDict(4)
+	d.SetKey(starlark.String("source"), starlark.String(info.file))
+	d.SetKey(starlark.String("page"), starlark.MakeInt(info.page))
+	d.SetKey(starlark.String("pages"), starlark.MakeInt(info.pages))
+	d.SetKey(starlark.String("mode"), starlark.String(modeName(printMode)))
+	v, ok, err := hooks.call("on_image", &scriptImage{img: img}, d)
+	if err != nil || !ok || v == starlark.None {
+		return img, err
+	}
+	si, isImage := v.(*scriptImage)
+	if !isImage {
+		return nil, fmt.Errorf("script: on_image returned a %s instead of an image", v.Type())
+	}
+	return si.img, nil
+}
+
+// jobDict is what hooks get to know about a print job
+func jobDict(ctx context.Context, job printJob) *starlark.Dict {
+	d := starlark.NewDict(6)
+	d.SetKey(starlark.String("source"), starlark.String(jobSource))
+	d.SetKey(starlark.String("mode"), starlark.String(modeName(job.mode)))
+	d.SetKey(starlark.String("lines"), starlark.MakeInt(job.height))
+	d.SetKey(starlark.String("length_mm"), starlark.Float(float64(job.height)*25.4/dpi))
+	d.SetKey(starlark.String("intensity"), starlark.MakeInt(int(job.intensity)))
+	d.SetKey(starlark.String("printer"), starlark.String(printerAddress(ctx)))
+	return d
+}
+
+// scriptBeforePrint runs on_before_print on job, applying what the script
+// changed. It returns the context to print the job under, or ok false when
+// the script wants the job skipped. Within a batch the printer is already
+// connected, so it can't be changed.
+func scriptBeforePrint(ctx context.Context, job *printJob, batch bool) (context.Context, *starlark.Dict, bool, error) {
+	d := jobDict(ctx, *job)
+	v, called, err := hooks.call("on_before_print", d)
+	if err != nil || !called {
+		return ctx, d, true, err
+	}
+	if v == starlark.False {
+		log.Println("Job skipped by the script")
+		return ctx, d, false, nil
+	}
+
+	if v, found, _ := d.Get(starlark.String("intensity")); found {
+		i, err := starlark.AsInt32(v)
+		if err != nil || i < 0 || i > 100 {
+			return ctx, d, false, fmt.Errorf("script: intensity must be from 0 to 100, not %s", v)
+		}
+		job.intensity = byte(i)
+	}
+	if v, found, _ := d.Get(starlark.String("printer")); found {
+		addr, isString := starlark.AsString(v)
+		switch {
+		case !isString:
+			return ctx, d, false, fmt.Errorf("script: printer must be an address, not %s", v)
+		case addr == printerAddress(ctx):
+		case batch:
+			log.Printf("Script: can't send a job of a batch to %s, the printer is already connected", addr)
+		default:
+			log.Printf("Script: sending the job to %s", addr)
+			ctx = onPrinter(ctx, &printerTarget{name: addr, address: addr})
+		}
+	}
+	return ctx, d, true, nil
+}
+
+// scriptComplete runs on_complete with how the job went
+func scriptComplete(job *starlark.Dict, jobErr error) {
+	if job == nil {
+		return
+	}
+	var e starlark.Value = starlark.None
+	if jobErr != nil {
+		e = starlark.String(jobErr.Error())
+	}
+	if _, _, err := hooks.call("on_complete", job, e); err != nil {
+		log.Println(err)
+	}
+}
+
+// scriptImage is an image as scripts see it
+type scriptImage struct {
+	img image.Image
+}
+
+func (s *scriptImage) String() string {
+	return fmt.Sprintf("<image %dx%d>", s.img.Bounds().Dx(), s.img.Bounds().Dy())
+}
+func (s *scriptImage) Type() string          { return "image" }
+func (s *scriptImage) Freeze()               {}
+func (s *scriptImage) Truth() starlark.Bool  { return starlark.True }
+func (s *scriptImage) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: image") }
+
+func (s *scriptImage) AttrNames() []string {
+	return []string{"apply", "height", "watermark", "width"}
+}
+
+func (s *scriptImage) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "width":
+		return starlark.MakeInt(s.img.Bounds().Dx()), nil
+	case "height":
+		return starlark.MakeInt(s.img.Bounds().Dy()), nil
+	case "apply":
+		return starlark.NewBuiltin("apply", s.apply), nil
+	case "watermark":
+		return starlark.NewBuiltin("watermark", s.watermark), nil
+	}
+	return nil, nil
+}
+
+// apply runs an imageproc step, e.g. image.apply("rotate", 180) or
+// image.apply("crop", x=0, y=10, height=200)
+func (s *scriptImage) apply(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var step string
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, nil, 1, &step, &value); err != nil {
+		return nil, err
+	}
+	params := map[string]any{}
+	if value != nil {
+		v, err := fromStarlark(value)
+		if err != nil {
+			return nil, err
+		}
+		params["value"] = v
+	}
+	for _, kv := range kwargs {
+		v, err := fromStarlark(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		params[string(kv[0].(starlark.String))] = v
+	}
+	var p imageproc.Pipeline
+	if err := p.Add(step, params); err != nil {
+		return nil, err
+	}
+	return &scriptImage{img: p.Apply(s.img)}, nil
+}
+
+// watermark writes text in black in a corner, or the center, of the image
+func (s *scriptImage) watermark(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text string
+	position, scale := "bottom-right", 1
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text, "position?", &position, "scale?", &scale); err != nil {
+		return nil, err
+	}
+	if scale < 1 || scale > 8 {
+		return nil, fmt.Errorf("%s: scale must be from 1 to 8", b.Name())
+	}
+	const margin = 8
+	dst := imaging.Clone(s.img)
+	bounds := dst.Bounds()
+	w, h := len([]rune(text))*glyphWidth*scale, glyphHeight*scale
+	var x, y int
+	switch position {
+	case "top-left":
+		x, y = margin, margin
+	case "top-right":
+		x, y = bounds.Dx()-w-margin, margin
+	case "bottom-left":
+		x, y = margin, bounds.Dy()-h-margin
+	case "bottom-right":
+		x, y = bounds.Dx()-w-margin, bounds.Dy()-h-margin
+	case "center":
+		x, y = (bounds.Dx()-w)/2, (bounds.Dy()-h)/2
+	default:
+		return nil, fmt.Errorf("%s: position must be top-left, top-right, bottom-left, bottom-right or center", b.Name())
+	}
+	drawTextScaled(dst, max(x, 0), max(y, 0), text, color.Black, scale)
+	return &scriptImage{img: dst}, nil
+}
+
+// fromStarlark converts a step parameter to what imageproc expects
+func fromStarlark(v starlark.Value) (any, error) {
+	switch v := v.(type) {
+	case starlark.Int:
+		i, err := starlark.AsInt32(v)
+		return i, err
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bool:
+		return bool(v), nil
+	}
+	return nil, fmt.Errorf("unsupported parameter of type %s", v.Type())
+}
@@ -0,0 +1,131 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	stampPadding     = 4 // blank lines between the text and the image
+	stampLineSpacing = 2
+)
+
+// stampInfo holds the values substituted into --header and --footer
+type stampInfo struct {
+	file        string
+	page, pages int
+	taken       time.Time // when the photo was taken, if its EXIF says
+}
+
+// stampFor is the stampInfo of the image at path, page of pages
+func stampFor(path string, page, pages int) stampInfo {
+	return stampInfo{file: sourceName(path), page: page, pages: pages, taken: captureTime(path)}
+}
+
+// expandStamp fills in the placeholders of a header or footer template:
+// {file}, {date}, {time}, {datetime}, {page} and {pages}. A literal "\n"
+// starts a new line.
+func expandStamp(tmpl string, info stampInfo, now time.Time) string {
+	file := info.file
+	if file == "" || file == "-" {
+		file = "stdin"
+	}
+	return strings.NewReplacer(
+		`\n`, "\n",
+		"{file}", file,
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("15:04"),
+		"{datetime}", now.Format("2006-01-02 15:04"),
+		"{page}", strconv.Itoa(info.page),
+		"{pages}", strconv.Itoa(info.pages),
+	).Replace(tmpl)
+}
+
+// stampText adds the expanded header above img and the footer below it.
+// Lines too long for the image are cut off.
+func stampText(img image.Image, header, footer string, info stampInfo) image.Image {
+	if header == "" && footer == "" {
+		return img
+	}
+	now := time.Now()
+	top := textBlock(expandStamp(header, info, now), img.Bounds().Dx())
+	bottom := textBlock(expandStamp(footer, info, now), img.Bounds().Dx())
+
+	parts := make([]image.Image, 0, 3)
+	if top != nil {
+		parts = append(parts, top)
+	}
+	parts = append(parts, img)
+	if bottom != nil {
+		parts = append(parts, bottom)
+	}
+	return stackImages(parts)
+}
+
+// textBlock renders s, one line per row of text, on a white band width dots
+// wide with stampPadding lines above and below
+func textBlock(s string, width int) image.Image {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	maxChars := width / glyphWidth
+	height := len(lines)*(glyphHeight+stampLineSpacing) - stampLineSpacing + 2*stampPadding
+	img := imaging.New(width, height, color.White)
+	for i, line := range lines {
+		if r := []rune(line); len(r) > maxChars {
+			line = string(r[:maxChars])
+		}
+		drawText(img, 0, stampPadding+i*(glyphHeight+stampLineSpacing), line, color.Black)
+	}
+	return img
+}
+
+// dateCorners are where --stamp-date can go
+var dateCorners = []string{"bottom-right", "bottom-left", "top-right", "top-left"}
+
+// stampDate writes when the photo was taken, or else the current date and
+// time, in a corner of img on a small white patch, like cameras used to
+func stampDate(img image.Image, corner string, info stampInfo) image.Image {
+	if corner == "" {
+		return img
+	}
+	t := info.taken
+	if t.IsZero() {
+		t = time.Now()
+	}
+	text := t.Format("2006-01-02 15:04")
+	b := img.Bounds()
+	w, h := textWidth(text)+2*stampLineSpacing, glyphHeight+2*stampLineSpacing
+	if w+2*stampPadding > b.Dx() || h+2*stampPadding > b.Dy() {
+		return img // no room for it
+	}
+	x, y := stampPadding, stampPadding
+	if strings.HasSuffix(corner, "right") {
+		x = b.Dx() - w - stampPadding
+	}
+	if strings.HasPrefix(corner, "bottom") {
+		y = b.Dy() - h - stampPadding
+	}
+	out := imaging.Clone(img)
+	patch := image.Rect(x, y, x+w, y+h)
+	draw.Draw(out, patch, image.White, image.Point{}, draw.Src)
+	drawText(out, x+stampLineSpacing, y+stampLineSpacing, text, color.Black)
+	return out
+}
@@ -0,0 +1,269 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"bleh/drivers"
+)
+
+// printerStatus is a printer's reply to a status query
+type printerStatus struct {
+	ok          bool
+	state       string // what the printer is doing, or what is wrong with it
+	battery     int    // percent, or unknownLevel
+	temperature int    // °C, or unknownLevel
+}
+
+// unknownLevel is the battery or temperature of models that don't report it
+const unknownLevel = drivers.Unknown
+
+func (s printerStatus) String() string {
+	level := func(v int) string {
+		if v == unknownLevel {
+			return "unknown"
+		}
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprintf("Status: %v (%s), Battery: %s, Temp: %s", s.ok, s.state, level(s.battery), level(s.temperature))
+}
+
+// queryStatus connects to the printer and asks for its status
+func queryStatus(ctx context.Context) (printerStatus, error) {
+	var s printerStatus
+	err := withPrinter(ctx, func(c *printerConn) error {
+		var err error
+		s, err = c.status(ctx)
+		return err
+	})
+	return s, err
+}
+
+// feedPaper connects to the printer and ejects lines of paper
+func feedPaper(ctx context.Context, lines uint) error {
+	return withPrinter(ctx, func(c *printerConn) error {
+		return c.driver.Feed(lines)
+	})
+}
+
+// statusReport is what --status --json prints
+type statusReport struct {
+	Time        string      `json:"time,omitempty"`
+	OK          bool        `json:"ok"`
+	State       string      `json:"state"`
+	Battery     int         `json:"battery"`
+	Temperature int         `json:"temperature"`
+	Paper       paperReport `json:"paper"`
+}
+
+func (s printerStatus) report() statusReport {
+	r := statusReport{
+		OK:          s.ok,
+		State:       s.state,
+		Battery:     s.battery,
+		Temperature: s.temperature,
+	}
+	if u, err := currentPaperUsage(); err == nil {
+		r.Paper = u.report()
+	}
+	return r
+}
+
+func printStatusJSON(ctx context.Context) error {
+	s, err := queryStatus(ctx)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(s.report())
+}
+
+// countReport is what --querycount --json prints
+type countReport struct {
+	LinesPrinted  int `json:"lines_printed"`
+	PrintsSinceOn int `json:"prints_since_power_on"`
+}
+
+// printCountJSON asks for the printer's counter and prints it as JSON
+func printCountJSON(ctx context.Context) error {
+	return withPrinter(ctx, func(c *printerConn) error {
+		c.quiet.Store(true)
+		defer c.quiet.Store(false)
+		c.drain()
+		if err := c.driver.Query(drivers.AskCount); err != nil {
+			return err
+		}
+		select {
+		case n := <-c.counts:
+			return json.NewEncoder(os.Stdout).Encode(countReport{LinesPrinted: n.Lines, PrintsSinceOn: n.Prints})
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("no counter reply, the %s may not have one", c.model.Name)
+		}
+	})
+}
+
+var statusCmd = &command{
+	name:  "status",
+	usage: "[--watch [--interval 30s]] [--json]",
+}
+
+func init() {
+	statusCmd.run = runStatus
+	registerCommand(statusCmd)
+}
+
+func runStatus(ctx context.Context, args []string) error {
+	fs := statusCmd.flagSet()
+	watch := fs.Bool("watch", false, "Keep the connection open and report every change")
+	interval := fs.Duration("interval", 30*time.Second, "How often to poll the printer with --watch")
+	fs.Parse(args)
+
+	if !*watch {
+		s, err := queryStatus(ctx)
+		if err != nil {
+			return err
+		}
+		reportStatus(s)
+		return nil
+	}
+	if *interval < time.Second {
+		return fmt.Errorf("--interval must be at least 1s")
+	}
+	return watchStatus(ctx, *interval)
+}
+
+// reportStatus prints a status as a line of text, or of JSON with --json
+func reportStatus(s printerStatus) {
+	now := time.Now()
+	if jsonOutput {
+		r := s.report()
+		r.Time = now.Format(time.RFC3339)
+		json.NewEncoder(os.Stdout).Encode(r)
+		return
+	}
+	fmt.Printf("%s %v\n", now.Format("2006-01-02 15:04:05"), s)
+}
+
+// watchStatus polls the printer over a single connection and reports the
+// status whenever it changes, until the printer stops answering
+func watchStatus(ctx context.Context, interval time.Duration) error {
+	c, err := openPrinter(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last *printerStatus
+	misses := 0
+	for {
+		s, err := c.status(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return nil // Ctrl-C or --timeout is how watching ends
+		case err != nil:
+			misses++
+			log.Printf("%v (%d in a row)", err, misses)
+			if misses == 3 {
+				return fmt.Errorf("printer stopped responding")
+			}
+		default:
+			misses = 0
+			if last == nil || *last != s {
+				reportStatus(s)
+				last = &s
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Below this battery level the head voltage sags and prints come out faded
+const lowBatteryLevel = 20
+
+// Thermal pacing: from hotHeadTemp on, sending pauses after every
+// drivers.PacingBatch lines, longer the hotter the head is, so the firmware doesn't
+// abort the print for overheating halfway through
+const (
+	hotHeadTemp     = 55
+	pacingPausePerC = 40 * time.Millisecond
+)
+
+// checkPrinter asks for the printer status before a print and returns the
+// pause to insert between line batches. It fails when the battery is below
+// --min-battery and warns when the battery is low or the head hot. An
+// overheated printer is given a couple of minutes to cool down. A printer
+// that doesn't answer is only an error if --min-battery was asked for.
+func checkPrinter(ctx context.Context, c *printerConn) (time.Duration, error) {
+	var s printerStatus
+	for wait := 0; ; wait++ {
+		c.drain()
+		if err := c.driver.Query(drivers.AskStatus); err != nil {
+			return 0, err
+		}
+		select {
+		case s = <-c.replies:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(3 * time.Second):
+			if minBattery > 0 {
+				return 0, withCause(errLowBattery, fmt.Errorf("no status reply, can't check the battery level"))
+			}
+			log.Println("No status reply, printing anyway")
+			return 0, nil
+		}
+		if s.ok || s.state != "Overheated" {
+			break
+		}
+		if wait == 12 {
+			return 0, fmt.Errorf("%w, still after 2 minutes", errOverheated)
+		}
+		log.Printf("Printer overheated (%d°C), waiting for it to cool down", s.temperature)
+		if err := sleepCtx(ctx, 10*time.Second); err != nil {
+			return 0, err
+		}
+	}
+
+	switch {
+	case !s.ok && s.state == "No paper":
+		return 0, errNoPaper
+	case !s.ok && s.state == "Low battery":
+		return 0, withCause(errLowBattery, fmt.Errorf("printer reports a low battery"))
+	}
+	if minBattery > 0 && s.battery == unknownLevel {
+		return 0, withCause(errLowBattery, fmt.Errorf("the %s doesn't report its battery level", c.model.Name))
+	}
+	if minBattery > 0 && s.battery < minBattery {
+		return 0, withCause(errLowBattery, fmt.Errorf("battery at %d%%, below --min-battery %d%%", s.battery, minBattery))
+	}
+	if s.battery != unknownLevel && s.battery < lowBatteryLevel {
+		log.Printf("Warning: battery at %d%%, prints may come out faded. Charge the printer for best results.", s.battery)
+	}
+
+	if s.temperature < hotHeadTemp {
+		return 0, nil
+	}
+	pause := time.Duration(s.temperature-hotHeadTemp+1) * pacingPausePerC
+	log.Printf("Warning: print head at %d°C, pausing %v every %d lines to let it cool", s.temperature, pause, drivers.PacingBatch)
+	return pause, nil
+}
@@ -0,0 +1,163 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// With --stdin-format, "-" is a stream of images rather than a single one:
+// a tar stream (tar) or images each preceded by their length as a 4-byte
+// big-endian number (frames, where a length of 0 also ends the stream).
+// Every image is printed as its own job as soon as it has arrived, so a
+// program can feed a batch without temporary files.
+
+// sourceImage is a decoded image and the name it is printed under
+type sourceImage struct {
+	name  string
+	img   image.Image
+	taken time.Time // from its EXIF data, zero if unknown
+}
+
+// readImageStream calls fn with every image of the stream r in format
+func readImageStream(r io.Reader, format string, fn func(sourceImage) error) error {
+	switch format {
+	case "tar":
+		return readTarImages(r, fn)
+	case "frames":
+		return readFramedImages(r, fn)
+	}
+	return fmt.Errorf("unknown stream format %q", format)
+}
+
+// readTarImages skips the files of a tar stream that aren't images, as
+// archives often come with a stray text file or two
+func readTarImages(r io.Reader, fn func(sourceImage) error) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("tar: %v", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, fetchMaxBytes))
+		if err != nil {
+			return fmt.Errorf("tar: %v", err)
+		}
+		img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+		if err != nil {
+			log.Printf("Skipping %s: not an image", h.Name)
+			continue
+		}
+		if err := fn(sourceImage{name: path.Base(h.Name), img: img, taken: exifTime(data)}); err != nil {
+			return err
+		}
+	}
+}
+
+func readFramedImages(r io.Reader, fn func(sourceImage) error) error {
+	br := bufio.NewReader(r)
+	for i := 1; ; i++ {
+		var n uint32
+		if err := binary.Read(br, binary.BigEndian, &n); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("frame %d: %v", i, err)
+		}
+		if n == 0 {
+			return nil
+		}
+		if n > fetchMaxBytes {
+			return fmt.Errorf("frame %d: %d bytes is more than the %d MB allowed", i, n, fetchMaxBytes>>20)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("frame %d: %v", i, err)
+		}
+		img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+		if err != nil {
+			return fmt.Errorf("frame %d: %v", i, err)
+		}
+		if err := fn(sourceImage{name: fmt.Sprintf("frame %d", i), img: img, taken: exifTime(data)}); err != nil {
+			return err
+		}
+	}
+}
+
+// printStream prints every image of the stream r as it arrives. Previews
+// and --concat need them all first, and show or print them as one strip.
+func printStream(ctx context.Context, r io.Reader, format string, printMode PrintMode, opts imageOptions) error {
+	if concat || previewOnly() {
+		var srcs []sourceImage
+		err := readImageStream(r, format, func(s sourceImage) error {
+			srcs = append(srcs, s)
+			return nil
+		})
+		if err == nil && len(srcs) == 0 {
+			err = fmt.Errorf("no images in the stream")
+		}
+		if err != nil {
+			return withCause(errBadInput, err)
+		}
+		pixels, height, printMode, err := concatSources(srcs, printMode, opts)
+		if err != nil {
+			return err
+		}
+		return previewOrPrint(ctx, pixels, height, printMode)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := make(chan preparedJob, pipelineDepth)
+	go func() {
+		defer close(queue)
+		n := 0
+		err := readImageStream(r, format, func(s sourceImage) error {
+			n++
+			opts := opts
+			opts.stamp = stampInfo{file: s.name, page: n, taken: s.taken}
+			printMode, opts := resolveAutoMode(s.img, printMode, opts)
+			pixels, height, err := processImage(s.img, printMode, opts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.name, err)
+			}
+			select {
+			case queue <- preparedJob{job: printJob{pixels: pixels, height: height, mode: printMode, intensity: intensityByte()}}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == nil && n == 0 {
+			err = fmt.Errorf("no images in the stream")
+		}
+		if err != nil && ctx.Err() == nil {
+			queue <- preparedJob{err: withCause(errBadInput, err)}
+		}
+	}()
+	return printJobQueue(ctx, queue, 0)
+}
@@ -0,0 +1,446 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"gopkg.in/yaml.v3"
+)
+
+// Templates are Go text/template files that produce a small layout language,
+// one line at a time. Plain lines are text, wrapped to the paper width; lines
+// starting with @ are directives:
+//
+//	@left, @center, @right   align what follows
+//	@size N                  text N times the normal size, 1 to 4
+//	@bold, @normal           bold text; @normal also goes back to size 1
+//	@line [dashed]           a rule across the paper
+//	@row left | right        left and right aligned text on one line
+//	@qr [size=L] text        a QR code, L wide (default half the paper)
+//	@barcode [size=L] text   a Code 128 barcode, L tall, with the text under it
+//	@image [size=L] path     an image, at most L wide, relative to the template
+//	@icon [size=L] name text a weather icon, L wide (default 32 dots), with
+//	                         optional text beside it
+//	@feed L                  blank space
+//
+// Lengths are in dots or millimeters ("5mm"). @@ starts a line of text with @.
+
+const (
+	maxLayoutScale       = 4
+	layoutRuleHeight     = 10 // a 2-dot @line with space around it
+	layoutBarcodeHeight  = 64 // default @barcode height, 8 mm
+	layoutCodeSpacing    = 4  // dots above and below codes and images
+	layoutBarcodeCaption = 2  // dots between a barcode and its text
+	layoutIconSize       = 32 // default @icon width, twice the icons' own
+)
+
+var receiptCmd = &command{
+	name:  "receipt",
+	usage: "[--data file.json|file.yaml] <template>",
+}
+
+func init() {
+	receiptCmd.run = runReceipt
+	registerCommand(receiptCmd)
+}
+
+// runReceipt fills a template in with the --data file and prints the result
+func runReceipt(ctx context.Context, args []string) error {
+	fs := receiptCmd.flagSet()
+	dataPath := fs.String("data", "", "JSON or YAML file the template is filled in with, '-' for stdin")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one template")
+	}
+
+	var data any
+	if *dataPath != "" {
+		var err error
+		if data, err = loadTemplateData(*dataPath); err != nil {
+			return withCause(errBadInput, err)
+		}
+	}
+	tmpl, err := loadTemplate(fs.Arg(0))
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	img, err := renderTemplate(tmpl, data, opts.margins.width(), filepath.Dir(fs.Arg(0)))
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	opts.stamp.file = sourceName(fs.Arg(0))
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// loadTemplateData reads a JSON or YAML file, YAML being a superset of JSON
+func loadTemplateData(path string) (any, error) {
+	var raw []byte
+	var err error
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data any
+	if err := yaml.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return data, nil
+}
+
+func loadTemplate(path string) (*template.Template, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplate(filepath.Base(path), string(src))
+}
+
+// parseTemplate parses a layout template. Fields missing from the data are
+// an error rather than printing "<no value>".
+func parseTemplate(name, src string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("template %v", err)
+	}
+	return tmpl, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+	"repeat": strings.Repeat,
+	"now":    time.Now,
+	"add":    func(a, b any) float64 { return toFloat(a) + toFloat(b) },
+	"mul":    func(a, b any) float64 { return toFloat(a) * toFloat(b) },
+}
+
+// toFloat reads the numbers YAML and JSON data come with, and numeric strings
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f
+	}
+	return 0
+}
+
+// renderTemplate fills tmpl in with data and lays the result out width dots
+// wide. Image paths are relative to dir.
+func renderTemplate(tmpl *template.Template, data any, width int, dir string) (image.Image, error) {
+	var src bytes.Buffer
+	if err := tmpl.Execute(&src, data); err != nil {
+		return nil, fmt.Errorf("template %v", err)
+	}
+	return renderLayout(src.String(), width, dir)
+}
+
+// layoutText escapes s to be printed as text in layout source, whatever it
+// starts with
+func layoutText(s string) string {
+	if strings.HasPrefix(s, "@") {
+		return "@" + s
+	}
+	return s
+}
+
+// layout is a document being laid out, as bands of the paper width
+type layout struct {
+	width int
+	dir   string
+	align string // left, center or right
+	scale int
+	bold  bool
+	bands []image.Image
+}
+
+// renderLayout draws layout language source width dots wide
+func renderLayout(src string, width int, dir string) (image.Image, error) {
+	l := &layout{width: width, dir: dir, align: "left", scale: 1}
+	for n, line := range strings.Split(strings.TrimRight(src, "\n"), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if !strings.HasPrefix(line, "@") || strings.HasPrefix(line, "@@") {
+			l.text(strings.TrimPrefix(line, "@"))
+			continue
+		}
+		name, arg, _ := strings.Cut(line[1:], " ")
+		if err := l.directive(name, strings.TrimSpace(arg)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+	}
+	if len(l.bands) == 0 {
+		return nil, fmt.Errorf("the template came out empty")
+	}
+	return stackImages(l.bands), nil
+}
+
+func (l *layout) directive(name, arg string) error {
+	switch name {
+	case "left", "center", "right":
+		l.align = name
+	case "size":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > maxLayoutScale {
+			return fmt.Errorf("@size takes 1 to %d", maxLayoutScale)
+		}
+		l.scale = n
+	case "bold":
+		l.bold = true
+	case "normal":
+		l.bold, l.scale = false, 1
+	case "line":
+		l.rule(arg == "dashed")
+	case "row":
+		left, right, _ := strings.Cut(arg, "|")
+		l.row(strings.TrimSpace(left), strings.TrimSpace(right))
+	case "feed":
+		n, err := parseLength(arg)
+		if err != nil {
+			return err
+		}
+		l.bands = append(l.bands, imaging.New(l.width, max(n, 1), color.White))
+	case "qr", "barcode", "image":
+		size, content := sizeArg(arg)
+		if content == "" {
+			return fmt.Errorf("@%s needs something to show", name)
+		}
+		return l.graphic(name, size, content)
+	case "icon":
+		size, rest := sizeArg(arg)
+		icon, text, _ := strings.Cut(rest, " ")
+		if icon == "" {
+			return fmt.Errorf("@icon needs an icon name")
+		}
+		return l.icon(size, icon, strings.TrimSpace(text))
+	default:
+		return fmt.Errorf("unknown directive @%s", name)
+	}
+	return nil
+}
+
+// sizeArg splits an optional leading size=<length> off a directive argument
+func sizeArg(arg string) (string, string) {
+	if !strings.HasPrefix(arg, "size=") {
+		return "", arg
+	}
+	size, rest, _ := strings.Cut(strings.TrimPrefix(arg, "size="), " ")
+	return size, strings.TrimSpace(rest)
+}
+
+func (l *layout) lineHeight() int {
+	return (glyphHeight + 2) * l.scale
+}
+
+// textWidth is how wide s comes out in the current style
+func (l *layout) textWidth(s string) int {
+	w := textWidth(s) * l.scale
+	if l.bold && w > 0 {
+		w += l.scale
+	}
+	return w
+}
+
+// drawText draws s in the current style, bold being the text drawn twice
+func (l *layout) drawText(dst *image.NRGBA, x int, s string) {
+	drawTextScaled(dst, x, 0, s, color.Black, l.scale)
+	if l.bold {
+		drawTextScaled(dst, x+l.scale, 0, s, color.Black, l.scale)
+	}
+}
+
+// text adds s, wrapped to the paper width and aligned. An empty s is a
+// blank line.
+func (l *layout) text(s string) {
+	extra := 0
+	if l.bold {
+		extra = l.scale
+	}
+	maxChars := max((l.width-extra)/(glyphWidth*l.scale), 1)
+	for _, line := range wrapText(s, maxChars) {
+		band := imaging.New(l.width, l.lineHeight(), color.White)
+		l.drawText(band, l.offset(l.textWidth(line)), line)
+		l.bands = append(l.bands, band)
+	}
+}
+
+// row puts left and right on the same line, shortening left if they don't
+// both fit
+func (l *layout) row(left, right string) {
+	band := imaging.New(l.width, l.lineHeight(), color.White)
+	rightWidth := l.textWidth(right)
+	room := l.width - rightWidth - glyphWidth*l.scale
+	for len(left) > 0 && l.textWidth(left) > room {
+		r := []rune(left)
+		left = string(r[:len(r)-1])
+	}
+	l.drawText(band, 0, left)
+	l.drawText(band, l.width-rightWidth, right)
+	l.bands = append(l.bands, band)
+}
+
+func (l *layout) rule(dashed bool) {
+	band := imaging.New(l.width, layoutRuleHeight, color.White)
+	y := layoutRuleHeight/2 - 1
+	for x := 0; x < l.width; x++ {
+		if dashed && (x/8)%2 == 1 {
+			continue
+		}
+		band.Set(x, y, color.Black)
+		band.Set(x, y+1, color.Black)
+	}
+	l.bands = append(l.bands, band)
+}
+
+// graphic adds a QR code, barcode or image, aligned like text
+func (l *layout) graphic(kind, size, content string) error {
+	n := 0
+	if size != "" {
+		var err error
+		if n, err = parseLength(size); err != nil {
+			return err
+		}
+	}
+	var img image.Image
+	var err error
+	switch kind {
+	case "qr":
+		if n == 0 {
+			n = l.width / 2
+		}
+		img, err = qrImage(content, min(n, l.width))
+	case "barcode":
+		if n == 0 {
+			n = layoutBarcodeHeight
+		}
+		img, err = l.barcode(content, n)
+	case "image":
+		img, err = l.image(content, n)
+	}
+	if err != nil {
+		return err
+	}
+	band := imaging.New(l.width, img.Bounds().Dy()+2*layoutCodeSpacing, color.White)
+	band = imaging.Paste(band, img, image.Pt(l.offset(img.Bounds().Dx()), layoutCodeSpacing))
+	l.bands = append(l.bands, band)
+	return nil
+}
+
+// icon adds an icon from the built-in set, scaled to a multiple of its size
+// close to size, with text to the right of it, both aligned like text
+func (l *layout) icon(size, name, text string) error {
+	n := layoutIconSize
+	if size != "" {
+		var err error
+		if n, err = parseLength(size); err != nil {
+			return err
+		}
+	}
+	img, err := iconImage(name, n)
+	if err != nil {
+		return err
+	}
+	w := img.Bounds().Dx()
+	if text != "" {
+		gap := glyphWidth * l.scale
+		for len(text) > 0 && w+gap+l.textWidth(text) > l.width {
+			r := []rune(text)
+			text = string(r[:len(r)-1])
+		}
+		w += gap + l.textWidth(text)
+	}
+	h := max(img.Bounds().Dy(), l.lineHeight())
+	band := imaging.New(l.width, h+2*layoutCodeSpacing, color.White)
+	x := l.offset(w)
+	band = imaging.Paste(band, img, image.Pt(x, layoutCodeSpacing+(h-img.Bounds().Dy())/2))
+	if text != "" {
+		line := imaging.New(l.textWidth(text), l.lineHeight(), color.White)
+		l.drawText(line, 0, text)
+		band = imaging.Paste(band, line, image.Pt(x+img.Bounds().Dx()+glyphWidth*l.scale, layoutCodeSpacing+(h-l.lineHeight())/2))
+	}
+	l.bands = append(l.bands, band)
+	return nil
+}
+
+// barcode draws content as a barcode height dots tall with the text under it
+func (l *layout) barcode(content string, height int) (image.Image, error) {
+	bars, err := code128Image(content, l.width, height)
+	if err != nil {
+		return nil, err
+	}
+	w := bars.Bounds().Dx()
+	img := imaging.New(w, height+layoutBarcodeCaption+glyphHeight, color.White)
+	img = imaging.Paste(img, bars, image.Pt(0, 0))
+	drawText(img, max((w-textWidth(content))/2, 0), height+layoutBarcodeCaption, content, color.Black)
+	return img, nil
+}
+
+// image loads the picture at path, scaled down to at most width dots wide,
+// or the paper width for 0
+func (l *layout) image(path string, width int) (image.Image, error) {
+	if !filepath.IsAbs(path) && !isURL(path) {
+		path = filepath.Join(l.dir, path)
+	}
+	img, err := openImage(path)
+	if err != nil {
+		return nil, err
+	}
+	if width == 0 || width > l.width {
+		width = l.width
+	}
+	if img.Bounds().Dx() > width {
+		img = imaging.Resize(img, width, 0, imaging.Lanczos)
+	}
+	return flattenAlpha(img, color.White), nil
+}
+
+// offset is where something w dots wide starts in the current alignment
+func (l *layout) offset(w int) int {
+	switch l.align {
+	case "center":
+		return max((l.width-w)/2, 0)
+	case "right":
+		return max(l.width-w, 0)
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// writeTermPreview shows packed pixels in the terminal with the given
+// protocol: kitty, sixel, blocks or auto
+func writeTermPreview(w io.Writer, protocol string, pixels []byte, height int, printMode PrintMode) error {
+	img := styledPreview(previewImage(pixels, height, printMode))
+	if protocol == "auto" {
+		protocol = detectTermGraphics()
+	}
+	bw := bufio.NewWriter(w)
+	var err error
+	switch protocol {
+	case "kitty":
+		err = writeKitty(bw, img)
+	case "sixel":
+		writeSixel(bw, img)
+	default:
+		writeHalfBlocks(bw, img, termColumns())
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// detectTermGraphics guesses the best protocol from the environment, since
+// querying the terminal would need raw mode
+func detectTermGraphics() string {
+	term := os.Getenv("TERM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "kitty"
+	case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm"):
+		return "sixel"
+	}
+	return "blocks"
+}
+
+func termColumns() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// writeKitty sends img as PNG with the kitty graphics protocol, in the 4096
+// byte chunks it requires
+func writeKitty(w *bufio.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.PNG); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for first := true; data != ""; first = false {
+		chunk := data[:min(len(data), 4096)]
+		data = data[len(chunk):]
+		more := 0
+		if data != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// writeSixel encodes img with the 16 printer gray levels as a sixel image
+func writeSixel(w *bufio.Writer, img image.Image) {
+	gray := toGray(img)
+	b := gray.Bounds()
+	level := func(x, y int) int { return int(255-gray.Pix[y*gray.Stride+x]) / 17 }
+
+	fmt.Fprintf(w, "\x1bPq\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i := 0; i < 16; i++ {
+		v := 100 - i*100/15
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i, v, v, v)
+	}
+	for top := 0; top < b.Dy(); top += 6 {
+		// Which levels appear in this band at all
+		var used [16]bool
+		for y := top; y < min(top+6, b.Dy()); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				used[level(x, y)] = true
+			}
+		}
+		first := true
+		for c := 0; c < 16; c++ {
+			if !used[c] {
+				continue
+			}
+			if !first {
+				w.WriteByte('$') // back to the start of the band
+			}
+			first = false
+			fmt.Fprintf(w, "#%d", c)
+			run, prev := 0, byte(0)
+			for x := 0; x <= b.Dx(); x++ {
+				var bits byte
+				if x < b.Dx() {
+					for dy := 0; dy < 6 && top+dy < b.Dy(); dy++ {
+						if level(x, top+dy) == c {
+							bits |= 1 << dy
+						}
+					}
+				}
+				if x > 0 && (bits != prev || x == b.Dx()) {
+					writeSixelRun(w, prev+63, run)
+					run = 0
+				}
+				prev = bits
+				run++
+			}
+		}
+		w.WriteByte('-')
+	}
+	w.WriteString("\x1b\\\n")
+}
+
+func writeSixelRun(w *bufio.Writer, ch byte, n int) {
+	if n > 3 {
+		fmt.Fprintf(w, "!%d%c", n, ch)
+		return
+	}
+	for i := 0; i < n; i++ {
+		w.WriteByte(ch)
+	}
+}
+
+// writeHalfBlocks draws img scaled down to cols characters with upper half
+// blocks, two pixel rows per line, in 24-bit color
+func writeHalfBlocks(w *bufio.Writer, img image.Image, cols int) {
+	if img.Bounds().Dx() > cols {
+		img = imaging.Resize(img, cols, 0, imaging.Box)
+	}
+	gray := toGray(img)
+	b := gray.Bounds()
+	for y := 0; y < b.Dy(); y += 2 {
+		for x := 0; x < b.Dx(); x++ {
+			top := gray.Pix[y*gray.Stride+x]
+			bottom := byte(255)
+			if y+1 < b.Dy() {
+				bottom = gray.Pix[(y+1)*gray.Stride+x]
+			}
+			fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top, top, top, bottom, bottom, bottom)
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
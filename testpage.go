@@ -0,0 +1,205 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+var testpageCmd = &command{
+	name:  "testpage",
+	usage: "--intensity-sweep [--sweep-step N] | --head",
+}
+
+func init() {
+	testpageCmd.run = runTestpage
+	registerCommand(testpageCmd)
+}
+
+func runTestpage(ctx context.Context, args []string) error {
+	fs := testpageCmd.flagSet()
+	sweep := fs.Bool("intensity-sweep", false, "Print the same band at increasing intensities")
+	sweepStep := fs.Int("sweep-step", 10, "Intensity increment between sweep bands")
+	head := fs.Bool("head", false, "Print a pattern exercising every heating element")
+	fs.Parse(args)
+
+	switch {
+	case *sweep:
+		if *sweepStep < 1 || *sweepStep > 100 {
+			return fmt.Errorf("--sweep-step must be between 1 and 100")
+		}
+		return printIntensitySweep(ctx, *sweepStep)
+	case *head:
+		return printHeadPattern(ctx)
+	default:
+		fs.Usage()
+		return fmt.Errorf("no test page selected")
+	}
+}
+
+// printIntensitySweep prints one labeled band per intensity from step to 100.
+// Intensity is a per-job setting, so every band is its own print job.
+func printIntensitySweep(ctx context.Context, step int) error {
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	opts.header, opts.footer = "", ""
+
+	var levels []int
+	for i := step; i <= 100; i += step {
+		levels = append(levels, i)
+	}
+	if levels[len(levels)-1] != 100 {
+		levels = append(levels, 100)
+	}
+
+	if outputPath != "" {
+		// A preview can't show intensity, but it does show the layout
+		bands := make([]image.Image, len(levels))
+		for i, level := range levels {
+			bands[i] = sweepBand(level)
+		}
+		pixels, height, err := processImage(stackImages(bands), printMode, opts)
+		if err != nil {
+			return err
+		}
+		return writePreview(outputPath, pixels, height, printMode)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	queue := prepareJobs(ctx, len(levels), func(i int) (printJob, error) {
+		pixels, height, err := processImage(sweepBand(levels[i]), printMode, opts)
+		return printJob{pixels: pixels, height: height, mode: printMode, intensity: byte(levels[i])}, err
+	})
+	return printJobQueue(ctx, queue, len(levels))
+}
+
+// sweepBand draws the sample shown at every intensity: the label, a solid
+// block, a gray ramp, and fine lines that smear first when it's too hot
+func sweepBand(level int) image.Image {
+	const height = defaultMinLines + 10
+	img := imaging.New(linePixels, height, color.White)
+
+	drawTextScaled(img, 8, 8, fmt.Sprintf("%d%%", level), color.Black, 2)
+
+	// Fine lines, one dot wide and one apart
+	for y := 44; y < height-8; y += 2 {
+		for x := 8; x < 120; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+
+	draw.Draw(img, image.Rect(130, 8, 200, height-8), image.Black, image.Point{}, draw.Src)
+
+	for x := 210; x < linePixels-8; x++ {
+		gray := uint8(255 - 255*(x-210)/(linePixels-8-210-1))
+		for y := 8; y < height-8; y++ {
+			img.Set(x, y, color.Gray{Y: gray})
+		}
+	}
+	return img
+}
+
+// stackImages places images below each other, left aligned, on white
+func stackImages(imgs []image.Image) image.Image {
+	width, height := 0, 0
+	for _, img := range imgs {
+		width = max(width, img.Bounds().Dx())
+		height += img.Bounds().Dy()
+	}
+	dst := imaging.New(width, height, color.White)
+	y := 0
+	for _, img := range imgs {
+		dst = imaging.Paste(dst, img, image.Pt(0, y))
+		y += img.Bounds().Dy()
+	}
+	return dst
+}
+
+// printHeadPattern prints patterns that make dead or weak heating elements
+// stand out: a solid band, one-dot vertical lines for every column (split in
+// four passes so neighbouring elements never share a line) with a column
+// scale, and checkerboards
+func printHeadPattern(ctx context.Context) error {
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	// The pattern is already black and white, keep it exactly as drawn
+	opts.ditherType = "none"
+	opts.threshold = thresholdSpec{method: "fixed", level: 128}
+	opts.tone = toneSpace{}
+	opts.curve = nil
+	opts.header, opts.footer = "", ""
+
+	const (
+		solidHeight = 40
+		scaleHeight = 24
+		passHeight  = 40
+		checkHeight = 48
+	)
+	height := solidHeight + scaleHeight + 4*passHeight + 2*checkHeight + 16
+	img := imaging.New(linePixels, height, color.White)
+	y := 0
+
+	draw.Draw(img, image.Rect(0, y, linePixels, y+solidHeight), image.Black, image.Point{}, draw.Src)
+	y += solidHeight + 4
+
+	// Column scale: long ticks every 32 columns, short ones every 8
+	for x := 0; x < linePixels; x += 8 {
+		tick := 4
+		if x%32 == 0 {
+			tick = 8
+			if x > 0 && x+textWidth("000")/2 < linePixels {
+				drawText(img, x-textWidth(fmt.Sprint(x))/2, y+tick+1, fmt.Sprint(x), color.Black)
+			}
+		}
+		for ty := y; ty < y+tick; ty++ {
+			img.Set(x, ty, color.Black)
+		}
+	}
+	y += scaleHeight
+
+	for pass := 0; pass < 4; pass++ {
+		for x := pass; x < linePixels; x += 4 {
+			for ly := y; ly < y+passHeight-4; ly++ {
+				img.Set(x, ly, color.Black)
+			}
+		}
+		y += passHeight
+	}
+	y += 4
+
+	for _, cell := range []int{1, 8} {
+		for cy := y; cy < y+checkHeight-4; cy++ {
+			for x := 0; x < linePixels; x++ {
+				if (x/cell+(cy-y)/cell)%2 == 0 {
+					img.Set(x, cy, color.Black)
+				}
+			}
+		}
+		y += checkHeight
+	}
+
+	pixels, pixelHeight, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, pixelHeight, printMode)
+}
@@ -0,0 +1,98 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Metrics of the built-in 7x13 bitmap font
+const (
+	glyphWidth  = 7
+	glyphHeight = 13
+)
+
+// drawText draws s in the built-in bitmap font with the top-left corner of the
+// first glyph at (x, y)
+func drawText(dst draw.Image, x, y int, s string, c color.Color) {
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y+basicfont.Face7x13.Ascent),
+	}
+	d.DrawString(s)
+}
+
+// drawTextScaled draws s like drawText, with every font pixel blown up to a
+// scale x scale block
+func drawTextScaled(dst draw.Image, x, y int, s string, c color.Color, scale int) {
+	if scale <= 1 {
+		drawText(dst, x, y, s, c)
+		return
+	}
+	w := textWidth(s)
+	if w == 0 {
+		return
+	}
+	small := image.NewNRGBA(image.Rect(0, 0, w, glyphHeight))
+	drawText(small, 0, 0, s, c)
+	big := imaging.Resize(small, w*scale, glyphHeight*scale, imaging.NearestNeighbor)
+	draw.Draw(dst, big.Bounds().Add(image.Pt(x, y)), big, image.Point{}, draw.Over)
+}
+
+// textWidth returns the width in pixels of s in the built-in bitmap font
+func textWidth(s string) int {
+	return len([]rune(s)) * glyphWidth
+}
+
+// wrapText breaks s into lines of at most maxChars characters, at spaces
+// where possible. Existing line breaks are kept and tabs become spaces.
+func wrapText(s string, maxChars int) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(s, "\t", "    "), "\n") {
+		line := []rune(strings.TrimRight(para, " \r"))
+		for len(line) > maxChars {
+			cut := maxChars
+			for i := maxChars; i > 0; i-- {
+				if line[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+			lines = append(lines, strings.TrimRight(string(line[:cut]), " "))
+			line = []rune(strings.TrimLeft(string(line[cut:]), " "))
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// textImage renders s word-wrapped to width dots in the built-in font blown
+// up by scale, black on white
+func textImage(s string, width, scale int) image.Image {
+	scale = max(scale, 1)
+	lineHeight := (glyphHeight + 2) * scale
+	lines := wrapText(s, max(width/(glyphWidth*scale), 1))
+	img := imaging.New(width, len(lines)*lineHeight, color.White)
+	for i, line := range lines {
+		drawTextScaled(img, 0, i*lineHeight, line, color.Black, scale)
+	}
+	return img
+}
@@ -0,0 +1,184 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+)
+
+// thresholdSpec describes how grayscale pixels are split into black and white
+// when printing in 1bpp mode without dithering
+type thresholdSpec struct {
+	method string // "fixed", "auto" (Otsu), "sauvola" or "bradley"
+	level  uint8  // pixels darker than this are printed black (fixed only)
+	window int    // neighbourhood size in pixels for adaptive methods, 0 picks one
+}
+
+func parseThreshold(s string, window int) (thresholdSpec, error) {
+	if window < 0 {
+		return thresholdSpec{}, fmt.Errorf("invalid threshold window %d", window)
+	}
+	switch s {
+	case "auto", "sauvola", "bradley":
+		return thresholdSpec{method: s, window: window}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 255 {
+		return thresholdSpec{}, fmt.Errorf("invalid threshold %q, use 0-255, 'auto', 'sauvola' or 'bradley'", s)
+	}
+	return thresholdSpec{method: "fixed", level: uint8(n)}, nil
+}
+
+// binarize returns a copy of img where every pixel is either pure black or
+// pure white according to the threshold method
+func (t thresholdSpec) binarize(img image.Image) *image.Gray {
+	gray := toGray(img)
+	switch t.method {
+	case "sauvola", "bradley":
+		return adaptiveThreshold(gray, t.method, t.windowFor(gray))
+	case "auto":
+		return globalThreshold(gray, otsuThreshold(gray))
+	default:
+		return globalThreshold(gray, t.level)
+	}
+}
+
+func (t thresholdSpec) windowFor(img *image.Gray) int {
+	if t.window > 0 {
+		return t.window
+	}
+	// Roughly the size of a couple of text lines at print resolution
+	return max(img.Bounds().Dx()/16, 15)
+}
+
+// toGray converts any image to an *image.Gray with its origin at (0, 0)
+func toGray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Set(x, y, color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+	return gray
+}
+
+func globalThreshold(img *image.Gray, level uint8) *image.Gray {
+	out := image.NewGray(img.Bounds())
+	for i, v := range img.Pix {
+		if v >= level {
+			out.Pix[i] = 255
+		}
+	}
+	return out
+}
+
+// adaptiveThreshold binarizes each pixel against statistics of its own
+// neighbourhood, so uneven lighting across a photographed page doesn't turn
+// whole regions black or white. Window sums come from integral images, making
+// the cost independent of the window size.
+func adaptiveThreshold(img *image.Gray, method string, window int) *image.Gray {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	stride := w + 1
+	sum := make([]float64, stride*(h+1))
+	sumSq := make([]float64, stride*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSumSq float64
+		for x := 0; x < w; x++ {
+			v := float64(img.Pix[y*img.Stride+x])
+			rowSum += v
+			rowSumSq += v * v
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + rowSum
+			sumSq[(y+1)*stride+x+1] = sumSq[y*stride+x+1] + rowSumSq
+		}
+	}
+
+	const (
+		sauvolaK = 0.2   // sensitivity to local contrast
+		sauvolaR = 128.0 // dynamic range of the standard deviation
+		bradleyT = 0.15  // how much darker than the local mean ink has to be
+	)
+
+	half := window / 2
+	out := image.NewGray(img.Bounds())
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-half, 0), min(y+half+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, w)
+			n := float64((x1 - x0) * (y1 - y0))
+			s := sum[y1*stride+x1] - sum[y0*stride+x1] - sum[y1*stride+x0] + sum[y0*stride+x0]
+			mean := s / n
+
+			var cutoff float64
+			if method == "sauvola" {
+				sq := sumSq[y1*stride+x1] - sumSq[y0*stride+x1] - sumSq[y1*stride+x0] + sumSq[y0*stride+x0]
+				std := math.Sqrt(max(sq/n-mean*mean, 0))
+				cutoff = mean * (1 + sauvolaK*(std/sauvolaR-1))
+			} else {
+				cutoff = mean * (1 - bradleyT)
+			}
+
+			if float64(img.Pix[y*img.Stride+x]) >= cutoff {
+				out.Pix[y*out.Stride+x] = 255
+			}
+		}
+	}
+	return out
+}
+
+// otsuThreshold picks the cutoff that maximizes the between-class variance of
+// the image histogram, which separates ink from paper on faint scans far better
+// than a fixed midpoint
+func otsuThreshold(img *image.Gray) uint8 {
+	var hist [256]int
+	for _, v := range img.Pix {
+		hist[v]++
+	}
+
+	total := len(img.Pix)
+	if total == 0 {
+		return 128
+	}
+
+	sumAll := 0.0
+	for i, n := range hist {
+		sumAll += float64(i * n)
+	}
+
+	var sumBg, bestVar float64
+	var weightBg int
+	best := 127
+	for t := 0; t < 256; t++ {
+		weightBg += hist[t]
+		if weightBg == 0 {
+			continue
+		}
+		weightFg := total - weightBg
+		if weightFg == 0 {
+			break
+		}
+		sumBg += float64(t * hist[t])
+		meanBg := sumBg / float64(weightBg)
+		meanFg := (sumAll - sumBg) / float64(weightFg)
+		between := float64(weightBg) * float64(weightFg) * (meanBg - meanFg) * (meanBg - meanFg)
+		if between > bestVar {
+			bestVar = between
+			best = t
+		}
+	}
+
+	// Otsu's class boundary is inclusive, our cutoff is exclusive
+	return uint8(best + 1)
+}
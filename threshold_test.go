@@ -0,0 +1,44 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		s      string
+		window int
+		want   thresholdSpec
+		ok     bool
+	}{
+		{"128", 0, thresholdSpec{method: "fixed", level: 128}, true},
+		{"0", 0, thresholdSpec{method: "fixed", level: 0}, true},
+		{"255", 0, thresholdSpec{method: "fixed", level: 255}, true},
+		{"auto", 0, thresholdSpec{method: "auto"}, true},
+		{"sauvola", 31, thresholdSpec{method: "sauvola", window: 31}, true},
+		{"bradley", 0, thresholdSpec{method: "bradley"}, true},
+		{"256", 0, thresholdSpec{}, false},
+		{"-1", 0, thresholdSpec{}, false},
+		{"otsu", 0, thresholdSpec{}, false},
+		{"", 0, thresholdSpec{}, false},
+		{"auto", -5, thresholdSpec{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseThreshold(tt.s, tt.window)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseThreshold(%q, %d) error = %v, want ok %v", tt.s, tt.window, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("parseThreshold(%q, %d) = %+v, want %+v", tt.s, tt.window, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+var todoCmd = &command{
+	name:  "todo",
+	usage: "[--size N] [--hide-done] <todo.txt, Markdown file or ->",
+}
+
+func init() {
+	todoCmd.run = runTodo
+	registerCommand(todoCmd)
+}
+
+// todoSpacing is the room between tasks, in dots at size 1
+const todoSpacing = 3
+
+// todoItem is a line of a task list
+type todoItem struct {
+	text     string
+	task     bool // has a checkbox
+	done     bool
+	priority byte // 'A' to 'Z', or 0
+	heading  bool
+	indent   int // nesting level of Markdown lists
+}
+
+// runTodo prints a todo.txt file or the task lists of a Markdown file as a
+// checklist
+func runTodo(ctx context.Context, args []string) error {
+	fs := todoCmd.flagSet()
+	size := fs.Int("size", 1, "Text size, 1 to 4")
+	hideDone := fs.Bool("hide-done", false, "Leave out the tasks that are done")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one task list")
+	}
+	if *size < 1 || *size > maxLayoutScale {
+		return withCause(errBadInput, fmt.Errorf("--size must be 1 to %d", maxLayoutScale))
+	}
+	path := fs.Arg(0)
+	var src []byte
+	var err error
+	if path == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+
+	var items []todoItem
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".md" || ext == ".markdown" || (ext != ".txt" && markdownTask.Match(src)):
+		items = parseMarkdownTasks(string(src))
+	default:
+		items = parseTodoTxt(string(src))
+	}
+	if *hideDone {
+		kept := items[:0]
+		for _, it := range items {
+			if !it.done {
+				kept = append(kept, it)
+			}
+		}
+		items = kept
+	}
+	if len(items) == 0 {
+		return withCause(errBadInput, fmt.Errorf("no tasks in %s", path))
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	opts.stamp.file = sourceName(path)
+	img := todoImage(items, opts.margins.width(), *size)
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+var (
+	markdownTask    = regexp.MustCompile(`(?m)^(\s*)[-*+] \[([ xX])\] (.*)$`)
+	markdownBullet  = regexp.MustCompile(`^(\s*)[-*+] (.*)$`)
+	markdownHeading = regexp.MustCompile(`^#{1,6} (.*)$`)
+	todoPriority    = regexp.MustCompile(`^\(([A-Z])\) `)
+	todoDate        = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)
+	todoPriTag      = regexp.MustCompile(`(?:^|\s)pri:([A-Z])(?:\s|$)`)
+)
+
+// parseMarkdownTasks reads "- [ ]" and "- [x]" task list items along with
+// the headings, plain list items and text around them
+func parseMarkdownTasks(src string) []todoItem {
+	var items []todoItem
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(strings.ReplaceAll(line, "\t", "    "), " \r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if m := markdownTask.FindStringSubmatch(line); m != nil {
+			it := todoItem{task: true, done: m[2] != " ", indent: len(m[1]) / 2}
+			it.priority, it.text = taskPriority(m[3])
+			items = append(items, it)
+		} else if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			items = append(items, todoItem{text: m[1], heading: true})
+		} else if m := markdownBullet.FindStringSubmatch(line); m != nil {
+			items = append(items, todoItem{text: "- " + m[2], indent: len(m[1]) / 2})
+		} else {
+			items = append(items, todoItem{text: strings.TrimSpace(line)})
+		}
+	}
+	return items
+}
+
+// parseTodoTxt reads a todo.txt file, where a task starts with "x " once
+// done and its priority is an "(A) " in front. Completion and creation
+// dates are left out; +projects, @contexts and key:value tags stay.
+func parseTodoTxt(src string) []todoItem {
+	var items []todoItem
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		it := todoItem{task: true}
+		if strings.HasPrefix(line, "x ") {
+			it.done = true
+			line = strings.TrimPrefix(line, "x ")
+		}
+		it.priority, line = taskPriority(line)
+		for i := 0; i < 2 && todoDate.MatchString(line); i++ {
+			line = line[len("2006-01-02 "):]
+		}
+		// Done tasks keep their priority as a pri: tag
+		if m := todoPriTag.FindStringSubmatch(line); m != nil && it.priority == 0 {
+			it.priority = m[1][0]
+			line = strings.Join(strings.Fields(todoPriTag.ReplaceAllString(line, " ")), " ")
+		}
+		it.text = line
+		items = append(items, it)
+	}
+	return items
+}
+
+// taskPriority splits a leading "(A) " priority off a task
+func taskPriority(s string) (byte, string) {
+	if m := todoPriority.FindStringSubmatch(s); m != nil {
+		return m[1][0], s[len(m[0]):]
+	}
+	return 0, s
+}
+
+// todoImage draws the items width dots wide, tasks with a checkbox and their
+// priority letter in a black box, wrapped under themselves
+func todoImage(items []todoItem, width, scale int) image.Image {
+	lineHeight := (glyphHeight + 2) * scale
+	var bands []image.Image
+	for i, it := range items {
+		if it.heading {
+			if i > 0 {
+				bands = append(bands, imaging.New(width, lineHeight/2, color.White))
+			}
+			bands = append(bands, textImage(it.text, width, scale+1))
+			continue
+		}
+		x := it.indent * 2 * glyphWidth * scale
+		if it.task {
+			x += 2 * glyphWidth * scale
+		}
+		if it.priority != 0 {
+			x += 2 * glyphWidth * scale
+		}
+		text := textImage(it.text, max(width-x, glyphWidth*scale), scale)
+		band := imaging.New(width, text.Bounds().Dy()+todoSpacing*scale, color.White)
+		band = imaging.Paste(band, text, image.Pt(x, 0))
+		x = it.indent * 2 * glyphWidth * scale
+		if it.task {
+			drawCheckbox(band, x, scale, glyphHeight*scale-2*scale, it.done)
+			x += 2 * glyphWidth * scale
+		}
+		if it.priority != 0 {
+			draw.Draw(band, image.Rect(x, 0, x+glyphWidth*scale+2*scale, glyphHeight*scale), image.Black, image.Point{}, draw.Src)
+			drawTextScaled(band, x+scale, 0, string(it.priority), color.White, scale)
+		}
+		bands = append(bands, band)
+	}
+	return stackImages(bands)
+}
+
+// drawCheckbox draws a size dots square box at (x, y), with a check mark in
+// it when checked
+func drawCheckbox(dst draw.Image, x, y, size int, checked bool) {
+	t := max(size/10, 1)
+	for i := 0; i < t; i++ {
+		for d := 0; d < size; d++ {
+			dst.Set(x+d, y+i, color.Black)
+			dst.Set(x+d, y+size-1-i, color.Black)
+			dst.Set(x+i, y+d, color.Black)
+			dst.Set(x+size-1-i, y+d, color.Black)
+		}
+	}
+	if !checked {
+		return
+	}
+	// A check mark from the left middle down to the bottom third and up to
+	// the top right corner, 2t thick
+	pen := func(px, py int) {
+		draw.Draw(dst, image.Rect(px-t, py-t, px+t, py+t), image.Black, image.Point{}, draw.Src)
+	}
+	x0, y0 := x+size/5, y+size/2
+	x1, y1 := x+size*2/5, y+size*3/4
+	x2, y2 := x+size*4/5, y+size/5
+	for i := 0; i <= x1-x0; i++ {
+		pen(x0+i, y0+(y1-y0)*i/max(x1-x0, 1))
+	}
+	for i := 0; i <= x2-x1; i++ {
+		pen(x1+i, y1+(y2-y1)*i/max(x2-x1, 1))
+	}
+}
@@ -0,0 +1,173 @@
+//go:build gui
+
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+)
+
+var trayCmd = &command{
+	name:  "tray",
+	usage: "[--poll duration] [--eject-lines N]",
+}
+
+func init() {
+	trayCmd.run = runTray
+	registerCommand(trayCmd)
+}
+
+// runTray sits in the system tray, showing whether the printer is reachable
+// and its battery level. Every menu action connects on its own, like the CLI
+// does, unless --stay-connected keeps one connection open for all of them.
+func runTray(ctx context.Context, args []string) error {
+	fs := trayCmd.flagSet()
+	poll := fs.Duration("poll", 2*time.Minute, "How often to refresh the printer status, 0 to only refresh on demand")
+	ejectLines := fs.Uint("eject-lines", 60, "Lines to feed for \"Eject paper\"")
+	fs.Parse(args)
+
+	a := app.NewWithID("io.github.igna503.bleh")
+	desk, ok := a.(desktop.App)
+	if !ok {
+		return fmt.Errorf("no system tray available")
+	}
+
+	// Dialogs need a window; it only shows up while picking a file
+	w := a.NewWindow("Bleh!")
+	w.SetCloseIntercept(w.Hide)
+
+	statusItem := fyne.NewMenuItem("Printer: checking…", nil)
+	statusItem.Disabled = true
+	menu := fyne.NewMenu("Bleh!")
+
+	busy := make(chan struct{}, 1) // one printer connection at a time
+	run := func(what string, fn func() error) {
+		go func() {
+			select {
+			case busy <- struct{}{}:
+			default:
+				log.Printf("%s: printer is busy", what)
+				return
+			}
+			defer func() { <-busy }()
+			if err := fn(); err != nil {
+				log.Printf("%s failed: %v", what, err)
+				statusItem.Label = what + " failed"
+				menu.Refresh()
+				if what != "Status" {
+					desktopNotify(what+" failed", err.Error(), true)
+				}
+			}
+		}()
+	}
+	var notifier statusNotifier
+	refresh := func() error {
+		s, err := queryStatus(ctx)
+		if err != nil {
+			statusItem.Label = "Printer: not reachable"
+		} else {
+			statusItem.Label = "Printer: " + s.state
+			if s.battery != unknownLevel {
+				statusItem.Label += fmt.Sprintf(", battery %d%%", s.battery)
+			}
+			notifier.update(s)
+		}
+		menu.Refresh()
+		return err
+	}
+	printImg := func(img image.Image, name string) error {
+		printMode, opts, err := imageOptionsFromFlags()
+		if err != nil {
+			return err
+		}
+		opts.stamp.file = name
+		printMode, opts = resolveAutoMode(img, printMode, opts)
+		pixels, height, err := processImage(img, printMode, opts)
+		if err != nil {
+			return err
+		}
+		err = printJobs(ctx, []printJob{{pixels: pixels, height: height, mode: printMode, intensity: intensityByte()}})
+		if err != nil {
+			return err
+		}
+		desktopNotify("Print complete", name, false)
+		return nil
+	}
+
+	menu.Items = []*fyne.MenuItem{
+		statusItem,
+		fyne.NewMenuItem("Refresh status", func() { run("Status", refresh) }),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Print clipboard", func() {
+			run("Print clipboard", func() error {
+				_, opts, err := imageOptionsFromFlags()
+				if err != nil {
+					return err
+				}
+				img, err := readClipboard(opts.margins.width(), 2)
+				if err != nil {
+					return err
+				}
+				return printImg(img, "clipboard")
+			})
+		}),
+		fyne.NewMenuItem("Print file…", func() {
+			w.Show()
+			dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+				w.Hide()
+				if err != nil || r == nil {
+					return
+				}
+				r.Close()
+				path := r.URI().Path()
+				run("Print file", func() error {
+					img, err := decodeImage(path)
+					if err != nil {
+						return err
+					}
+					return printImg(img, sourceName(path))
+				})
+			}, w)
+		}),
+		fyne.NewMenuItem("Eject paper", func() {
+			run("Eject", func() error { return feedPaper(ctx, *ejectLines) })
+		}),
+	}
+	desk.SetSystemTrayMenu(menu)
+	desk.SetSystemTrayIcon(theme.DocumentPrintIcon())
+
+	run("Status", refresh)
+	if *poll > 0 {
+		go func() {
+			for range time.Tick(*poll) {
+				run("Status", refresh)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done() // Ctrl-C in the terminal it was started from
+		a.Quit()
+	}()
+	a.Run()
+	return nil
+}
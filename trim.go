@@ -0,0 +1,41 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// trimWhitespace crops away the borders of img made up only of pixels at
+// least as light as level. Images that are blank all over are left alone.
+func trimWhitespace(img image.Image, level uint8) image.Image {
+	gray := toGray(img)
+	w, h := gray.Bounds().Dx(), gray.Bounds().Dy()
+
+	minX, minY, maxX, maxY := w, h, -1, -1
+	for y := 0; y < h; y++ {
+		row := gray.Pix[y*gray.Stride : y*gray.Stride+w]
+		for x, v := range row {
+			if v < level {
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+	}
+	if maxX < 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	return imaging.Crop(img, image.Rect(b.Min.X+minX, b.Min.Y+minY, b.Min.X+maxX+1, b.Min.Y+maxY+1))
+}
@@ -0,0 +1,280 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+var weatherCmd = &command{
+	name:  "weather",
+	usage: "--lat N --lon N [--days N] [--place name] [--fahrenheit] [--api URL] [--template file]",
+}
+
+func init() {
+	weatherCmd.run = runWeather
+	registerCommand(weatherCmd)
+}
+
+const defaultWeatherAPI = "https://api.open-meteo.com/v1/forecast"
+
+// weatherTemplate is the built-in forecast layout. --template replaces it
+// with one of your own, filled in with the same data.
+const weatherTemplate = `@center
+{{with .place}}@size 2
+{{.}}
+@normal
+{{end}}@icon size=96 {{.current.icon}}
+@size 3
+{{printf "%.0f" .current.temperature}}{{.unit}}
+@normal
+{{.current.description}}, wind {{printf "%.0f" .current.wind}} {{.wind_unit}}
+@line
+@left
+{{range .days}}@icon {{.icon}} {{.date.Format "Mon 2"}}  {{printf "%.0f" .min}}-{{printf "%.0f" .max}}{{$.unit}}  {{printf "%.0f" .precipitation}}%  {{.description}}
+{{end}}`
+
+// openMeteo is the part of an Open-Meteo forecast reply that gets printed
+type openMeteo struct {
+	CurrentUnits struct {
+		Temperature string `json:"temperature_2m"`
+		Wind        string `json:"wind_speed_10m"`
+	} `json:"current_units"`
+	Current struct {
+		Temperature float64 `json:"temperature_2m"`
+		Code        int     `json:"weather_code"`
+		Wind        float64 `json:"wind_speed_10m"`
+	} `json:"current"`
+	Daily struct {
+		Time          []string  `json:"time"`
+		Code          []int     `json:"weather_code"`
+		Max           []float64 `json:"temperature_2m_max"`
+		Min           []float64 `json:"temperature_2m_min"`
+		Precipitation []float64 `json:"precipitation_probability_max"`
+	} `json:"daily"`
+}
+
+// runWeather prints the current weather and a forecast for the coming days
+// from Open-Meteo, or another server with the same API
+func runWeather(ctx context.Context, args []string) error {
+	fs := weatherCmd.flagSet()
+	lat := fs.String("lat", "", "Latitude of the place, e.g. 52.52")
+	lon := fs.String("lon", "", "Longitude of the place, e.g. 13.41")
+	days := fs.Int("days", 3, "Days to forecast, 1 to 16")
+	place := fs.String("place", "", "Name printed as the title")
+	fahrenheit := fs.Bool("fahrenheit", false, "Temperatures in °F and wind in mph")
+	api := fs.String("api", defaultWeatherAPI, "Forecast endpoint of an Open-Meteo compatible server")
+	templatePath := fs.String("template", "", "Layout template to use instead of the built-in one")
+	fs.Parse(args)
+
+	if *lat == "" || *lon == "" {
+		fs.Usage()
+		return fmt.Errorf("--lat and --lon are required")
+	}
+	for _, v := range []string{*lat, *lon} {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return withCause(errBadInput, fmt.Errorf("invalid coordinate %q", v))
+		}
+	}
+	if *days < 1 || *days > 16 {
+		return withCause(errBadInput, fmt.Errorf("--days must be 1 to 16"))
+	}
+
+	var tmpl *template.Template
+	var err error
+	if *templatePath != "" {
+		tmpl, err = loadTemplate(*templatePath)
+	} else {
+		tmpl, err = parseTemplate("weather", weatherTemplate)
+	}
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+
+	q := url.Values{
+		"latitude":      {*lat},
+		"longitude":     {*lon},
+		"current":       {"temperature_2m,weather_code,wind_speed_10m"},
+		"daily":         {"weather_code,temperature_2m_max,temperature_2m_min,precipitation_probability_max"},
+		"forecast_days": {strconv.Itoa(*days)},
+		"timezone":      {"auto"},
+	}
+	if *fahrenheit {
+		q.Set("temperature_unit", "fahrenheit")
+		q.Set("wind_speed_unit", "mph")
+	}
+	raw, err := download(*api+"?"+q.Encode(), "forecast")
+	if err != nil {
+		return err
+	}
+	var forecast openMeteo
+	if err := json.Unmarshal(raw, &forecast); err != nil {
+		return fmt.Errorf("bad forecast reply: %v", err)
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	img, err := renderTemplate(tmpl, forecast.data(*place), opts.margins.width(), "")
+	if err != nil {
+		return withCause(errBadInput, err)
+	}
+	opts.stamp.file = "weather"
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// data is what weather templates are filled in with
+func (f openMeteo) data(place string) map[string]any {
+	var days []map[string]any
+	at := func(v []float64, i int) float64 {
+		if i < len(v) {
+			return v[i]
+		}
+		return 0
+	}
+	for i, day := range f.Daily.Time {
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil || i >= len(f.Daily.Code) {
+			continue
+		}
+		icon, description := weatherCode(f.Daily.Code[i])
+		days = append(days, map[string]any{
+			"date":          date,
+			"icon":          icon,
+			"description":   description,
+			"min":           at(f.Daily.Min, i),
+			"max":           at(f.Daily.Max, i),
+			"precipitation": at(f.Daily.Precipitation, i),
+		})
+	}
+	icon, description := weatherCode(f.Current.Code)
+	return map[string]any{
+		"place": place,
+		// The built-in font has no degree sign
+		"unit":      strings.TrimPrefix(f.CurrentUnits.Temperature, "°"),
+		"wind_unit": f.CurrentUnits.Wind,
+		"current": map[string]any{
+			"temperature": f.Current.Temperature,
+			"wind":        f.Current.Wind,
+			"icon":        icon,
+			"description": description,
+		},
+		"days": days,
+	}
+}
+
+// weatherCode returns the icon and a short description of a WMO weather
+// interpretation code, as Open-Meteo reports the weather
+func weatherCode(code int) (string, string) {
+	switch {
+	case code == 0:
+		return "sun", "Clear"
+	case code == 1:
+		return "sun", "Mostly clear"
+	case code == 2:
+		return "partly", "Partly cloudy"
+	case code == 3:
+		return "cloud", "Overcast"
+	case code == 45 || code == 48:
+		return "fog", "Fog"
+	case code >= 51 && code <= 57:
+		return "drizzle", "Drizzle"
+	case code >= 61 && code <= 67:
+		return "rain", "Rain"
+	case code >= 71 && code <= 77:
+		return "snow", "Snow"
+	case code >= 80 && code <= 82:
+		return "rain", "Showers"
+	case code == 85 || code == 86:
+		return "snow", "Snow showers"
+	case code >= 95:
+		return "thunder", "Thunderstorm"
+	}
+	return "cloud", "Unknown"
+}
+
+//go:embed icons/weather.txt
+var weatherIconSource string
+
+var (
+	weatherIcons     map[string]*image.Gray
+	weatherIconsOnce sync.Once
+)
+
+// loadIcons parses the icon set: blocks of # and . rows under a line with
+// the icon's name, "# " lines being comments
+func loadIcons(src string) map[string]*image.Gray {
+	icons := map[string]*image.Gray{}
+	var name string
+	var rows []string
+	add := func() {
+		if name == "" || len(rows) == 0 {
+			return
+		}
+		img := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+		for y, row := range rows {
+			for x, c := range row {
+				if c == '#' {
+					img.SetGray(x, y, color.Gray{})
+				} else {
+					img.SetGray(x, y, color.Gray{Y: 255})
+				}
+			}
+		}
+		icons[name] = img
+	}
+	sc := bufio.NewScanner(strings.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "# "):
+		case strings.Trim(line, "#.") == "":
+			rows = append(rows, line)
+		default:
+			add()
+			name, rows = line, nil
+		}
+	}
+	add()
+	return icons
+}
+
+// iconImage returns the named icon blown up to the multiple of its size
+// nearest to size dots
+func iconImage(name string, size int) (image.Image, error) {
+	weatherIconsOnce.Do(func() { weatherIcons = loadIcons(weatherIconSource) })
+	icon, ok := weatherIcons[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown icon %q", name)
+	}
+	w := icon.Bounds().Dx()
+	scale := max((size+w/2)/w, 1)
+	return imaging.Resize(icon, w*scale, icon.Bounds().Dy()*scale, imaging.NearestNeighbor), nil
+}
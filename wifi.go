@@ -0,0 +1,97 @@
+/*
+This file is part of Bleh!.
+
+Bleh! is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.
+
+Bleh! is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License along with Foobar. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+var wifiCmd = &command{
+	name:  "wifi",
+	usage: "--ssid name [--pass password] [--security WPA|WEP|none] [--hidden] [--hide-pass]",
+}
+
+func init() {
+	wifiCmd.run = runWifi
+	registerCommand(wifiCmd)
+}
+
+// runWifi prints a QR code that joins phones to a Wi-Fi network, with the
+// network name and password under it for everything else
+func runWifi(ctx context.Context, args []string) error {
+	fs := wifiCmd.flagSet()
+	ssid := fs.String("ssid", "", "Network name")
+	pass := fs.String("pass", "", "Password")
+	security := fs.String("security", "WPA", "WPA (also WPA2/WPA3), WEP or none")
+	hidden := fs.Bool("hidden", false, "The network doesn't broadcast its name")
+	hidePass := fs.Bool("hide-pass", false, "Leave the password out of the printed text, only the QR code has it")
+	fs.Parse(args)
+
+	if *ssid == "" {
+		fs.Usage()
+		return fmt.Errorf("--ssid is required")
+	}
+	sec := strings.ToUpper(*security)
+	switch {
+	case sec == "NONE" || sec == "NOPASS":
+		if *pass != "" {
+			return withCause(errBadInput, fmt.Errorf("an open network has no --pass"))
+		}
+		sec = "nopass"
+	case sec != "WPA" && sec != "WEP":
+		return withCause(errBadInput, fmt.Errorf("invalid --security %q, use WPA, WEP or none", *security))
+	case *pass == "":
+		return withCause(errBadInput, fmt.Errorf("a %s network needs a --pass", sec))
+	}
+
+	printMode, opts, err := imageOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+	img, err := renderLayout(wifiLayout(*ssid, *pass, sec, *hidden, *hidePass), opts.margins.width(), "")
+	if err != nil {
+		return err
+	}
+	opts.stamp.file = *ssid
+	pixels, height, err := processImage(img, printMode, opts)
+	if err != nil {
+		return err
+	}
+	return previewOrPrint(ctx, pixels, height, printMode)
+}
+
+// wifiPayload is the WIFI: URI phone cameras understand, as the Wi-Fi
+// Alliance defines it (originally ZXing's format)
+func wifiPayload(ssid, pass, security string, hidden bool) string {
+	esc := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+	var b strings.Builder
+	fmt.Fprintf(&b, "WIFI:T:%s;S:%s;", security, esc.Replace(ssid))
+	if security != "nopass" {
+		fmt.Fprintf(&b, "P:%s;", esc.Replace(pass))
+	}
+	if hidden {
+		b.WriteString("H:true;")
+	}
+	b.WriteString(";")
+	return b.String()
+}
+
+func wifiLayout(ssid, pass, security string, hidden, hidePass bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@center\n@size 2\nWi-Fi\n@normal\n@qr %s\n", wifiPayload(ssid, pass, security, hidden))
+	fmt.Fprintf(&b, "@bold\n%s\n@normal\n", layoutText("Network: "+ssid))
+	if security != "nopass" && !hidePass {
+		fmt.Fprintf(&b, "%s\n", layoutText("Password: "+pass))
+	}
+	return b.String()
+}